@@ -44,17 +44,17 @@ class WebSocketService {
       const response = await apiClient.get(endpoints.comments.websocket);
       const wsInfo = response.data;
 
+      // API Gateway proxies the WebSocket and injects the internal JWT server-side
       const wsUrl = wsInfo.comment_service_websocket_url;
-      const internalJWT = wsInfo.internal_jwt;
       const userToken = localStorage.getItem('accessToken');
 
-      if (!wsUrl || !internalJWT || !userToken) {
+      if (!wsUrl || !userToken) {
         throw new Error('Missing WebSocket connection information');
       }
 
       const wsFullUrl = `${wsUrl}?productId=${productId}&X-User-Token=${encodeURIComponent(
         userToken
-      )}&X-Internal-JWT=${encodeURIComponent(internalJWT)}`;
+      )}`;
 
       await this.connect(wsFullUrl);
     } catch (error) {
@@ -69,17 +69,17 @@ class WebSocketService {
       const response = await apiClient.get(endpoints.orders.websocket);
       const wsInfo = response.data;
 
+      // API Gateway proxies the WebSocket and injects the internal JWT server-side
       const wsUrl = wsInfo.order_service_websocket_url;
-      const internalJWT = wsInfo.internal_jwt;
       const userToken = localStorage.getItem('accessToken');
 
-      if (!wsUrl || !internalJWT || !userToken) {
+      if (!wsUrl || !userToken) {
         throw new Error('Missing WebSocket connection information');
       }
 
       const wsFullUrl = `${wsUrl}?orderId=${orderId}&X-User-Token=${encodeURIComponent(
         userToken
-      )}&X-Internal-JWT=${encodeURIComponent(internalJWT)}`;
+      )}`;
 
       await this.connect(wsFullUrl);
     } catch (error) {
@@ -1,25 +1,41 @@
 package main
 
 import (
+	"api_gateway/internal/apikey"
+	"api_gateway/internal/breaker"
+	"api_gateway/internal/cache"
 	"api_gateway/internal/config"
+	"api_gateway/internal/events"
 	"api_gateway/internal/handlers"
+	"api_gateway/internal/idempotency"
 	"api_gateway/internal/logger"
 	"api_gateway/internal/metrics"
 	"api_gateway/internal/middleware"
+	"api_gateway/internal/modes"
+	"api_gateway/internal/openapi"
+	"api_gateway/internal/revocation"
+	"api_gateway/internal/routes"
+	"api_gateway/internal/security"
 	"api_gateway/internal/telemetry"
+	"api_gateway/internal/upstream"
 	"context"
+	"errors"
 	"log"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	_ "api_gateway/docs"
+	"api_gateway/docs"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/swagger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
 )
 
 // @title API Gateway
@@ -59,6 +75,7 @@ func main() {
 		ServiceVersion: cfg.OTelServiceVersion,
 		Environment:    cfg.OTelEnvironment,
 		OTelEndpoint:   cfg.OTelEndpoint,
+		Prometheus:     cfg.PrometheusEnabled,
 	})
 	if err != nil {
 		log.Fatalf("Failed to initialize OpenTelemetry: %v", err)
@@ -78,19 +95,140 @@ func main() {
 		log.Fatalf("Failed to initialize metrics: %v", err)
 	}
 
-	// Initialize rate limiter with Redis
-	rateLimiter, err := middleware.NewRateLimiter(cfg)
+	// Connect Redis (shared by rate limiter and token revocation)
+	redisClient, err := config.ConnectRedis(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize rate limiter: %v", err)
+		log.Fatalf("Failed to connect to Redis: %v", err)
 	}
 	defer func() {
-		if err := rateLimiter.Close(); err != nil {
-			slog.Error("Error closing rate limiter", "error", err)
+		if err := redisClient.Close(); err != nil {
+			slog.Error("Error closing Redis client", "error", err)
 		}
 	}()
 
+	// Initialize rate limiter with Redis
+	rateLimiter, err := middleware.NewRateLimiter(cfg, redisClient)
+	if err != nil {
+		log.Fatalf("Failed to initialize rate limiter: %v", err)
+	}
+	rateLimiter.StartOverrideRefresh(ctx, time.Duration(cfg.RateLimitOverrideRefresh)*time.Second)
+
+	// Idempotency-Key store for retried mutating requests
+	idempotencyStore := idempotency.NewStore(redisClient,
+		time.Duration(cfg.IdempotencyLockTTL)*time.Second,
+		time.Duration(cfg.IdempotencyTTL)*time.Second,
+	)
+
+	// API keys and client credentials for partner integrations
+	apiKeyStore := apikey.NewStore(redisClient)
+	apiKeyAuth := middleware.NewAPIKeyAuth(cfg, apiKeyStore)
+
+	// Token revocation list
+	revocationStore := revocation.NewStore(redisClient, time.Duration(cfg.RevocationWatermarkTTL)*time.Second)
+
+	// Load JWKS key set for verifying user access tokens
+	keySet, err := middleware.NewKeySet(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize JWKS key set: %v", err)
+	}
+	keySet.Start(ctx)
+	rateLimiter.SetUserVerifier(middleware.VerifiedUserID(cfg, keySet))
+
+	// Load and validate route table, then watch for changes
+	routeRegistry, err := routes.NewRegistry(cfg.RoutesFile)
+	if err != nil {
+		log.Fatalf("Failed to load route table: %v", err)
+	}
+
+	// Load balancing across upstream endpoints with active health checks
+	balancer := upstream.NewBalancer(routeRegistry.Table(), upstream.HealthSettings{
+		Interval:           time.Duration(cfg.HealthCheckInterval) * time.Second,
+		Timeout:            time.Duration(cfg.HealthCheckTimeout) * time.Second,
+		UnhealthyThreshold: cfg.HealthCheckUnhealthyThreshold,
+		HealthyThreshold:   cfg.HealthCheckHealthyThreshold,
+	})
+	routeRegistry.OnReload(balancer.Sync)
+	routeRegistry.Watch(ctx, time.Duration(cfg.RoutesReloadInterval)*time.Second)
+	balancer.StartHealthChecks(ctx)
+
+	// Circuit breaker per upstream
+	var breakers *breaker.Registry
+	if cfg.CircuitBreakerEnabled {
+		breakers, err = breaker.NewRegistry(breaker.Settings{
+			Window:                time.Duration(cfg.CircuitBreakerWindow) * time.Second,
+			MinRequests:           cfg.CircuitBreakerMinRequests,
+			FailureRateThreshold:  cfg.CircuitBreakerFailureRate,
+			SlowCallDuration:      time.Duration(cfg.CircuitBreakerSlowCallDuration) * time.Millisecond,
+			SlowCallRateThreshold: cfg.CircuitBreakerSlowCallRate,
+			ConsecutiveFailures:   cfg.CircuitBreakerConsecutiveFailures,
+			OpenTimeout:           time.Duration(cfg.CircuitBreakerOpenTimeout) * time.Second,
+			HalfOpenMaxRequests:   cfg.CircuitBreakerHalfOpenRequests,
+		}, func(upstream string, from, to breaker.State) {
+			metrics.CircuitBreakerTransitions.Add(ctx, 1, metric.WithAttributes(
+				attribute.String("upstream", upstream),
+				attribute.String("from", from.String()),
+				attribute.String("to", to.String()),
+			))
+		})
+		if err != nil {
+			log.Fatalf("Invalid circuit breaker settings: %v", err)
+		}
+		err = metrics.RegisterCircuitBreakerStates(func() map[string]int64 {
+			states := breakers.States()
+			values := make(map[string]int64, len(states))
+			for upstream, state := range states {
+				values[upstream] = int64(state)
+			}
+			return values
+		})
+		if err != nil {
+			log.Fatalf("Failed to register circuit breaker metrics: %v", err)
+		}
+	}
+
+	// Security policy: CORS allowlist theo môi trường, security header, IP allow/deny
+	var corsOrigins []string
+	if cfg.CORSAllowedOrigins != "" {
+		corsOrigins = strings.Split(cfg.CORSAllowedOrigins, ",")
+	}
+	securityPolicy, err := security.Load(cfg.SecurityPolicyFile, cfg.OTelEnvironment, corsOrigins)
+	if errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Security policy file not found, using defaults", "file", cfg.SecurityPolicyFile)
+		securityPolicy, err = security.Default(cfg.OTelEnvironment, corsOrigins)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load security policy: %v", err)
+	}
+	slog.Info("Security policy loaded",
+		"environment", cfg.OTelEnvironment,
+		"cors_origins", securityPolicy.CORS.Allowed,
+		"ip_rules", len(securityPolicy.IPRules),
+	)
+	ipFilter := security.NewIPFilter(redisClient, securityPolicy.IPRules)
+	ipFilter.StartRefresh(ctx, time.Duration(cfg.IPRulesRefresh)*time.Second)
+
+	// Chế độ bảo trì / chỉ đọc, bật tắt qua /admin/modes và dùng chung cho mọi instance qua Redis
+	modeSwitch := modes.NewSwitch(redisClient)
+	modeSwitch.StartRefresh(ctx, time.Duration(cfg.ModesRefresh)*time.Second)
+
+	// Server-Sent Events: đọc Redis Stream mà comment-service và order-service ghi event của hub WebSocket
+	var eventBroker *events.Broker
+	if cfg.SSEEnabled {
+		eventsRedis := config.ConnectEventsRedis(cfg)
+		defer func() {
+			if err := eventsRedis.Close(); err != nil {
+				slog.Error("Error closing events Redis client", "error", err)
+			}
+		}()
+		eventBroker = events.NewBroker(eventsRedis, cfg.SSEMaxChannels)
+	}
+
 	// Create Fiber app
-	app := fiber.New(fiber.Config{
+	fiberConfig := fiber.Config{
+		// Stream request body tới upstream thay vì buffer toàn bộ trong gateway
+		StreamRequestBody: true,
+		// Giới hạn tổng kích thước header, request vượt quá nhận 431
+		ReadBufferSize: cfg.MaxHeaderBytes,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -100,13 +238,31 @@ func main() {
 				"error": err.Error(),
 			})
 		},
-	})
+	}
+	// Sau load balancer: chỉ lấy IP client từ header khi request đến từ proxy tin cậy
+	if cfg.TrustedProxies != "" {
+		fiberConfig.EnableTrustedProxyCheck = true
+		fiberConfig.TrustedProxies = strings.Split(cfg.TrustedProxies, ",")
+		fiberConfig.ProxyHeader = cfg.ClientIPHeader
+		fiberConfig.EnableIPValidation = true
+	}
+	app := fiber.New(fiberConfig)
 
 	// Middleware
 	app.Use(recover.New())
+	app.Use(middleware.RequestIDMiddleware())
 	app.Use(middleware.TracingMiddleware())
+	app.Use(middleware.SecurityHeadersMiddleware(securityPolicy))
+
+	// IP deny list và CORS trước rate limiter: IP bị chặn không tốn quota,
+	// response 429 vẫn có CORS header để browser đọc được
+	app.Use(middleware.IPFilterMiddleware(ipFilter))
+	app.Use(middleware.CORSMiddleware(securityPolicy))
+
+	// Chế độ bảo trì: 503 cho mọi route trừ health check, metrics và admin
+	app.Use(middleware.MaintenanceMiddleware(cfg, modeSwitch))
 	
-	// Apply rate limiting middleware (before logging and CORS)
+	// Apply rate limiting middleware (before logging)
 	app.Use(rateLimiter.Middleware())
 	
 	// Custom structured logger middleware
@@ -136,88 +292,159 @@ func main() {
 		if email, ok := c.Locals("email").(string); ok && email != "" {
 			logAttrs = append(logAttrs, slog.String("user_email", email))
 		}
+		if clientID, ok := c.Locals("clientID").(string); ok && clientID != "" {
+			logAttrs = append(logAttrs, slog.String("client_id", clientID))
+		}
 		
-		// Log level based on status code
+		// Log level based on status code, request_id và trace_id lấy từ context
+		log := logger.WithContext(c.UserContext())
 		if status >= 500 {
-			slog.Error("Request completed with server error", logAttrs...)
+			log.Error("Request completed with server error", logAttrs...)
 		} else if status >= 400 {
-			slog.Warn("Request completed with client error", logAttrs...)
+			log.Warn("Request completed with client error", logAttrs...)
 		} else {
-			slog.Info("Request completed successfully", logAttrs...)
+			log.Info("Request completed successfully", logAttrs...)
 		}
 		
 		return err
 	})
 
-	// CORS middleware - CRITICAL: Must be enabled for frontend to work properly
-	// This handles OPTIONS preflight requests from browsers
-	app.Use(func(c *fiber.Ctx) error {
-		c.Set("Access-Control-Allow-Origin", "*")
-		c.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
-		c.Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-User-Token, X-Internal-JWT")
-		c.Set("Access-Control-Allow-Credentials", "true")
-		c.Set("Access-Control-Max-Age", "86400") // 24 hours cache for preflight
-
-		// Handle preflight OPTIONS request
-		if c.Method() == "OPTIONS" {
-			return c.SendStatus(fiber.StatusOK)
-		}
-
-		return c.Next()
-	})
-
 	// Swagger
 	app.Get("/swagger/*", swagger.HandlerDefault)
 
 	// Initialize handlers
-	proxyHandler := handlers.NewProxyHandler(cfg)
+	proxyHandler := handlers.NewProxyHandler(cfg, balancer, breakers)
+	revocationHandler := handlers.NewRevocationHandler(revocationStore)
+	routeHandler := handlers.NewRouteHandler(routeRegistry, balancer)
+	breakerHandler := handlers.NewBreakerHandler(breakers)
+	rateLimitHandler := handlers.NewRateLimitHandler(rateLimiter, time.Duration(cfg.RateLimitOverrideMaxTTL)*time.Second)
+	securityHandler := handlers.NewSecurityHandler(securityPolicy, ipFilter)
+	modeHandler := handlers.NewModeHandler(modeSwitch, routeRegistry)
+
+	// Response cache for routes with a cache block in the route table
+	var cacheStore cache.Store
+	if cfg.CacheEnabled {
+		switch cfg.CacheStore {
+		case "redis":
+			cacheStore = cache.NewRedisStore(redisClient)
+		default:
+			cacheStore = cache.NewMemoryStore(cfg.CacheMaxEntries)
+		}
+	}
+	responseCache := middleware.NewResponseCache(cfg, cacheStore, proxyHandler)
+	cacheHandler := handlers.NewCacheHandler(cacheStore)
+	idempotencyMiddleware := middleware.NewIdempotency(cfg, idempotencyStore)
+	pageHandler := handlers.NewPageHandler(cfg, routeRegistry, proxyHandler)
+	openAPIHandler := handlers.NewOpenAPIHandler(openapi.NewAggregator(routeRegistry, proxyHandler, docs.SwaggerInfo.ReadDoc, openapi.Info{
+		Title:       "Online Auction API",
+		Description: "API public của hệ thống đấu giá qua API Gateway, tổng hợp từ spec của các service",
+		Version:     cfg.OTelServiceVersion,
+	}, time.Duration(cfg.OpenAPICacheTTL)*time.Second))
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyStore, apiKeyAuth, routeRegistry,
+		time.Duration(cfg.APIKeyRotationGrace)*time.Second,
+		time.Duration(cfg.APIKeyMaxRotationGrace)*time.Second,
+	)
 
 	// Health check
 	app.Get("/health", proxyHandler.HealthCheck)
 
-	// API routes with authentication
-	api := app.Group("/api")
-
-	// Auth service routes (no auth required for login/register)
-	auth := api.Group("/auth")
-	auth.All("/*", middleware.ProxyMiddlewareForAuthenService(cfg), proxyHandler.ProxyRequest(cfg.AuthServiceURL))
-
-	// Protected routes - require authentication
-	protected := api.Group("", middleware.AuthMiddleware(cfg))
-
-	// Category service
-	protected.All("/categories/*", middleware.ProxyMiddleware(cfg, cfg.CategoryServiceName), proxyHandler.ProxyRequest(cfg.CategoryServiceURL))
-
-	// Product service
-	protected.All("/products/*", middleware.ProxyMiddleware(cfg, cfg.ProductServiceName), proxyHandler.ProxyRequest(cfg.ProductServiceURL))
-
-	// User service
-	protected.All("/users/*", middleware.ProxyMiddleware(cfg, cfg.UserServiceName), proxyHandler.ProxyRequest(cfg.UserServiceURL))
-
-	// Bidding service
-	protected.All("/bids/*", middleware.ProxyMiddleware(cfg, cfg.BiddingServiceName), proxyHandler.ProxyRequest(cfg.BiddingServiceURL))
-
-	// Order service
-	protected.All("/orders/data/*", middleware.ProxyMiddleware(cfg, cfg.OrderServiceName), proxyHandler.ProxyRequest(cfg.OrderServiceURL))
-	protected.All("/order-websocket/*", middleware.ProxyMiddleware(cfg, cfg.OrderServiceName), proxyHandler.OrderProxyWebSocket)
-
-	// Payment service
-	protected.All("/payments/*", middleware.ProxyMiddleware(cfg, cfg.PaymentServiceName), proxyHandler.ProxyRequest(cfg.PaymentServiceURL))
-
-	// Notification service
-	protected.All("/notifications/*", middleware.ProxyMiddleware(cfg, cfg.NotificationServiceName), proxyHandler.ProxyRequest(cfg.NotificationServiceURL))
+	// Prometheus scrape endpoint (metrics cũng được đẩy qua OTLP)
+	if handler := telemetry.PrometheusHandler(); handler != nil {
+		app.Get("/metrics", adaptor.HTTPHandler(handler))
+	}
 
-	// Media service
-	protected.All("/media/*", middleware.ProxyMiddleware(cfg, cfg.MediaServiceName), proxyHandler.ProxyRequest(cfg.MediaServiceURL))
+	// Aggregated OpenAPI document of every upstream with an openapi block in the route table
+	app.Get("/openapi.json", openAPIHandler.Spec)
+	app.Get("/docs/*", swagger.New(swagger.Config{URL: "/openapi.json"}))
+
+	// Internal endpoints, only callable by internal services with X-Internal-JWT
+	internal := app.Group("/internal")
+	internal.Post("/revocations", middleware.InternalServiceAuth(cfg, cfg.UserServiceName, "auth-service"), revocationHandler.Revoke)
+	internal.Post("/cache/invalidate", middleware.InternalServiceAuth(cfg,
+		cfg.CategoryServiceName,
+		cfg.ProductServiceName,
+		cfg.SearchServiceName,
+		cfg.UserServiceName,
+		cfg.BiddingServiceName,
+		cfg.CommentServiceName,
+	), cacheHandler.Invalidate)
+
+	// Admin endpoints, require ROLE_ADMIN
+	admin := app.Group("/admin", middleware.AuthMiddleware(cfg, keySet, revocationStore), middleware.RequireRoles("ROLE_ADMIN"))
+	admin.Get("/routes", routeHandler.ListRoutes)
+	admin.Get("/upstreams", routeHandler.ListUpstreams)
+	admin.Get("/circuit-breakers", breakerHandler.ListBreakers)
+	admin.Post("/circuit-breakers/:upstream/reset", breakerHandler.ResetBreaker)
+	admin.Get("/rate-limits/policies", rateLimitHandler.ListPolicies)
+	admin.Get("/rate-limits/top", rateLimitHandler.TopOffenders)
+	admin.Get("/rate-limits/usage", rateLimitHandler.Usage)
+	admin.Post("/rate-limits/reset", rateLimitHandler.Reset)
+	admin.Get("/rate-limits/overrides", rateLimitHandler.ListOverrides)
+	admin.Post("/rate-limits/overrides", rateLimitHandler.SetOverride)
+	admin.Delete("/rate-limits/overrides/:subject", rateLimitHandler.DeleteOverride)
+	admin.Post("/cache/invalidate", cacheHandler.Invalidate)
+	admin.Get("/security/policy", securityHandler.GetPolicy)
+	admin.Get("/ip-rules", securityHandler.ListIPRules)
+	admin.Post("/ip-rules", securityHandler.SetIPRule)
+	admin.Delete("/ip-rules/:cidr", securityHandler.DeleteIPRule)
+	admin.Get("/modes", modeHandler.GetModes)
+	admin.Put("/modes/maintenance", modeHandler.SetMaintenance)
+	admin.Put("/modes/read-only", modeHandler.SetReadOnly)
+	admin.Get("/api-keys", apiKeyHandler.ListKeys)
+	admin.Post("/api-keys", apiKeyHandler.CreateKey)
+	admin.Get("/api-keys/:id", apiKeyHandler.GetKey)
+	admin.Post("/api-keys/:id/rotate", apiKeyHandler.RotateKey)
+	admin.Delete("/api-keys/:id", apiKeyHandler.RevokeKey)
+
+	// API routes
+	api := app.Group("/api")
 
-	// Comment service
-	protected.All("/comments/history/*", middleware.ProxyMiddleware(cfg, cfg.CommentServiceName), proxyHandler.ProxyRequest(cfg.CommentServiceURL))
-	protected.All("/comments/websocket/*", middleware.ProxyMiddleware(cfg, cfg.CommentServiceName), proxyHandler.ProxyWebSocket)
-	// Search service
-	protected.All("/search/*", middleware.ProxyMiddleware(cfg, cfg.SearchServiceName), proxyHandler.ProxyRequest(cfg.ProductServiceURL))
+	// Logout is handled by the gateway itself (token revocation list)
+	api.Post("/auth/logout", middleware.AuthMiddleware(cfg, keySet, revocationStore), revocationHandler.Logout)
+	api.Post("/auth/logout-all", middleware.AuthMiddleware(cfg, keySet, revocationStore), revocationHandler.LogoutAll)
+
+	// OAuth 2.0 client credentials for partners, exchanges an API key for a short-lived access token
+	api.Post("/oauth/token", apiKeyHandler.Token)
+
+	// Aggregated pages for the frontend, fan out to several upstreams in one request
+	api.Get("/pages/product/:id",
+		middleware.AuthMiddleware(cfg, keySet, revocationStore),
+		rateLimiter.AfterAuthMiddleware(),
+		pageHandler.ProductPage,
+	)
+
+	// Live product comments and order chat over Server-Sent Events, fallback for clients without WebSocket
+	if eventBroker != nil {
+		eventHandler := handlers.NewEventHandler(cfg, routeRegistry, proxyHandler, eventBroker)
+		api.Get("/events/products/:id",
+			middleware.EventStreamMiddleware(),
+			middleware.AuthMiddleware(cfg, keySet, revocationStore),
+			rateLimiter.AfterAuthMiddleware(),
+			eventHandler.ProductEvents,
+		)
+		api.Get("/events/orders/:id",
+			middleware.EventStreamMiddleware(),
+			middleware.AuthMiddleware(cfg, keySet, revocationStore),
+			rateLimiter.AfterAuthMiddleware(),
+			eventHandler.OrderEvents,
+		)
+	}
 
-	// Auto Bidding service
-	protected.All("/auto-bidding/*", middleware.ProxyMiddleware(cfg, cfg.AutoBiddingServiceName), proxyHandler.ProxyRequest(cfg.BiddingServiceURL))
+	// Upstream routes from the route table (ROUTES_FILE), hot reloaded on change
+	api.All("/*",
+		middleware.RouteMiddleware(routeRegistry),
+		middleware.RequestLimitsMiddleware(cfg),
+		middleware.ReadOnlyMiddleware(cfg, modeSwitch),
+		middleware.AuthMiddleware(cfg, keySet, revocationStore),
+		apiKeyAuth.Middleware(),
+		middleware.RouteAccessMiddleware(),
+		rateLimiter.AfterAuthMiddleware(),
+		middleware.TrafficSplitMiddleware(cfg),
+		middleware.ProxyMiddleware(cfg),
+		idempotencyMiddleware.Middleware(),
+		responseCache.Middleware(),
+		proxyHandler.Proxy,
+	)
 
 	// Setup graceful shutdown
 	quit := make(chan os.Signal, 1)
@@ -235,6 +462,11 @@ func main() {
 	<-quit
 	slog.Info("Shutting down server...")
 
+	// Đóng các SSE stream trước, nếu không shutdown phải chờ tới timeout
+	if eventBroker != nil {
+		eventBroker.Close()
+	}
+
 	// Graceful shutdown
 	if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
 		slog.Error("Server shutdown error", "error", err)
@@ -0,0 +1,433 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keyPrefix   = "apikey:"
+	indexKey    = "apikeys"
+	quotaPrefix = "apikey-quota:"
+	idPrefix    = "ak_"
+)
+
+var (
+	ErrNotFound           = errors.New("api key not found")
+	ErrInvalidCredentials = errors.New("invalid api key")
+	ErrRevoked            = errors.New("api key has been revoked")
+	ErrExpired            = errors.New("api key has expired")
+	ErrInvalidKey         = errors.New("invalid api key definition")
+)
+
+// scopePattern: <route>:read | <route>:write | <route>:*, route "*" áp dụng cho mọi route
+var scopePattern = regexp.MustCompile(`^(\*|[a-z0-9][a-z0-9-]*):(read|write|\*)$`)
+
+// consumeQuotaScript kiểm tra và tăng bộ đếm theo phút và theo ngày của một key trong một lần gọi.
+// KEYS = counter phút, counter ngày
+// ARGV = giới hạn/phút, giới hạn/ngày (0 = không giới hạn), TTL counter phút (ms), TTL counter ngày (ms)
+// Trả về {allowed, window bị vượt (0 = không, 1 = phút, 2 = ngày), số request trong phút, số request trong ngày}
+var consumeQuotaScript = redis.NewScript(`
+local minute = tonumber(redis.call('GET', KEYS[1]) or '0')
+local day = tonumber(redis.call('GET', KEYS[2]) or '0')
+local perMinute = tonumber(ARGV[1])
+local perDay = tonumber(ARGV[2])
+
+if perMinute > 0 and minute >= perMinute then
+	return {0, 1, minute, day}
+end
+if perDay > 0 and day >= perDay then
+	return {0, 2, minute, day}
+end
+
+minute = redis.call('INCR', KEYS[1])
+if minute == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+day = redis.call('INCR', KEYS[2])
+if day == 1 then
+	redis.call('PEXPIRE', KEYS[2], ARGV[4])
+end
+return {1, 0, minute, day}
+`)
+
+// Quota giới hạn số request của một key, 0 = không giới hạn
+type Quota struct {
+	PerMinute int64 `json:"per_minute"`
+	PerDay    int64 `json:"per_day"`
+}
+
+// Key là API key của một đối tác. ID là client_id, secret chỉ được trả về một lần khi cấp hoặc rotate,
+// Redis chỉ lưu sha256 của secret.
+type Key struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner,omitempty"`
+	Scopes    []string  `json:"scopes"`
+	Quota     Quota     `json:"quota"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"` // zero = không hết hạn
+	RotatedAt time.Time `json:"rotated_at,omitempty"`
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+
+	SecretHash string `json:"secret_hash"`
+	// Secret trước khi rotate còn được chấp nhận tới PreviousExpiresAt để đối tác kịp cập nhật
+	PreviousHash      string    `json:"previous_hash,omitempty"`
+	PreviousExpiresAt time.Time `json:"previous_expires_at,omitempty"`
+}
+
+// Check trả về ErrRevoked / ErrExpired nếu key không còn dùng được
+func (k *Key) Check(now time.Time) error {
+	if !k.RevokedAt.IsZero() {
+		return ErrRevoked
+	}
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Allows kiểm tra key có scope cho route và method. GET/HEAD/OPTIONS cần read, method khác cần write.
+func (k *Key) Allows(route, method string) bool {
+	return Allows(k.Scopes, route, method)
+}
+
+// Allows kiểm tra danh sách scope có cho phép route và method
+func Allows(scopes []string, route, method string) bool {
+	access := "write"
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		access = "read"
+	}
+	for _, scope := range scopes {
+		r, a, _ := strings.Cut(scope, ":")
+		if (r == "*" || r == route) && (a == "*" || a == access) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateScopes kiểm tra định dạng scope: <route>:read, <route>:write hoặc <route>:*
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidKey)
+	}
+	for _, scope := range scopes {
+		if !scopePattern.MatchString(scope) {
+			return fmt.Errorf("%w: invalid scope %q, expected <route>:read|write|*", ErrInvalidKey, scope)
+		}
+	}
+	return nil
+}
+
+// QuotaResult là kết quả kiểm tra quota của một request
+type QuotaResult struct {
+	Allowed bool
+	Window  string // minute | day: window bị vượt, hoặc window còn ít request nhất
+	Limit   int64
+	Used    int64
+	ResetAt time.Time
+}
+
+// Remaining là số request còn lại trong window
+func (r QuotaResult) Remaining() int64 {
+	return max(r.Limit-r.Used, 0)
+}
+
+// Usage là số request của key trong phút và ngày hiện tại (UTC)
+type Usage struct {
+	Minute int64 `json:"minute"`
+	Day    int64 `json:"day"`
+}
+
+// Store lưu API key trong Redis:
+//   - apikey:<id>                  : JSON của Key
+//   - apikeys                      : set các id để liệt kê
+//   - apikey-quota:<id>:m|d:<time> : bộ đếm quota theo phút / ngày
+type Store struct {
+	client *redis.Client
+}
+
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client}
+}
+
+// Create cấp key mới và trả về secret dạng rõ (chỉ trả một lần)
+func (s *Store) Create(ctx context.Context, k *Key) (string, error) {
+	if strings.TrimSpace(k.Name) == "" {
+		return "", fmt.Errorf("%w: name is required", ErrInvalidKey)
+	}
+	if err := ValidateScopes(k.Scopes); err != nil {
+		return "", err
+	}
+	if k.Quota.PerMinute < 0 || k.Quota.PerDay < 0 {
+		return "", fmt.Errorf("%w: quota must not be negative", ErrInvalidKey)
+	}
+
+	id, err := randomToken(8, hex.EncodeToString)
+	if err != nil {
+		return "", err
+	}
+	secret, err := randomToken(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", err
+	}
+
+	k.ID = idPrefix + id
+	k.CreatedAt = time.Now()
+	k.SecretHash = hashSecret(secret)
+
+	data, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	created, err := s.client.SetNX(ctx, keyPrefix+k.ID, data, 0).Result()
+	if err != nil {
+		return "", err
+	}
+	if !created {
+		return "", fmt.Errorf("api key id collision: %s", k.ID)
+	}
+	if err := s.client.SAdd(ctx, indexKey, k.ID).Err(); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Get trả về key theo id, ErrNotFound nếu không tồn tại
+func (s *Store) Get(ctx context.Context, id string) (*Key, error) {
+	data, err := s.client.Get(ctx, keyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var k Key
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("invalid api key record %s: %w", id, err)
+	}
+	return &k, nil
+}
+
+// List trả về mọi key, sắp xếp theo thời điểm tạo
+func (s *Store) List(ctx context.Context) ([]*Key, error) {
+	ids, err := s.client.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*Key, 0, len(ids))
+	for _, id := range ids {
+		k, err := s.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			s.client.SRem(ctx, indexKey, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Rotate cấp secret mới cho key. Secret cũ còn được chấp nhận trong khoảng grace.
+func (s *Store) Rotate(ctx context.Context, id string, grace time.Duration) (*Key, string, error) {
+	secret, err := randomToken(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+
+	k, err := s.update(ctx, id, func(k *Key, now time.Time) error {
+		if err := k.Check(now); err != nil {
+			return err
+		}
+		k.PreviousHash, k.PreviousExpiresAt = "", time.Time{}
+		if grace > 0 {
+			k.PreviousHash = k.SecretHash
+			k.PreviousExpiresAt = now.Add(grace)
+		}
+		k.SecretHash = hashSecret(secret)
+		k.RotatedAt = now
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return k, secret, nil
+}
+
+// Revoke thu hồi key ngay lập tức, record được giữ lại để tra cứu
+func (s *Store) Revoke(ctx context.Context, id string) (*Key, error) {
+	return s.update(ctx, id, func(k *Key, now time.Time) error {
+		if k.RevokedAt.IsZero() {
+			k.RevokedAt = now
+		}
+		k.PreviousHash, k.PreviousExpiresAt = "", time.Time{}
+		return nil
+	})
+}
+
+// update đọc, sửa và ghi lại key trong một transaction (WATCH) để các thao tác admin đồng thời không ghi đè nhau
+func (s *Store) update(ctx context.Context, id string, fn func(*Key, time.Time) error) (*Key, error) {
+	var updated *Key
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, keyPrefix+id).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		var k Key
+		if err := json.Unmarshal(data, &k); err != nil {
+			return fmt.Errorf("invalid api key record %s: %w", id, err)
+		}
+		if err := fn(&k, time.Now()); err != nil {
+			return err
+		}
+		if data, err = json.Marshal(&k); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, keyPrefix+id, data, 0)
+			return nil
+		})
+		updated = &k
+		return err
+	}, keyPrefix+id)
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, fmt.Errorf("api key %s was modified concurrently, retry", id)
+	}
+	return updated, err
+}
+
+// Authenticate kiểm tra API key dạng <id>.<secret> và trả về key nếu còn hiệu lực
+func (s *Store) Authenticate(ctx context.Context, raw string) (*Key, error) {
+	id, secret, ok := strings.Cut(raw, ".")
+	if !ok || !strings.HasPrefix(id, idPrefix) || secret == "" {
+		return nil, ErrInvalidCredentials
+	}
+	return s.AuthenticateClient(ctx, id, secret)
+}
+
+// AuthenticateClient kiểm tra client_id và client_secret (OAuth client credentials)
+func (s *Store) AuthenticateClient(ctx context.Context, id, secret string) (*Key, error) {
+	k, err := s.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	hash := hashSecret(secret)
+	valid := subtle.ConstantTimeCompare([]byte(hash), []byte(k.SecretHash)) == 1
+	if !valid && k.PreviousHash != "" && now.Before(k.PreviousExpiresAt) {
+		valid = subtle.ConstantTimeCompare([]byte(hash), []byte(k.PreviousHash)) == 1
+	}
+	if !valid {
+		return nil, ErrInvalidCredentials
+	}
+	if err := k.Check(now); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Consume tính một request vào quota của key (window cố định theo phút và theo ngày, UTC)
+func (s *Store) Consume(ctx context.Context, k *Key) (QuotaResult, error) {
+	if k.Quota.PerMinute <= 0 && k.Quota.PerDay <= 0 {
+		return QuotaResult{Allowed: true}, nil
+	}
+
+	now := time.Now().UTC()
+	minuteReset := now.Truncate(time.Minute).Add(time.Minute)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	dayReset := dayStart.AddDate(0, 0, 1)
+
+	values, err := consumeQuotaScript.Run(ctx, s.client,
+		[]string{minuteKey(k.ID, now), dayKey(k.ID, now)},
+		k.Quota.PerMinute, k.Quota.PerDay,
+		minuteReset.Sub(now).Milliseconds()+1000, dayReset.Sub(now).Milliseconds()+1000,
+	).Int64Slice()
+	if err != nil {
+		return QuotaResult{}, err
+	}
+	if len(values) != 4 {
+		return QuotaResult{}, fmt.Errorf("unexpected quota script result: %v", values)
+	}
+
+	minute := QuotaResult{Window: "minute", Limit: k.Quota.PerMinute, Used: values[2], ResetAt: minuteReset}
+	day := QuotaResult{Window: "day", Limit: k.Quota.PerDay, Used: values[3], ResetAt: dayReset}
+	switch values[1] {
+	case 1:
+		return minute, nil
+	case 2:
+		return day, nil
+	}
+
+	minute.Allowed, day.Allowed = true, true
+	if k.Quota.PerMinute <= 0 {
+		return day, nil
+	}
+	if k.Quota.PerDay <= 0 || minute.Remaining() < day.Remaining() {
+		return minute, nil
+	}
+	return day, nil
+}
+
+// Usage trả về số request của key trong phút và ngày hiện tại
+func (s *Store) Usage(ctx context.Context, id string) (Usage, error) {
+	now := time.Now().UTC()
+	values, err := s.client.MGet(ctx, minuteKey(id, now), dayKey(id, now)).Result()
+	if err != nil {
+		return Usage{}, err
+	}
+	var usage Usage
+	if v, ok := values[0].(string); ok {
+		fmt.Sscan(v, &usage.Minute)
+	}
+	if v, ok := values[1].(string); ok {
+		fmt.Sscan(v, &usage.Day)
+	}
+	return usage, nil
+}
+
+func minuteKey(id string, now time.Time) string {
+	return fmt.Sprintf("%s%s:m:%d", quotaPrefix, id, now.Unix()/60)
+}
+
+func dayKey(id string, now time.Time) string {
+	return fmt.Sprintf("%s%s:d:%s", quotaPrefix, id, now.Format("20060102"))
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
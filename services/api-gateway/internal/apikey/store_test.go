@@ -0,0 +1,225 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewStore(client)
+}
+
+func createKey(t *testing.T, s *Store, quota Quota) (*Key, string) {
+	t.Helper()
+	k := &Key{Name: "Shopee Affiliate", Owner: "partner@example.com", Scopes: []string{"products:read"}, Quota: quota}
+	secret, err := s.Create(context.Background(), k)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	return k, secret
+}
+
+func TestAllows(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []string
+		route  string
+		method string
+		want   bool
+	}{
+		{"read scope allows GET", []string{"products:read"}, "products", "GET", true},
+		{"read scope allows HEAD", []string{"products:read"}, "products", "HEAD", true},
+		{"read scope rejects POST", []string{"products:read"}, "products", "POST", false},
+		{"write scope rejects GET", []string{"products:write"}, "products", "GET", false},
+		{"write scope allows DELETE", []string{"products:write"}, "products", "DELETE", true},
+		{"route wildcard access", []string{"products:*"}, "products", "PATCH", true},
+		{"other route", []string{"products:*"}, "orders", "GET", false},
+		{"any route read", []string{"*:read"}, "orders", "GET", true},
+		{"any route read rejects write", []string{"*:read"}, "orders", "PUT", false},
+		{"second scope matches", []string{"search:read", "orders:write"}, "orders", "POST", true},
+		{"no scopes", nil, "products", "GET", false},
+	}
+	for _, tt := range tests {
+		if got := Allows(tt.scopes, tt.route, tt.method); got != tt.want {
+			t.Errorf("%s: Allows(%v, %q, %q) = %v, want %v", tt.name, tt.scopes, tt.route, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		wantErr bool
+	}{
+		{"valid scopes", []string{"products:read", "order-service:write", "*:*"}, false},
+		{"empty", nil, true},
+		{"missing access", []string{"products"}, true},
+		{"unknown access", []string{"products:admin"}, true},
+		{"uppercase route", []string{"Products:read"}, true},
+	}
+	for _, tt := range tests {
+		err := ValidateScopes(tt.scopes)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ValidateScopes(%v) error = %v, wantErr %v", tt.name, tt.scopes, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%s: error %v is not ErrInvalidKey", tt.name, err)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	k, secret := createKey(t, s, Quota{})
+
+	if k.SecretHash == secret || k.SecretHash != hashSecret(secret) {
+		t.Fatal("store must keep only the hash of the secret")
+	}
+
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr error
+	}{
+		{"valid key", k.ID + "." + secret, nil},
+		{"wrong secret", k.ID + ".not-the-secret", ErrInvalidCredentials},
+		{"unknown id", "ak_0000000000000000." + secret, ErrInvalidCredentials},
+		{"missing separator", k.ID + secret, ErrInvalidCredentials},
+		{"missing prefix", "0000." + secret, ErrInvalidCredentials},
+		{"empty secret", k.ID + ".", ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		got, err := s.Authenticate(ctx, tt.raw)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: Authenticate() error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr == nil && got.ID != k.ID {
+			t.Errorf("%s: Authenticate() = %s, want %s", tt.name, got.ID, k.ID)
+		}
+	}
+}
+
+func TestRotateAndRevoke(t *testing.T) {
+	s := newTestStore(t)
+	ctx := context.Background()
+	k, oldSecret := createKey(t, s, Quota{})
+
+	// Secret cũ còn dùng được trong thời gian grace
+	_, newSecret, err := s.Rotate(ctx, k.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{oldSecret, newSecret} {
+		if _, err := s.AuthenticateClient(ctx, k.ID, secret); err != nil {
+			t.Errorf("AuthenticateClient() during grace error = %v", err)
+		}
+	}
+
+	// Rotate không có grace: chỉ secret mới nhất hợp lệ
+	_, latest, err := s.Rotate(ctx, k.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{oldSecret, newSecret} {
+		if _, err := s.AuthenticateClient(ctx, k.ID, secret); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("AuthenticateClient() with a rotated secret error = %v, want %v", err, ErrInvalidCredentials)
+		}
+	}
+
+	if _, err := s.Revoke(ctx, k.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticateClient(ctx, k.ID, latest); !errors.Is(err, ErrRevoked) {
+		t.Errorf("AuthenticateClient() after revoke error = %v, want %v", err, ErrRevoked)
+	}
+	if _, _, err := s.Rotate(ctx, k.ID, 0); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Rotate() of a revoked key error = %v, want %v", err, ErrRevoked)
+	}
+	if _, err := s.Revoke(ctx, "ak_missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Revoke() of an unknown key error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestExpiredKey(t *testing.T) {
+	s := newTestStore(t)
+	k := &Key{Name: "Trial", Scopes: []string{"search:read"}, ExpiresAt: time.Now().Add(-time.Minute)}
+	secret, err := s.Create(context.Background(), k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AuthenticateClient(context.Background(), k.ID, secret); !errors.Is(err, ErrExpired) {
+		t.Errorf("AuthenticateClient() error = %v, want %v", err, ErrExpired)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	// Các request của test phải nằm trong cùng một phút
+	if now := time.Now(); now.Second() >= 55 {
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+	}
+
+	tests := []struct {
+		name        string
+		quota       Quota
+		requests    int
+		wantAllowed []bool
+		wantWindow  string // window của request cuối
+		wantUsed    int64
+	}{
+		{"unlimited", Quota{}, 3, []bool{true, true, true}, "", 0},
+		{"per minute limit", Quota{PerMinute: 2}, 3, []bool{true, true, false}, "minute", 2},
+		{"per day limit", Quota{PerDay: 2}, 3, []bool{true, true, false}, "day", 2},
+		{"minute exceeded before day", Quota{PerMinute: 1, PerDay: 10}, 2, []bool{true, false}, "minute", 1},
+		{"day closer to its limit", Quota{PerMinute: 10, PerDay: 3}, 2, []bool{true, true}, "day", 2},
+	}
+	for _, tt := range tests {
+		s := newTestStore(t)
+		k, _ := createKey(t, s, tt.quota)
+
+		var last QuotaResult
+		for i := 0; i < tt.requests; i++ {
+			result, err := s.Consume(context.Background(), k)
+			if err != nil {
+				t.Fatalf("%s: Consume() error = %v", tt.name, err)
+			}
+			if result.Allowed != tt.wantAllowed[i] {
+				t.Errorf("%s: request %d allowed = %v, want %v", tt.name, i+1, result.Allowed, tt.wantAllowed[i])
+			}
+			last = result
+		}
+		if last.Window != tt.wantWindow || last.Used != tt.wantUsed {
+			t.Errorf("%s: last result window %q used %d, want %q used %d", tt.name, last.Window, last.Used, tt.wantWindow, tt.wantUsed)
+		}
+	}
+}
+
+func TestConsumeRejectedRequestIsNotCounted(t *testing.T) {
+	s := newTestStore(t)
+	k, _ := createKey(t, s, Quota{PerDay: 1})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.Consume(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usage, err := s.Usage(ctx, k.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.Day != 1 {
+		t.Errorf("day usage = %d, want 1 (rejected requests are not counted)", usage.Day)
+	}
+}
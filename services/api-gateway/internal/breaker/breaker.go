@@ -0,0 +1,332 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State là trạng thái của circuit breaker
+type State int
+
+const (
+	StateClosed State = iota
+	StateHalfOpen
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "unknown"
+	}
+}
+
+// Outcome là kết quả của một request đã được breaker cho phép
+type Outcome int
+
+const (
+	Success Outcome = iota
+	Failure
+	// Ignored dùng cho request bị huỷ vì lý do phía client (client ngắt kết nối, body quá lớn...)
+	Ignored
+)
+
+var (
+	// ErrOpen được trả về khi circuit đang mở, request bị từ chối ngay
+	ErrOpen = errors.New("circuit breaker is open")
+	// ErrTooManyProbes được trả về khi đang half-open và đã đủ số request thử
+	ErrTooManyProbes = errors.New("circuit breaker is half-open, too many probe requests")
+)
+
+// Settings cấu hình ngưỡng của circuit breaker
+type Settings struct {
+	Window                time.Duration // cửa sổ thống kê (rolling window, mỗi bucket 1 giây)
+	MinRequests           int           // số request tối thiểu trong window trước khi xét tỉ lệ lỗi
+	FailureRateThreshold  float64       // tỉ lệ lỗi (0..1) để mở circuit
+	SlowCallDuration      time.Duration // request chậm hơn ngưỡng này được tính là slow call
+	SlowCallRateThreshold float64       // tỉ lệ slow call (0..1) để mở circuit, 0 = tắt
+	ConsecutiveFailures   int           // số lỗi liên tiếp để mở circuit ngay (outlier detection), 0 = tắt
+	OpenTimeout           time.Duration // thời gian giữ trạng thái open trước khi chuyển half-open
+	HalfOpenMaxRequests   int           // số request thử khi half-open
+}
+
+// Validate kiểm tra settings để breaker không bị kẹt ở một trạng thái
+// (ví dụ HalfOpenMaxRequests = 0 thì half-open không bao giờ có request thử để đóng lại)
+func (s Settings) Validate() error {
+	var errs []error
+	if s.Window < time.Second {
+		errs = append(errs, fmt.Errorf("window must be at least 1s, got %s", s.Window))
+	}
+	if s.MinRequests < 0 {
+		errs = append(errs, fmt.Errorf("min requests must not be negative, got %d", s.MinRequests))
+	}
+	// Tỉ lệ 0 tắt điều kiện mở circuit tương ứng
+	if s.FailureRateThreshold < 0 || s.FailureRateThreshold > 1 {
+		errs = append(errs, fmt.Errorf("failure rate threshold must be in [0, 1], got %v", s.FailureRateThreshold))
+	}
+	if s.SlowCallRateThreshold < 0 || s.SlowCallRateThreshold > 1 {
+		errs = append(errs, fmt.Errorf("slow call rate threshold must be in [0, 1], got %v", s.SlowCallRateThreshold))
+	}
+	if s.SlowCallRateThreshold > 0 && s.SlowCallDuration <= 0 {
+		errs = append(errs, fmt.Errorf("slow call duration must be positive, got %s", s.SlowCallDuration))
+	}
+	if s.ConsecutiveFailures < 0 {
+		errs = append(errs, fmt.Errorf("consecutive failures must not be negative, got %d", s.ConsecutiveFailures))
+	}
+	if s.OpenTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("open timeout must be positive, got %s", s.OpenTimeout))
+	}
+	if s.HalfOpenMaxRequests < 1 {
+		errs = append(errs, fmt.Errorf("half-open max requests must be at least 1, got %d", s.HalfOpenMaxRequests))
+	}
+	return errors.Join(errs...)
+}
+
+type bucket struct {
+	second   int64
+	total    int
+	failures int
+	slow     int
+}
+
+// Breaker là circuit breaker của một upstream
+type Breaker struct {
+	name     string
+	settings Settings
+	onChange func(name string, from, to State)
+
+	mu                  sync.Mutex
+	state               State
+	generation          uint64
+	buckets             []bucket
+	consecutiveFailures int
+	openedAt            time.Time
+	halfOpenInFlight    int
+	halfOpenSuccesses   int
+}
+
+// Snapshot là trạng thái hiện tại của breaker, dùng cho admin endpoint
+type Snapshot struct {
+	Upstream            string    `json:"upstream"`
+	State               string    `json:"state"`
+	Requests            int       `json:"requests"`
+	Failures            int       `json:"failures"`
+	SlowCalls           int       `json:"slow_calls"`
+	FailureRate         float64   `json:"failure_rate"`
+	SlowCallRate        float64   `json:"slow_call_rate"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"`
+	RetryAfter          float64   `json:"retry_after_seconds,omitempty"`
+}
+
+func newBreaker(name string, settings Settings, onChange func(string, State, State)) *Breaker {
+	window := int(settings.Window / time.Second)
+	if window < 1 {
+		window = 1
+	}
+	return &Breaker{
+		name:     name,
+		settings: settings,
+		onChange: onChange,
+		buckets:  make([]bucket, window),
+	}
+}
+
+// Allow kiểm tra request có được gửi tới upstream không.
+// Nếu được phép, caller phải gọi done với kết quả của request.
+func (b *Breaker) Allow() (done func(outcome Outcome, latency time.Duration), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.state == StateOpen {
+		if now.Sub(b.openedAt) < b.settings.OpenTimeout {
+			return nil, ErrOpen
+		}
+		b.setState(StateHalfOpen, now)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.halfOpenInFlight >= b.settings.HalfOpenMaxRequests {
+			return nil, ErrTooManyProbes
+		}
+		b.halfOpenInFlight++
+	}
+
+	generation := b.generation
+	return func(outcome Outcome, latency time.Duration) {
+		b.record(generation, outcome, latency)
+	}, nil
+}
+
+// RetryAfter trả về thời gian còn lại trước khi circuit chuyển half-open
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != StateOpen {
+		return 0
+	}
+	remaining := b.settings.OpenTimeout - time.Since(b.openedAt)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// State trả về trạng thái hiện tại
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Reset đóng circuit và xoá thống kê
+func (b *Breaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.setState(StateClosed, time.Now())
+}
+
+// Snapshot trả về thống kê hiện tại của breaker
+func (b *Breaker) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	total, failures, slow := b.counts(time.Now().Unix())
+	s := Snapshot{
+		Upstream:            b.name,
+		State:               b.state.String(),
+		Requests:            total,
+		Failures:            failures,
+		SlowCalls:           slow,
+		ConsecutiveFailures: b.consecutiveFailures,
+	}
+	if total > 0 {
+		s.FailureRate = float64(failures) / float64(total)
+		s.SlowCallRate = float64(slow) / float64(total)
+	}
+	if b.state == StateOpen {
+		s.OpenedAt = b.openedAt
+		remaining := b.settings.OpenTimeout - time.Since(b.openedAt)
+		if remaining > 0 {
+			s.RetryAfter = remaining.Seconds()
+		}
+	}
+	return s
+}
+
+func (b *Breaker) record(generation uint64, outcome Outcome, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Kết quả của request được gửi trước khi breaker đổi trạng thái thì bỏ qua
+	if generation != b.generation {
+		return
+	}
+
+	now := time.Now()
+	success := outcome != Failure
+	slow := b.settings.SlowCallDuration > 0 && latency >= b.settings.SlowCallDuration
+
+	if b.state == StateHalfOpen {
+		b.halfOpenInFlight--
+		if outcome == Ignored {
+			return
+		}
+		if !success || slow {
+			b.setState(StateOpen, now)
+			return
+		}
+		b.halfOpenSuccesses++
+		if b.halfOpenSuccesses >= b.settings.HalfOpenMaxRequests {
+			b.setState(StateClosed, now)
+		}
+		return
+	}
+
+	if outcome == Ignored {
+		return
+	}
+
+	sec := now.Unix()
+	bk := &b.buckets[sec%int64(len(b.buckets))]
+	if bk.second != sec {
+		*bk = bucket{second: sec}
+	}
+	bk.total++
+	if !success {
+		bk.failures++
+		b.consecutiveFailures++
+	} else {
+		b.consecutiveFailures = 0
+	}
+	if slow {
+		bk.slow++
+	}
+
+	if b.shouldTrip(sec) {
+		b.setState(StateOpen, now)
+	}
+}
+
+func (b *Breaker) shouldTrip(now int64) bool {
+	if b.settings.ConsecutiveFailures > 0 && b.consecutiveFailures >= b.settings.ConsecutiveFailures {
+		return true
+	}
+
+	total, failures, slow := b.counts(now)
+	if total < b.settings.MinRequests || total == 0 {
+		return false
+	}
+	if b.settings.FailureRateThreshold > 0 && float64(failures)/float64(total) >= b.settings.FailureRateThreshold {
+		return true
+	}
+	if b.settings.SlowCallRateThreshold > 0 && float64(slow)/float64(total) >= b.settings.SlowCallRateThreshold {
+		return true
+	}
+	return false
+}
+
+// counts cộng dồn các bucket còn nằm trong window
+func (b *Breaker) counts(now int64) (total, failures, slow int) {
+	window := int64(len(b.buckets))
+	for _, bk := range b.buckets {
+		if now-bk.second < window {
+			total += bk.total
+			failures += bk.failures
+			slow += bk.slow
+		}
+	}
+	return total, failures, slow
+}
+
+// setState chuyển trạng thái, reset thống kê của trạng thái cũ. Caller phải giữ lock.
+func (b *Breaker) setState(to State, now time.Time) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.halfOpenInFlight = 0
+	b.halfOpenSuccesses = 0
+
+	switch to {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.consecutiveFailures = 0
+		for i := range b.buckets {
+			b.buckets[i] = bucket{}
+		}
+	}
+
+	if from != to && b.onChange != nil {
+		b.onChange(b.name, from, to)
+	}
+}
@@ -0,0 +1,51 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func defaultSettings() Settings {
+	return Settings{
+		Window:                30 * time.Second,
+		MinRequests:           10,
+		FailureRateThreshold:  0.5,
+		SlowCallDuration:      5 * time.Second,
+		SlowCallRateThreshold: 0.8,
+		ConsecutiveFailures:   5,
+		OpenTimeout:           30 * time.Second,
+		HalfOpenMaxRequests:   3,
+	}
+}
+
+func TestSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(s *Settings)
+		wantErr bool
+	}{
+		{"defaults", func(s *Settings) {}, false},
+		{"outlier detection disabled", func(s *Settings) { s.ConsecutiveFailures = 0 }, false},
+		{"no half-open probes", func(s *Settings) { s.HalfOpenMaxRequests = 0 }, true},
+		{"window below one second", func(s *Settings) { s.Window = 500 * time.Millisecond }, true},
+		{"zero open timeout", func(s *Settings) { s.OpenTimeout = 0 }, true},
+		{"zero slow call duration", func(s *Settings) { s.SlowCallDuration = 0 }, true},
+		{"failure rate above one", func(s *Settings) { s.FailureRateThreshold = 1.5 }, true},
+		{"negative failure rate", func(s *Settings) { s.FailureRateThreshold = -0.1 }, true},
+		{"failure rate tripping disabled", func(s *Settings) { s.FailureRateThreshold = 0 }, false},
+		{"slow call tripping disabled", func(s *Settings) { s.SlowCallRateThreshold = 0 }, false},
+		{"slow call tripping disabled without duration", func(s *Settings) {
+			s.SlowCallRateThreshold = 0
+			s.SlowCallDuration = 0
+		}, false},
+		{"slow call rate above one", func(s *Settings) { s.SlowCallRateThreshold = 1.2 }, true},
+		{"negative min requests", func(s *Settings) { s.MinRequests = -1 }, true},
+	}
+	for _, tt := range tests {
+		s := defaultSettings()
+		tt.modify(&s)
+		if err := s.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package breaker
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// Registry quản lý circuit breaker theo tên upstream
+type Registry struct {
+	settings Settings
+	onChange func(name string, from, to State)
+
+	mu       sync.RWMutex
+	breakers map[string]*Breaker
+}
+
+// NewRegistry tạo registry, onChange (có thể nil) được gọi mỗi khi một breaker đổi trạng thái.
+// Trả về lỗi nếu settings không hợp lệ.
+func NewRegistry(settings Settings, onChange func(name string, from, to State)) (*Registry, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+	return &Registry{
+		settings: settings,
+		onChange: onChange,
+		breakers: map[string]*Breaker{},
+	}, nil
+}
+
+// Get trả về breaker của upstream, tạo mới nếu chưa có
+func (r *Registry) Get(upstream string) *Breaker {
+	r.mu.RLock()
+	b, ok := r.breakers[upstream]
+	r.mu.RUnlock()
+	if ok {
+		return b
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.breakers[upstream]; ok {
+		return b
+	}
+	b = newBreaker(upstream, r.settings, r.stateChanged)
+	r.breakers[upstream] = b
+	return b
+}
+
+// Lookup trả về breaker của upstream nếu đã tồn tại
+func (r *Registry) Lookup(upstream string) (*Breaker, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	b, ok := r.breakers[upstream]
+	return b, ok
+}
+
+// Snapshots trả về trạng thái của mọi breaker, sắp xếp theo tên upstream
+func (r *Registry) Snapshots() []Snapshot {
+	r.mu.RLock()
+	breakers := make([]*Breaker, 0, len(r.breakers))
+	for _, b := range r.breakers {
+		breakers = append(breakers, b)
+	}
+	r.mu.RUnlock()
+
+	snapshots := make([]Snapshot, 0, len(breakers))
+	for _, b := range breakers {
+		snapshots = append(snapshots, b.Snapshot())
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Upstream < snapshots[j].Upstream
+	})
+	return snapshots
+}
+
+// States trả về trạng thái của mọi breaker, dùng cho metrics
+func (r *Registry) States() map[string]State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	states := make(map[string]State, len(r.breakers))
+	for name, b := range r.breakers {
+		states[name] = b.State()
+	}
+	return states
+}
+
+func (r *Registry) stateChanged(name string, from, to State) {
+	slog.Warn("Circuit breaker state changed",
+		"upstream", name,
+		"from", from.String(),
+		"to", to.String(),
+	)
+	if r.onChange != nil {
+		r.onChange(name, from, to)
+	}
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+const keyPrefix = "cache:"
+
+// Entry là một response đã được cache
+type Entry struct {
+	Route  string              `json:"route"`
+	Path   string              `json:"path"`
+	Status int                 `json:"status"`
+	Header map[string][]string `json:"header"`
+	Body   []byte              `json:"body"`
+	// ETag trả cho client: ETag của upstream, hoặc hash của body nếu upstream không gửi
+	ETag string `json:"etag"`
+	// Validator là ETag của upstream, dùng cho If-None-Match khi revalidate; rỗng nếu upstream không gửi
+	Validator string        `json:"validator,omitempty"`
+	StoredAt  time.Time     `json:"stored_at"`
+	TTL       time.Duration `json:"ttl"`
+	Stale     time.Duration `json:"stale"`
+}
+
+// Age là thời gian kể từ lúc entry được lưu (hoặc revalidate gần nhất)
+func (e *Entry) Age(now time.Time) time.Duration {
+	return now.Sub(e.StoredAt)
+}
+
+// Fresh cho biết entry còn trong TTL
+func (e *Entry) Fresh(now time.Time) bool {
+	return e.Age(now) < e.TTL
+}
+
+// Expired cho biết entry đã quá cả TTL lẫn stale_while_revalidate, không được dùng nữa
+func (e *Entry) Expired(now time.Time) bool {
+	return e.Age(now) >= e.TTL+e.Stale
+}
+
+// Store lưu response đã cache. Get trả về nil, nil khi không có entry.
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry *Entry) error
+	// Invalidate xoá entry của route (rỗng = mọi route) có path bắt đầu bằng pathPrefix
+	// (rỗng = mọi path), trả về số entry đã xoá
+	Invalidate(ctx context.Context, route, pathPrefix string) (int, error)
+}
+
+// Key build cache key từ route, path của request và các giá trị response phụ thuộc vào
+// (query, role, Accept-Language...). Route và path được giữ nguyên để invalidate theo prefix.
+func Key(route, path string, vary ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(vary, "\x00")))
+	return keyPrefix + route + ":" + path + "#" + hex.EncodeToString(sum[:16])
+}
+
+// ETag tạo strong ETag từ nội dung body
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matches kiểm tra entry thuộc route và có path bắt đầu bằng pathPrefix
+func matches(e *Entry, route, pathPrefix string) bool {
+	if route != "" && e.Route != route {
+		return false
+	}
+	return strings.HasPrefix(e.Path, pathPrefix)
+}
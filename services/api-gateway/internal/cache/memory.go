@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore là LRU cache trong bộ nhớ của process, mỗi instance gateway có cache riêng
+type MemoryStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // phần tử đầu là entry được dùng gần nhất
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+}
+
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*memoryItem)
+	if item.entry.Expired(time.Now()) {
+		s.remove(el)
+		return nil, nil
+	}
+	s.order.MoveToFront(el)
+	return item.entry, nil
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*memoryItem).entry = entry
+		s.order.MoveToFront(el)
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryItem{key: key, entry: entry})
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+func (s *MemoryStore) Invalidate(_ context.Context, route, pathPrefix string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for el := s.order.Front(); el != nil; {
+		next := el.Next()
+		if matches(el.Value.(*memoryItem).entry, route, pathPrefix) {
+			s.remove(el)
+			removed++
+		}
+		el = next
+	}
+	return removed, nil
+}
+
+// remove xoá phần tử khỏi LRU. Caller phải giữ lock.
+func (s *MemoryStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*memoryItem).key)
+}
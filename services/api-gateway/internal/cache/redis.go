@@ -0,0 +1,97 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore lưu cache trong Redis, dùng chung giữa các instance gateway.
+// Entry hết hạn theo TTL của Redis sau ttl + stale_while_revalidate.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (*Entry, error) {
+	data, err := s.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, key, data, entry.TTL+entry.Stale).Err()
+}
+
+func (s *RedisStore) Invalidate(ctx context.Context, route, pathPrefix string) (int, error) {
+	pattern := keyPrefix + "*"
+	if route != "" {
+		pattern = keyPrefix + escapeGlob(route) + ":" + escapeGlob(pathPrefix) + "*"
+	}
+
+	removed := 0
+	iter := s.client.Scan(ctx, 0, pattern, 500).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		keyRoute, keyPath, ok := parseKey(key)
+		if !ok || (route != "" && keyRoute != route) || !strings.HasPrefix(keyPath, pathPrefix) {
+			continue
+		}
+		n, err := s.client.Del(ctx, key).Result()
+		if err != nil {
+			return removed, err
+		}
+		removed += int(n)
+	}
+	return removed, iter.Err()
+}
+
+// parseKey tách route và path từ key cache:<route>:<path>#<hash>
+func parseKey(key string) (route, path string, ok bool) {
+	rest, found := strings.CutPrefix(key, keyPrefix)
+	if !found {
+		return "", "", false
+	}
+	route, rest, found = strings.Cut(rest, ":")
+	if !found {
+		return "", "", false
+	}
+	i := strings.LastIndex(rest, "#")
+	if i < 0 {
+		return "", "", false
+	}
+	return route, rest[:i], true
+}
+
+// escapeGlob escape các ký tự đặc biệt của Redis SCAN MATCH
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
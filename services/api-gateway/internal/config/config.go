@@ -42,19 +42,128 @@ type Config struct {
 	RedisPassword string
 	RedisDB       int
 
+	// Route table configuration
+	RoutesFile           string // YAML/JSON file describing upstream routes
+	RoutesReloadInterval int    // seconds between checks for routes file changes
+
+	// Reverse proxy configuration
+	ProxyMaxRequestBodyBytes  int64 // 0 = unlimited
+	ProxyMaxResponseBodyBytes int64 // 0 = unlimited
+	ProxyDialTimeout          int   // seconds
+	ProxyMaxIdleConns         int
+	ProxyMaxIdleConnsPerHost  int
+
+	// WebSocket proxy configuration
+	WebSocketPingInterval    int   // seconds
+	WebSocketIdleTimeout     int   // seconds without any frame/pong before closing
+	WebSocketMaxMessageBytes int64 // 0 = unlimited
+
+	// Upstream health check configuration
+	HealthCheckInterval           int // seconds, 0 = disabled
+	HealthCheckTimeout            int // seconds
+	HealthCheckUnhealthyThreshold int // consecutive failures before an endpoint is ejected
+	HealthCheckHealthyThreshold   int // consecutive successes before an endpoint is restored
+
+	// Circuit breaker configuration (per upstream)
+	CircuitBreakerEnabled             bool
+	CircuitBreakerWindow              int     // seconds, rolling window for failure/slow-call rates
+	CircuitBreakerMinRequests         int     // min requests in window before rates are evaluated
+	CircuitBreakerFailureRate         float64 // 0..1
+	CircuitBreakerSlowCallDuration    int     // milliseconds
+	CircuitBreakerSlowCallRate        float64 // 0..1
+	CircuitBreakerConsecutiveFailures int     // open immediately after N consecutive failures, 0 = disabled
+	CircuitBreakerOpenTimeout         int     // seconds before half-open
+	CircuitBreakerHalfOpenRequests    int     // probe requests allowed while half-open
+
+	// Retry configuration (safe methods only: GET, HEAD, OPTIONS)
+	RetryMaxAttempts int // retries after the first attempt, 0 = disabled
+	RetryBaseDelay   int // milliseconds
+	RetryMaxDelay    int // milliseconds
+
+	// Token revocation configuration
+	RevocationWatermarkTTL int  // seconds, must cover the max access token lifetime
+	RevocationFailOpen     bool // accept tokens when Redis is unavailable
+
 	// Rate limiting configuration
 	RateLimitEnabled       bool
-	RateLimitRequestsPerIP int    // requests per window
-	RateLimitWindow        int    // window in seconds
-	RateLimitBurstSize     int    // burst size for token bucket
+	RateLimitRequestsPerIP int // requests per window
+	RateLimitWindow        int // window in seconds
+	RateLimitBurstSize     int // burst size for token bucket
+	// Policies file; when missing a single per-IP policy is built from the values above
+	RateLimitPoliciesFile  string
+	RateLimitFallbackRetry int // seconds to use in-process limits before retrying Redis
+
+	// Admin allow/deny overrides
+	RateLimitOverrideRefresh int // seconds between reloads of overrides set by other instances
+	RateLimitOverrideMaxTTL  int // seconds
+
+	// Response cache configuration (routes opt in with a cache block in the route table)
+	CacheEnabled      bool
+	CacheStore        string // memory | redis
+	CacheMaxEntries   int    // max entries of the in-memory LRU
+	CacheMaxBodyBytes int64  // responses larger than this are not cached
+
+	// Idempotency-Key configuration (POST/PUT/PATCH/DELETE)
+	IdempotencyEnabled      bool
+	IdempotencyTTL          int   // seconds a stored response is replayed
+	IdempotencyLockTTL      int   // seconds a key stays locked while the first request is in flight
+	IdempotencyMaxBodyBytes int64 // max request body and stored response body
+
+	// Aggregated page endpoints (/api/pages/...)
+	PageSectionTimeout int // milliseconds per upstream call of a page section
+
+	// API keys and OAuth client credentials for partner integrations
+	APIKeysEnabled         bool
+	APIKeyRole             string // role forwarded as X-User-Role for requests authenticated with a key
+	ClientTokenTTL         int    // seconds, lifetime of tokens issued by /api/oauth/token
+	APIKeyRotationGrace    int    // seconds the previous secret stays valid after a rotation (default)
+	APIKeyMaxRotationGrace int    // seconds
+
+	// Aggregated OpenAPI document (/openapi.json)
+	OpenAPICacheTTL int // seconds before upstream specs are fetched again
+
+	// Canary and shadow traffic (routes opt in with targets/shadow blocks in the route table)
+	CanaryHeader         string // request header selecting a route target by name; empty disables header selection
+	ShadowEnabled        bool
+	ShadowMaxConcurrency int // max in-flight mirrored requests, extra samples are dropped
+
+	// Security policy: CORS allowlist, security headers and IP allow/deny rules
+	SecurityPolicyFile string
+	CORSAllowedOrigins string // comma-separated, replaces the origins of the current environment in the policy file
+	MaxHeaderBytes     int    // max size of request headers, routes may set a lower limit
+	TrustedProxies     string // comma-separated IPs/CIDRs of load balancers allowed to set the client IP header
+	ClientIPHeader     string // header carrying the client IP when the request comes from a trusted proxy
+	IPRulesRefresh     int    // seconds between reloads of runtime IP rules set by other instances
+
+	// Maintenance and read-only modes, toggled through /admin/modes and shared by every instance via Redis
+	ModesRefresh   int // seconds between reloads of the modes set by other instances
+	ModeRetryAfter int // Retry-After (seconds) when the admin does not set one
+
+	// Server-Sent Events (/api/events/...), fallback for clients that cannot keep a WebSocket open
+	SSEEnabled     bool
+	SSEHeartbeat   int   // seconds between heartbeat comments keeping idle streams open through proxies
+	SSERetry       int   // milliseconds the browser waits before reconnecting (SSE retry field)
+	SSEMaxDuration int   // seconds before a stream is closed so the client reconnects and is authenticated again
+	SSEReplayLimit int64 // max events replayed after Last-Event-ID
+	SSEMaxChannels int   // max product/order channels read from Redis at the same time per instance
 
 	OTelEndpoint            string
 	OTelServiceName         string
 	OTelServiceVersion      string
 	OTelEnvironment         string
+	PrometheusEnabled       bool // expose /metrics for Prometheus scraping alongside OTLP export
 	JWTPublicKeyAuthService string
 	JWTPrivateKey           string
 
+	// JWKS configuration for verifying user access tokens
+	JWKSURL             string // auth-service JWKS endpoint, e.g. http://auth-service/auth/.well-known/jwks.json
+	JWKSFile            string // local JWKS file, used when JWKSURL is empty
+	JWKSRefreshInterval int    // refresh interval in seconds
+	JWTClockSkew        int    // allowed clock skew in seconds for exp/nbf/iat
+	// Secret HS256 cũ của auth-service: token ký trước khi chuyển sang RS256 vẫn hợp lệ trong một release chuyển đổi.
+	// Để trống để chỉ chấp nhận RS256
+	JWTLegacyHS256Secret string
+
 	APIGatewayName          string
 	CategoryServiceName     string
 	ProductServiceName      string
@@ -94,11 +203,109 @@ func LoadConfig() *Config {
 		RedisPassword: getEnv("REDIS_PASSWORD", "redis123"),
 		RedisDB:       getEnvInt("REDIS_DB", 0),
 
+		// Route table configuration
+		RoutesFile:           getEnv("ROUTES_FILE", "routes.yaml"),
+		RoutesReloadInterval: getEnvInt("ROUTES_RELOAD_INTERVAL_SECONDS", 5),
+
+		// Reverse proxy configuration
+		ProxyMaxRequestBodyBytes:  getEnvInt64("PROXY_MAX_REQUEST_BODY_BYTES", 10*1024*1024),   // 10MB, media route sets its own limit
+		ProxyMaxResponseBodyBytes: getEnvInt64("PROXY_MAX_RESPONSE_BODY_BYTES", 200*1024*1024), // 200MB
+		ProxyDialTimeout:          getEnvInt("PROXY_DIAL_TIMEOUT_SECONDS", 5),
+		ProxyMaxIdleConns:         getEnvInt("PROXY_MAX_IDLE_CONNS", 200),
+		ProxyMaxIdleConnsPerHost:  getEnvInt("PROXY_MAX_IDLE_CONNS_PER_HOST", 50),
+
+		// WebSocket proxy configuration
+		WebSocketPingInterval:    getEnvInt("WS_PING_INTERVAL_SECONDS", 30),
+		WebSocketIdleTimeout:     getEnvInt("WS_IDLE_TIMEOUT_SECONDS", 90),
+		WebSocketMaxMessageBytes: getEnvInt64("WS_MAX_MESSAGE_BYTES", 64*1024),
+
+		// Upstream health check configuration
+		HealthCheckInterval:           getEnvInt("HEALTH_CHECK_INTERVAL_SECONDS", 10),
+		HealthCheckTimeout:            getEnvInt("HEALTH_CHECK_TIMEOUT_SECONDS", 2),
+		HealthCheckUnhealthyThreshold: getEnvInt("HEALTH_CHECK_UNHEALTHY_THRESHOLD", 3),
+		HealthCheckHealthyThreshold:   getEnvInt("HEALTH_CHECK_HEALTHY_THRESHOLD", 2),
+
+		// Circuit breaker configuration (per upstream)
+		CircuitBreakerEnabled:             getEnvBool("CB_ENABLED", true),
+		CircuitBreakerWindow:              getEnvInt("CB_WINDOW_SECONDS", 30),
+		CircuitBreakerMinRequests:         getEnvInt("CB_MIN_REQUESTS", 10),
+		CircuitBreakerFailureRate:         getEnvFloat("CB_FAILURE_RATE", 0.5),
+		CircuitBreakerSlowCallDuration:    getEnvInt("CB_SLOW_CALL_MS", 5000),
+		CircuitBreakerSlowCallRate:        getEnvFloat("CB_SLOW_CALL_RATE", 0.8),
+		CircuitBreakerConsecutiveFailures: getEnvInt("CB_CONSECUTIVE_FAILURES", 5),
+		CircuitBreakerOpenTimeout:         getEnvInt("CB_OPEN_SECONDS", 30),
+		CircuitBreakerHalfOpenRequests:    getEnvInt("CB_HALF_OPEN_REQUESTS", 3),
+
+		// Retry configuration (safe methods only: GET, HEAD, OPTIONS)
+		RetryMaxAttempts: getEnvInt("RETRY_MAX_ATTEMPTS", 2),
+		RetryBaseDelay:   getEnvInt("RETRY_BASE_DELAY_MS", 50),
+		RetryMaxDelay:    getEnvInt("RETRY_MAX_DELAY_MS", 1000),
+
+		// Token revocation configuration
+		RevocationWatermarkTTL: getEnvInt("REVOCATION_WATERMARK_TTL_SECONDS", 86400),
+		RevocationFailOpen:     getEnvBool("REVOCATION_FAIL_OPEN", true),
+
 		// Rate limiting configuration
 		RateLimitEnabled:       getEnvBool("RATE_LIMIT_ENABLED", true),
-		RateLimitRequestsPerIP: getEnvInt("RATE_LIMIT_REQUESTS_PER_IP", 100),   // 100 requests
-		RateLimitWindow:        getEnvInt("RATE_LIMIT_WINDOW_SECONDS", 60),    // per 60 seconds
-		RateLimitBurstSize:     getEnvInt("RATE_LIMIT_BURST_SIZE", 20),        // allow burst of 20
+		RateLimitRequestsPerIP: getEnvInt("RATE_LIMIT_REQUESTS_PER_IP", 100), // 100 requests
+		RateLimitWindow:        getEnvInt("RATE_LIMIT_WINDOW_SECONDS", 60),   // per 60 seconds
+		RateLimitBurstSize:     getEnvInt("RATE_LIMIT_BURST_SIZE", 20),       // allow burst of 20
+		RateLimitPoliciesFile:  getEnv("RATE_LIMIT_POLICIES_FILE", "ratelimits.yaml"),
+		RateLimitFallbackRetry: getEnvInt("RATE_LIMIT_FALLBACK_RETRY_SECONDS", 5),
+
+		// Admin allow/deny overrides
+		RateLimitOverrideRefresh: getEnvInt("RATE_LIMIT_OVERRIDE_REFRESH_SECONDS", 5),
+		RateLimitOverrideMaxTTL:  getEnvInt("RATE_LIMIT_OVERRIDE_MAX_TTL_SECONDS", 7*24*3600),
+
+		// Response cache configuration
+		CacheEnabled:      getEnvBool("CACHE_ENABLED", true),
+		CacheStore:        getEnv("CACHE_STORE", "memory"),
+		CacheMaxEntries:   getEnvInt("CACHE_MAX_ENTRIES", 10000),
+		CacheMaxBodyBytes: getEnvInt64("CACHE_MAX_BODY_BYTES", 1024*1024), // 1MB
+
+		// Idempotency-Key configuration
+		IdempotencyEnabled:      getEnvBool("IDEMPOTENCY_ENABLED", true),
+		IdempotencyTTL:          getEnvInt("IDEMPOTENCY_TTL_SECONDS", 24*3600),
+		IdempotencyLockTTL:      getEnvInt("IDEMPOTENCY_LOCK_SECONDS", 120),
+		IdempotencyMaxBodyBytes: getEnvInt64("IDEMPOTENCY_MAX_BODY_BYTES", 1024*1024), // 1MB
+
+		// Aggregated page endpoints
+		PageSectionTimeout: getEnvInt("PAGE_SECTION_TIMEOUT_MS", 2000),
+
+		// API keys and OAuth client credentials
+		APIKeysEnabled:         getEnvBool("API_KEYS_ENABLED", true),
+		APIKeyRole:             getEnv("API_KEY_ROLE", "ROLE_PARTNER"),
+		ClientTokenTTL:         getEnvInt("CLIENT_TOKEN_TTL_SECONDS", 3600),
+		APIKeyRotationGrace:    getEnvInt("API_KEY_ROTATION_GRACE_SECONDS", 24*3600),
+		APIKeyMaxRotationGrace: getEnvInt("API_KEY_MAX_ROTATION_GRACE_SECONDS", 7*24*3600),
+
+		// Aggregated OpenAPI document
+		OpenAPICacheTTL: getEnvInt("OPENAPI_CACHE_SECONDS", 60),
+
+		// Canary and shadow traffic
+		CanaryHeader:         getEnv("CANARY_HEADER", "X-Canary"),
+		ShadowEnabled:        getEnvBool("SHADOW_ENABLED", true),
+		ShadowMaxConcurrency: getEnvInt("SHADOW_MAX_CONCURRENCY", 50),
+
+		// Security policy
+		SecurityPolicyFile: getEnv("SECURITY_POLICY_FILE", "security.yaml"),
+		CORSAllowedOrigins: getEnv("CORS_ALLOWED_ORIGINS", ""),
+		MaxHeaderBytes:     getEnvInt("MAX_HEADER_BYTES", 16*1024), // 16KB
+		TrustedProxies:     getEnv("TRUSTED_PROXIES", ""),
+		ClientIPHeader:     getEnv("CLIENT_IP_HEADER", "X-Real-IP"),
+		IPRulesRefresh:     getEnvInt("IP_RULES_REFRESH_SECONDS", 5),
+
+		// Maintenance and read-only modes
+		ModesRefresh:   getEnvInt("MODES_REFRESH_SECONDS", 5),
+		ModeRetryAfter: getEnvInt("MODE_RETRY_AFTER_SECONDS", 300),
+
+		// Server-Sent Events
+		SSEEnabled:     getEnvBool("SSE_ENABLED", true),
+		SSEHeartbeat:   getEnvInt("SSE_HEARTBEAT_SECONDS", 15),
+		SSERetry:       getEnvInt("SSE_RETRY_MS", 3000),
+		SSEMaxDuration: getEnvInt("SSE_MAX_DURATION_SECONDS", 30*60),
+		SSEReplayLimit: getEnvInt64("SSE_REPLAY_LIMIT", 500),
+		SSEMaxChannels: getEnvInt("SSE_MAX_CHANNELS", 200),
 
 		APIGatewayName:          getEnv("API_GATEWAY_NAME", "api-gateway"),
 		CategoryServiceName:     getEnv("CATEGORY_SERVICE_NAME", "category-service"),
@@ -117,10 +324,18 @@ func LoadConfig() *Config {
 		OTelServiceName:    getEnv("OTEL_SERVICE_NAME", "api-gateway"),
 		OTelServiceVersion: getEnv("OTEL_SERVICE_VERSION", "1.0.0"),
 		OTelEnvironment:    getEnv("OTEL_ENVIRONMENT", "development"),
+		PrometheusEnabled:  getEnvBool("PROMETHEUS_ENABLED", true),
 
 		JWTPublicKeyAuthService: getEnv("JWT_PUBLIC_KEY_AUTH_SERVICE", ""),
 		JWTPrivateKey:           getEnv("JWT_PRIVATE_KEY", ""),
 
+		JWKSURL:             getEnv("JWKS_URL", ""),
+		JWKSFile:            getEnv("JWKS_FILE", ""),
+		JWKSRefreshInterval: getEnvInt("JWKS_REFRESH_INTERVAL_SECONDS", 300),
+		JWTClockSkew:        getEnvInt("JWT_CLOCK_SKEW_SECONDS", 30),
+
+		JWTLegacyHS256Secret: getEnv("JWT_LEGACY_HS256_SECRET", ""),
+
 		PublicKeys: map[string]string{
 			"api-gateway":          getEnv("JWT_PUBLIC_KEY_API_GATEWAY", ""),
 			"auth-service":         getEnv("JWT_PUBLIC_KEY_AUTH_SERVICE", ""),
@@ -169,6 +384,24 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvInt64(key string, defaultValue int64) int64 {
+	if value := os.Getenv(key); value != "" {
+		if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return intValue
+		}
+	}
+	return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
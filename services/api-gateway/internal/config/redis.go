@@ -0,0 +1,50 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ConnectRedis tạo Redis client dùng chung cho rate limiter, revocation list...
+func ConnectRedis(cfg *Config) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.RedisAddr,
+		Password:     cfg.RedisPassword,
+		DB:           cfg.RedisDB,
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 3 * time.Second,
+		PoolSize:     10,
+		MinIdleConns: 5,
+	})
+
+	// Test Redis connection
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		slog.Error("Failed to connect to Redis", "error", err)
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	slog.Info("Connected to Redis", "addr", cfg.RedisAddr, "db", cfg.RedisDB)
+	return client, nil
+}
+
+// ConnectEventsRedis tạo Redis client riêng cho SSE: mỗi kênh đang có client giữ một connection
+// trong lúc XREAD BLOCK nên không dùng chung pool với rate limiter
+func ConnectEventsRedis(cfg *Config) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:         cfg.RedisAddr,
+		Password:     cfg.RedisPassword,
+		DB:           cfg.RedisDB,
+		DialTimeout:  5 * time.Second,
+		ReadTimeout:  3 * time.Second,
+		WriteTimeout: 3 * time.Second,
+		PoolSize:     cfg.SSEMaxChannels + 10,
+	})
+}
@@ -0,0 +1,306 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis Stream mà comment-service và order-service ghi các event hub WebSocket của họ broadcast,
+// key là prefix + product ID / order ID
+const (
+	ProductStreamPrefix = "events:product:"
+	OrderStreamPrefix   = "events:order:"
+)
+
+const (
+	// readBatch là số event tối đa đọc trong một lần XREAD của một kênh
+	readBatch = 100
+	// subscriberBuffer là số event chờ gửi của một subscriber, đầy thì subscriber bị ngắt
+	subscriberBuffer = 64
+	// readBlock là thời gian XREAD BLOCK, cũng là độ trễ tối đa để dừng kênh khi không còn subscriber
+	readBlock  = 5 * time.Second
+	retryDelay = time.Second
+)
+
+var (
+	ErrClosed          = errors.New("event broker is closed")
+	ErrTooManyChannels = errors.New("too many event channels")
+	ErrInvalidEventID  = errors.New("invalid event id")
+)
+
+// Event là một entry của stream, ID là stream ID của Redis (dùng làm id của SSE)
+type Event struct {
+	ID   string
+	Type string
+	Data string
+}
+
+// Broker đọc Redis Stream của các kênh đang có subscriber và phát event cho các subscriber trong instance.
+// Mỗi kênh chỉ có một goroutine XREAD dù có bao nhiêu client, goroutine dừng khi kênh không còn subscriber.
+type Broker struct {
+	client      *redis.Client
+	maxChannels int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	channels map[string]*channel
+	closed   bool
+}
+
+type channel struct {
+	key         string
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription nhận event của một kênh. Events bị đóng khi subscriber đọc không kịp (client nên kết nối lại
+// với Last-Event-ID) hoặc khi broker đóng.
+type Subscription struct {
+	Events <-chan Event
+
+	events chan Event
+	broker *Broker
+	key    string
+	once   sync.Once
+}
+
+// NewBroker tạo broker; client nên là Redis client riêng vì mỗi kênh giữ một connection trong lúc XREAD BLOCK
+func NewBroker(client *redis.Client, maxChannels int) *Broker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Broker{
+		client:      client,
+		maxChannels: maxChannels,
+		ctx:         ctx,
+		cancel:      cancel,
+		channels:    map[string]*channel{},
+	}
+}
+
+// Subscribe đăng ký nhận event của stream key. Nếu lastEventID khác rỗng, trả về tối đa replayLimit event
+// sau lastEventID còn trong stream; event live trùng với backlog được bỏ qua bởi SSE writer theo ID.
+func (b *Broker) Subscribe(ctx context.Context, key, lastEventID string, replayLimit int64) (*Subscription, []Event, error) {
+	if lastEventID != "" && !ValidID(lastEventID) {
+		return nil, nil, ErrInvalidEventID
+	}
+
+	events := make(chan Event, subscriberBuffer)
+	sub := &Subscription{Events: events, events: events, broker: b, key: key}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, nil, ErrClosed
+	}
+	ch, ok := b.channels[key]
+	if !ok {
+		if b.maxChannels > 0 && len(b.channels) >= b.maxChannels {
+			b.mu.Unlock()
+			return nil, nil, ErrTooManyChannels
+		}
+		ch = &channel{key: key, subscribers: map[*Subscription]struct{}{}}
+		b.channels[key] = ch
+	}
+	ch.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	if !ok {
+		// Vị trí bắt đầu đọc được lấy trước khi trả về để event ghi sau khi subscribe không bị mất
+		start, err := b.lastID(ctx, key)
+		if err != nil {
+			// Kênh không có goroutine đọc: bỏ kênh, subscriber khác vừa đăng ký vào sẽ kết nối lại
+			b.drop(ch)
+			return nil, nil, err
+		}
+		go b.run(ch, start)
+	}
+
+	if lastEventID == "" {
+		return sub, nil, nil
+	}
+	backlog, err := b.replay(ctx, key, lastEventID, replayLimit)
+	if err != nil {
+		sub.Close()
+		return nil, nil, err
+	}
+	return sub, backlog, nil
+}
+
+// Close hủy đăng ký, gọi nhiều lần không sao
+func (s *Subscription) Close() {
+	s.broker.remove(s, false)
+}
+
+// Close dừng mọi kênh và đóng Events của mọi subscriber để các SSE stream kết thúc trước khi server shutdown
+func (b *Broker) Close() {
+	b.mu.Lock()
+	b.closed = true
+	for key, ch := range b.channels {
+		for sub := range ch.subscribers {
+			sub.once.Do(func() { close(sub.events) })
+		}
+		delete(b.channels, key)
+	}
+	b.mu.Unlock()
+	b.cancel()
+}
+
+func (b *Broker) drop(ch *channel) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.channels[ch.key] == ch {
+		delete(b.channels, ch.key)
+	}
+	for sub := range ch.subscribers {
+		sub.once.Do(func() { close(sub.events) })
+		delete(ch.subscribers, sub)
+	}
+}
+
+func (b *Broker) remove(sub *Subscription, dropped bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ch, ok := b.channels[sub.key]; ok {
+		delete(ch.subscribers, sub)
+	}
+	sub.once.Do(func() {
+		close(sub.events)
+		if dropped {
+			slog.Warn("SSE subscriber too slow, dropped", slog.String("stream", sub.key))
+		}
+	})
+}
+
+// run đọc stream của kênh từ sau start cho tới khi kênh không còn subscriber
+func (b *Broker) run(ch *channel, start string) {
+	last := start
+	for {
+		b.mu.Lock()
+		if len(ch.subscribers) == 0 || b.channels[ch.key] != ch {
+			if b.channels[ch.key] == ch {
+				delete(b.channels, ch.key)
+			}
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+
+		streams, err := b.client.XRead(b.ctx, &redis.XReadArgs{
+			Streams: []string{ch.key, last},
+			Count:   readBatch,
+			Block:   readBlock,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if b.ctx.Err() != nil {
+				return
+			}
+			slog.Warn("Failed to read event stream", slog.String("stream", ch.key), slog.String("error", err.Error()))
+			select {
+			case <-b.ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				last = msg.ID
+				b.publish(ch, toEvent(msg))
+			}
+		}
+	}
+}
+
+func (b *Broker) publish(ch *channel, event Event) {
+	b.mu.Lock()
+	var slow []*Subscription
+	for sub := range ch.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, sub := range slow {
+		b.remove(sub, true)
+	}
+}
+
+// lastID trả về ID của entry cuối cùng trong stream, "0-0" nếu stream chưa có entry
+func (b *Broker) lastID(ctx context.Context, key string) (string, error) {
+	msgs, err := b.client.XRevRangeN(ctx, key, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(msgs) == 0 {
+		return "0-0", nil
+	}
+	return msgs[0].ID, nil
+}
+
+// replay trả về các event sau lastEventID còn trong stream (stream bị cắt theo MAXLEN/TTL ở phía service)
+func (b *Broker) replay(ctx context.Context, key, lastEventID string, limit int64) ([]Event, error) {
+	msgs, err := b.client.XRangeN(ctx, key, "("+lastEventID, "+", limit).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(msgs))
+	for _, msg := range msgs {
+		events = append(events, toEvent(msg))
+	}
+	return events, nil
+}
+
+func toEvent(msg redis.XMessage) Event {
+	event := Event{ID: msg.ID}
+	event.Type, _ = msg.Values["type"].(string)
+	event.Data, _ = msg.Values["data"].(string)
+	return event
+}
+
+// ValidID kiểm tra ID có dạng stream ID của Redis (<ms>-<seq>)
+func ValidID(id string) bool {
+	_, _, ok := parseID(id)
+	return ok
+}
+
+// After cho biết stream ID a đứng sau b
+func After(a, b string) bool {
+	ams, aseq, _ := parseID(a)
+	bms, bseq, _ := parseID(b)
+	if ams != bms {
+		return ams > bms
+	}
+	return aseq > bseq
+}
+
+func parseID(id string) (uint64, uint64, bool) {
+	msPart, seqPart, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ms, seq, true
+}
@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"api_gateway/internal/apikey"
+	"api_gateway/internal/middleware"
+	"api_gateway/internal/routes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type APIKeyHandler struct {
+	store        *apikey.Store
+	auth         *middleware.APIKeyAuth
+	registry     *routes.Registry
+	defaultGrace time.Duration
+	maxGrace     time.Duration
+}
+
+func NewAPIKeyHandler(store *apikey.Store, auth *middleware.APIKeyAuth, registry *routes.Registry, defaultGrace, maxGrace time.Duration) *APIKeyHandler {
+	return &APIKeyHandler{
+		store:        store,
+		auth:         auth,
+		registry:     registry,
+		defaultGrace: defaultGrace,
+		maxGrace:     maxGrace,
+	}
+}
+
+// CreateAPIKeyRequest là thông tin key cấp cho đối tác
+type CreateAPIKeyRequest struct {
+	Name             string       `json:"name"`
+	Owner            string       `json:"owner"`
+	Scopes           []string     `json:"scopes"` // <route>:read | <route>:write | <route>:*
+	Quota            apikey.Quota `json:"quota"`
+	ExpiresInSeconds int64        `json:"expires_in_seconds"` // 0 = không hết hạn
+}
+
+// RotateAPIKeyRequest: secret cũ còn hiệu lực grace_seconds sau khi rotate (mặc định API_KEY_ROTATION_GRACE_SECONDS)
+type RotateAPIKeyRequest struct {
+	GraceSeconds *int64 `json:"grace_seconds"`
+}
+
+// APIKeyResponse là thông tin key trả về cho admin, không chứa hash của secret
+type APIKeyResponse struct {
+	ID                string        `json:"id"`
+	Name              string        `json:"name"`
+	Owner             string        `json:"owner,omitempty"`
+	Scopes            []string      `json:"scopes"`
+	Quota             apikey.Quota  `json:"quota"`
+	Status            string        `json:"status"` // active | expired | revoked
+	CreatedBy         string        `json:"created_by,omitempty"`
+	CreatedAt         time.Time     `json:"created_at"`
+	ExpiresAt         *time.Time    `json:"expires_at,omitempty"`
+	RotatedAt         *time.Time    `json:"rotated_at,omitempty"`
+	RevokedAt         *time.Time    `json:"revoked_at,omitempty"`
+	PreviousExpiresAt *time.Time    `json:"previous_secret_expires_at,omitempty"`
+	Usage             *apikey.Usage `json:"usage,omitempty"`
+}
+
+// APIKeySecretResponse trả về secret, chỉ một lần khi cấp hoặc rotate
+type APIKeySecretResponse struct {
+	Key          APIKeyResponse `json:"key"`
+	APIKey       string         `json:"api_key"` // giá trị header X-API-Key
+	ClientID     string         `json:"client_id"`
+	ClientSecret string         `json:"client_secret"`
+}
+
+// ClientTokenRequest là request OAuth 2.0 client credentials (form hoặc JSON)
+type ClientTokenRequest struct {
+	GrantType    string `json:"grant_type" form:"grant_type"`
+	ClientID     string `json:"client_id" form:"client_id"`
+	ClientSecret string `json:"client_secret" form:"client_secret"`
+	Scope        string `json:"scope" form:"scope"` // phân tách bởi dấu cách, mặc định mọi scope của key
+}
+
+// ListKeys trả về các API key đã cấp
+// @Summary List API keys
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {array} APIKeyResponse
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/api-keys [get]
+func (h *APIKeyHandler) ListKeys(c *fiber.Ctx) error {
+	keys, err := h.store.List(c.UserContext())
+	if err != nil {
+		return h.error(c, err)
+	}
+	now := time.Now()
+	resp := make([]APIKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, toAPIKeyResponse(k, now))
+	}
+	return c.JSON(resp)
+}
+
+// GetKey trả về một API key cùng số request trong phút và ngày hiện tại
+// @Summary Get API key
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Param id path string true "Client ID"
+// @Success 200 {object} APIKeyResponse
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/api-keys/{id} [get]
+func (h *APIKeyHandler) GetKey(c *fiber.Ctx) error {
+	k, err := h.store.Get(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return h.error(c, err)
+	}
+	resp := toAPIKeyResponse(k, time.Now())
+	if usage, err := h.store.Usage(c.UserContext(), k.ID); err == nil {
+		resp.Usage = &usage
+	}
+	return c.JSON(resp)
+}
+
+// CreateKey cấp API key cho đối tác
+// @Summary Issue API key
+// @Description Secret chỉ được trả về một lần, gateway chỉ lưu hash
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security X-User-Token
+// @Param request body CreateAPIKeyRequest true "API key"
+// @Success 201 {object} APIKeySecretResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/api-keys [post]
+func (h *APIKeyHandler) CreateKey(c *fiber.Ctx) error {
+	var req CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if req.ExpiresInSeconds < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expires_in_seconds must not be negative",
+		})
+	}
+	if err := h.validateScopeRoutes(req.Scopes); err != nil {
+		return h.error(c, err)
+	}
+
+	adminID, _ := c.Locals("userID").(string)
+	k := &apikey.Key{
+		Name:      strings.TrimSpace(req.Name),
+		Owner:     req.Owner,
+		Scopes:    req.Scopes,
+		Quota:     req.Quota,
+		CreatedBy: adminID,
+	}
+	if req.ExpiresInSeconds > 0 {
+		k.ExpiresAt = time.Now().Add(time.Duration(req.ExpiresInSeconds) * time.Second)
+	}
+
+	secret, err := h.store.Create(c.UserContext(), k)
+	if err != nil {
+		return h.error(c, err)
+	}
+
+	slog.Info("API key issued",
+		slog.String("client_id", k.ID),
+		slog.String("name", k.Name),
+		slog.String("owner", k.Owner),
+		slog.Any("scopes", k.Scopes),
+		slog.String("admin_id", adminID),
+	)
+	return c.Status(fiber.StatusCreated).JSON(secretResponse(k, secret))
+}
+
+// RotateKey cấp secret mới cho API key
+// @Summary Rotate API key secret
+// @Description Secret cũ còn hiệu lực trong grace_seconds để đối tác kịp cập nhật
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security X-User-Token
+// @Param id path string true "Client ID"
+// @Param request body RotateAPIKeyRequest false "Grace period"
+// @Success 200 {object} APIKeySecretResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /admin/api-keys/{id}/rotate [post]
+func (h *APIKeyHandler) RotateKey(c *fiber.Ctx) error {
+	var req RotateAPIKeyRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	grace := h.defaultGrace
+	if req.GraceSeconds != nil {
+		grace = time.Duration(*req.GraceSeconds) * time.Second
+	}
+	if grace < 0 || grace > h.maxGrace {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":             "grace_seconds is out of range",
+			"max_grace_seconds": int64(h.maxGrace / time.Second),
+		})
+	}
+
+	k, secret, err := h.store.Rotate(c.UserContext(), c.Params("id"), grace)
+	if err != nil {
+		return h.error(c, err)
+	}
+
+	adminID, _ := c.Locals("userID").(string)
+	slog.Info("API key rotated",
+		slog.String("client_id", k.ID),
+		slog.Duration("grace", grace),
+		slog.String("admin_id", adminID),
+	)
+	return c.JSON(secretResponse(k, secret))
+}
+
+// RevokeKey thu hồi API key, mọi request và client token của key bị từ chối ngay
+// @Summary Revoke API key
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Param id path string true "Client ID"
+// @Success 200 {object} APIKeyResponse
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/api-keys/{id} [delete]
+func (h *APIKeyHandler) RevokeKey(c *fiber.Ctx) error {
+	k, err := h.store.Revoke(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return h.error(c, err)
+	}
+
+	adminID, _ := c.Locals("userID").(string)
+	slog.Info("API key revoked",
+		slog.String("client_id", k.ID),
+		slog.String("admin_id", adminID),
+	)
+	return c.JSON(toAPIKeyResponse(k, time.Now()))
+}
+
+// Token cấp access token theo OAuth 2.0 client credentials
+// @Summary OAuth client credentials token
+// @Description client_id/client_secret gửi qua HTTP Basic hoặc trong body. Token dùng với header Authorization: Bearer.
+// @Tags Auth
+// @Accept x-www-form-urlencoded,json
+// @Produce json
+// @Param request body ClientTokenRequest true "Client credentials"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /oauth/token [post]
+func (h *APIKeyHandler) Token(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	if !h.auth.Enabled() {
+		return oauthError(c, fiber.StatusNotFound, "invalid_request", "API keys are disabled")
+	}
+
+	var req ClientTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return oauthError(c, fiber.StatusBadRequest, "invalid_request", "Invalid request body")
+	}
+	if req.GrantType != "client_credentials" {
+		return oauthError(c, fiber.StatusBadRequest, "unsupported_grant_type", "Only client_credentials is supported")
+	}
+
+	clientID, clientSecret, ok := basicAuth(c)
+	if !ok {
+		clientID, clientSecret = req.ClientID, req.ClientSecret
+	}
+	if clientID == "" || clientSecret == "" {
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Missing client credentials")
+	}
+
+	k, err := h.auth.AuthenticateClient(c, clientID, clientSecret)
+	switch {
+	case errors.Is(err, apikey.ErrInvalidCredentials), errors.Is(err, apikey.ErrRevoked), errors.Is(err, apikey.ErrExpired):
+		slog.Warn("Client credentials rejected",
+			slog.String("client_id", clientID),
+			slog.String("error", err.Error()),
+			slog.String("ip", c.IP()),
+		)
+		return oauthError(c, fiber.StatusUnauthorized, "invalid_client", "Invalid client credentials")
+	case err != nil:
+		slog.Error("Failed to verify client credentials", slog.String("error", err.Error()))
+		return oauthError(c, fiber.StatusServiceUnavailable, "temporarily_unavailable", "Unable to verify client credentials")
+	}
+
+	scopes := k.Scopes
+	if req.Scope != "" {
+		scopes = strings.Fields(req.Scope)
+		for _, scope := range scopes {
+			if !containsString(k.Scopes, scope) {
+				return oauthError(c, fiber.StatusBadRequest, "invalid_scope", fmt.Sprintf("Scope %q is not granted to this client", scope))
+			}
+		}
+	}
+
+	token, ttl, err := h.auth.IssueToken(k, scopes)
+	if errors.Is(err, middleware.ErrClientTokensDisabled) {
+		return oauthError(c, fiber.StatusServiceUnavailable, "temporarily_unavailable", "Client credentials are not configured")
+	}
+	if err != nil {
+		slog.Error("Failed to issue client token", slog.String("client_id", k.ID), slog.String("error", err.Error()))
+		return oauthError(c, fiber.StatusInternalServerError, "server_error", "Failed to issue token")
+	}
+
+	slog.Info("Client token issued", slog.String("client_id", k.ID))
+	return c.JSON(fiber.Map{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int64(ttl / time.Second),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+// validateScopeRoutes kiểm tra scope và route của scope có trong bảng route hiện tại
+func (h *APIKeyHandler) validateScopeRoutes(scopes []string) error {
+	if err := apikey.ValidateScopes(scopes); err != nil {
+		return err
+	}
+	table := h.registry.Table()
+	for _, scope := range scopes {
+		route, _, _ := strings.Cut(scope, ":")
+		if route == "*" {
+			continue
+		}
+		if _, ok := table.Lookup(route); !ok {
+			return fmt.Errorf("%w: unknown route %q in scope %q", apikey.ErrInvalidKey, route, scope)
+		}
+	}
+	return nil
+}
+
+func (h *APIKeyHandler) error(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, apikey.ErrInvalidKey):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, apikey.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	case errors.Is(err, apikey.ErrRevoked), errors.Is(err, apikey.ErrExpired):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	slog.Error("API key operation failed", slog.String("error", err.Error()))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "API key operation failed",
+	})
+}
+
+func toAPIKeyResponse(k *apikey.Key, now time.Time) APIKeyResponse {
+	resp := APIKeyResponse{
+		ID:        k.ID,
+		Name:      k.Name,
+		Owner:     k.Owner,
+		Scopes:    k.Scopes,
+		Quota:     k.Quota,
+		Status:    "active",
+		CreatedBy: k.CreatedBy,
+		CreatedAt: k.CreatedAt,
+		ExpiresAt: optionalTime(k.ExpiresAt),
+		RotatedAt: optionalTime(k.RotatedAt),
+		RevokedAt: optionalTime(k.RevokedAt),
+	}
+	if k.PreviousHash != "" && now.Before(k.PreviousExpiresAt) {
+		resp.PreviousExpiresAt = optionalTime(k.PreviousExpiresAt)
+	}
+	switch {
+	case errors.Is(k.Check(now), apikey.ErrRevoked):
+		resp.Status = "revoked"
+	case errors.Is(k.Check(now), apikey.ErrExpired):
+		resp.Status = "expired"
+	}
+	return resp
+}
+
+func secretResponse(k *apikey.Key, secret string) APIKeySecretResponse {
+	return APIKeySecretResponse{
+		Key:          toAPIKeyResponse(k, time.Now()),
+		APIKey:       k.ID + "." + secret,
+		ClientID:     k.ID,
+		ClientSecret: secret,
+	}
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// basicAuth đọc client_id/client_secret từ header Authorization: Basic (RFC 6749 2.3.1)
+func basicAuth(c *fiber.Ctx) (string, string, bool) {
+	encoded, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	id, secret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", false
+	}
+	id, errID := url.QueryUnescape(id)
+	secret, errSecret := url.QueryUnescape(secret)
+	return id, secret, errID == nil && errSecret == nil
+}
+
+func oauthError(c *fiber.Ctx, status int, code, description string) error {
+	return c.Status(status).JSON(fiber.Map{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"api_gateway/internal/breaker"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type BreakerHandler struct {
+	registry *breaker.Registry // nil khi circuit breaker bị tắt
+}
+
+func NewBreakerHandler(registry *breaker.Registry) *BreakerHandler {
+	return &BreakerHandler{registry: registry}
+}
+
+// ListBreakers trả về trạng thái circuit breaker của các upstream
+// @Summary List circuit breakers
+// @Description Trạng thái và thống kê circuit breaker của từng upstream đã nhận request
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/circuit-breakers [get]
+func (h *BreakerHandler) ListBreakers(c *fiber.Ctx) error {
+	if h.registry == nil {
+		return c.JSON(fiber.Map{
+			"enabled":          false,
+			"circuit_breakers": []breaker.Snapshot{},
+		})
+	}
+	return c.JSON(fiber.Map{
+		"enabled":          true,
+		"circuit_breakers": h.registry.Snapshots(),
+	})
+}
+
+// ResetBreaker đóng circuit của một upstream và xoá thống kê
+// @Summary Reset circuit breaker
+// @Description Đóng circuit của upstream ngay lập tức (ví dụ sau khi service đã được khắc phục)
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Param upstream path string true "Upstream name"
+// @Success 200 {object} breaker.Snapshot
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/circuit-breakers/{upstream}/reset [post]
+func (h *BreakerHandler) ResetBreaker(c *fiber.Ctx) error {
+	upstream := c.Params("upstream")
+	if h.registry == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Circuit breakers are disabled",
+		})
+	}
+
+	b, ok := h.registry.Lookup(upstream)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Circuit breaker not found",
+		})
+	}
+
+	b.Reset()
+	slog.Info("Circuit breaker reset",
+		slog.String("upstream", upstream),
+		slog.String("admin_id", c.Locals("userID").(string)),
+	)
+	return c.JSON(b.Snapshot())
+}
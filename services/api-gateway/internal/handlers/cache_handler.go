@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"api_gateway/internal/cache"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type CacheHandler struct {
+	store cache.Store // nil khi response cache bị tắt
+}
+
+func NewCacheHandler(store cache.Store) *CacheHandler {
+	return &CacheHandler{store: store}
+}
+
+// InvalidateCacheRequest chọn các entry cần xoá theo route và/hoặc prefix của path
+type InvalidateCacheRequest struct {
+	Route      string `json:"route"`       // tên route trong routes.yaml, ví dụ products
+	PathPrefix string `json:"path_prefix"` // path phía client, ví dụ /api/products/42
+}
+
+// Invalidate xoá các response đã cache khi dữ liệu ở service thay đổi
+// @Summary Invalidate cached responses
+// @Description Gọi bởi internal service (X-Internal-JWT) hoặc admin khi dữ liệu thay đổi
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security X-User-Token
+// @Param request body InvalidateCacheRequest true "Route và/hoặc path prefix"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /admin/cache/invalidate [post]
+func (h *CacheHandler) Invalidate(c *fiber.Ctx) error {
+	if h.store == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Response cache is disabled",
+		})
+	}
+
+	var req InvalidateCacheRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if req.Route == "" && req.PathPrefix == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "route or path_prefix is required",
+		})
+	}
+
+	invalidated, err := h.store.Invalidate(c.UserContext(), req.Route, req.PathPrefix)
+	if err != nil {
+		slog.Error("Failed to invalidate response cache",
+			slog.String("route", req.Route),
+			slog.String("path_prefix", req.PathPrefix),
+			slog.String("error", err.Error()),
+		)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to invalidate cache",
+		})
+	}
+
+	caller, _ := c.Locals("internalIssuer").(string)
+	if caller == "" {
+		caller, _ = c.Locals("userID").(string)
+	}
+	slog.Info("Response cache invalidated",
+		slog.String("route", req.Route),
+		slog.String("path_prefix", req.PathPrefix),
+		slog.Int("entries", invalidated),
+		slog.String("caller", caller),
+	)
+	return c.JSON(fiber.Map{"invalidated": invalidated})
+}
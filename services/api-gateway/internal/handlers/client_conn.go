@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Deadline đã qua, dùng để đánh thức goroutine đang chờ đọc trên kết nối
+var aLongTimeAgo = time.Unix(1, 0)
+
+// clientWatch theo dõi kết nối của client trong lúc gateway chờ response header của upstream và gọi onClose
+// khi client đóng kết nối. fasthttp không đọc socket trong lúc handler chạy nên tự nó không phát hiện được.
+// Chỉ bắt đầu theo dõi sau khi request body đã được đọc hết (không đọc socket song song với body stream)
+// và chỉ với kết nối TCP trực tiếp; kết nối TLS hoặc bọc bởi listener khác không được theo dõi.
+type clientWatch struct {
+	conn    net.Conn
+	onClose func()
+
+	mu      sync.Mutex
+	stopped bool
+	done    chan struct{}
+}
+
+func newClientWatch(conn net.Conn, onClose func()) *clientWatch {
+	return &clientWatch{conn: conn, onClose: onClose}
+}
+
+// start bắt đầu theo dõi kết nối; gọi nhiều lần hoặc sau stop không có tác dụng
+func (w *clientWatch) start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped || w.done != nil || w.conn == nil {
+		return
+	}
+	wait, ok := clientCloseWaiter(w.conn)
+	if !ok {
+		return
+	}
+	done := make(chan struct{})
+	w.done = done
+	go func() {
+		defer close(done)
+		if wait() {
+			w.onClose()
+		}
+	}()
+}
+
+// stop dừng theo dõi và chờ goroutine thoát, trả lại read deadline cho fasthttp
+// (fasthttp đặt lại deadline trước khi đọc request kế tiếp)
+func (w *clientWatch) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+	w.stopped = true
+	if w.done == nil {
+		return
+	}
+	w.conn.SetReadDeadline(aLongTimeAgo)
+	<-w.done
+	w.conn.SetReadDeadline(time.Time{})
+}
+
+// eofNotifyReader gọi onEOF khi request body của client đã được đọc hết
+type eofNotifyReader struct {
+	r     io.Reader
+	onEOF func()
+}
+
+func (r *eofNotifyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err == io.EOF {
+		r.onEOF()
+	}
+	return n, err
+}
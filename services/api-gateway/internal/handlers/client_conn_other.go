@@ -0,0 +1,10 @@
+//go:build !unix
+
+package handlers
+
+import "net"
+
+// clientCloseWaiter không được hỗ trợ ngoài unix: client ngắt kết nối chỉ được phát hiện khi stream response
+func clientCloseWaiter(conn net.Conn) (wait func() bool, ok bool) {
+	return nil, false
+}
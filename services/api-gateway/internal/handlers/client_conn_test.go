@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPair trả về hai đầu của một kết nối TCP loopback: server (như fasthttp nhận) và client
+func tcpPair(t *testing.T) (server, client net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return server, client
+}
+
+func TestClientWatchDetectsClose(t *testing.T) {
+	server, client := tcpPair(t)
+
+	closed := make(chan struct{})
+	watch := newClientWatch(server, func() { close(closed) })
+	watch.start()
+	defer watch.stop()
+
+	client.Close()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client close was not detected")
+	}
+}
+
+func TestClientWatchStopKeepsConnection(t *testing.T) {
+	server, client := tcpPair(t)
+
+	watch := newClientWatch(server, func() { t.Error("onClose called for an open connection") })
+	watch.start()
+
+	stopped := make(chan struct{})
+	go func() {
+		watch.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop did not return")
+	}
+
+	// Deadline được trả lại và dữ liệu của request kế tiếp vẫn còn nguyên cho fasthttp
+	if _, err := client.Write([]byte("GET")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatalf("read after stop: %v", err)
+	}
+	if string(buf) != "GET" {
+		t.Errorf("read %q after stop, want %q", buf, "GET")
+	}
+}
+
+func TestClientWatchPeeksPipelinedData(t *testing.T) {
+	server, client := tcpPair(t)
+
+	watch := newClientWatch(server, func() { t.Error("onClose called for pipelined data") })
+	watch.start()
+	if _, err := client.Write([]byte("GET")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	watch.stop()
+
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(server, buf); err != nil {
+		t.Fatalf("read after stop: %v", err)
+	}
+	if string(buf) != "GET" {
+		t.Errorf("read %q after stop, want %q", buf, "GET")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package handlers
+
+import (
+	"net"
+	"syscall"
+)
+
+// clientCloseWaiter trả về hàm chặn tới khi client đóng kết nối (true), hoặc tới khi socket có dữ liệu mới
+// (request pipelined) hay read deadline bị đặt (false). Dữ liệu chỉ được peek (MSG_PEEK), không lấy khỏi socket,
+// nên fasthttp vẫn đọc được request kế tiếp. ok=false khi kết nối không phải socket trực tiếp.
+func clientCloseWaiter(conn net.Conn) (wait func() bool, ok bool) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, false
+	}
+	return func() bool {
+		var buf [1]byte
+		closed := false
+		// Socket ở chế độ non-blocking: trả false khi chưa có gì để đọc, runtime chờ socket sẵn sàng rồi gọi lại
+		err := raw.Read(func(fd uintptr) bool {
+			n, _, err := syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				return false
+			}
+			closed = n == 0 || err != nil
+			return true
+		})
+		return err == nil && closed
+	}, true
+}
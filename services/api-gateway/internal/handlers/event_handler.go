@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"api_gateway/internal/breaker"
+	"api_gateway/internal/config"
+	"api_gateway/internal/events"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/middleware"
+	"api_gateway/internal/routes"
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// eventRouteOrders là route trong routes.yaml dùng để kiểm tra user có quyền theo dõi đơn hàng
+const eventRouteOrders = "orders"
+
+const eventMaxAccessCheckBytes = 64 * 1024
+
+// EventHandler stream event của hub WebSocket (bình luận sản phẩm, chat đơn hàng) qua Server-Sent Events
+// cho client không dùng được WebSocket. Event được comment-service và order-service ghi vào Redis Stream,
+// stream ID là id của SSE nên client kết nối lại với Last-Event-ID nhận tiếp các event bị lỡ.
+type EventHandler struct {
+	cfg      *config.Config
+	registry *routes.Registry
+	proxy    *ProxyHandler
+	broker   *events.Broker
+}
+
+func NewEventHandler(cfg *config.Config, registry *routes.Registry, proxy *ProxyHandler, broker *events.Broker) *EventHandler {
+	return &EventHandler{
+		cfg:      cfg,
+		registry: registry,
+		proxy:    proxy,
+		broker:   broker,
+	}
+}
+
+// ProductEvents stream bình luận mới của sản phẩm
+// @Summary Product comment events (SSE)
+// @Description Stream text/event-stream bình luận mới (event comment, giống WebSocket bình luận), không cần đăng nhập.
+// @Description Kết nối lại với header Last-Event-ID (hoặc query lastEventId) để nhận các event bị lỡ.
+// @Tags Events
+// @Produce text/event-stream
+// @Param id path int true "Product ID"
+// @Param Last-Event-ID header string false "ID của event cuối cùng đã nhận"
+// @Success 200 {string} string "text/event-stream"
+// @Failure 400 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /events/products/{id} [get]
+func (h *EventHandler) ProductEvents(c *fiber.Ctx) error {
+	id, ok := eventChannelID(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid product ID",
+		})
+	}
+	return h.stream(c, events.ProductStreamPrefix+id)
+}
+
+// OrderEvents stream tin nhắn chat của đơn hàng, chỉ người mua và người bán của đơn
+// @Summary Order chat events (SSE)
+// @Description Stream text/event-stream tin nhắn mới (event message, giống WebSocket chat đơn hàng).
+// @Description Quyền truy cập được kiểm tra với order-service như GET /api/orders/data/order/{id}.
+// @Description Kết nối lại với header Last-Event-ID (hoặc query lastEventId) để nhận các event bị lỡ.
+// @Tags Events
+// @Produce text/event-stream
+// @Security X-User-Token
+// @Param id path int true "Order ID"
+// @Param Last-Event-ID header string false "ID của event cuối cùng đã nhận"
+// @Success 200 {string} string "text/event-stream"
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /events/orders/{id} [get]
+func (h *EventHandler) OrderEvents(c *fiber.Ctx) error {
+	id, ok := eventChannelID(c)
+	if !ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid order ID",
+		})
+	}
+
+	identity := middleware.IdentityFromContext(c)
+	if identity.UserID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Unauthorized",
+		})
+	}
+	if status, message := h.authorizeOrder(c, id, identity); status != fiber.StatusOK {
+		return c.Status(status).JSON(fiber.Map{
+			"error": message,
+		})
+	}
+	return h.stream(c, events.OrderStreamPrefix+id)
+}
+
+// authorizeOrder hỏi order-service user có xem được đơn hàng không (chỉ người mua và người bán),
+// trả về status và message cho client
+func (h *EventHandler) authorizeOrder(c *fiber.Ctx, id string, identity middleware.Identity) (int, string) {
+	log := logger.WithContext(c.UserContext())
+
+	route, ok := h.registry.Table().Lookup(eventRouteOrders)
+	if !ok {
+		return fiber.StatusServiceUnavailable, "Route " + eventRouteOrders + " is not configured"
+	}
+	headers, err := middleware.InternalHeaders(h.cfg, route, identity)
+	if err != nil {
+		log.Error("Failed to generate internal JWT",
+			slog.String("error", err.Error()),
+			slog.String("service", route.Upstream),
+		)
+		return fiber.StatusInternalServerError, "Failed to create request"
+	}
+	header := http.Header{}
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+	for key, value := range middleware.CorrelationHeaders(c.UserContext()) {
+		header.Set(key, value)
+	}
+	header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	header.Set(fiber.HeaderXForwardedFor, c.IP())
+
+	resp, err := h.proxy.Fetch(c.UserContext(), route, "/order/"+id, header)
+	if err != nil {
+		log.Warn("Order access check failed",
+			slog.String("order_id", id),
+			slog.String("error", err.Error()),
+		)
+		switch {
+		case errors.Is(err, breaker.ErrOpen), errors.Is(err, breaker.ErrTooManyProbes):
+			return fiber.StatusServiceUnavailable, "Service temporarily unavailable"
+		case errors.Is(err, errUpstreamTimeout):
+			return fiber.StatusGatewayTimeout, "Service timeout"
+		}
+		return fiber.StatusBadGateway, "Failed to reach service"
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, eventMaxAccessCheckBytes))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return fiber.StatusOK, ""
+	case resp.StatusCode == fiber.StatusUnauthorized, resp.StatusCode == fiber.StatusForbidden, resp.StatusCode == fiber.StatusNotFound:
+		return resp.StatusCode, upstreamErrorMessage(resp.StatusCode, body)
+	}
+	log.Warn("Unexpected order access check response",
+		slog.String("order_id", id),
+		slog.Int("status", resp.StatusCode),
+	)
+	return fiber.StatusBadGateway, "Failed to verify order access"
+}
+
+// stream đăng ký kênh, gửi lại các event sau Last-Event-ID rồi stream event mới tới khi client ngắt kết nối,
+// hết SSE_MAX_DURATION_SECONDS hoặc gateway shutdown. Khi không có event, comment heartbeat giữ kết nối qua proxy.
+func (h *EventHandler) stream(c *fiber.Ctx, key string) error {
+	// Clone vì giá trị của fiber trỏ vào buffer của request, stream writer chạy sau khi handler return
+	lastEventID := strings.Clone(c.Get("Last-Event-ID"))
+	if lastEventID == "" {
+		lastEventID = strings.Clone(c.Query("lastEventId"))
+	}
+
+	sub, backlog, err := h.broker.Subscribe(c.UserContext(), key, lastEventID, h.cfg.SSEReplayLimit)
+	if err != nil {
+		switch {
+		case errors.Is(err, events.ErrInvalidEventID):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid Last-Event-ID",
+			})
+		case errors.Is(err, events.ErrTooManyChannels):
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "Too many event streams, please try again later",
+			})
+		case errors.Is(err, events.ErrClosed):
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+				"error": "Server is shutting down",
+			})
+		}
+		logger.WithContext(c.UserContext()).Error("Failed to subscribe to event stream",
+			slog.String("stream", key),
+			slog.String("error", err.Error()),
+		)
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Event stream is unavailable",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	// Tắt buffer của nginx để event tới client ngay
+	c.Set("X-Accel-Buffering", "no")
+
+	// fiber.Ctx được giải phóng khi handler return, stream writer chỉ dùng giá trị đã copy
+	log := logger.WithContext(c.UserContext()).With(slog.String("stream", key))
+	retry := h.cfg.SSERetry
+	heartbeat := time.Duration(h.cfg.SSEHeartbeat) * time.Second
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	maxDuration := time.Duration(h.cfg.SSEMaxDuration) * time.Second
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+
+		if retry > 0 {
+			w.WriteString("retry: " + strconv.Itoa(retry) + "\n\n")
+		}
+		last := lastEventID
+		for _, event := range backlog {
+			writeEvent(w, event)
+			last = event.ID
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		var expired <-chan time.Time
+		if maxDuration > 0 {
+			timer := time.NewTimer(maxDuration)
+			defer timer.Stop()
+			expired = timer.C
+		}
+
+		for {
+			select {
+			case event, ok := <-sub.Events:
+				if !ok {
+					// Subscriber bị ngắt (đọc không kịp) hoặc gateway shutdown, client sẽ kết nối lại
+					return
+				}
+				// Event live đã được gửi trong backlog
+				if last != "" && !events.After(event.ID, last) {
+					continue
+				}
+				writeEvent(w, event)
+				last = event.ID
+			case <-ticker.C:
+				w.WriteString(": heartbeat\n\n")
+			case <-expired:
+				return
+			}
+			if err := w.Flush(); err != nil {
+				log.Debug("SSE client disconnected", slog.String("error", err.Error()))
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// writeEvent ghi một event theo định dạng text/event-stream, data nhiều dòng được tách thành nhiều dòng data
+func writeEvent(w *bufio.Writer, event events.Event) {
+	w.WriteString("id: " + event.ID + "\n")
+	if event.Type != "" && !strings.ContainsAny(event.Type, "\r\n") {
+		w.WriteString("event: " + event.Type + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\n") {
+		w.WriteString("data: " + line + "\n")
+	}
+	w.WriteString("\n")
+}
+
+// eventChannelID đọc ID sản phẩm / đơn hàng trên path
+func eventChannelID(c *fiber.Ctx) (string, bool) {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return "", false
+	}
+	return strconv.FormatInt(id, 10), true
+}
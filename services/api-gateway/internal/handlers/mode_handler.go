@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"api_gateway/internal/modes"
+	"api_gateway/internal/routes"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ModeHandler struct {
+	modes    *modes.Switch
+	registry *routes.Registry
+}
+
+func NewModeHandler(sw *modes.Switch, registry *routes.Registry) *ModeHandler {
+	return &ModeHandler{modes: sw, registry: registry}
+}
+
+// MaintenanceRequest bật hoặc tắt chế độ bảo trì
+type MaintenanceRequest struct {
+	Enabled           bool   `json:"enabled"`
+	Message           string `json:"message"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"` // 0 = MODE_RETRY_AFTER_SECONDS
+}
+
+// ReadOnlyRequest đặt danh sách upstream ở chế độ chỉ đọc ("*" = mọi upstream, rỗng = tắt)
+type ReadOnlyRequest struct {
+	Upstreams         []string `json:"upstreams"`
+	Message           string   `json:"message"`
+	RetryAfterSeconds int      `json:"retry_after_seconds"` // 0 = MODE_RETRY_AFTER_SECONDS
+}
+
+// GetModes trả về trạng thái chế độ bảo trì và chỉ đọc
+// @Summary Get maintenance and read-only modes
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {object} modes.State
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/modes [get]
+func (h *ModeHandler) GetModes(c *fiber.Ctx) error {
+	return c.JSON(h.modes.State())
+}
+
+// SetMaintenance bật hoặc tắt chế độ bảo trì cho mọi instance gateway
+// @Summary Set maintenance mode
+// @Description Khi bật, mọi route trừ /health, /metrics và /admin trả 503 kèm Retry-After
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security X-User-Token
+// @Param request body MaintenanceRequest true "Maintenance mode"
+// @Success 200 {object} modes.State
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/modes/maintenance [put]
+func (h *ModeHandler) SetMaintenance(c *fiber.Ctx) error {
+	var req MaintenanceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	adminID, _ := c.Locals("userID").(string)
+	state, err := h.modes.SetMaintenance(c.UserContext(), modes.Maintenance{
+		Enabled:           req.Enabled,
+		Message:           strings.TrimSpace(req.Message),
+		RetryAfterSeconds: req.RetryAfterSeconds,
+		UpdatedBy:         adminID,
+	})
+	if err != nil {
+		return h.error(c, err)
+	}
+	return c.JSON(state)
+}
+
+// SetReadOnly đặt danh sách upstream ở chế độ chỉ đọc cho mọi instance gateway
+// @Summary Set read-only mode
+// @Description Request ghi (khác GET/HEAD/OPTIONS) tới các upstream trong danh sách trả 503, request đọc vẫn hoạt động.
+// @Description "*" áp dụng cho mọi upstream, danh sách rỗng để tắt.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security X-User-Token
+// @Param request body ReadOnlyRequest true "Read-only mode"
+// @Success 200 {object} modes.State
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/modes/read-only [put]
+func (h *ModeHandler) SetReadOnly(c *fiber.Ctx) error {
+	var req ReadOnlyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	// Chỉ nhận upstream có trong bảng route để tránh gõ sai tên mà tưởng đã bật
+	known := map[string]bool{}
+	for _, route := range h.registry.Table().Routes {
+		known[route.Upstream] = true
+	}
+	upstreams := []string{}
+	seen := map[string]bool{}
+	for _, u := range req.Upstreams {
+		u = strings.TrimSpace(u)
+		if u == "" || seen[u] {
+			continue
+		}
+		if u != modes.AllUpstreams && !known[u] {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":    "Unknown upstream",
+				"upstream": u,
+			})
+		}
+		seen[u] = true
+		upstreams = append(upstreams, u)
+	}
+
+	adminID, _ := c.Locals("userID").(string)
+	state, err := h.modes.SetReadOnly(c.UserContext(), modes.ReadOnly{
+		Upstreams:         upstreams,
+		Message:           strings.TrimSpace(req.Message),
+		RetryAfterSeconds: req.RetryAfterSeconds,
+		UpdatedBy:         adminID,
+	})
+	if err != nil {
+		return h.error(c, err)
+	}
+	return c.JSON(state)
+}
+
+func (h *ModeHandler) error(c *fiber.Ctx, err error) error {
+	if errors.Is(err, modes.ErrInvalidRetryAfter) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	slog.Error("Failed to update gateway mode", slog.String("error", err.Error()))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to update gateway mode",
+	})
+}
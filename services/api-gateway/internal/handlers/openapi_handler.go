@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"api_gateway/internal/openapi"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type OpenAPIHandler struct {
+	aggregator *openapi.Aggregator
+}
+
+func NewOpenAPIHandler(aggregator *openapi.Aggregator) *OpenAPIHandler {
+	return &OpenAPIHandler{aggregator: aggregator}
+}
+
+// Spec trả về spec Swagger 2.0 tổng hợp từ các service, path theo gateway (/api/...)
+// @Summary Aggregated OpenAPI document
+// @Description Spec của các upstream có khối openapi trong routes.yaml, dùng để sinh client cho frontend
+// @Tags Docs
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /openapi.json [get]
+func (h *OpenAPIHandler) Spec(c *fiber.Ctx) error {
+	doc, err := h.aggregator.Document(c.UserContext())
+	if err != nil {
+		slog.Error("Failed to build OpenAPI document", slog.String("error", err.Error()))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to build OpenAPI document",
+		})
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	c.Set(fiber.HeaderCacheControl, "public, max-age=60")
+	return c.Send(doc)
+}
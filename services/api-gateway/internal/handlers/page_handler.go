@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"api_gateway/internal/breaker"
+	"api_gateway/internal/config"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/middleware"
+	"api_gateway/internal/routes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Tên route trong routes.yaml mà trang chi tiết sản phẩm gọi tới
+const (
+	pageRouteProducts = "products"
+	pageRouteOrders   = "orders"
+	pageRouteComments = "comments"
+	pageRouteBids     = "bids"
+)
+
+const (
+	pageRelatedProducts = 5
+	pageRecentComments  = 20
+	pageBidHistorySize  = 10
+	pageMaxSectionBytes = 2 * 1024 * 1024
+)
+
+// PageHandler gom dữ liệu từ nhiều service cho một trang của frontend trong một request
+type PageHandler struct {
+	cfg            *config.Config
+	registry       *routes.Registry
+	proxy          *ProxyHandler
+	sectionTimeout time.Duration
+}
+
+func NewPageHandler(cfg *config.Config, registry *routes.Registry, proxy *ProxyHandler) *PageHandler {
+	return &PageHandler{
+		cfg:            cfg,
+		registry:       registry,
+		proxy:          proxy,
+		sectionTimeout: time.Duration(cfg.PageSectionTimeout) * time.Millisecond,
+	}
+}
+
+// SectionError là lỗi của một section, các section khác vẫn được trả về
+type SectionError struct {
+	Status int    `json:"status,omitempty"` // status của upstream, không có nếu không gọi được upstream
+	Error  string `json:"error"`
+}
+
+// ProductPage là dữ liệu trang chi tiết sản phẩm, mỗi section giữ nguyên response của upstream.
+// Section lỗi có giá trị null và được mô tả trong errors.
+type ProductPage struct {
+	Product         json.RawMessage          `json:"product" swaggertype:"object"`
+	SellerRating    json.RawMessage          `json:"seller_rating" swaggertype:"object"`
+	TopBidderRating json.RawMessage          `json:"top_bidder_rating" swaggertype:"object"`      // null nếu chưa có ai ra giá
+	Comments        json.RawMessage          `json:"comments" swaggertype:"array,object"`         // câu hỏi và trả lời mới nhất
+	BidHistory      json.RawMessage          `json:"bid_history" swaggertype:"object"`            // cần đăng nhập
+	Watchlist       json.RawMessage          `json:"watchlist" swaggertype:"object"`              // null nếu chưa đăng nhập
+	RelatedProducts json.RawMessage          `json:"related_products" swaggertype:"array,object"` // cùng chuyên mục
+	Errors          map[string]*SectionError `json:"errors,omitempty"`
+}
+
+// ProductPage trả về toàn bộ dữ liệu trang chi tiết sản phẩm.
+// Các section được gọi song song, mỗi lần gọi upstream có timeout riêng (PAGE_SECTION_TIMEOUT_MS).
+// @Summary Product detail page
+// @Description Sản phẩm, điểm đánh giá người bán và người giữ giá cao nhất, bình luận, lịch sử ra giá,
+// @Description trạng thái watchlist và 5 sản phẩm cùng chuyên mục. Section lỗi trả về null kèm lỗi trong errors.
+// @Tags Pages
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {object} ProductPage
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /pages/product/{id} [get]
+func (h *PageHandler) ProductPage(c *fiber.Ctx) error {
+	productID, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil || productID <= 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid product ID",
+		})
+	}
+	id := strconv.FormatInt(productID, 10)
+
+	f := h.newFetcher(c)
+	page := &ProductPage{Errors: map[string]*SectionError{}}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	set := func(section string, dst *json.RawMessage, data json.RawMessage, err *SectionError) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			page.Errors[section] = err
+			return
+		}
+		*dst = data
+	}
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	run(func() {
+		data, err := f.get(pageRouteProducts, "/"+id)
+		set("product", &page.Product, data, err)
+		if err != nil {
+			unavailable := &SectionError{Error: "Product is unavailable"}
+			set("seller_rating", &page.SellerRating, nil, unavailable)
+			set("top_bidder_rating", &page.TopBidderRating, nil, unavailable)
+			set("related_products", &page.RelatedProducts, nil, unavailable)
+			return
+		}
+
+		// Các section phụ thuộc vào thông tin của sản phẩm
+		var product struct {
+			SellerID      int64 `json:"sellerId"`
+			CategoryID    int64 `json:"categoryId"`
+			HighestBidder *struct {
+				ID int64 `json:"id"`
+			} `json:"highestBidder"`
+		}
+		if err := json.Unmarshal(data, &product); err != nil {
+			invalid := &SectionError{Error: "Invalid product response"}
+			set("seller_rating", &page.SellerRating, nil, invalid)
+			set("top_bidder_rating", &page.TopBidderRating, nil, invalid)
+			set("related_products", &page.RelatedProducts, nil, invalid)
+			return
+		}
+
+		run(func() {
+			data, err := f.get(pageRouteOrders, fmt.Sprintf("/users/%d/rating", product.SellerID))
+			set("seller_rating", &page.SellerRating, data, err)
+		})
+		if product.HighestBidder != nil && product.HighestBidder.ID > 0 {
+			run(func() {
+				data, err := f.get(pageRouteOrders, fmt.Sprintf("/users/%d/rating", product.HighestBidder.ID))
+				set("top_bidder_rating", &page.TopBidderRating, data, err)
+			})
+		}
+		run(func() {
+			data, err := f.relatedProducts(productID, product.CategoryID)
+			set("related_products", &page.RelatedProducts, data, err)
+		})
+	})
+	run(func() {
+		data, err := f.get(pageRouteComments, fmt.Sprintf("/products/%s?limit=%d&offset=0", id, pageRecentComments))
+		set("comments", &page.Comments, data, err)
+	})
+	run(func() {
+		data, err := f.get(pageRouteBids, fmt.Sprintf("/search?productId=%s&status=SUCCESS&page=0&size=%d", id, pageBidHistorySize))
+		set("bid_history", &page.BidHistory, data, err)
+	})
+	if f.identity.UserID != "" {
+		run(func() {
+			data, err := f.get(pageRouteOrders, "/watchlist/"+id+"/check")
+			set("watchlist", &page.Watchlist, data, err)
+		})
+	}
+
+	wg.Wait()
+
+	if e := page.Errors["product"]; e != nil && e.Status == fiber.StatusNotFound {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Product not found",
+		})
+	}
+	if len(page.Errors) > 0 {
+		logger.WithContext(c.UserContext()).Warn("Product page served with partial results",
+			slog.String("product_id", id),
+			slog.Any("errors", page.Errors),
+		)
+	}
+	return c.JSON(page)
+}
+
+// pageFetcher gọi upstream cho các section của một trang. Thông tin của request được copy
+// trước khi fan out vì fiber.Ctx không an toàn khi dùng từ nhiều goroutine.
+type pageFetcher struct {
+	h              *PageHandler
+	ctx            context.Context
+	identity       middleware.Identity
+	acceptLanguage string
+	forwardedFor   string
+}
+
+func (h *PageHandler) newFetcher(c *fiber.Ctx) *pageFetcher {
+	forwardedFor := c.IP()
+	if prior := c.Get(fiber.HeaderXForwardedFor); prior != "" {
+		forwardedFor = prior + ", " + forwardedFor
+	}
+	return &pageFetcher{
+		h:              h,
+		ctx:            c.UserContext(),
+		identity:       middleware.IdentityFromContext(c),
+		acceptLanguage: strings.Clone(c.Get(fiber.HeaderAcceptLanguage)),
+		forwardedFor:   strings.Clone(forwardedFor),
+	}
+}
+
+// get gọi GET target trên upstream của route (qua load balancer, circuit breaker và retry)
+// và trả về body JSON nếu upstream trả 2xx
+func (f *pageFetcher) get(routeName, target string) (json.RawMessage, *SectionError) {
+	route, ok := f.h.registry.Table().Lookup(routeName)
+	if !ok {
+		return nil, &SectionError{Error: "Route " + routeName + " is not configured"}
+	}
+
+	headers, err := middleware.InternalHeaders(f.h.cfg, route, f.identity)
+	if err != nil {
+		logger.WithContext(f.ctx).Error("Failed to generate internal JWT",
+			slog.String("error", err.Error()),
+			slog.String("service", route.Upstream),
+		)
+		return nil, &SectionError{Error: "Failed to create request"}
+	}
+	header := http.Header{}
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+	for key, value := range middleware.CorrelationHeaders(f.ctx) {
+		header.Set(key, value)
+	}
+	header.Set(fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	header.Set(fiber.HeaderXForwardedFor, f.forwardedFor)
+	if f.acceptLanguage != "" {
+		header.Set(fiber.HeaderAcceptLanguage, f.acceptLanguage)
+	}
+
+	ctx, cancel := context.WithTimeout(f.ctx, f.h.sectionTimeout)
+	defer cancel()
+
+	resp, err := f.h.proxy.Fetch(ctx, route, target, header)
+	if err != nil {
+		logger.WithContext(f.ctx).Warn("Page section request failed",
+			slog.String("route", route.Name),
+			slog.String("target_path", target),
+			slog.String("error", err.Error()),
+		)
+		switch {
+		case errors.Is(err, breaker.ErrOpen), errors.Is(err, breaker.ErrTooManyProbes):
+			return nil, &SectionError{Error: "Service temporarily unavailable"}
+		case errors.Is(err, errUpstreamTimeout), errors.Is(err, context.DeadlineExceeded):
+			return nil, &SectionError{Error: "Service timeout"}
+		}
+		return nil, &SectionError{Error: "Failed to reach service"}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, pageMaxSectionBytes+1))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, &SectionError{Error: "Service timeout"}
+		}
+		return nil, &SectionError{Error: "Failed to read service response"}
+	}
+	if len(body) > pageMaxSectionBytes {
+		return nil, &SectionError{Error: "Service response too large"}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &SectionError{Status: resp.StatusCode, Error: upstreamErrorMessage(resp.StatusCode, body)}
+	}
+	if !json.Valid(body) {
+		return nil, &SectionError{Status: resp.StatusCode, Error: "Invalid service response"}
+	}
+	return body, nil
+}
+
+// relatedProducts lấy các sản phẩm cùng chuyên mục, bỏ sản phẩm đang xem
+func (f *pageFetcher) relatedProducts(productID, categoryID int64) (json.RawMessage, *SectionError) {
+	target := fmt.Sprintf("/search?categoryId=%d&page=0&pageSize=%d", categoryID, pageRelatedProducts+1)
+	data, sectionErr := f.get(pageRouteProducts, target)
+	if sectionErr != nil {
+		return nil, sectionErr
+	}
+
+	var result struct {
+		Data struct {
+			Content []json.RawMessage `json:"content"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, &SectionError{Error: "Invalid service response"}
+	}
+
+	related := make([]json.RawMessage, 0, pageRelatedProducts)
+	for _, item := range result.Data.Content {
+		var p struct {
+			ID int64 `json:"id"`
+		}
+		if json.Unmarshal(item, &p) == nil && p.ID == productID {
+			continue
+		}
+		if len(related) == pageRelatedProducts {
+			break
+		}
+		related = append(related, item)
+	}
+
+	out, err := json.Marshal(related)
+	if err != nil {
+		return nil, &SectionError{Error: "Invalid service response"}
+	}
+	return out, nil
+}
+
+// upstreamErrorMessage lấy error/message trong body lỗi của upstream, mặc định là status text
+func upstreamErrorMessage(status int, body []byte) string {
+	var payload struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		if payload.Error != "" {
+			return payload.Error
+		}
+		if payload.Message != "" {
+			return payload.Message
+		}
+	}
+	return http.StatusText(status)
+}
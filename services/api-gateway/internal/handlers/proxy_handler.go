@@ -1,170 +1,382 @@
 package handlers
 
 import (
+	"api_gateway/internal/breaker"
 	"api_gateway/internal/config"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/middleware"
+	"api_gateway/internal/routes"
+	"api_gateway/internal/upstream"
+	"api_gateway/internal/utils"
 	"bytes"
+	"context"
+	"errors"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// Status (theo quy ước của nginx) khi client đóng kết nối trước khi upstream trả response, chỉ dùng để ghi log
+const statusClientClosedRequest = 499
+
+var (
+	errRequestBodyTooLarge  = errors.New("request body too large")
+	errResponseBodyTooLarge = errors.New("response body too large")
+)
+
 type ProxyHandler struct {
-	cfg *config.Config
+	cfg       *config.Config
+	client    *http.Client
+	balancer  *upstream.Balancer
+	breakers  *breaker.Registry // nil khi circuit breaker bị tắt
+	wsUpgrade fiber.Handler
+	// shadowSlots giới hạn số request shadow đang chạy
+	shadowSlots chan struct{}
 }
 
-func NewProxyHandler(cfg *config.Config) *ProxyHandler {
-	return &ProxyHandler{cfg: cfg}
+func NewProxyHandler(cfg *config.Config, balancer *upstream.Balancer, breakers *breaker.Registry) *ProxyHandler {
+	// Transport dùng chung cho mọi upstream để tái sử dụng connection
+	transport := &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout:   time.Duration(cfg.ProxyDialTimeout) * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          cfg.ProxyMaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.ProxyMaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		// Giữ nguyên Accept-Encoding/Content-Encoding giữa client và upstream
+		DisableCompression: true,
+	}
+
+	h := &ProxyHandler{
+		cfg:      cfg,
+		balancer: balancer,
+		breakers: breakers,
+		client: &http.Client{
+			Transport: transport,
+			// Proxy trả redirect nguyên vẹn cho client, không tự follow
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	h.wsUpgrade = h.newWebSocketUpgrader()
+	if cfg.ShadowEnabled && cfg.ShadowMaxConcurrency > 0 {
+		h.shadowSlots = make(chan struct{}, cfg.ShadowMaxConcurrency)
+	}
+	return h
 }
 
-// ProxyRequest forwards the request to the target service
-func (h *ProxyHandler) ProxyRequest(targetURL string) fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		startTime := time.Now()
-		
-		// Build target URL
-		path := strings.Trim(c.Params("*"), "/")
-
-		fullURL := strings.TrimSuffix(targetURL, "/")
-		if path != "" {
-			fullURL += "/" + path
-		}
+// Proxy forwards the request to the upstream of the route matched by RouteMiddleware
+func (h *ProxyHandler) Proxy(c *fiber.Ctx) error {
+	route, ok := c.Locals("route").(*routes.Route)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Route not found",
+		})
+	}
 
-		// Add query params
-		if len(c.Context().QueryArgs().String()) > 0 {
-			fullURL += "?" + c.Context().QueryArgs().String()
-		}
+	if route.Type == routes.TypeWebSocket {
+		return h.ProxyWebSocket(c)
+	}
+	return h.ProxyRequest(c)
+}
 
-		// Log proxy request
-		logAttrs := []any{
-			slog.String("target_url", fullURL),
-			slog.String("method", c.Method()),
-			slog.String("original_path", c.Path()),
-		}
-		
-		if userID, ok := c.Locals("userID").(string); ok && userID != "" {
-			logAttrs = append(logAttrs, slog.String("user_id", userID))
-		}
-		
-		slog.Debug("Proxying request to service", logAttrs...)
-		
-		// Create request
-		req, err := http.NewRequest(c.Method(), fullURL, bytes.NewReader(c.Body()))
-		if err != nil {
-			slog.Error("Failed to create proxy request", 
-				slog.String("error", err.Error()),
-				slog.String("target_url", fullURL),
-			)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to create request",
-			})
-		}
+// ProxyRequest streams the request to the target service and streams the response back.
+// Body không bị buffer toàn bộ trong gateway; route timeout áp dụng cho tới khi nhận được response header.
+func (h *ProxyHandler) ProxyRequest(c *fiber.Ctx) error {
+	route := c.Locals("route").(*routes.Route)
+	startTime := time.Now()
 
-		// Copy headers from original request
-		c.Request().Header.VisitAll(func(key, value []byte) {
-			req.Header.Set(string(key), string(value))
+	// Build target path, endpoint được chọn bởi load balancer cho mỗi lần gửi
+	path, _ := c.Locals("routePath").(string)
+
+	target := ""
+	if path != "" {
+		target += "/" + path
+	}
+
+	// Add query params
+	if query := c.Context().QueryArgs().String(); len(query) > 0 {
+		target += "?" + query
+	}
+
+	// Log proxy request
+	logAttrs := []any{
+		slog.String("route", route.Name),
+		slog.String("target_path", target),
+		slog.String("method", c.Method()),
+		slog.String("original_path", c.Path()),
+	}
+
+	if userID, ok := c.Locals("userID").(string); ok && userID != "" {
+		logAttrs = append(logAttrs, slog.String("user_id", userID))
+	}
+
+	logger.WithContext(c.UserContext()).Debug("Proxying request to service", logAttrs...)
+
+	// Giới hạn kích thước request body (limits của route hoặc giới hạn chung)
+	maxRequestBody := route.MaxBodyBytes(h.cfg.ProxyMaxRequestBodyBytes)
+	contentLength := int64(c.Request().Header.ContentLength())
+	if maxRequestBody > 0 && contentLength > maxRequestBody {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+			"error": "Request body too large",
+			"limit": maxRequestBody,
 		})
+	}
 
-		// Make request
-		client := &http.Client{
-			Timeout: 30 * time.Second,
-		}
-		resp, err := client.Do(req)
-		if err != nil {
-			duration := time.Since(startTime)
-			slog.Error("Failed to reach service",
-				slog.String("error", err.Error()),
-				slog.String("target_url", fullURL),
-				slog.Duration("duration", duration),
-			)
-			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
-				"error":   "Failed to reach service",
-				"details": err.Error(),
-			})
-		}
-		defer resp.Body.Close()
+	// Huỷ request tới upstream khi gateway shutdown (Done của fasthttp.RequestCtx chỉ đóng khi server dừng)
+	// hoặc khi client đóng kết nối trong lúc chờ response header (theo dõi từ khi đọc xong request body).
+	// Client ngắt kết nối khi đang stream response thì body bị Close và huỷ ctx.
+	ctx, cancel := context.WithCancel(c.UserContext())
+	stopAfter := context.AfterFunc(c.Context(), cancel)
+	var clientGone atomic.Bool
+	watch := newClientWatch(c.Context().Conn(), func() {
+		clientGone.Store(true)
+		cancel()
+	})
 
-		// Copy response headers
-		for key, values := range resp.Header {
-			for _, value := range values {
-				c.Response().Header.Add(key, value)
-			}
-		}
+	body := &eofNotifyReader{r: h.requestBody(c, maxRequestBody), onEOF: watch.start}
+	req, err := http.NewRequestWithContext(middleware.WithForwardTrace(c, ctx), c.Method(), route.URLs[0]+target, body)
+	if err != nil {
+		stopAfter()
+		cancel()
+		logger.WithContext(c.UserContext()).Error("Failed to create proxy request",
+			slog.String("error", err.Error()),
+			slog.String("route", route.Name),
+			slog.String("target_path", target),
+		)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create request",
+		})
+	}
+	switch {
+	case contentLength > 0:
+		req.ContentLength = contentLength
+	case contentLength < 0:
+		req.ContentLength = -1 // chunked
+	default:
+		req.Body = http.NoBody
+		watch.start()
+	}
 
-		// Copy response body
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			slog.Error("Failed to read service response",
-				slog.String("error", err.Error()),
-				slog.String("target_url", fullURL),
-			)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to read response",
-			})
-		}
+	copyRequestHeaders(c, req)
+
+	// Request đọc được mirror tới shadow của route (nếu có), kết quả được so sánh với response thật
+	dest, _ := c.Locals("routeTarget").(*routes.Target)
+	compareShadow := h.mirror(route, req, target)
 
+	// Gửi qua circuit breaker của upstream; request an toàn được retry khi upstream lỗi
+	sendStart := time.Now()
+	resp, err := h.send(route, dest, req, target, middleware.AffinityKey(c))
+	watch.stop()
+	if compareShadow != nil {
+		compareShadow(resp, err, time.Since(sendStart))
+	}
+	if err != nil {
+		stopAfter()
+		cancel()
+		if errors.Is(err, breaker.ErrOpen) || errors.Is(err, breaker.ErrTooManyProbes) {
+			return h.circuitOpen(c, route, dest)
+		}
 		duration := time.Since(startTime)
-		
-		// Log successful proxy
-		slog.Info("Proxy request completed",
-			slog.String("target_url", fullURL),
-			slog.Int("status", resp.StatusCode),
+		if clientGone.Load() {
+			logger.WithContext(c.UserContext()).Info("Client closed connection before service responded",
+				slog.String("route", route.Name),
+				slog.String("target_path", target),
+				slog.Duration("duration", duration),
+			)
+			return c.SendStatus(statusClientClosedRequest)
+		}
+		logger.WithContext(c.UserContext()).Error("Failed to reach service",
+			slog.String("error", err.Error()),
+			slog.String("route", route.Name),
+			slog.String("target_path", target),
 			slog.Duration("duration", duration),
-			slog.Int("response_size", len(body)),
 		)
-
-		c.Status(resp.StatusCode)
-		return c.Send(body)
+		switch {
+		case errors.Is(err, errRequestBodyTooLarge):
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": "Request body too large",
+				"limit": maxRequestBody,
+			})
+		case errors.Is(err, errUpstreamTimeout):
+			return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{
+				"error": "Service timeout",
+			})
+		}
+		// Chi tiết lỗi (địa chỉ nội bộ của upstream) chỉ được log, không trả về client
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Failed to reach service",
+		})
 	}
-}
 
-func (h *ProxyHandler) ProxyWebSocket(c *fiber.Ctx) error {
-	internalJWT := c.Get("X-Internal-JWT")
-	if internalJWT == "" {
-		slog.Warn("WebSocket proxy request missing internal JWT",
-			slog.String("path", c.Path()),
-			slog.String("ip", c.IP()),
+	maxResponseBody := h.cfg.ProxyMaxResponseBodyBytes
+	if maxResponseBody > 0 && resp.ContentLength > maxResponseBody {
+		resp.Body.Close()
+		stopAfter()
+		cancel()
+		logger.WithContext(c.UserContext()).Error("Service response too large",
+			slog.String("target_url", resp.Request.URL.String()),
+			slog.Int64("content_length", resp.ContentLength),
 		)
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Missing X-Internal-JWT header",
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Service response too large",
 		})
 	}
 
-	slog.Info("WebSocket connection info provided",
-		slog.String("service", "comment-service"),
-		slog.String("path", c.Path()),
+	copyResponseHeaders(c, resp)
+	c.Status(resp.StatusCode)
+
+	// Stream response body; fasthttp gọi Close() sau khi ghi xong hoặc khi client ngắt kết nối
+	c.Context().SetBodyStream(middleware.WrapResponseBody(c, &upstreamBody{
+		body:      resp.Body,
+		remaining: maxResponseBody,
+		limited:   maxResponseBody > 0,
+		release: func() {
+			stopAfter()
+			cancel()
+		},
+	}), int(resp.ContentLength))
+
+	duration := time.Since(startTime)
+
+	// Log successful proxy
+	logger.WithContext(c.UserContext()).Info("Proxy request completed",
+		slog.String("target_url", resp.Request.URL.String()),
+		slog.Int("status", resp.StatusCode),
+		slog.Duration("duration", duration),
+		slog.Int64("content_length", resp.ContentLength),
 	)
 
-	return c.JSON(fiber.Map{
-		"comment_service_websocket_url": h.cfg.CommentServiceWebSocketURL,
-		"internal_jwt":                  internalJWT,
-	})
+	return nil
 }
 
-func (h *ProxyHandler) OrderProxyWebSocket(c *fiber.Ctx) error {
-	internalJWT := c.Get("X-Internal-JWT")
-	if internalJWT == "" {
-		slog.Warn("WebSocket proxy request missing internal JWT",
-			slog.String("path", c.Path()),
-			slog.String("ip", c.IP()),
-		)
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": "Missing X-Internal-JWT header",
-		})
+// Fetch gửi request GET tới upstream của route qua load balancer và circuit breaker,
+// dùng bởi response cache để làm mới entry ở background
+func (h *ProxyHandler) Fetch(ctx context.Context, route *routes.Route, target string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, route.URLs[0]+target, nil)
+	if err != nil {
+		return nil, err
 	}
+	req.Header = header
+	utils.RemoveHopHeaders(req.Header)
+	return h.send(route, nil, req, target, "")
+}
 
-	slog.Info("WebSocket connection info provided",
-		slog.String("service", "order-service"),
-		slog.String("path", c.Path()),
-	)
+// requestBody trả về reader stream request body của client, có giới hạn kích thước
+func (h *ProxyHandler) requestBody(c *fiber.Ctx, limit int64) io.Reader {
+	var body io.Reader = c.Context().RequestBodyStream()
+	if body == nil {
+		// Body đã được đọc vào bộ nhớ (ví dụ bởi middleware khác); dùng body gốc, không giải nén Content-Encoding
+		body = bytes.NewReader(c.Request().Body())
+	}
+	if limit <= 0 {
+		return body
+	}
+	return &limitedReader{r: body, remaining: limit}
+}
 
-	return c.JSON(fiber.Map{
-		"order_service_websocket_url": h.cfg.OrderServiceWebSocketURL,
-		"internal_jwt":                internalJWT,
+// copyRequestHeaders copy header của client sang request upstream, bỏ hop-by-hop header
+// và thêm X-Forwarded-*. X-Forwarded-For do client gửi chỉ được giữ khi request đi qua proxy tin cậy (TRUSTED_PROXIES).
+func copyRequestHeaders(c *fiber.Ctx, req *http.Request) {
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		k := string(key)
+		if strings.EqualFold(k, fiber.HeaderHost) || strings.EqualFold(k, fiber.HeaderContentLength) {
+			return
+		}
+		req.Header.Add(k, string(value))
 	})
+	utils.RemoveHopHeaders(req.Header)
+
+	if !c.App().Config().EnableTrustedProxyCheck || !c.IsProxyTrusted() {
+		req.Header.Del(fiber.HeaderXForwardedFor)
+		req.Header.Del("X-Real-IP")
+	}
+	if prior := req.Header.Get(fiber.HeaderXForwardedFor); prior != "" {
+		req.Header.Set(fiber.HeaderXForwardedFor, prior+", "+c.IP())
+	} else {
+		req.Header.Set(fiber.HeaderXForwardedFor, c.IP())
+	}
+	req.Header.Set(fiber.HeaderXForwardedHost, c.Hostname())
+	req.Header.Set(fiber.HeaderXForwardedProto, c.Protocol())
+}
+
+// copyResponseHeaders copy header của upstream về client, bỏ hop-by-hop header
+func copyResponseHeaders(c *fiber.Ctx, resp *http.Response) {
+	utils.RemoveHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		if strings.EqualFold(key, fiber.HeaderContentLength) {
+			continue
+		}
+		for _, value := range values {
+			c.Response().Header.Add(key, value)
+		}
+	}
+}
+
+// limitedReader trả lỗi errRequestBodyTooLarge khi đọc quá remaining byte
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errRequestBodyTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errRequestBodyTooLarge
+	}
+	return n, err
+}
+
+// upstreamBody bọc response body của upstream: giới hạn kích thước và giải phóng context khi đóng
+type upstreamBody struct {
+	body      io.ReadCloser
+	remaining int64
+	limited   bool
+	release   func()
+}
+
+func (b *upstreamBody) Read(p []byte) (int, error) {
+	if !b.limited {
+		return b.body.Read(p)
+	}
+	if b.remaining <= 0 {
+		// Thử đọc thêm 1 byte để phân biệt EOF với body vượt giới hạn
+		var one [1]byte
+		if n, _ := b.body.Read(one[:]); n > 0 {
+			return 0, errResponseBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.body.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *upstreamBody) Close() error {
+	err := b.body.Close()
+	b.release()
+	return err
 }
 
 // Health check
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"api_gateway/internal/breaker"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/metrics"
+	"api_gateway/internal/routes"
+	"api_gateway/internal/upstream"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var errUpstreamTimeout = errors.New("upstream timeout")
+
+// send gửi request tới một endpoint của route (chọn bởi load balancer theo key) qua circuit breaker
+// của route.Upstream. dest là target canary của route (nil là urls của route), có circuit breaker riêng.
+// target là path và query nối sau URL của endpoint.
+// Request an toàn (GET/HEAD/OPTIONS, không có body) được retry với backoff khi gặp lỗi mạng hoặc 502/503/504,
+// mỗi lần thử chọn lại endpoint. Timeout của route áp dụng cho từng lần thử; request bị timeout không được retry.
+func (h *ProxyHandler) send(route *routes.Route, dest *routes.Target, req *http.Request, target, key string) (*http.Response, error) {
+	upstreamName := destUpstream(route, dest)
+
+	var cb *breaker.Breaker
+	if h.breakers != nil {
+		cb = h.breakers.Get(upstreamName)
+	}
+
+	attempts := 1
+	if isRetryableRequest(req) && h.cfg.RetryMaxAttempts > 0 {
+		attempts += h.cfg.RetryMaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		var done func(breaker.Outcome, time.Duration)
+		if cb != nil {
+			var err error
+			if done, err = cb.Allow(); err != nil {
+				return nil, err
+			}
+		}
+
+		endpoint := h.balancer.PickTarget(route, dest, key)
+		start := time.Now()
+		resp, err := h.attempt(req, endpoint, target, route.TimeoutDuration())
+		elapsed := time.Since(start)
+		if done != nil {
+			done(outcomeOf(req, resp, err), elapsed)
+		}
+		recordUpstreamDuration(req, route, upstreamName, resp, err, elapsed)
+
+		if attempt >= attempts || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+			// Đọc bỏ một phần body để connection được tái sử dụng
+			io.CopyN(io.Discard, resp.Body, 4096)
+			resp.Body.Close()
+		}
+
+		delay := h.retryDelay(attempt)
+		attrs := []any{
+			slog.String("upstream", upstreamName),
+			slog.String("endpoint", endpoint.URL),
+			slog.String("method", req.Method),
+			slog.Int("attempt", attempt),
+			slog.Int("upstream_status", status),
+			slog.Duration("delay", delay),
+		}
+		if err != nil {
+			attrs = append(attrs, slog.String("error", err.Error()))
+		}
+		logger.WithContext(req.Context()).Warn("Retrying upstream request", attrs...)
+		if metrics.ProxyRetries != nil {
+			metrics.ProxyRetries.Add(req.Context(), 1, metric.WithAttributes(
+				attribute.String("upstream", upstreamName),
+			))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// recordUpstreamDuration ghi thời gian chờ upstream của một lần thử (tới khi nhận response header),
+// tách riêng khỏi tổng thời gian xử lý request ở gateway
+func recordUpstreamDuration(req *http.Request, route *routes.Route, upstreamName string, resp *http.Response, err error, elapsed time.Duration) {
+	if metrics.UpstreamDuration == nil {
+		return
+	}
+	statusClass := "error"
+	if err == nil {
+		statusClass = metrics.StatusClass(resp.StatusCode)
+	}
+	metrics.UpstreamDuration.Record(req.Context(), float64(elapsed.Microseconds())/1000, metric.WithAttributes(
+		attribute.String("upstream", upstreamName),
+		attribute.String("route", "/api"+route.PathPrefix+"/*"),
+		attribute.String("method", req.Method),
+		attribute.String("status_class", statusClass),
+	))
+}
+
+// attempt thực hiện một lần gọi endpoint với timeout chờ response header.
+// Context của lần thử và endpoint được giải phóng khi response body được đóng.
+func (h *ProxyHandler) attempt(req *http.Request, endpoint *upstream.Endpoint, target string, timeout time.Duration) (*http.Response, error) {
+	u, err := url.Parse(endpoint.URL + target)
+	if err != nil {
+		endpoint.Release()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	release := func() {
+		cancel()
+		endpoint.Release()
+	}
+
+	out := req.WithContext(ctx)
+	out.URL = u
+	out.Host = u.Host
+
+	timer := time.AfterFunc(timeout, cancel)
+	resp, err := h.client.Do(out)
+	timedOut := !timer.Stop()
+
+	if err != nil {
+		release()
+		if timedOut && !errors.Is(err, errRequestBodyTooLarge) {
+			return nil, fmt.Errorf("%w after %s", errUpstreamTimeout, timeout)
+		}
+		return nil, err
+	}
+	if timedOut {
+		// Timer đã huỷ context ngay khi response header vừa tới
+		resp.Body.Close()
+		release()
+		return nil, fmt.Errorf("%w after %s", errUpstreamTimeout, timeout)
+	}
+
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// outcomeOf phân loại kết quả cho circuit breaker: lỗi mạng, timeout và 5xx là lỗi của upstream,
+// request bị huỷ bởi client hoặc body quá lớn không được tính
+func outcomeOf(req *http.Request, resp *http.Response, err error) breaker.Outcome {
+	if err != nil {
+		if errors.Is(err, errRequestBodyTooLarge) || req.Context().Err() != nil {
+			return breaker.Ignored
+		}
+		return breaker.Failure
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return breaker.Failure
+	}
+	return breaker.Success
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, errRequestBodyTooLarge) && !errors.Is(err, errUpstreamTimeout)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isRetryableRequest chỉ cho phép retry method an toàn không có body (body đã stream không gửi lại được)
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
+
+// retryDelay là exponential backoff có jitter: ngẫu nhiên trong [d/2, d], d = base * 2^(attempt-1) <= max
+func (h *ProxyHandler) retryDelay(attempt int) time.Duration {
+	base := time.Duration(h.cfg.RetryBaseDelay) * time.Millisecond
+	maxDelay := time.Duration(h.cfg.RetryMaxDelay) * time.Millisecond
+	if base <= 0 {
+		return 0
+	}
+
+	d := time.Duration(float64(base) * math.Pow(2, float64(attempt-1)))
+	if maxDelay > 0 && (d > maxDelay || d <= 0) {
+		d = maxDelay
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
+
+// destUpstream là tên upstream (và circuit breaker) của destination được chọn cho request:
+// target canary nếu có, ngược lại là upstream của route
+func destUpstream(route *routes.Route, dest *routes.Target) string {
+	if dest != nil {
+		return dest.Upstream
+	}
+	return route.Upstream
+}
+
+// circuitOpen trả về 503 ngay khi circuit của destination được chọn (dest, nil là upstream của route) đang mở
+func (h *ProxyHandler) circuitOpen(c *fiber.Ctx, route *routes.Route, dest *routes.Target) error {
+	upstreamName := destUpstream(route, dest)
+	retryAfter := time.Second
+	if b, ok := h.breakers.Lookup(upstreamName); ok {
+		if d := b.RetryAfter(); d > retryAfter {
+			retryAfter = d
+		}
+	}
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+
+	logger.WithContext(c.UserContext()).Warn("Circuit open, rejecting request",
+		slog.String("route", route.Name),
+		slog.String("upstream", upstreamName),
+		slog.String("path", c.Path()),
+		slog.Int("retry_after", seconds),
+	)
+
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+		"error":       "Service temporarily unavailable",
+		"code":        "CIRCUIT_OPEN",
+		"upstream":    upstreamName,
+		"retry_after": seconds,
+	})
+}
+
+// releaseOnClose giải phóng context của lần thử và endpoint khi response body được đóng
+type releaseOnClose struct {
+	io.ReadCloser
+	release   func()
+	closeOnce sync.Once
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.closeOnce.Do(b.release)
+	return err
+}
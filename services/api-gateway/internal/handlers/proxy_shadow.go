@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"api_gateway/internal/logger"
+	"api_gateway/internal/metrics"
+	"api_gateway/internal/routes"
+	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// shadowDrainLimit là số byte tối đa của response shadow được đọc bỏ để connection được tái sử dụng
+const shadowDrainLimit = 1 << 20
+
+// Kết quả so sánh request shadow với response thật, dùng làm label metrics
+const (
+	shadowMatch          = "match"
+	shadowStatusMismatch = "status_mismatch"
+	shadowError          = "error"
+	shadowDropped        = "dropped"
+)
+
+// primaryResult là kết quả của request thật gửi cho goroutine shadow để so sánh
+type primaryResult struct {
+	status  int
+	err     error
+	latency time.Duration
+}
+
+// mirror gửi bản sao của request đọc (GET/HEAD) tới shadow của route ở background theo tỉ lệ shadow.sample.
+// Trả về hàm nhận kết quả của request thật (phải được gọi ngay sau send), nil nếu request không được mirror.
+// Request shadow không đi qua circuit breaker và không được retry; response của shadow bị bỏ đi.
+func (h *ProxyHandler) mirror(route *routes.Route, req *http.Request, target string) func(*http.Response, error, time.Duration) {
+	s := route.Shadow
+	if s == nil || h.shadowSlots == nil {
+		return nil
+	}
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil
+	}
+	if rand.Float64()*100 >= s.Sample {
+		return nil
+	}
+
+	select {
+	case h.shadowSlots <- struct{}{}:
+	default:
+		// Quá nhiều request shadow đang chạy: bỏ mẫu này thay vì làm chậm request thật
+		recordShadow(req.Context(), route, s, shadowDropped)
+		return nil
+	}
+
+	header := req.Header.Clone()
+	header.Set("X-Shadow-Request", "true")
+	primary := make(chan primaryResult, 1)
+
+	go func() {
+		defer func() { <-h.shadowSlots }()
+
+		// Không bị huỷ theo request thật: shadow chạy tiếp sau khi client đã nhận response.
+		// Context vẫn giữ request ID và trace của request thật để log nối được với nhau.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), route.TimeoutDuration())
+		defer cancel()
+
+		status, latency, err := h.sendShadow(ctx, route, req.Method, target, header)
+		result := <-primary
+		compareShadow(ctx, route, req.Method, target, result, status, latency, err)
+	}()
+
+	return func(resp *http.Response, err error, latency time.Duration) {
+		result := primaryResult{err: err, latency: latency}
+		if resp != nil {
+			result.status = resp.StatusCode
+		}
+		primary <- result
+	}
+}
+
+// sendShadow gọi một endpoint của shadow, trả về status và thời gian tới khi nhận response header
+func (h *ProxyHandler) sendShadow(ctx context.Context, route *routes.Route, method, target string, header http.Header) (int, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, method, route.Shadow.URLs[0]+target, http.NoBody)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header = header
+
+	endpoint := h.balancer.PickTarget(route, route.Shadow.Target(), "")
+	start := time.Now()
+	resp, err := h.attempt(req, endpoint, target, route.TimeoutDuration())
+	latency := time.Since(start)
+	recordUpstreamDuration(req, route, route.Shadow.Upstream, resp, err, latency)
+	if err != nil {
+		return 0, latency, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, shadowDrainLimit))
+	resp.Body.Close()
+	return resp.StatusCode, latency, nil
+}
+
+// compareShadow log khác biệt giữa response thật và response của shadow
+func compareShadow(ctx context.Context, route *routes.Route, method, target string, primary primaryResult, status int, latency time.Duration, err error) {
+	attrs := []any{
+		slog.String("route", route.Name),
+		slog.String("shadow_upstream", route.Shadow.Upstream),
+		slog.String("method", method),
+		slog.String("target_path", target),
+		slog.Int("primary_status", primary.status),
+		slog.Int("shadow_status", status),
+		slog.Duration("primary_latency", primary.latency),
+		slog.Duration("shadow_latency", latency),
+		slog.Duration("latency_diff", latency-primary.latency),
+	}
+	if primary.err != nil {
+		attrs = append(attrs, slog.String("primary_error", primary.err.Error()))
+	}
+
+	switch {
+	case err != nil:
+		attrs = append(attrs, slog.String("error", err.Error()))
+		logger.WithContext(ctx).Warn("Shadow request failed", attrs...)
+		recordShadow(context.Background(), route, route.Shadow, shadowError)
+	case status != primary.status:
+		logger.WithContext(ctx).Warn("Shadow response status differs", attrs...)
+		recordShadow(context.Background(), route, route.Shadow, shadowStatusMismatch)
+	default:
+		logger.WithContext(ctx).Debug("Shadow response matches", attrs...)
+		recordShadow(context.Background(), route, route.Shadow, shadowMatch)
+	}
+}
+
+func recordShadow(ctx context.Context, route *routes.Route, s *routes.Shadow, result string) {
+	if metrics.ShadowRequests != nil {
+		metrics.ShadowRequests.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("route", route.Name),
+			attribute.String("upstream", s.Upstream),
+			attribute.String("result", result),
+		))
+	}
+}
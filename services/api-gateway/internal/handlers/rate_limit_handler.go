@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"api_gateway/internal/middleware"
+	"errors"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type RateLimitHandler struct {
+	limiter        *middleware.RateLimiter
+	maxOverrideTTL time.Duration
+}
+
+func NewRateLimitHandler(limiter *middleware.RateLimiter, maxOverrideTTL time.Duration) *RateLimitHandler {
+	return &RateLimitHandler{limiter: limiter, maxOverrideTTL: maxOverrideTTL}
+}
+
+// ResetRateLimitRequest reset mọi key của một subject, hoặc một key cụ thể của policy
+type ResetRateLimitRequest struct {
+	Subject string `json:"subject"` // user:<id>, ip:<address>, api_key:<hash>
+	Policy  string `json:"policy"`
+	Key     string `json:"key"`
+}
+
+// OverrideRequest tạm thời cho phép (allow) hoặc chặn (deny) một subject
+type OverrideRequest struct {
+	Subject    string `json:"subject"`
+	Action     string `json:"action"`
+	TTLSeconds int64  `json:"ttl_seconds"`
+	Reason     string `json:"reason"`
+}
+
+// ListPolicies trả về các rate-limit policy đang áp dụng
+// @Summary List rate limit policies
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {array} ratelimit.Policy
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/rate-limits/policies [get]
+func (h *RateLimitHandler) ListPolicies(c *fiber.Ctx) error {
+	return c.JSON(h.limiter.Policies())
+}
+
+// TopOffenders trả về các key có nhiều request nhất trong window hiện tại
+// @Summary Top rate limit offenders
+// @Description Key bị throttle hoặc có nhiều request nhất trong window hiện tại
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Param policy query string false "Policy name"
+// @Param limit query int false "Max results (default 20, max 200)"
+// @Success 200 {array} middleware.KeyUsage
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/rate-limits/top [get]
+func (h *RateLimitHandler) TopOffenders(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 20)
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+
+	usages, err := h.limiter.TopOffenders(c.UserContext(), c.Query("policy"), limit)
+	if err != nil {
+		return h.error(c, err)
+	}
+	return c.JSON(usages)
+}
+
+// Usage trả về số request hiện tại của một user hoặc IP trên mọi policy
+// @Summary Rate limit usage of a subject
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Param subject query string false "user:<id>, ip:<address> hoặc api_key:<hash>"
+// @Param user query string false "User ID"
+// @Param ip query string false "IP address"
+// @Success 200 {array} middleware.KeyUsage
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/rate-limits/usage [get]
+func (h *RateLimitHandler) Usage(c *fiber.Ctx) error {
+	subject := c.Query("subject")
+	switch {
+	case c.Query("user") != "":
+		subject = "user:" + c.Query("user")
+	case c.Query("ip") != "":
+		subject = "ip:" + c.Query("ip")
+	}
+	if err := middleware.ValidateSubject(subject); err != nil {
+		return h.error(c, err)
+	}
+
+	usages, err := h.limiter.SubjectUsage(c.UserContext(), subject)
+	if err != nil {
+		return h.error(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"subject": subject,
+		"keys":    usages,
+	})
+}
+
+// Reset xoá bộ đếm rate limit của một subject hoặc một key
+// @Summary Reset rate limit
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security X-User-Token
+// @Param request body ResetRateLimitRequest true "Subject hoặc policy + key"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/rate-limits/reset [post]
+func (h *RateLimitHandler) Reset(c *fiber.Ctx) error {
+	var req ResetRateLimitRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	adminID, _ := c.Locals("userID").(string)
+
+	if req.Policy != "" && req.Key != "" {
+		if err := h.limiter.ResetKey(c.UserContext(), req.Policy, req.Key); err != nil {
+			return h.error(c, err)
+		}
+		slog.Info("Rate limit key reset",
+			slog.String("policy", req.Policy),
+			slog.String("key", req.Key),
+			slog.String("admin_id", adminID),
+		)
+		return c.JSON(fiber.Map{"reset": 1})
+	}
+
+	if err := middleware.ValidateSubject(req.Subject); err != nil {
+		return h.error(c, err)
+	}
+	reset, err := h.limiter.ResetSubject(c.UserContext(), req.Subject)
+	if err != nil {
+		return h.error(c, err)
+	}
+	slog.Info("Rate limit subject reset",
+		slog.String("subject", req.Subject),
+		slog.Int("keys", reset),
+		slog.String("admin_id", adminID),
+	)
+	return c.JSON(fiber.Map{"reset": reset})
+}
+
+// ListOverrides trả về các override allow/deny đang có hiệu lực
+// @Summary List rate limit overrides
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {array} middleware.Override
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/rate-limits/overrides [get]
+func (h *RateLimitHandler) ListOverrides(c *fiber.Ctx) error {
+	return c.JSON(h.limiter.Overrides())
+}
+
+// SetOverride tạm thời bỏ qua rate limit (allow) hoặc chặn (deny) một subject
+// @Summary Set rate limit override
+// @Description Ví dụ: mở chặn cho bidder hợp lệ trong phiên đấu giá nóng
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security X-User-Token
+// @Param request body OverrideRequest true "Override"
+// @Success 201 {object} middleware.Override
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/rate-limits/overrides [post]
+func (h *RateLimitHandler) SetOverride(c *fiber.Ctx) error {
+	var req OverrideRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl <= 0 || ttl > h.maxOverrideTTL {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":           "ttl_seconds is out of range",
+			"max_ttl_seconds": int64(h.maxOverrideTTL / time.Second),
+		})
+	}
+
+	adminID, _ := c.Locals("userID").(string)
+	override, err := h.limiter.SetOverride(c.UserContext(), middleware.Override{
+		Subject:   req.Subject,
+		Action:    middleware.OverrideAction(req.Action),
+		Reason:    req.Reason,
+		CreatedBy: adminID,
+	}, ttl)
+	if err != nil {
+		return h.error(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(override)
+}
+
+// DeleteOverride xoá override của một subject
+// @Summary Delete rate limit override
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Param subject path string true "user:<id>, ip:<address> hoặc api_key:<hash>"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/rate-limits/overrides/{subject} [delete]
+func (h *RateLimitHandler) DeleteOverride(c *fiber.Ctx) error {
+	subject, err := url.PathUnescape(c.Params("subject"))
+	if err == nil {
+		err = middleware.ValidateSubject(subject)
+	}
+	if err != nil {
+		return h.error(c, middleware.ErrInvalidSubject)
+	}
+
+	if err := h.limiter.DeleteOverride(c.UserContext(), subject); err != nil {
+		return h.error(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Override removed"})
+}
+
+func (h *RateLimitHandler) error(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, middleware.ErrInvalidSubject), errors.Is(err, middleware.ErrInvalidAction):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, middleware.ErrUnknownPolicy):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, middleware.ErrRateLimitDisabled):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	slog.Error("Rate limit admin operation failed", slog.String("error", err.Error()))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Rate limit operation failed",
+	})
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"api_gateway/internal/revocation"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type RevocationHandler struct {
+	store *revocation.Store
+}
+
+func NewRevocationHandler(store *revocation.Store) *RevocationHandler {
+	return &RevocationHandler{store: store}
+}
+
+// RevokeRequest là request user-service/auth-service gửi để thu hồi token.
+// Có thể gửi jti (thu hồi một token) và/hoặc user_id (thu hồi mọi token cấp trước issued_before).
+type RevokeRequest struct {
+	JTI          string `json:"jti"`
+	ExpiresAt    int64  `json:"expires_at"` // unix seconds, bắt buộc khi có jti
+	UserID       string `json:"user_id"`
+	IssuedBefore int64  `json:"issued_before"` // unix seconds, mặc định là thời điểm hiện tại
+	Reason       string `json:"reason"`
+}
+
+// Logout thu hồi access token hiện tại
+// @Summary Logout
+// @Description Thu hồi access token hiện tại theo jti. Token không có jti không thu hồi riêng được, dùng /auth/logout-all.
+// @Tags Auth
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/logout [post]
+func (h *RevocationHandler) Logout(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(string)
+	if userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	jti, _ := c.Locals("jti").(string)
+	expiresAt, _ := c.Locals("tokenExpiresAt").(time.Time)
+	if jti == "" {
+		// Không thu hồi theo watermark thay cho token: sẽ đăng xuất mọi phiên khác của user
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Token has no jti and cannot be revoked individually, use /auth/logout-all",
+			"code":  "TOKEN_WITHOUT_JTI",
+		})
+	}
+
+	if err := h.store.RevokeToken(c.UserContext(), jti, expiresAt); err != nil {
+		slog.Error("Failed to revoke token on logout",
+			slog.String("error", err.Error()),
+			slog.String("user_id", userID),
+		)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to logout",
+		})
+	}
+
+	slog.Info("User logged out", slog.String("user_id", userID))
+	return c.JSON(fiber.Map{"message": "Logged out"})
+}
+
+// LogoutAll thu hồi mọi token hiện có của user
+// @Summary Logout all sessions
+// @Description Thu hồi mọi access token của user được cấp trước giây hiện tại và access token đang dùng
+// @Tags Auth
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /auth/logout-all [post]
+func (h *RevocationHandler) LogoutAll(c *fiber.Ctx) error {
+	userID, _ := c.Locals("userID").(string)
+	if userID == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Authentication required",
+		})
+	}
+
+	if err := h.store.RevokeUserTokensBefore(c.UserContext(), userID, time.Now()); err != nil {
+		slog.Error("Failed to revoke user tokens",
+			slog.String("error", err.Error()),
+			slog.String("user_id", userID),
+		)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to logout",
+		})
+	}
+
+	// Watermark không chặn token cấp trong cùng giây, thu hồi riêng token hiện tại theo jti
+	if jti, _ := c.Locals("jti").(string); jti != "" {
+		expiresAt, _ := c.Locals("tokenExpiresAt").(time.Time)
+		if err := h.store.RevokeToken(c.UserContext(), jti, expiresAt); err != nil {
+			slog.Error("Failed to revoke current token",
+				slog.String("error", err.Error()),
+				slog.String("user_id", userID),
+			)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to logout",
+			})
+		}
+	}
+
+	slog.Info("User logged out from all sessions", slog.String("user_id", userID))
+	return c.JSON(fiber.Map{"message": "Logged out from all sessions"})
+}
+
+// Revoke nhận revocation từ internal service (đổi mật khẩu, ban user...)
+func (h *RevocationHandler) Revoke(c *fiber.Ctx) error {
+	var req RevokeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.JTI == "" && req.UserID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "jti or user_id is required",
+		})
+	}
+
+	ctx := c.UserContext()
+
+	if req.JTI != "" {
+		if req.ExpiresAt == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "expires_at is required when revoking by jti",
+			})
+		}
+		if err := h.store.RevokeToken(ctx, req.JTI, time.Unix(req.ExpiresAt, 0)); err != nil {
+			return h.revokeError(c, err)
+		}
+	}
+
+	if req.UserID != "" {
+		before := time.Now()
+		if req.IssuedBefore > 0 {
+			before = time.Unix(req.IssuedBefore, 0)
+		}
+		if err := h.store.RevokeUserTokensBefore(ctx, req.UserID, before); err != nil {
+			return h.revokeError(c, err)
+		}
+	}
+
+	issuer, _ := c.Locals("internalIssuer").(string)
+	slog.Info("Token revocation applied",
+		slog.String("issuer", issuer),
+		slog.String("jti", req.JTI),
+		slog.String("user_id", req.UserID),
+		slog.String("reason", req.Reason),
+	)
+
+	return c.JSON(fiber.Map{"message": "Revocation applied"})
+}
+
+func (h *RevocationHandler) revokeError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, revocation.ErrInvalidRevocation) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	slog.Error("Failed to apply revocation", slog.String("error", err.Error()))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "Failed to apply revocation",
+	})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"api_gateway/internal/routes"
+	"api_gateway/internal/upstream"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type RouteHandler struct {
+	registry *routes.Registry
+	balancer *upstream.Balancer
+}
+
+func NewRouteHandler(registry *routes.Registry, balancer *upstream.Balancer) *RouteHandler {
+	return &RouteHandler{registry: registry, balancer: balancer}
+}
+
+// ListRoutes trả về bảng route đang active của gateway
+// @Summary List active routes
+// @Description Bảng route đang được gateway sử dụng (sau lần reload gần nhất)
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {object} routes.Table
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/routes [get]
+func (h *RouteHandler) ListRoutes(c *fiber.Ctx) error {
+	return c.JSON(h.registry.Table())
+}
+
+// ListUpstreams trả về trạng thái health và số connection của từng endpoint upstream
+// @Summary List upstream endpoints
+// @Description Endpoint của các upstream cùng trạng thái active health check
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {array} upstream.EndpointSnapshot
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/upstreams [get]
+func (h *RouteHandler) ListUpstreams(c *fiber.Ctx) error {
+	return c.JSON(h.balancer.Snapshots())
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"api_gateway/internal/security"
+	"errors"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SecurityHandler struct {
+	policy *security.Policy
+	filter *security.IPFilter
+}
+
+func NewSecurityHandler(policy *security.Policy, filter *security.IPFilter) *SecurityHandler {
+	return &SecurityHandler{policy: policy, filter: filter}
+}
+
+// IPRuleRequest thêm rule allow/deny cho một IP hoặc CIDR
+type IPRuleRequest struct {
+	CIDR       string `json:"cidr"`
+	Action     string `json:"action"`
+	TTLSeconds int64  `json:"ttl_seconds"` // 0 = không hết hạn
+	Reason     string `json:"reason"`
+}
+
+// GetPolicy trả về security policy đang áp dụng (CORS của môi trường hiện tại, security header)
+// @Summary Get security policy
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {object} security.Policy
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/security/policy [get]
+func (h *SecurityHandler) GetPolicy(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"cors":     h.policy.CORS,
+		"headers":  h.policy.Headers,
+		"ip_rules": h.filter.Rules(),
+	})
+}
+
+// ListIPRules trả về các IP rule đang có hiệu lực (tĩnh và runtime)
+// @Summary List IP rules
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Success 200 {array} security.IPRule
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/ip-rules [get]
+func (h *SecurityHandler) ListIPRules(c *fiber.Ctx) error {
+	return c.JSON(h.filter.Rules())
+}
+
+// SetIPRule thêm hoặc thay rule runtime của một IP/CIDR, áp dụng cho mọi instance gateway
+// @Summary Set IP rule
+// @Description Ví dụ: chặn dải IP đang dò mật khẩu, hoặc allow một IP nằm trong dải bị chặn
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security X-User-Token
+// @Param request body IPRuleRequest true "IP rule"
+// @Success 201 {object} security.IPRule
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Router /admin/ip-rules [post]
+func (h *SecurityHandler) SetIPRule(c *fiber.Ctx) error {
+	var req IPRuleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if req.TTLSeconds < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "ttl_seconds must not be negative",
+		})
+	}
+
+	adminID, _ := c.Locals("userID").(string)
+	rule, err := h.filter.Set(c.UserContext(), security.IPRule{
+		CIDR:      req.CIDR,
+		Action:    security.Action(req.Action),
+		Reason:    req.Reason,
+		CreatedBy: adminID,
+	}, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		return h.error(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(rule)
+}
+
+// DeleteIPRule xoá rule runtime của một IP/CIDR
+// @Summary Delete IP rule
+// @Tags Admin
+// @Produce json
+// @Security X-User-Token
+// @Param cidr path string true "IP hoặc CIDR (URL-encoded, ví dụ 203.0.113.0%2F24)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /admin/ip-rules/{cidr} [delete]
+func (h *SecurityHandler) DeleteIPRule(c *fiber.Ctx) error {
+	cidr, err := url.PathUnescape(c.Params("cidr"))
+	if err != nil {
+		return h.error(c, security.ErrInvalidCIDR)
+	}
+
+	if err := h.filter.Delete(c.UserContext(), cidr); err != nil {
+		return h.error(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "IP rule removed"})
+}
+
+func (h *SecurityHandler) error(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, security.ErrInvalidCIDR), errors.Is(err, security.ErrInvalidAction):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, security.ErrRuleNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, security.ErrStaticRule):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	slog.Error("IP rule operation failed", slog.String("error", err.Error()))
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": "IP rule operation failed",
+	})
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"api_gateway/internal/breaker"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/middleware"
+	"api_gateway/internal/routes"
+	"api_gateway/internal/upstream"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+)
+
+// newWebSocketUpgrader tạo handler upgrade kết nối client, chạy pumpWebSocket sau khi upgrade
+func (h *ProxyHandler) newWebSocketUpgrader() fiber.Handler {
+	return websocket.New(h.pumpWebSocket, websocket.Config{
+		HandshakeTimeout: 10 * time.Second,
+		ReadBufferSize:   4096,
+		WriteBufferSize:  4096,
+	})
+}
+
+// ProxyWebSocket upgrade kết nối của client và proxy frame hai chiều tới WebSocket của upstream.
+// JWT nội bộ chỉ được gửi từ gateway tới upstream, không bao giờ trả về cho browser.
+func (h *ProxyHandler) ProxyWebSocket(c *fiber.Ctx) error {
+	route := c.Locals("route").(*routes.Route)
+
+	if !websocket.IsWebSocketUpgrade(c) {
+		// Request thường: trả về URL WebSocket của gateway (giữ format cũ <service>_websocket_url)
+		return c.JSON(fiber.Map{
+			websocketURLKey(route): h.gatewayWebSocketURL(c),
+		})
+	}
+
+	endpoint := h.balancer.Pick(route, middleware.AffinityKey(c))
+	target, err := h.upstreamWebSocketURL(c, endpoint.URL)
+	if err != nil {
+		endpoint.Release()
+		logger.WithContext(c.UserContext()).Error("Failed to build upstream WebSocket URL",
+			slog.String("error", err.Error()),
+			slog.String("route", route.Name),
+		)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Invalid upstream WebSocket URL",
+		})
+	}
+
+	// Dial upstream trước khi upgrade client để có thể trả lỗi HTTP nếu upstream không sẵn sàng
+	ctx, cancel := context.WithTimeout(c.UserContext(), route.TimeoutDuration())
+	defer cancel()
+
+	header := http.Header{}
+	header.Set("X-Internal-JWT", c.Get("X-Internal-JWT"))
+	header.Set("X-User-ID", c.Get("X-User-ID"))
+	header.Set("X-User-Role", c.Get("X-User-Role"))
+	for key, value := range middleware.CorrelationHeaders(c.UserContext()) {
+		header.Set(key, value)
+	}
+
+	dialer := fasthttpws.Dialer{
+		HandshakeTimeout: route.TimeoutDuration(),
+		ReadBufferSize:   4096,
+		WriteBufferSize:  4096,
+	}
+	var done func(breaker.Outcome, time.Duration)
+	if h.breakers != nil {
+		if done, err = h.breakers.Get(route.Upstream).Allow(); err != nil {
+			endpoint.Release()
+			// Route websocket không có target canary, breaker luôn là của upstream của route
+			return h.circuitOpen(c, route, nil)
+		}
+	}
+
+	start := time.Now()
+	upstream, resp, err := dialer.DialContext(ctx, target, header)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	if done != nil {
+		outcome := breaker.Success
+		if err != nil && (resp == nil || status >= http.StatusInternalServerError) {
+			outcome = breaker.Failure
+		}
+		done(outcome, time.Since(start))
+	}
+	if err != nil {
+		endpoint.Release()
+		logger.WithContext(c.UserContext()).Error("Failed to connect to upstream WebSocket",
+			slog.String("error", err.Error()),
+			slog.String("route", route.Name),
+			slog.String("endpoint", endpoint.URL),
+			slog.Int("upstream_status", status),
+		)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Failed to reach service",
+		})
+	}
+
+	c.Locals("upstreamConn", upstream)
+	c.Locals("upstreamEndpoint", endpoint)
+	if err := h.wsUpgrade(c); err != nil {
+		upstream.Close()
+		endpoint.Release()
+		return err
+	}
+	return nil
+}
+
+// pumpWebSocket chuyển frame giữa client và upstream cho tới khi một bên đóng kết nối
+func (h *ProxyHandler) pumpWebSocket(client *websocket.Conn) {
+	endpoint := client.Locals("upstreamEndpoint").(*upstream.Endpoint)
+	defer endpoint.Release()
+	upstream := client.Locals("upstreamConn").(*fasthttpws.Conn)
+	route, _ := client.Locals("route").(*routes.Route)
+	routeName := ""
+	if route != nil {
+		routeName = route.Name
+	}
+	userID, _ := client.Locals("userID").(string)
+	requestID, _ := client.Locals("requestID").(string)
+	log := logger.WithContext(logger.ContextWithRequestID(context.Background(), requestID))
+
+	idleTimeout := time.Duration(h.cfg.WebSocketIdleTimeout) * time.Second
+	pingInterval := time.Duration(h.cfg.WebSocketPingInterval) * time.Second
+	maxMessage := h.cfg.WebSocketMaxMessageBytes
+
+	log.Info("WebSocket proxy connected",
+		slog.String("route", routeName),
+		slog.String("user_id", userID),
+	)
+	start := time.Now()
+
+	done := make(chan struct{})
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			close(done)
+			client.Close()
+			upstream.Close()
+		})
+	}
+	defer closeBoth()
+
+	for _, conn := range []*fasthttpws.Conn{client.Conn, upstream} {
+		conn := conn
+		if maxMessage > 0 {
+			conn.SetReadLimit(maxMessage)
+		}
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		})
+		go pingLoop(conn, pingInterval, done, closeBoth)
+	}
+
+	errc := make(chan error, 2)
+	go func() { errc <- copyFrames(upstream, client.Conn, idleTimeout) }()
+	go func() { errc <- copyFrames(client.Conn, upstream, idleTimeout) }()
+
+	err := <-errc
+	closeBoth()
+
+	attrs := []any{
+		slog.String("route", routeName),
+		slog.String("user_id", userID),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if err != nil && !isNormalClose(err) {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	log.Info("WebSocket proxy closed", attrs...)
+}
+
+// copyFrames đọc message từ src và ghi sang dst. Khi src đóng, gửi close frame tương ứng cho dst.
+func copyFrames(dst, src *fasthttpws.Conn, idleTimeout time.Duration) error {
+	for {
+		msgType, data, err := src.ReadMessage()
+		if err != nil {
+			code, text := fasthttpws.CloseNormalClosure, ""
+			var closeErr *fasthttpws.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code != fasthttpws.CloseNoStatusReceived {
+				code, text = closeErr.Code, closeErr.Text
+			} else if !isNormalClose(err) {
+				code = fasthttpws.CloseGoingAway
+			}
+			dst.WriteControl(fasthttpws.CloseMessage,
+				fasthttpws.FormatCloseMessage(code, text),
+				time.Now().Add(time.Second))
+			return err
+		}
+
+		src.SetReadDeadline(time.Now().Add(idleTimeout))
+		dst.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := dst.WriteMessage(msgType, data); err != nil {
+			return err
+		}
+	}
+}
+
+// pingLoop gửi ping định kỳ để phát hiện kết nối chết, đóng cả hai phía nếu ping lỗi
+func pingLoop(conn *fasthttpws.Conn, interval time.Duration, done <-chan struct{}, closeBoth func()) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(fasthttpws.PingMessage, nil, time.Now().Add(5*time.Second)); err != nil {
+				closeBoth()
+				return
+			}
+		}
+	}
+}
+
+func isNormalClose(err error) bool {
+	return fasthttpws.IsCloseError(err,
+		fasthttpws.CloseNormalClosure,
+		fasthttpws.CloseGoingAway,
+		fasthttpws.CloseNoStatusReceived,
+	)
+}
+
+// upstreamWebSocketURL build URL tới WebSocket của endpoint: giữ query của client, thay X-User-Token
+// bằng token đã verify và thêm X-Internal-JWT do gateway ký (comment/order service đọc từ query)
+func (h *ProxyHandler) upstreamWebSocketURL(c *fiber.Ctx, endpointURL string) (string, error) {
+	target, err := url.Parse(endpointURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := target.Query()
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		query.Set(string(key), string(value))
+	})
+	query.Del("X-Internal-JWT")
+	query.Del("X-User-Token")
+
+	if token, _ := c.Locals("token").(string); token != "" {
+		query.Set("X-User-Token", token)
+	}
+	if internalJWT := c.Get("X-Internal-JWT"); internalJWT != "" {
+		query.Set("X-Internal-JWT", internalJWT)
+	}
+
+	target.RawQuery = query.Encode()
+	return target.String(), nil
+}
+
+// gatewayWebSocketURL là URL WebSocket của chính gateway cho route hiện tại
+func (h *ProxyHandler) gatewayWebSocketURL(c *fiber.Ctx) string {
+	scheme := "ws"
+	if c.Protocol() == "https" {
+		scheme = "wss"
+	}
+	return scheme + "://" + c.Hostname() + c.Path()
+}
+
+func websocketURLKey(route *routes.Route) string {
+	return strings.ReplaceAll(route.Upstream, "-", "_") + "_websocket_url"
+}
@@ -0,0 +1,138 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "idempotency:"
+
+// finishScript chỉ cho request đang giữ key (cùng token) ghi response hoặc xoá key,
+// tránh request đã hết lock ghi đè kết quả của request khác
+var finishScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current then
+	return 0
+end
+local record = cjson.decode(current)
+if record.token ~= ARGV[1] then
+	return 0
+end
+if ARGV[2] == "" then
+	redis.call("DEL", KEYS[1])
+else
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return 1
+`)
+
+// Record là trạng thái của một Idempotency-Key: đang xử lý (Status = 0) hoặc response đã lưu
+type Record struct {
+	// Fingerprint là hash của method, path, query và body của request đầu tiên
+	Fingerprint string              `json:"fingerprint"`
+	Token       string              `json:"token,omitempty"`
+	Status      int                 `json:"status,omitempty"`
+	Header      map[string][]string `json:"header,omitempty"`
+	Body        []byte              `json:"body,omitempty"`
+	// BodyMissing: request đã tới upstream nhưng response không lưu được (body quá lớn, client ngắt kết nối,
+	// upstream timeout...), key vẫn được giữ để request không bị xử lý lần hai
+	BodyMissing bool      `json:"body_missing,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// InFlight cho biết request đầu tiên chưa có response
+func (r *Record) InFlight() bool {
+	return r.Status == 0
+}
+
+// Store lưu Idempotency-Key trong Redis:
+//   - idempotency:<scope>:<key> : record JSON, TTL = lockTTL khi đang xử lý, ttl sau khi đã có response
+type Store struct {
+	client  *redis.Client
+	lockTTL time.Duration
+	ttl     time.Duration
+}
+
+// NewStore tạo idempotency store. lockTTL phải lớn hơn thời gian xử lý tối đa của một request.
+func NewStore(client *redis.Client, lockTTL, ttl time.Duration) *Store {
+	return &Store{
+		client:  client,
+		lockTTL: lockTTL,
+		ttl:     ttl,
+	}
+}
+
+// Begin giữ key cho request hiện tại. Nếu key đã tồn tại, trả về record hiện có và token rỗng;
+// ngược lại trả về nil và token dùng cho Complete/Release.
+func (s *Store) Begin(ctx context.Context, scope, key, fingerprint string) (*Record, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := json.Marshal(Record{
+		Fingerprint: fingerprint,
+		Token:       token,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	for {
+		acquired, err := s.client.SetNX(ctx, redisKey(scope, key), data, s.lockTTL).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		if acquired {
+			return nil, token, nil
+		}
+
+		existing, err := s.client.Get(ctx, redisKey(scope, key)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// Key vừa hết hạn hoặc bị release giữa SETNX và GET, thử giữ lại
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		var record Record
+		if err := json.Unmarshal(existing, &record); err != nil {
+			return nil, "", err
+		}
+		record.Token = ""
+		return &record, "", nil
+	}
+}
+
+// Complete lưu response của request đang giữ key trong ttl
+func (s *Store) Complete(ctx context.Context, scope, key, token string, record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return finishScript.Run(ctx, s.client, []string{redisKey(scope, key)}, token, data, s.ttl.Milliseconds()).Err()
+}
+
+// Release xoá key khi request không có response cần lưu, cho phép client retry
+func (s *Store) Release(ctx context.Context, scope, key, token string) error {
+	return finishScript.Run(ctx, s.client, []string{redisKey(scope, key)}, token, "", 0).Err()
+}
+
+func redisKey(scope, key string) string {
+	return keyPrefix + scope + ":" + key
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	testScope = "user:42"
+	testKey   = "order-1001-confirm"
+)
+
+func newTestStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewStore(client, 2*time.Minute, 24*time.Hour), mr
+}
+
+func begin(t *testing.T, s *Store, fingerprint string) (*Record, string) {
+	t.Helper()
+	record, token, err := s.Begin(context.Background(), testScope, testKey, fingerprint)
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	return record, token
+}
+
+func TestBeginLocksKey(t *testing.T) {
+	s, mr := newTestStore(t)
+
+	record, token := begin(t, s, "fp-1")
+	if record != nil || token == "" {
+		t.Fatalf("first Begin() = %+v, %q, want nil record and a token", record, token)
+	}
+	if ttl := mr.TTL(redisKey(testScope, testKey)); ttl != 2*time.Minute {
+		t.Errorf("lock TTL = %s, want %s", ttl, 2*time.Minute)
+	}
+
+	// Retry trong lúc request đầu tiên đang xử lý nhận record in-flight, không có token
+	record, retryToken := begin(t, s, "fp-1")
+	if record == nil || !record.InFlight() {
+		t.Fatalf("second Begin() record = %+v, want in-flight record", record)
+	}
+	if retryToken != "" || record.Token != "" {
+		t.Errorf("second Begin() leaked a token: %q, record token %q", retryToken, record.Token)
+	}
+	if record.Fingerprint != "fp-1" {
+		t.Errorf("record fingerprint = %q, want %q", record.Fingerprint, "fp-1")
+	}
+}
+
+func TestCompleteStoresResponse(t *testing.T) {
+	s, mr := newTestStore(t)
+	ctx := context.Background()
+	_, token := begin(t, s, "fp-1")
+
+	// Token sai (request đã hết lock) không ghi đè được record
+	if err := s.Complete(ctx, testScope, testKey, "stale-token", &Record{Fingerprint: "fp-1", Status: 500}); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := begin(t, s, "fp-1"); !record.InFlight() {
+		t.Fatalf("record after Complete with a stale token = %+v, want still in flight", record)
+	}
+
+	err := s.Complete(ctx, testScope, testKey, token, &Record{
+		Fingerprint: "fp-1",
+		Status:      201,
+		Header:      map[string][]string{"Content-Type": {"application/json"}},
+		Body:        []byte(`{"id":1001}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL(redisKey(testScope, testKey)); ttl != 24*time.Hour {
+		t.Errorf("record TTL = %s, want %s", ttl, 24*time.Hour)
+	}
+
+	record, retryToken := begin(t, s, "fp-1")
+	if record == nil || record.Status != 201 || string(record.Body) != `{"id":1001}` || retryToken != "" {
+		t.Fatalf("Begin() after Complete = %+v, %q, want stored 201 response", record, retryToken)
+	}
+	if got := record.Header["Content-Type"]; len(got) != 1 || got[0] != "application/json" {
+		t.Errorf("stored header = %v", record.Header)
+	}
+}
+
+func TestReleaseFreesKey(t *testing.T) {
+	s, _ := newTestStore(t)
+	ctx := context.Background()
+	_, token := begin(t, s, "fp-1")
+
+	// Release với token khác không xoá lock của request đang giữ key
+	if err := s.Release(ctx, testScope, testKey, "stale-token"); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := begin(t, s, "fp-1"); record == nil {
+		t.Fatal("key released by a stale token")
+	}
+
+	if err := s.Release(ctx, testScope, testKey, token); err != nil {
+		t.Fatal(err)
+	}
+	record, newToken := begin(t, s, "fp-2")
+	if record != nil || newToken == "" || newToken == token {
+		t.Errorf("Begin() after Release = %+v, %q, want a fresh lock", record, newToken)
+	}
+}
+
+func TestExpiredLockCanBeRetaken(t *testing.T) {
+	s, mr := newTestStore(t)
+	_, token := begin(t, s, "fp-1")
+
+	mr.FastForward(3 * time.Minute)
+
+	record, newToken := begin(t, s, "fp-1")
+	if record != nil || newToken == "" {
+		t.Fatalf("Begin() after lock expiry = %+v, %q, want a fresh lock", record, newToken)
+	}
+	// Request cũ hết lock không ghi được response
+	if err := s.Complete(context.Background(), testScope, testKey, token, &Record{Fingerprint: "fp-1", Status: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if record, _ := begin(t, s, "fp-1"); !record.InFlight() {
+		t.Errorf("record = %+v, want the new request still in flight", record)
+	}
+}
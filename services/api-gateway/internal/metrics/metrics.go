@@ -3,8 +3,10 @@ package metrics
 import (
 	"context"
 	"log/slog"
+	"strconv"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 )
 
@@ -22,6 +24,13 @@ var (
 	// Database Metrics
 	DBQueryDuration     metric.Float64Histogram
 	DBConnectionsActive metric.Int64UpDownCounter
+
+	// Gateway Metrics
+	CircuitBreakerTransitions metric.Int64Counter
+	ProxyRetries              metric.Int64Counter
+	CacheRequests             metric.Int64Counter
+	UpstreamDuration          metric.Float64Histogram
+	ShadowRequests            metric.Int64Counter
 )
 
 // InitMetrics khởi tạo tất cả metrics
@@ -105,6 +114,78 @@ func InitMetrics(ctx context.Context) error {
 		return err
 	}
 
+	// Gateway Metrics
+	CircuitBreakerTransitions, err = meter.Int64Counter(
+		"gateway.circuit_breaker.transitions",
+		metric.WithDescription("Number of circuit breaker state transitions"),
+		metric.WithUnit("{transition}"),
+	)
+	if err != nil {
+		return err
+	}
+
+	ProxyRetries, err = meter.Int64Counter(
+		"gateway.proxy.retries",
+		metric.WithDescription("Number of retried upstream requests"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return err
+	}
+
+	CacheRequests, err = meter.Int64Counter(
+		"gateway.cache.requests",
+		metric.WithDescription("Number of cacheable requests by cache result (hit, stale, miss, bypass)"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return err
+	}
+
+	UpstreamDuration, err = meter.Float64Histogram(
+		"gateway.upstream.duration",
+		metric.WithDescription("Time from sending a request to an upstream until its response headers arrive, per attempt"),
+		metric.WithUnit("ms"),
+	)
+	if err != nil {
+		return err
+	}
+
+	ShadowRequests, err = meter.Int64Counter(
+		"gateway.shadow.requests",
+		metric.WithDescription("Mirrored requests sent to shadow upstreams, by comparison result"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return err
+	}
+
 	slog.Info("Metrics initialized successfully")
 	return nil
 }
+
+// RegisterCircuitBreakerStates đăng ký gauge trạng thái circuit breaker theo upstream
+// (0 = closed, 1 = half-open, 2 = open). states được gọi mỗi lần metrics được thu thập.
+func RegisterCircuitBreakerStates(states func() map[string]int64) error {
+	meter := otel.Meter("final4-api")
+
+	_, err := meter.Int64ObservableGauge(
+		"gateway.circuit_breaker.state",
+		metric.WithDescription("Circuit breaker state per upstream (0 = closed, 1 = half-open, 2 = open)"),
+		metric.WithInt64Callback(func(ctx context.Context, o metric.Int64Observer) error {
+			for upstream, state := range states() {
+				o.Observe(state, metric.WithAttributes(attribute.String("upstream", upstream)))
+			}
+			return nil
+		}),
+	)
+	return err
+}
+
+// StatusClass trả về nhóm status code cho label metrics (2xx, 4xx, 5xx...)
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
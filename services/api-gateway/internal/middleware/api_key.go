@@ -0,0 +1,290 @@
+package middleware
+
+import (
+	"api_gateway/internal/apikey"
+	"api_gateway/internal/config"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/routes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Error codes trả về khi API key hoặc client token không hợp lệ
+const (
+	ErrCodeAPIKeyInvalid = "API_KEY_INVALID"
+	ErrCodeAPIKeyRevoked = "API_KEY_REVOKED"
+	ErrCodeAPIKeyExpired = "API_KEY_EXPIRED"
+	ErrCodeAPIKeyScope   = "API_KEY_SCOPE"
+	ErrCodeAPIKeyQuota   = "API_KEY_QUOTA_EXCEEDED"
+)
+
+const clientTokenType = "client"
+
+var ErrClientTokensDisabled = errors.New("client credentials are disabled: missing JWT_PRIVATE_KEY")
+
+// ClientClaims là claims của access token cấp qua OAuth client credentials
+type ClientClaims struct {
+	Type  string `json:"type"`
+	Scope string `json:"scope"`
+	jwt.RegisteredClaims
+}
+
+// APIKeyAuth xác thực request của đối tác bằng header X-API-Key (<client_id>.<secret>) hoặc
+// Authorization: Bearer <token> cấp bởi /api/oauth/token. Request hợp lệ được map thành service principal:
+// Locals("clientID") là id của key, Locals("role") là role cấu hình (API_KEY_ROLE).
+type APIKeyAuth struct {
+	enabled    bool
+	store      *apikey.Store
+	role       string
+	issuer     string
+	tokenTTL   time.Duration
+	privateKey *rsa.PrivateKey // nil nếu không có JWT_PRIVATE_KEY, chỉ dùng được X-API-Key
+	parser     *jwt.Parser
+}
+
+func NewAPIKeyAuth(cfg *config.Config, store *apikey.Store) *APIKeyAuth {
+	a := &APIKeyAuth{
+		enabled:  cfg.APIKeysEnabled,
+		store:    store,
+		role:     cfg.APIKeyRole,
+		issuer:   cfg.APIGatewayName,
+		tokenTTL: time.Duration(cfg.ClientTokenTTL) * time.Second,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+			jwt.WithIssuer(cfg.APIGatewayName),
+			jwt.WithAudience(cfg.APIGatewayName),
+			jwt.WithLeeway(time.Duration(cfg.JWTClockSkew)*time.Second),
+			jwt.WithExpirationRequired(),
+		),
+	}
+
+	if cfg.APIKeysEnabled && cfg.JWTPrivateKey != "" {
+		key, err := parseRSAPrivateKeyFromPEM([]byte(cfg.JWTPrivateKey))
+		if err != nil {
+			slog.Error("Client credentials disabled, invalid JWT_PRIVATE_KEY", slog.String("error", err.Error()))
+		} else {
+			a.privateKey = key
+		}
+	}
+	return a
+}
+
+// Enabled cho biết API key có được bật
+func (a *APIKeyAuth) Enabled() bool {
+	return a.enabled
+}
+
+// Middleware phải đứng sau RouteMiddleware và AuthMiddleware, trước RouteAccessMiddleware.
+// Request đã có user token không dùng API key.
+func (a *APIKeyAuth) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !a.enabled {
+			return c.Next()
+		}
+		if userID, _ := c.Locals("userID").(string); userID != "" {
+			return c.Next()
+		}
+
+		rawKey := c.Get("X-API-Key")
+		bearer, hasBearer := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if rawKey == "" && !hasBearer {
+			return c.Next()
+		}
+
+		var (
+			key    *apikey.Key
+			scopes []string
+			err    error
+		)
+		if rawKey != "" {
+			key, err = a.store.Authenticate(c.UserContext(), rawKey)
+			if key != nil {
+				scopes = key.Scopes
+			}
+		} else {
+			key, scopes, err = a.authenticateToken(c, bearer)
+		}
+		if err != nil {
+			return a.reject(c, err)
+		}
+
+		route, _ := c.Locals("route").(*routes.Route)
+		if route == nil || !apikey.Allows(scopes, route.Name, c.Method()) {
+			logger.WithContext(c.UserContext()).Warn("API key scope does not allow route",
+				slog.String("client_id", key.ID),
+				slog.String("method", c.Method()),
+				slog.String("path", c.Path()),
+			)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "API key is not allowed to access this route",
+				"code":  ErrCodeAPIKeyScope,
+			})
+		}
+
+		quota, err := a.store.Consume(c.UserContext(), key)
+		if err != nil {
+			// Quota không chặn request khi Redis lỗi (key đã được xác thực)
+			logger.WithContext(c.UserContext()).Warn("Failed to check API key quota", slog.String("client_id", key.ID), slog.String("error", err.Error()))
+		} else if quota.Limit > 0 {
+			c.Set("X-Quota-Limit", strconv.FormatInt(quota.Limit, 10))
+			c.Set("X-Quota-Remaining", strconv.FormatInt(quota.Remaining(), 10))
+			c.Set("X-Quota-Reset", strconv.FormatInt(quota.ResetAt.Unix(), 10))
+			if !quota.Allowed {
+				retryAfter := max(int64(time.Until(quota.ResetAt).Seconds()+0.5), 1)
+				c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(retryAfter, 10))
+				logger.WithContext(c.UserContext()).Warn("API key quota exceeded",
+					slog.String("client_id", key.ID),
+					slog.String("window", quota.Window),
+					slog.Int64("limit", quota.Limit),
+				)
+				return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+					"error":  "API key quota exceeded",
+					"code":   ErrCodeAPIKeyQuota,
+					"window": quota.Window,
+					"limit":  quota.Limit,
+				})
+			}
+		}
+
+		c.Locals("clientID", key.ID)
+		c.Locals("role", a.role)
+		c.Locals("apiKeyScopes", scopes)
+
+		logger.WithContext(c.UserContext()).Debug("Partner authenticated with API key",
+			slog.String("client_id", key.ID),
+			slog.String("path", c.Path()),
+		)
+		return c.Next()
+	}
+}
+
+// authenticateToken kiểm tra client token và trạng thái hiện tại của key (key bị thu hồi thì token cũng mất hiệu lực)
+func (a *APIKeyAuth) authenticateToken(c *fiber.Ctx, tokenString string) (*apikey.Key, []string, error) {
+	if a.privateKey == nil {
+		return nil, nil, apikey.ErrInvalidCredentials
+	}
+
+	var claims ClientClaims
+	token, err := a.parser.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+		return &a.privateKey.PublicKey, nil
+	})
+	if err != nil || !token.Valid || claims.Type != clientTokenType {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, nil, apikey.ErrExpired
+		}
+		return nil, nil, apikey.ErrInvalidCredentials
+	}
+
+	key, err := a.store.Get(c.UserContext(), claims.Subject)
+	if errors.Is(err, apikey.ErrNotFound) {
+		return nil, nil, apikey.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := key.Check(time.Now()); err != nil {
+		return nil, nil, err
+	}
+
+	// Scope của token bị giới hạn bởi scope hiện tại của key
+	var scopes []string
+	for _, scope := range strings.Fields(claims.Scope) {
+		for _, allowed := range key.Scopes {
+			if scope == allowed {
+				scopes = append(scopes, scope)
+				break
+			}
+		}
+	}
+	return key, scopes, nil
+}
+
+func (a *APIKeyAuth) reject(c *fiber.Ctx, err error) error {
+	code, message := ErrCodeAPIKeyInvalid, "Invalid API key"
+	switch {
+	case errors.Is(err, apikey.ErrRevoked):
+		code, message = ErrCodeAPIKeyRevoked, "API key has been revoked"
+	case errors.Is(err, apikey.ErrExpired):
+		code, message = ErrCodeAPIKeyExpired, "API key or token has expired"
+	case !errors.Is(err, apikey.ErrInvalidCredentials):
+		slog.Error("Failed to verify API key", slog.String("error", err.Error()))
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Unable to verify API key",
+		})
+	}
+
+	slog.Warn("API key rejected",
+		slog.String("code", code),
+		slog.String("path", c.Path()),
+		slog.String("ip", c.IP()),
+	)
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": message,
+		"code":  code,
+	})
+}
+
+// IssueToken cấp access token cho client đã xác thực bằng client credentials
+func (a *APIKeyAuth) IssueToken(key *apikey.Key, scopes []string) (string, time.Duration, error) {
+	if a.privateKey == nil {
+		return "", 0, ErrClientTokensDisabled
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", 0, err
+	}
+
+	now := time.Now()
+	ttl := a.tokenTTL
+	if !key.ExpiresAt.IsZero() && key.ExpiresAt.Sub(now) < ttl {
+		ttl = key.ExpiresAt.Sub(now)
+	}
+	claims := ClientClaims{
+		Type:  clientTokenType,
+		Scope: strings.Join(scopes, " "),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    a.issuer,
+			Subject:   key.ID,
+			Audience:  []string{a.issuer},
+			ID:        hex.EncodeToString(jti),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(a.privateKey)
+	return token, ttl, err
+}
+
+// AuthenticateClient xác thực client_id và client_secret
+func (a *APIKeyAuth) AuthenticateClient(c *fiber.Ctx, clientID, clientSecret string) (*apikey.Key, error) {
+	return a.store.AuthenticateClient(c.UserContext(), clientID, clientSecret)
+}
+
+// parseRSAPrivateKeyFromPEM parses PEM encoded PKCS8 private key
+func parseRSAPrivateKeyFromPEM(privPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privPEM)
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block for private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not RSA private key")
+	}
+	return rsaKey, nil
+}
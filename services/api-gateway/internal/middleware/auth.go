@@ -2,6 +2,9 @@ package middleware
 
 import (
 	"api_gateway/internal/config"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/revocation"
+	"api_gateway/internal/routes"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -21,58 +24,77 @@ type ValidateResponse struct {
 	Role   string `json:"role"`
 }
 
-// AuthMiddleware validates JWT token directly in API Gateway
-func AuthMiddleware(cfg *config.Config) fiber.Handler {
+// Error codes trả về khi access token không hợp lệ
+const (
+	ErrCodeTokenMalformed        = "TOKEN_MALFORMED"
+	ErrCodeTokenExpired          = "TOKEN_EXPIRED"
+	ErrCodeTokenNotYetValid      = "TOKEN_NOT_YET_VALID"
+	ErrCodeTokenSignatureInvalid = "TOKEN_SIGNATURE_INVALID"
+	ErrCodeTokenInvalid          = "TOKEN_INVALID"
+)
+
+// AuthMiddleware verifies the user access token (RS256) against the JWKS key set
+// and rejects tokens that have been revoked
+func AuthMiddleware(cfg *config.Config, keySet *KeySet, revocations *revocation.Store) fiber.Handler {
+	parser := newAccessTokenParser(cfg)
+
 	return func(c *fiber.Ctx) error {
+		// Route public không verify token của user
+		route, _ := c.Locals("route").(*routes.Route)
+		if route != nil && route.Auth == routes.AuthPublic {
+			return c.Next()
+		}
+
 		tokenString := c.Get("X-User-Token")
+		if tokenString == "" && (route != nil && route.Type == routes.TypeWebSocket || isEventStream(c)) {
+			// Browser không set được header khi mở WebSocket hoặc EventSource, token được gửi qua query
+			tokenString = c.Query("X-User-Token")
+		}
 		if tokenString == "" {
 			return c.Next()
 		}
 
-		token, _, err := new(jwt.Parser).ParseUnverified(
-			tokenString,
-			jwt.MapClaims{},
-		)
-		if err != nil {
-			slog.Warn("Invalid token format received",
-				slog.String("error", err.Error()),
+		token, err := parser.ParseWithClaims(tokenString, jwt.MapClaims{}, keySet.Keyfunc)
+		if err != nil || !token.Valid {
+			code, message := classifyTokenError(err)
+			logger.WithContext(c.UserContext()).Warn("Access token rejected",
+				slog.String("code", code),
+				slog.Any("error", err),
 				slog.String("path", c.Path()),
 				slog.String("ip", c.IP()),
 			)
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid token format",
+				"error": message,
+				"code":  code,
 			})
 		}
 
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
-			slog.Warn("Invalid token claims",
+			logger.WithContext(c.UserContext()).Warn("Invalid token claims",
 				slog.String("path", c.Path()),
 				slog.String("ip", c.IP()),
 			)
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid token claims",
+				"code":  ErrCodeTokenInvalid,
 			})
 		}
 
 		// Kiểm tra type == "access"
 		if t, ok := claims["type"].(string); !ok || t != "access" {
-			slog.Warn("Token is not access token",
+			logger.WithContext(c.UserContext()).Warn("Token is not access token",
 				slog.String("token_type", t),
 				slog.String("path", c.Path()),
 			)
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Token is not access token",
+				"code":  ErrCodeTokenInvalid,
 			})
 		}
 
 		// Lấy userId (subject), email, role
-		userID := ""
-		if sub, ok := claims["sub"].(string); ok {
-			userID = sub
-		} else if subf, ok := claims["sub"].(float64); ok {
-			userID = fmt.Sprintf("%.0f", subf)
-		}
+		userID := subjectFromClaims(claims)
 		email, _ := claims["email"].(string)
 		role := ""
 		if r, ok := claims["role"].(string); ok {
@@ -84,12 +106,51 @@ func AuthMiddleware(cfg *config.Config) fiber.Handler {
 			}
 		}
 
+		jti, _ := claims["jti"].(string)
+		var issuedAt, expiresAt time.Time
+		if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+			issuedAt = iat.Time
+		}
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			expiresAt = exp.Time
+		}
+
+		// Kiểm tra token đã bị thu hồi (logout, đổi mật khẩu, bị ban...)
+		if revocations != nil {
+			revoked, reason, err := revocations.IsRevoked(c.UserContext(), jti, userID, issuedAt)
+			if err != nil {
+				logger.WithContext(c.UserContext()).Error("Failed to check token revocation",
+					slog.String("error", err.Error()),
+					slog.String("user_id", userID),
+				)
+				if !cfg.RevocationFailOpen {
+					return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+						"error": "Unable to verify token status",
+					})
+				}
+			}
+			if revoked {
+				logger.WithContext(c.UserContext()).Warn("Revoked token rejected",
+					slog.String("reason", reason),
+					slog.String("user_id", userID),
+					slog.String("path", c.Path()),
+				)
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Token has been revoked",
+					"code":  reason,
+				})
+			}
+		}
+
 		c.Locals("userID", userID)
 		c.Locals("email", email)
 		c.Locals("role", role)
 		c.Locals("token", tokenString)
+		c.Locals("jti", jti)
+		c.Locals("tokenIssuedAt", issuedAt)
+		c.Locals("tokenExpiresAt", expiresAt)
 
-		slog.Debug("User authenticated successfully",
+		logger.WithContext(c.UserContext()).Debug("User authenticated successfully",
 			slog.String("user_id", userID),
 			slog.String("email", email),
 			slog.String("role", role),
@@ -100,6 +161,79 @@ func AuthMiddleware(cfg *config.Config) fiber.Handler {
 	}
 }
 
+// VerifiedUserID trả về hàm lấy user ID của access token hợp lệ trong request, dùng cho middleware chạy trước
+// AuthMiddleware (rate limit trước xác thực). Chỉ kiểm tra chữ ký, thời hạn và type, không kiểm tra revocation:
+// token bị thu hồi vẫn bị AuthMiddleware từ chối sau đó.
+func VerifiedUserID(cfg *config.Config, keySet *KeySet) func(c *fiber.Ctx) string {
+	parser := newAccessTokenParser(cfg)
+
+	return func(c *fiber.Ctx) string {
+		tokenString := c.Get("X-User-Token")
+		if tokenString == "" {
+			tokenString = c.Query("X-User-Token")
+		}
+		if tokenString == "" {
+			return ""
+		}
+
+		claims := jwt.MapClaims{}
+		token, err := parser.ParseWithClaims(tokenString, claims, keySet.Keyfunc)
+		if err != nil || !token.Valid {
+			return ""
+		}
+		if t, _ := claims["type"].(string); t != "access" {
+			return ""
+		}
+		return subjectFromClaims(claims)
+	}
+}
+
+// newAccessTokenParser tạo parser cho access token RS256 của auth-service,
+// chấp nhận thêm HS256 khi có secret cũ (JWT_LEGACY_HS256_SECRET) trong release chuyển đổi
+func newAccessTokenParser(cfg *config.Config) *jwt.Parser {
+	methods := []string{jwt.SigningMethodRS256.Alg()}
+	if cfg.JWTLegacyHS256Secret != "" {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	return jwt.NewParser(
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(time.Duration(cfg.JWTClockSkew)*time.Second),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+}
+
+// subjectFromClaims lấy user ID từ claim sub (chuỗi hoặc số)
+func subjectFromClaims(claims jwt.MapClaims) string {
+	if sub, ok := claims["sub"].(string); ok {
+		return sub
+	}
+	if subf, ok := claims["sub"].(float64); ok {
+		return fmt.Sprintf("%.0f", subf)
+	}
+	return ""
+}
+
+// classifyTokenError map lỗi của jwt parser sang error code và message cho client
+func classifyTokenError(err error) (string, string) {
+	switch {
+	case err == nil:
+		return ErrCodeTokenInvalid, "Invalid token"
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ErrCodeTokenMalformed, "Invalid token format"
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrCodeTokenExpired, "Token has expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return ErrCodeTokenNotYetValid, "Token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid),
+		errors.Is(err, jwt.ErrTokenUnverifiable),
+		errors.Is(err, ErrKeyNotFound):
+		return ErrCodeTokenSignatureInvalid, "Invalid token signature"
+	default:
+		return ErrCodeTokenInvalid, "Invalid token"
+	}
+}
+
 // parseRSAPublicKeyFromPEM parses PEM encoded PKCS1 or PKCS8 public key
 func parseRSAPublicKeyFromPEM(pubPEM []byte) (*rsa.PublicKey, error) {
 	block, _ := pem.Decode(pubPEM)
@@ -118,53 +252,112 @@ func parseRSAPublicKeyFromPEM(pubPEM []byte) (*rsa.PublicKey, error) {
 	}
 }
 
-// ProxyMiddleware adds required headers when proxying to internal services
-func ProxyMiddleware(cfg *config.Config, serviceName string) fiber.Handler {
+// ProxyMiddleware adds required headers when proxying to the matched route's upstream
+func ProxyMiddleware(cfg *config.Config) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Get user info from context (set by AuthMiddleware)
-		userID, _ := c.Locals("userID").(string)
-		email, _ := c.Locals("email").(string)
-		role, _ := c.Locals("role").(string)
-		token, _ := c.Locals("token").(string)
-
-		// Tạo JWT nội bộ ký bằng private key của API Gateway
-		internalJWT, err := generateInternalJWT(cfg, serviceName)
+		route, ok := c.Locals("route").(*routes.Route)
+		if !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Route not found"})
+		}
+
+		identity := IdentityFromContext(c)
+		headers, err := InternalHeaders(cfg, route, identity)
 		if err != nil {
-			slog.Error("Failed to generate internal JWT",
+			logger.WithContext(c.UserContext()).Error("Failed to generate internal JWT",
 				slog.String("error", err.Error()),
-				slog.String("service", serviceName),
+				slog.String("service", route.Upstream),
 			)
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
 
+		// Route không có audience (auth-service): không forward thông tin user do client tự gửi
+		if route.Audience == "" {
+			c.Request().Header.Del("X-User-ID")
+			c.Request().Header.Del("X-User-Email")
+			c.Request().Header.Del("X-User-Role")
+			c.Request().Header.Del("X-Internal-JWT")
+			c.Request().Header.Del("X-Client-ID")
+		}
+
+		// Credential của đối tác chỉ dùng tại gateway, không gửi tiếp cho upstream
+		if identity.ClientID != "" {
+			c.Request().Header.Del("X-API-Key")
+			c.Request().Header.Del(fiber.HeaderAuthorization)
+		}
+
 		// Set headers for internal services
-		c.Request().Header.Set("X-User-ID", userID)
-		c.Request().Header.Set("X-User-Email", email)
-		c.Request().Header.Set("X-User-Role", role)
-		c.Request().Header.Set("X-User-Token", token)
-		c.Request().Header.Set("X-Api-Gateway", cfg.APIGatewayPrivateKey)
-		c.Request().Header.Set("X-Auth-Internal-Service", cfg.AuthInternalSecret)
-		c.Request().Header.Set("X-Internal-JWT", internalJWT)
-
-		slog.Debug("Proxy headers set for internal service",
-			slog.String("service", serviceName),
-			slog.String("user_id", userID),
-			slog.String("email", email),
-			slog.String("role", role),
-		)
-		
+		for key, value := range headers {
+			c.Request().Header.Set(key, value)
+		}
+
+		// Request ID và trace context của span gateway để log của upstream nối được với request này
+		for key, value := range CorrelationHeaders(c.UserContext()) {
+			c.Request().Header.Set(key, value)
+		}
+
+		if route.Audience != "" {
+			logger.WithContext(c.UserContext()).Debug("Proxy headers set for internal service",
+				slog.String("route", route.Name),
+				slog.String("service", route.Upstream),
+				slog.String("user_id", identity.UserID),
+				slog.String("email", identity.Email),
+				slog.String("role", identity.Role),
+				slog.String("client_id", identity.ClientID),
+			)
+		}
+
 		return c.Next()
 	}
 }
 
-func ProxyMiddlewareForAuthenService(cfg *config.Config) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+// Identity là thông tin user (set bởi AuthMiddleware) hoặc đối tác dùng API key (set bởi APIKeyAuth)
+// được forward cho internal service
+type Identity struct {
+	UserID   string
+	Email    string
+	Role     string
+	Token    string
+	ClientID string
+}
 
-		c.Request().Header.Set("X-Api-Gateway", cfg.APIGatewayPrivateKey)
-		c.Request().Header.Set("X-Auth-Internal-Service", cfg.AuthInternalSecret)
+// Authenticated cho biết request đã được xác thực bằng user token hoặc API key
+func (id Identity) Authenticated() bool {
+	return id.UserID != "" || id.ClientID != ""
+}
 
-		return c.Next()
+// IdentityFromContext lấy thông tin user từ context, rỗng nếu request chưa đăng nhập
+func IdentityFromContext(c *fiber.Ctx) Identity {
+	var id Identity
+	id.UserID, _ = c.Locals("userID").(string)
+	id.Email, _ = c.Locals("email").(string)
+	id.Role, _ = c.Locals("role").(string)
+	id.Token, _ = c.Locals("token").(string)
+	id.ClientID, _ = c.Locals("clientID").(string)
+	return id
+}
+
+// InternalHeaders build các header gateway gửi tới upstream của route: secret của gateway,
+// và với route có audience là thông tin user cùng X-Internal-JWT ký bằng private key của API Gateway
+func InternalHeaders(cfg *config.Config, route *routes.Route, id Identity) (map[string]string, error) {
+	headers := map[string]string{
+		"X-Api-Gateway":           cfg.APIGatewayPrivateKey,
+		"X-Auth-Internal-Service": cfg.AuthInternalSecret,
+	}
+	if route.Audience == "" {
+		return headers, nil
+	}
+
+	internalJWT, err := generateInternalJWT(cfg, route.Audience)
+	if err != nil {
+		return nil, err
 	}
+	headers["X-User-ID"] = id.UserID
+	headers["X-User-Email"] = id.Email
+	headers["X-User-Role"] = id.Role
+	headers["X-User-Token"] = id.Token
+	headers["X-Client-ID"] = id.ClientID
+	headers["X-Internal-JWT"] = internalJWT
+	return headers, nil
 }
 
 // generateInternalJWT tạo JWT ký bằng private key của API Gateway
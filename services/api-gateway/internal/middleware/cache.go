@@ -0,0 +1,351 @@
+package middleware
+
+import (
+	"api_gateway/internal/cache"
+	"api_gateway/internal/config"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/metrics"
+	"api_gateway/internal/ratelimit"
+	"api_gateway/internal/routes"
+	"api_gateway/internal/utils"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Kết quả tra cache, trả cho client qua header X-Cache
+const (
+	cacheHit    = "HIT"
+	cacheStale  = "STALE"
+	cacheMiss   = "MISS"
+	cacheBypass = "BYPASS"
+)
+
+// cacheStoreTimeout giới hạn thời gian ghi entry vào store sau khi response đã gửi xong
+const cacheStoreTimeout = 2 * time.Second
+
+// skippedCacheHeaders là các header của response không được lưu vào cache
+var skippedCacheHeaders = []string{
+	fiber.HeaderContentLength,
+	fiber.HeaderDate,
+	fiber.HeaderAge,
+	fiber.HeaderSetCookie,
+	fiber.HeaderETag,
+	"X-Cache",
+}
+
+// UpstreamFetcher gửi request GET tới upstream của route, dùng để làm mới entry ở background
+type UpstreamFetcher interface {
+	Fetch(ctx context.Context, route *routes.Route, target string, header http.Header) (*http.Response, error)
+}
+
+// ResponseCache cache response GET/HEAD của các route có cấu hình cache trong bảng route.
+// Cache key gồm route, path, query (đã sắp xếp), role, user (nếu có token) và Accept-Language.
+type ResponseCache struct {
+	enabled    bool
+	store      cache.Store
+	fetcher    UpstreamFetcher
+	maxBody    int64
+	refreshing sync.Map // key đang được làm mới ở background
+}
+
+func NewResponseCache(cfg *config.Config, store cache.Store, fetcher UpstreamFetcher) *ResponseCache {
+	return &ResponseCache{
+		enabled: cfg.CacheEnabled,
+		store:   store,
+		fetcher: fetcher,
+		maxBody: cfg.CacheMaxBodyBytes,
+	}
+}
+
+// Middleware phải đứng sau ProxyMiddleware để request làm mới ở background mang header nội bộ của gateway
+func (rc *ResponseCache) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !rc.enabled {
+			return c.Next()
+		}
+
+		route, ok := c.Locals("route").(*routes.Route)
+		if !ok || route.Cache == nil {
+			return c.Next()
+		}
+		// Request tới target canary không dùng cache: response của canary không được trả cho user của bản stable
+		// và ngược lại
+		if _, canary := c.Locals("routeTarget").(*routes.Target); canary {
+			return c.Next()
+		}
+		method := c.Method()
+		if method != fiber.MethodGet && method != fiber.MethodHead {
+			return c.Next()
+		}
+		rest, _ := c.Locals("routePath").(string)
+		if route.Cache.Excludes(rest) {
+			return c.Next()
+		}
+
+		cacheControl := c.Get(fiber.HeaderCacheControl)
+		if hasDirective(cacheControl, "no-store") {
+			rc.record(c, route, cacheBypass)
+			c.Set("X-Cache", cacheBypass)
+			return c.Next()
+		}
+
+		key := rc.key(c, route)
+
+		// no-cache: bỏ qua entry hiện có nhưng vẫn lưu response mới
+		if !hasDirective(cacheControl, "no-cache") {
+			entry, err := rc.store.Get(c.UserContext(), key)
+			if err != nil {
+				logger.WithContext(c.UserContext()).Warn("Failed to read response cache",
+					slog.String("route", route.Name),
+					slog.String("error", err.Error()),
+				)
+			}
+			now := time.Now()
+			if entry != nil && !entry.Expired(now) {
+				if entry.Fresh(now) {
+					return rc.serve(c, route, entry, cacheHit)
+				}
+				rc.revalidate(c, route, key, entry)
+				return rc.serve(c, route, entry, cacheStale)
+			}
+		}
+
+		rc.record(c, route, cacheMiss)
+		c.Set("X-Cache", cacheMiss)
+		if method == fiber.MethodHead {
+			return c.Next()
+		}
+		return rc.fill(c, route, key)
+	}
+}
+
+// fill gọi upstream và lưu response vào cache sau khi body đã được stream hết về client
+func (rc *ResponseCache) fill(c *fiber.Ctx, route *routes.Route, key string) error {
+	gatewayHeaders := responseHeaderNames(c)
+	recorder := RecordResponse(c, rc.maxBody)
+	if err := c.Next(); err != nil {
+		return err
+	}
+
+	resp := c.Response()
+	if !resp.IsBodyStream() {
+		// Response do gateway tự tạo (lỗi, circuit open...), không phải từ upstream
+		return nil
+	}
+	hasCookie := false
+	resp.Header.VisitAllCookie(func(_, _ []byte) { hasCookie = true })
+	if !cacheable(resp.StatusCode(), string(resp.Header.Peek(fiber.HeaderCacheControl)), string(resp.Header.Peek(fiber.HeaderVary)), hasCookie) {
+		return nil
+	}
+
+	entry := &cache.Entry{
+		Route:     route.Name,
+		Path:      strings.Clone(c.Path()),
+		Status:    resp.StatusCode(),
+		Header:    upstreamHeaders(c, gatewayHeaders, skippedCacheHeaders),
+		Validator: string(resp.Header.Peek(fiber.HeaderETag)),
+		TTL:       route.Cache.TTLDuration(),
+		Stale:     route.Cache.StaleDuration(),
+	}
+	recorder.OnComplete(func(body []byte) {
+		entry.Body = body
+		entry.ETag = entry.Validator
+		if entry.ETag == "" {
+			entry.ETag = cache.ETag(body)
+		}
+		entry.StoredAt = time.Now()
+		go rc.set(key, entry)
+	})
+	return nil
+}
+
+// serve trả entry cho client, hoặc 304 nếu If-None-Match khớp ETag của entry
+func (rc *ResponseCache) serve(c *fiber.Ctx, route *routes.Route, entry *cache.Entry, result string) error {
+	rc.record(c, route, result)
+
+	setResponseHeaders(c, entry.Header)
+	c.Set(fiber.HeaderETag, entry.ETag)
+	c.Set(fiber.HeaderAge, strconv.Itoa(int(entry.Age(time.Now()).Seconds())))
+	c.Set("X-Cache", result)
+
+	if etagMatches(c.Get(fiber.HeaderIfNoneMatch), entry.ETag) {
+		c.Status(fiber.StatusNotModified)
+		return nil
+	}
+	c.Status(entry.Status)
+	return c.Send(entry.Body)
+}
+
+// revalidate làm mới entry stale ở background, mỗi key chỉ có một request làm mới cùng lúc.
+// Upstream trả 304 cho ETag cũ thì entry chỉ được gia hạn.
+func (rc *ResponseCache) revalidate(c *fiber.Ctx, route *routes.Route, key string, entry *cache.Entry) {
+	if _, loading := rc.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return
+	}
+
+	target := upstreamTarget(c)
+	header := http.Header{}
+	c.Request().Header.VisitAll(func(k, v []byte) {
+		name := http.CanonicalHeaderKey(string(k))
+		switch name {
+		case fiber.HeaderHost, fiber.HeaderContentLength, fiber.HeaderIfNoneMatch, fiber.HeaderIfModifiedSince, fiber.HeaderCacheControl:
+			return
+		}
+		header.Add(name, string(v))
+	})
+	if entry.Validator != "" {
+		header.Set(fiber.HeaderIfNoneMatch, entry.Validator)
+	}
+
+	go func() {
+		defer rc.refreshing.Delete(key)
+
+		ctx, cancel := context.WithTimeout(context.Background(), route.TimeoutDuration())
+		defer cancel()
+
+		resp, err := rc.fetcher.Fetch(ctx, route, target, header)
+		if err != nil {
+			slog.Warn("Failed to revalidate cached response",
+				slog.String("route", route.Name),
+				slog.String("path", entry.Path),
+				slog.String("error", err.Error()),
+			)
+			return
+		}
+		defer resp.Body.Close()
+
+		next := *entry
+		next.TTL = route.Cache.TTLDuration()
+		next.Stale = route.Cache.StaleDuration()
+		next.StoredAt = time.Now()
+
+		switch {
+		case resp.StatusCode == http.StatusNotModified && entry.Validator != "":
+		case cacheable(resp.StatusCode, resp.Header.Get(fiber.HeaderCacheControl), resp.Header.Get(fiber.HeaderVary), len(resp.Header.Values(fiber.HeaderSetCookie)) > 0):
+			var reader io.Reader = resp.Body
+			if rc.maxBody > 0 {
+				reader = io.LimitReader(resp.Body, rc.maxBody+1)
+			}
+			body, err := io.ReadAll(reader)
+			if err != nil || (rc.maxBody > 0 && int64(len(body)) > rc.maxBody) {
+				return
+			}
+			utils.RemoveHopHeaders(resp.Header)
+			next.Header = map[string][]string{}
+			for name, values := range resp.Header {
+				if !containsHeader(skippedCacheHeaders, name) {
+					next.Header[name] = values
+				}
+			}
+			next.Body = body
+			next.Validator = resp.Header.Get(fiber.HeaderETag)
+			next.ETag = next.Validator
+			if next.ETag == "" {
+				next.ETag = cache.ETag(body)
+			}
+		default:
+			// Upstream lỗi: giữ entry cũ tới khi hết stale_while_revalidate
+			return
+		}
+		rc.set(key, &next)
+	}()
+}
+
+func (rc *ResponseCache) set(key string, entry *cache.Entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheStoreTimeout)
+	defer cancel()
+
+	if err := rc.store.Set(ctx, key, entry); err != nil {
+		slog.Warn("Failed to store cached response",
+			slog.String("route", entry.Route),
+			slog.String("path", entry.Path),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+func (rc *ResponseCache) key(c *fiber.Ctx, route *routes.Route) string {
+	var query []string
+	c.Request().URI().QueryArgs().VisitAll(func(k, v []byte) {
+		query = append(query, string(k)+"="+string(v))
+	})
+	sort.Strings(query)
+
+	role, _ := c.Locals("role").(string)
+	if role == "" {
+		role = ratelimit.RoleAnonymous
+	}
+	// Request có token được cache riêng theo user: route optional-auth có thể trả field riêng của user
+	// (giá đặt của mình, trạng thái watchlist...)
+	userID, _ := c.Locals("userID").(string)
+	return cache.Key(route.Name, c.Path(), strings.Join(query, "&"), role, userID, c.Get(fiber.HeaderAcceptLanguage))
+}
+
+func (rc *ResponseCache) record(c *fiber.Ctx, route *routes.Route, result string) {
+	if metrics.CacheRequests == nil {
+		return
+	}
+	metrics.CacheRequests.Add(c.UserContext(), 1, metric.WithAttributes(
+		attribute.String("route", route.Name),
+		attribute.String("result", strings.ToLower(result)),
+	))
+}
+
+// cacheable kiểm tra response của upstream có được phép cache không
+func cacheable(status int, cacheControl, vary string, hasCookie bool) bool {
+	if status != http.StatusOK || hasCookie || strings.TrimSpace(vary) == "*" {
+		return false
+	}
+	return !hasDirective(cacheControl, "no-store") &&
+		!hasDirective(cacheControl, "no-cache") &&
+		!hasDirective(cacheControl, "private")
+}
+
+// hasDirective kiểm tra Cache-Control có chứa directive (không phân biệt hoa thường, bỏ qua giá trị)
+func hasDirective(cacheControl, directive string) bool {
+	for _, d := range strings.Split(cacheControl, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(d), "=")
+		if strings.EqualFold(name, directive) {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches so sánh If-None-Match với ETag theo weak comparison (RFC 7232 2.3.2)
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamTarget build path và query gửi tới upstream, giống ProxyHandler
+func upstreamTarget(c *fiber.Ctx) string {
+	target := ""
+	if path, _ := c.Locals("routePath").(string); path != "" {
+		target += "/" + path
+	}
+	if query := c.Context().QueryArgs().String(); len(query) > 0 {
+		target += "?" + query
+	}
+	return target
+}
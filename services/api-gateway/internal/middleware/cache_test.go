@@ -0,0 +1,327 @@
+package middleware
+
+import (
+	"api_gateway/internal/cache"
+	"api_gateway/internal/ratelimit"
+	"api_gateway/internal/routes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const testCacheRoutes = `
+routes:
+  - name: products
+    path_prefix: /products
+    upstream: product-service
+    urls: ["http://product-service:8083"]
+    auth: optional
+    cache:
+      ttl: 10s
+      stale_while_revalidate: 30s
+`
+
+func cachedRoute(t *testing.T) *routes.Route {
+	t.Helper()
+	table, err := routes.Parse("routes.yaml", []byte(testCacheRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, _ := table.Lookup("products")
+	return route
+}
+
+// fakeFetcher thay cho ProxyHandler khi làm mới entry ở background
+type fakeFetcher struct {
+	mu      sync.Mutex
+	header  http.Header // header của request làm mới gần nhất
+	status  int
+	etag    string
+	body    string
+	fetched chan struct{}
+}
+
+func (f *fakeFetcher) Fetch(ctx context.Context, route *routes.Route, target string, header http.Header) (*http.Response, error) {
+	f.mu.Lock()
+	f.header = header
+	f.mu.Unlock()
+	defer func() { f.fetched <- struct{}{} }()
+
+	resp := &http.Response{StatusCode: f.status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(f.body))}
+	resp.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp.Header.Set("Connection", "keep-alive")
+	if f.etag != "" {
+		resp.Header.Set(fiber.HeaderETag, f.etag)
+	}
+	return resp, nil
+}
+
+// newCacheApp dựng chain như gateway: route và user (từ header X-Test-User/X-Test-Role) đã được gắn vào Locals
+func newCacheApp(t *testing.T, store cache.Store, fetcher UpstreamFetcher, key *string) *fiber.App {
+	t.Helper()
+	route := cachedRoute(t)
+	rc := &ResponseCache{enabled: true, store: store, fetcher: fetcher, maxBody: 1024}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("route", route)
+		c.Locals("routePath", strings.TrimPrefix(c.Path(), "/api/products/"))
+		if user := c.Get("X-Test-User"); user != "" {
+			c.Locals("userID", user)
+		}
+		if role := c.Get("X-Test-Role"); role != "" {
+			c.Locals("role", role)
+		}
+		if key != nil {
+			*key = rc.key(c, route)
+		}
+		return c.Next()
+	})
+	app.Get("/api/products/*", rc.Middleware(), func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusTeapot).SendString("upstream must not be called")
+	})
+	return app
+}
+
+func TestCacheKey(t *testing.T) {
+	var key string
+	app := newCacheApp(t, cache.NewMemoryStore(10), nil, &key)
+	keyOf := func(target string, header map[string]string) string {
+		req := httptest.NewRequest(fiber.MethodGet, target, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		req.Header.Set(fiber.HeaderCacheControl, "no-store")
+		if _, err := app.Test(req, -1); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	base := keyOf("/api/products/42?sort=price&page=2", nil)
+	tests := []struct {
+		name     string
+		target   string
+		header   map[string]string
+		wantSame bool
+	}{
+		{"query order", "/api/products/42?page=2&sort=price", nil, true},
+		{"different query", "/api/products/42?page=3&sort=price", nil, false},
+		{"different path", "/api/products/43?sort=price&page=2", nil, false},
+		{"explicit anonymous role", "/api/products/42?sort=price&page=2", map[string]string{"X-Test-Role": ratelimit.RoleAnonymous}, true},
+		{"signed-in user", "/api/products/42?sort=price&page=2", map[string]string{"X-Test-User": "7", "X-Test-Role": "ROLE_USER"}, false},
+		{"accept language", "/api/products/42?sort=price&page=2", map[string]string{fiber.HeaderAcceptLanguage: "en"}, false},
+	}
+	for _, tt := range tests {
+		if got := keyOf(tt.target, tt.header); (got == base) != tt.wantSame {
+			t.Errorf("%s: key %q, base %q, want same = %v", tt.name, got, base, tt.wantSame)
+		}
+	}
+
+	// Cùng role nhưng khác user: field riêng của user không bị trả chéo
+	alice := keyOf("/api/products/42", map[string]string{"X-Test-User": "7", "X-Test-Role": "ROLE_USER"})
+	bob := keyOf("/api/products/42", map[string]string{"X-Test-User": "8", "X-Test-Role": "ROLE_USER"})
+	if alice == bob {
+		t.Errorf("users 7 and 8 share cache key %q", alice)
+	}
+	if !strings.HasPrefix(alice, "cache:products:/api/products/42#") {
+		t.Errorf("key %q does not keep route and path for invalidation", alice)
+	}
+}
+
+// storeEntry lưu entry dưới key của request GET target chưa đăng nhập, trả về key
+func storeEntry(t *testing.T, store cache.Store, target string, entry *cache.Entry) string {
+	t.Helper()
+	var key string
+	route := cachedRoute(t)
+	probe := fiber.New()
+	probe.Get("/*", func(c *fiber.Ctx) error {
+		key = (&ResponseCache{}).key(c, route)
+		return nil
+	})
+	if _, err := probe.Test(httptest.NewRequest(fiber.MethodGet, target, nil), -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set(context.Background(), key, entry); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func cachedEntry(storedAt time.Time, body, validator string) *cache.Entry {
+	return &cache.Entry{
+		Route:     "products",
+		Path:      "/api/products/42",
+		Status:    fiber.StatusOK,
+		Header:    map[string][]string{fiber.HeaderContentType: {fiber.MIMEApplicationJSON}},
+		Body:      []byte(body),
+		ETag:      cache.ETag([]byte(body)),
+		Validator: validator,
+		StoredAt:  storedAt,
+		TTL:       10 * time.Second,
+		Stale:     30 * time.Second,
+	}
+}
+
+func getCached(t *testing.T, app *fiber.App, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodGet, "/api/products/42", nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestCacheETag(t *testing.T) {
+	store := cache.NewMemoryStore(10)
+	app := newCacheApp(t, store, nil, nil)
+	entry := cachedEntry(time.Now(), `{"id":42,"name":"iPhone 15"}`, "")
+	storeEntry(t, store, "/api/products/42", entry)
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+		wantBody    string
+	}{
+		{"no validator", "", fiber.StatusOK, `{"id":42,"name":"iPhone 15"}`},
+		{"matching etag", entry.ETag, fiber.StatusNotModified, ""},
+		{"weak matching etag", "W/" + entry.ETag, fiber.StatusNotModified, ""},
+		{"one of several etags", `"other", ` + entry.ETag, fiber.StatusNotModified, ""},
+		{"wildcard", "*", fiber.StatusNotModified, ""},
+		{"stale etag", `"other"`, fiber.StatusOK, `{"id":42,"name":"iPhone 15"}`},
+	}
+	for _, tt := range tests {
+		header := map[string]string{}
+		if tt.ifNoneMatch != "" {
+			header[fiber.HeaderIfNoneMatch] = tt.ifNoneMatch
+		}
+		resp, body := getCached(t, app, header)
+		if resp.StatusCode != tt.wantStatus || body != tt.wantBody {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, resp.StatusCode, body, tt.wantStatus, tt.wantBody)
+		}
+		if got := resp.Header.Get(fiber.HeaderETag); got != entry.ETag {
+			t.Errorf("%s: ETag = %q, want %q", tt.name, got, entry.ETag)
+		}
+		if got := resp.Header.Get("X-Cache"); got != cacheHit {
+			t.Errorf("%s: X-Cache = %q, want %q", tt.name, got, cacheHit)
+		}
+	}
+}
+
+func waitFetched(t *testing.T, f *fakeFetcher) {
+	t.Helper()
+	select {
+	case <-f.fetched:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stale entry was not revalidated")
+	}
+}
+
+// waitEntry chờ entry được ghi lại sau khi làm mới ở background
+func waitEntry(t *testing.T, app *fiber.App, want func(resp *http.Response, body string) bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, body := getCached(t, app, nil)
+		if want(resp, body) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("entry not refreshed, last response X-Cache=%s body=%q", resp.Header.Get("X-Cache"), body)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCacheStaleWhileRevalidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream *fakeFetcher
+		wantBody string
+	}{
+		{
+			name:     "upstream sends a new body",
+			upstream: &fakeFetcher{status: http.StatusOK, etag: `"v2"`, body: `{"id":42,"current_price":12000000}`},
+			wantBody: `{"id":42,"current_price":12000000}`,
+		},
+		{
+			name:     "upstream confirms the old etag",
+			upstream: &fakeFetcher{status: http.StatusNotModified, etag: `"v1"`},
+			wantBody: `{"id":42,"current_price":10000000}`,
+		},
+	}
+	for _, tt := range tests {
+		store := cache.NewMemoryStore(10)
+		fetcher := tt.upstream
+		fetcher.fetched = make(chan struct{}, 1)
+		app := newCacheApp(t, store, fetcher, nil)
+		// Entry đã quá TTL 10s nhưng còn trong stale_while_revalidate 30s
+		key := storeEntry(t, store, "/api/products/42",
+			cachedEntry(time.Now().Add(-15*time.Second), `{"id":42,"current_price":10000000}`, `"v1"`))
+
+		resp, body := getCached(t, app, map[string]string{fiber.HeaderAuthorization: "Bearer token"})
+		if got := resp.Header.Get("X-Cache"); got != cacheStale || body != `{"id":42,"current_price":10000000}` {
+			t.Errorf("%s: first request X-Cache=%s body=%q, want the stale entry", tt.name, got, body)
+		}
+		waitFetched(t, fetcher)
+
+		fetcher.mu.Lock()
+		header := fetcher.header
+		fetcher.mu.Unlock()
+		if got := header.Get(fiber.HeaderIfNoneMatch); got != `"v1"` {
+			t.Errorf("%s: revalidation If-None-Match = %q, want %q", tt.name, got, `"v1"`)
+		}
+		if got := header.Get(fiber.HeaderAuthorization); got != "Bearer token" {
+			t.Errorf("%s: revalidation Authorization = %q, want the client's header", tt.name, got)
+		}
+
+		waitEntry(t, app, func(resp *http.Response, body string) bool {
+			return resp.Header.Get("X-Cache") == cacheHit && body == tt.wantBody
+		})
+		entry, err := store.Get(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := entry.Header["Connection"]; ok {
+			t.Errorf("%s: hop-by-hop header stored in the refreshed entry: %v", tt.name, entry.Header)
+		}
+	}
+}
+
+func TestCacheExpiredEntryIsNotServed(t *testing.T) {
+	store := cache.NewMemoryStore(10)
+	app := fiber.New()
+	route := cachedRoute(t)
+	rc := &ResponseCache{enabled: true, store: store, maxBody: 1024}
+	app.Get("/api/products/*", func(c *fiber.Ctx) error {
+		c.Locals("route", route)
+		c.Locals("routePath", "42")
+		return c.Next()
+	}, rc.Middleware(), func(c *fiber.Ctx) error {
+		return c.SendString("from upstream")
+	})
+	// Quá cả TTL lẫn stale_while_revalidate
+	storeEntry(t, store, "/api/products/42", cachedEntry(time.Now().Add(-time.Minute), `{"id":42}`, ""))
+
+	resp, body := getCached(t, app, nil)
+	if got := resp.Header.Get("X-Cache"); got != cacheMiss || body != "from upstream" {
+		t.Errorf("X-Cache=%s body=%q, want a miss served by upstream", got, body)
+	}
+}
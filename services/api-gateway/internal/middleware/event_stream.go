@@ -0,0 +1,17 @@
+package middleware
+
+import "github.com/gofiber/fiber/v2"
+
+// EventStreamMiddleware đánh dấu request mở Server-Sent Events. EventSource của browser không set được header
+// nên AuthMiddleware nhận user token qua query X-User-Token như với WebSocket. Đặt trước AuthMiddleware.
+func EventStreamMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("eventStream", true)
+		return c.Next()
+	}
+}
+
+func isEventStream(c *fiber.Ctx) bool {
+	eventStream, _ := c.Locals("eventStream").(bool)
+	return eventStream
+}
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"api_gateway/internal/config"
+	"api_gateway/internal/idempotency"
+	"api_gateway/internal/logger"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	idempotencyKeyHeader    = "Idempotency-Key"
+	idempotencyMaxKeyLength = 255
+	idempotencyStoreTimeout = 2 * time.Second
+)
+
+var errIdempotencyBodyTooLarge = errors.New("request body too large for idempotency")
+
+// skippedIdempotencyHeaders là các header của response không được lưu để replay
+var skippedIdempotencyHeaders = []string{
+	fiber.HeaderContentLength,
+	fiber.HeaderDate,
+	fiber.HeaderSetCookie,
+}
+
+// Idempotency cho phép client retry request POST/PUT/PATCH/DELETE có header Idempotency-Key mà không
+// bị xử lý hai lần: response đầu tiên được lưu theo key + user và trả lại nguyên vẹn cho các lần retry.
+type Idempotency struct {
+	enabled bool
+	store   *idempotency.Store
+	maxBody int64 // giới hạn request body và response body được lưu
+}
+
+func NewIdempotency(cfg *config.Config, store *idempotency.Store) *Idempotency {
+	return &Idempotency{
+		enabled: cfg.IdempotencyEnabled,
+		store:   store,
+		maxBody: cfg.IdempotencyMaxBodyBytes,
+	}
+}
+
+// Middleware phải đứng sau AuthMiddleware (key được tách theo userID)
+func (i *Idempotency) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(idempotencyKeyHeader)
+		if !i.enabled || key == "" {
+			return c.Next()
+		}
+		switch c.Method() {
+		case fiber.MethodPost, fiber.MethodPut, fiber.MethodPatch, fiber.MethodDelete:
+		default:
+			return c.Next()
+		}
+
+		if len(key) > idempotencyMaxKeyLength {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Idempotency-Key must be at most 255 characters",
+				"code":  "IDEMPOTENCY_KEY_INVALID",
+			})
+		}
+
+		// Body được đọc vào bộ nhớ (tối đa maxBody) để so sánh với request đầu tiên, ProxyHandler gửi lại từ bộ nhớ.
+		// Body chunked không có Content-Length nên chỉ bị từ chối khi đọc quá giới hạn.
+		contentLength := c.Request().Header.ContentLength()
+		if i.maxBody > 0 && int64(contentLength) > i.maxBody {
+			return i.bodyTooLarge(c)
+		}
+		if err := i.bufferBody(c); err != nil {
+			if errors.Is(err, errIdempotencyBodyTooLarge) {
+				return i.bodyTooLarge(c)
+			}
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Failed to read request body",
+			})
+		}
+
+		key = strings.Clone(key)
+		scope := idempotencyScope(c)
+		fingerprint := requestFingerprint(c)
+
+		record, token, err := i.store.Begin(c.UserContext(), scope, key, fingerprint)
+		if err != nil {
+			logger.WithContext(c.UserContext()).Warn("Idempotency store unavailable, processing request without idempotency",
+				slog.String("path", c.Path()),
+				slog.String("error", err.Error()),
+			)
+			return c.Next()
+		}
+
+		if record != nil {
+			return i.replay(c, record, fingerprint)
+		}
+		return i.process(c, scope, key, token, fingerprint)
+	}
+}
+
+// bufferBody đọc request body đang stream qua reader giới hạn maxBody byte và thay body của request bằng bản trong bộ nhớ
+func (i *Idempotency) bufferBody(c *fiber.Ctx) error {
+	stream := c.Context().RequestBodyStream()
+	if stream == nil {
+		// Body đã nằm trong bộ nhớ (StreamRequestBody tắt hoặc body nhỏ đã được đọc)
+		if i.maxBody > 0 && int64(len(c.Request().Body())) > i.maxBody {
+			return errIdempotencyBodyTooLarge
+		}
+		return nil
+	}
+
+	var r io.Reader = stream
+	if i.maxBody > 0 {
+		r = io.LimitReader(stream, i.maxBody+1)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if i.maxBody > 0 && int64(len(body)) > i.maxBody {
+		return errIdempotencyBodyTooLarge
+	}
+	c.Request().SetBodyRaw(body)
+	c.Request().Header.SetContentLength(len(body))
+	return nil
+}
+
+func (i *Idempotency) bodyTooLarge(c *fiber.Ctx) error {
+	// Phần body chưa đọc vẫn nằm trên connection, không dùng lại connection cho request kế tiếp
+	c.Context().SetConnectionClose()
+	return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+		"error": "Request body too large for Idempotency-Key",
+		"limit": i.maxBody,
+	})
+}
+
+// replay trả response đã lưu, hoặc lỗi nếu request đầu tiên chưa xong hoặc key bị dùng cho request khác
+func (i *Idempotency) replay(c *fiber.Ctx, record *idempotency.Record, fingerprint string) error {
+	if record.Fingerprint != fingerprint {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": "Idempotency-Key was already used with a different request",
+			"code":  "IDEMPOTENCY_KEY_REUSED",
+		})
+	}
+	if record.InFlight() {
+		c.Set(fiber.HeaderRetryAfter, "1")
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "A request with this Idempotency-Key is still being processed",
+			"code":  "IDEMPOTENCY_KEY_IN_USE",
+		})
+	}
+
+	setResponseHeaders(c, record.Header)
+	c.Set("Idempotent-Replayed", "true")
+	if record.BodyMissing {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error":           "A request with this Idempotency-Key was already processed but its response was not stored",
+			"code":            "IDEMPOTENCY_RESPONSE_UNAVAILABLE",
+			"original_status": record.Status,
+		})
+	}
+	c.Status(record.Status)
+	return c.Send(record.Body)
+}
+
+// process proxy request đầu tiên và lưu response sau khi body đã được stream hết về client.
+// Key chỉ được giải phóng khi request chưa tới upstream (circuit open, không kết nối được...) để client retry.
+// Request đã tới upstream thì key luôn được giữ: response không lưu được (body quá lớn, client ngắt kết nối,
+// upstream timeout) được thay bằng record BodyMissing để retry không xử lý request lần hai.
+func (i *Idempotency) process(c *fiber.Ctx, scope, key, token, fingerprint string) error {
+	gatewayHeaders := responseHeaderNames(c)
+	recorder := RecordResponse(c, i.maxBody)
+	forwarded := TrackForwarding(c)
+
+	err := c.Next()
+	if !forwarded.Load() {
+		i.release(scope, key, token)
+		return err
+	}
+
+	resp := c.Response()
+	record := &idempotency.Record{
+		Fingerprint: fingerprint,
+		Status:      resp.StatusCode(),
+		CreatedAt:   time.Now(),
+	}
+	if err != nil || !resp.IsBodyStream() {
+		// Response do gateway tạo sau khi request đã tới upstream, không biết upstream đã xử lý hay chưa
+		record.BodyMissing = true
+		if err != nil {
+			record.Status = fiber.StatusBadGateway
+		}
+		go i.complete(scope, key, token, record)
+		return err
+	}
+
+	record.Header = upstreamHeaders(c, gatewayHeaders, skippedIdempotencyHeaders)
+	recorder.OnComplete(func(body []byte) {
+		record.Body = body
+		go i.complete(scope, key, token, record)
+	})
+	recorder.OnAbort(func() {
+		record.BodyMissing = true
+		go i.complete(scope, key, token, record)
+	})
+	return nil
+}
+
+func (i *Idempotency) complete(scope, key, token string, record *idempotency.Record) {
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+	defer cancel()
+
+	if err := i.store.Complete(ctx, scope, key, token, record); err != nil {
+		slog.Error("Failed to store idempotent response",
+			slog.String("scope", scope),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+func (i *Idempotency) release(scope, key, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), idempotencyStoreTimeout)
+	defer cancel()
+
+	if err := i.store.Release(ctx, scope, key, token); err != nil {
+		slog.Warn("Failed to release idempotency key",
+			slog.String("scope", scope),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// idempotencyScope tách key theo user hoặc API key, request chưa đăng nhập dùng IP
+func idempotencyScope(c *fiber.Ctx) string {
+	if userID, ok := c.Locals("userID").(string); ok && userID != "" {
+		return "user:" + userID
+	}
+	if clientID, ok := c.Locals("clientID").(string); ok && clientID != "" {
+		return "client:" + clientID
+	}
+	return "ip:" + c.IP()
+}
+
+// requestFingerprint là hash của method, path, query và body của request
+func requestFingerprint(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method()))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Path()))
+	h.Write([]byte{0})
+	h.Write(c.Request().URI().QueryString())
+	h.Write([]byte{0})
+	h.Write(c.Request().Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
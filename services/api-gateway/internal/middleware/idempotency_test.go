@@ -0,0 +1,217 @@
+package middleware
+
+import (
+	"api_gateway/internal/idempotency"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const testIdempotencyKey = "order-1001-confirm"
+
+// fakeUpstream thay cho ProxyHandler: đánh dấu request đã tới upstream và stream response như proxy thật
+type fakeUpstream struct {
+	calls atomic.Int32
+	body  atomic.Value  // request body upstream nhận được
+	hold  chan struct{} // nếu khác nil, upstream chờ tới khi hold đóng mới trả response
+}
+
+func (u *fakeUpstream) handle(c *fiber.Ctx) error {
+	u.calls.Add(1)
+	u.body.Store(string(c.Request().Body()))
+	if u.hold != nil {
+		<-u.hold
+	}
+	if trace := httptrace.ContextClientTrace(WithForwardTrace(c, context.Background())); trace != nil {
+		trace.WroteHeaders()
+	}
+	c.Status(fiber.StatusCreated)
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	c.Context().SetBodyStream(WrapResponseBody(c, io.NopCloser(strings.NewReader(`{"id":1001}`))), -1)
+	return nil
+}
+
+// newIdempotencyApp chạy gateway trên listener thật (app.Test không gửi được body chunked), trả về URL của route
+func newIdempotencyApp(t *testing.T, maxBody int64, upstream *fakeUpstream) (string, *idempotency.Store) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	store := idempotency.NewStore(client, 2*time.Minute, time.Hour)
+
+	i := &Idempotency{enabled: true, store: store, maxBody: maxBody}
+	app := fiber.New(fiber.Config{StreamRequestBody: true, DisableStartupMessage: true})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("userID", "42")
+		return c.Next()
+	})
+	app.Post("/api/orders/1001/confirm", i.Middleware(), upstream.handle)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	return "http://" + ln.Addr().String() + "/api/orders/1001/confirm", store
+}
+
+func idempotentRequest(t *testing.T, url, body string, chunked bool) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(fiber.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(idempotencyKeyHeader, testIdempotencyKey)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if chunked {
+		// Ẩn độ dài để request được gửi với Transfer-Encoding: chunked
+		req.Body = io.NopCloser(strings.NewReader(body))
+		req.ContentLength = -1
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(respBody)
+}
+
+// waitStored chờ response của request đầu tiên được lưu (Complete chạy sau khi body đã gửi về client)
+func waitStored(t *testing.T, store *idempotency.Store) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		record, _, err := store.Begin(context.Background(), "user:42", testIdempotencyKey, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record != nil && !record.InFlight() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("response was not stored")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestIdempotencyReplaysStoredResponse(t *testing.T) {
+	upstream := &fakeUpstream{}
+	url, store := newIdempotencyApp(t, 1024, upstream)
+
+	resp, body := idempotentRequest(t, url, `{"confirm":true}`, false)
+	if resp.StatusCode != fiber.StatusCreated || body != `{"id":1001}` {
+		t.Fatalf("first request = %d %s, want 201 from upstream", resp.StatusCode, body)
+	}
+	waitStored(t, store)
+
+	resp, body = idempotentRequest(t, url, `{"confirm":true}`, false)
+	if resp.StatusCode != fiber.StatusCreated || body != `{"id":1001}` {
+		t.Errorf("retry = %d %s, want the stored 201 response", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Idempotent-Replayed") != "true" {
+		t.Error("retry missing Idempotent-Replayed header")
+	}
+	if got := upstream.calls.Load(); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+}
+
+func TestIdempotencyKeyInFlight(t *testing.T) {
+	upstream := &fakeUpstream{hold: make(chan struct{})}
+	url, _ := newIdempotencyApp(t, 1024, upstream)
+
+	// Request đầu tiên đang chờ upstream
+	first := make(chan int, 1)
+	go func() {
+		resp, _ := idempotentRequest(t, url, `{"confirm":true}`, false)
+		first <- resp.StatusCode
+	}()
+	deadline := time.Now().Add(2 * time.Second)
+	for upstream.calls.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first request did not reach upstream")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	resp, body := idempotentRequest(t, url, `{"confirm":true}`, false)
+	if resp.StatusCode != fiber.StatusConflict || !strings.Contains(body, "IDEMPOTENCY_KEY_IN_USE") {
+		t.Errorf("retry while in flight = %d %s, want 409 IDEMPOTENCY_KEY_IN_USE", resp.StatusCode, body)
+	}
+	if resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Error("409 missing Retry-After")
+	}
+
+	close(upstream.hold)
+	if status := <-first; status != fiber.StatusCreated {
+		t.Errorf("first request = %d, want 201", status)
+	}
+	if got := upstream.calls.Load(); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+}
+
+func TestIdempotencyKeyReusedForDifferentRequest(t *testing.T) {
+	upstream := &fakeUpstream{}
+	url, store := newIdempotencyApp(t, 1024, upstream)
+
+	if resp, _ := idempotentRequest(t, url, `{"confirm":true}`, false); resp.StatusCode != fiber.StatusCreated {
+		t.Fatalf("first request = %d, want 201", resp.StatusCode)
+	}
+	waitStored(t, store)
+
+	resp, body := idempotentRequest(t, url, `{"confirm":false}`, false)
+	if resp.StatusCode != fiber.StatusUnprocessableEntity || !strings.Contains(body, "IDEMPOTENCY_KEY_REUSED") {
+		t.Errorf("request with a different body = %d %s, want 422 IDEMPOTENCY_KEY_REUSED", resp.StatusCode, body)
+	}
+	if got := upstream.calls.Load(); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+}
+
+func TestIdempotencyChunkedBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"chunked body within limit", `{"confirm":true}`, fiber.StatusCreated},
+		{"chunked body over limit", strings.Repeat("x", 64), fiber.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		upstream := &fakeUpstream{}
+		url, _ := newIdempotencyApp(t, 32, upstream)
+
+		resp, body := idempotentRequest(t, url, tt.body, true)
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: status = %d %s, want %d", tt.name, resp.StatusCode, body, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != fiber.StatusCreated {
+			if got := upstream.calls.Load(); got != 0 {
+				t.Errorf("%s: upstream called %d times, want 0", tt.name, got)
+			}
+			continue
+		}
+		// Upstream nhận body đã đọc vào bộ nhớ
+		if got, _ := upstream.body.Load().(string); got != tt.body {
+			t.Errorf("%s: upstream body = %q, want %q", tt.name, got, tt.body)
+		}
+	}
+}
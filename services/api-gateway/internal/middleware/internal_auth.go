@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"api_gateway/internal/config"
+	"api_gateway/internal/logger"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// InternalServiceAuth chỉ cho phép các internal service (được liệt kê trong allowedIssuers)
+// gọi endpoint của gateway. Service gọi phải gửi X-Internal-JWT ký bằng private key của nó,
+// với aud = tên của API Gateway.
+func InternalServiceAuth(cfg *config.Config, allowedIssuers ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokenString := c.Get("X-Internal-JWT")
+		if tokenString == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Missing X-Internal-JWT header",
+			})
+		}
+
+		issuer, err := verifyInternalJWT(cfg, tokenString, allowedIssuers)
+		if err != nil {
+			logger.WithContext(c.UserContext()).Warn("Internal service authentication failed",
+				slog.String("error", err.Error()),
+				slog.String("path", c.Path()),
+				slog.String("ip", c.IP()),
+			)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid Internal JWT",
+			})
+		}
+
+		c.Locals("internalIssuer", issuer)
+		return c.Next()
+	}
+}
+
+// verifyInternalJWT verify JWT nội bộ bằng public key của issuer, trả về issuer nếu hợp lệ
+func verifyInternalJWT(cfg *config.Config, tokenString string, allowedIssuers []string) (string, error) {
+	unverified := &jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, unverified); err != nil {
+		return "", err
+	}
+
+	issuer := unverified.Issuer
+	if issuer == "" {
+		return "", errors.New("missing issuer")
+	}
+	if !slices.Contains(allowedIssuers, issuer) {
+		return "", errors.New("issuer not allowed")
+	}
+
+	publicPem := cfg.PublicKeys[issuer]
+	if publicPem == "" {
+		return "", errors.New("unknown issuer")
+	}
+	pubKey, err := parseRSAPublicKeyFromPEM([]byte(publicPem))
+	if err != nil {
+		return "", err
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithAudience(cfg.APIGatewayName),
+		jwt.WithIssuer(issuer),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	token, err := parser.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return pubKey, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid {
+		return "", errors.New("invalid token")
+	}
+
+	return issuer, nil
+}
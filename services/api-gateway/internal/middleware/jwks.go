@@ -0,0 +1,311 @@
+package middleware
+
+import (
+	"api_gateway/internal/config"
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// minRefreshGap là khoảng cách tối thiểu giữa hai lần tải JWKS khi gặp kid lạ (tính cả lần tải lỗi),
+// tránh bị client spam token giả làm gateway gọi liên tục tới auth-service
+const minRefreshGap = 30 * time.Second
+
+var (
+	ErrKeyNotFound   = errors.New("no key found for kid")
+	ErrNoKeysLoaded  = errors.New("key set is empty")
+	ErrInvalidJWKSet = errors.New("invalid JWK set")
+)
+
+// jwk là một key trong JWKS (RFC 7517), chỉ hỗ trợ RSA
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// KeySet lưu cache các public key của auth-service theo kid.
+// Nguồn key là JWKS URL hoặc file, được refresh định kỳ để auth-service có thể rotate key
+// mà không cần redeploy gateway.
+type KeySet struct {
+	url             string
+	file            string
+	refreshInterval time.Duration
+	httpClient      *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	fallback    *rsa.PublicKey // key PEM tĩnh (JWT_PUBLIC_KEY_AUTH_SERVICE), dùng cho token không có kid
+	legacy      []byte         // secret HS256 cũ (JWT_LEGACY_HS256_SECRET), nil nếu chỉ chấp nhận RS256
+	lastAttempt time.Time      // lần tải JWKS gần nhất, kể cả lần lỗi
+
+	// Các request cùng gặp kid lạ chờ chung một lần refresh
+	missGroup singleflight.Group
+}
+
+// NewKeySet tạo KeySet từ config và load key lần đầu
+func NewKeySet(ctx context.Context, cfg *config.Config) (*KeySet, error) {
+	ks := &KeySet{
+		url:             cfg.JWKSURL,
+		file:            cfg.JWKSFile,
+		refreshInterval: time.Duration(cfg.JWKSRefreshInterval) * time.Second,
+		httpClient:      &http.Client{Timeout: 5 * time.Second},
+		keys:            map[string]*rsa.PublicKey{},
+	}
+	if cfg.JWTLegacyHS256Secret != "" {
+		ks.legacy = []byte(cfg.JWTLegacyHS256Secret)
+	}
+
+	if cfg.JWTPublicKeyAuthService != "" {
+		pub, err := parseRSAPublicKeyFromPEM([]byte(cfg.JWTPublicKeyAuthService))
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_PUBLIC_KEY_AUTH_SERVICE: %w", err)
+		}
+		ks.fallback = pub
+	}
+
+	if ks.url == "" && ks.file == "" {
+		if ks.fallback == nil {
+			return nil, errors.New("no JWKS_URL, JWKS_FILE or JWT_PUBLIC_KEY_AUTH_SERVICE configured")
+		}
+		slog.Warn("JWKS source not configured, using static auth-service public key only")
+		return ks, nil
+	}
+
+	if err := ks.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Start chạy vòng refresh định kỳ cho tới khi ctx bị huỷ
+func (ks *KeySet) Start(ctx context.Context) {
+	if (ks.url == "" && ks.file == "") || ks.refreshInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(ks.refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := ks.Refresh(ctx); err != nil {
+					// Giữ lại key cũ nếu refresh lỗi
+					slog.Error("Failed to refresh JWKS", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Refresh load lại JWKS từ nguồn và thay thế toàn bộ cache (khởi động và refresh định kỳ)
+func (ks *KeySet) Refresh(ctx context.Context) error {
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now()
+	ks.mu.Unlock()
+
+	return ks.load(ctx)
+}
+
+// load tải JWKS từ nguồn và thay thế toàn bộ cache
+func (ks *KeySet) load(ctx context.Context) error {
+	var (
+		data []byte
+		err  error
+	)
+	if ks.url != "" {
+		data, err = ks.fetchURL(ctx)
+	} else {
+		data, err = os.ReadFile(ks.file)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load JWKS: %w", err)
+	}
+
+	keys, err := parseJWKSet(data)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+
+	slog.Info("JWKS refreshed", "keys", len(keys))
+	return nil
+}
+
+func (ks *KeySet) fetchURL(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ks.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected JWKS status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// Keyfunc chọn public key theo header kid của token, dùng cho jwt.Parse.
+// Token HS256 chỉ được verify bằng secret cũ (không bao giờ bằng public key) khi secret được cấu hình.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if token.Method != nil && token.Method.Alg() == jwt.SigningMethodHS256.Alg() {
+		if ks.legacy == nil {
+			return nil, ErrKeyNotFound
+		}
+		return ks.legacy, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	if kid == "" {
+		if ks.fallback != nil {
+			return ks.fallback, nil
+		}
+		ks.mu.RLock()
+		defer ks.mu.RUnlock()
+		// Chỉ chấp nhận token không có kid khi JWKS có đúng một key
+		if len(ks.keys) == 1 {
+			for _, k := range ks.keys {
+				return k, nil
+			}
+		}
+		return nil, ErrKeyNotFound
+	}
+
+	if key := ks.lookup(kid); key != nil {
+		return key, nil
+	}
+
+	// kid lạ: có thể auth-service vừa rotate key, thử refresh ngay (có giới hạn tần suất)
+	ks.refreshOnMiss(kid)
+	if key := ks.lookup(kid); key != nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, kid)
+}
+
+// refreshOnMiss tải lại JWKS khi gặp kid lạ. Các request đồng thời chờ chung một lần tải,
+// và chỉ tải nếu lần tải trước (kể cả lỗi) đã cách ít nhất minRefreshGap.
+func (ks *KeySet) refreshOnMiss(kid string) {
+	if ks.url == "" && ks.file == "" {
+		return
+	}
+	ks.missGroup.Do("refresh", func() (interface{}, error) {
+		if !ks.claimRefresh() {
+			return nil, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := ks.load(ctx); err != nil {
+			slog.Warn("JWKS refresh on unknown kid failed", "kid", kid, "error", err)
+		}
+		return nil, nil
+	})
+}
+
+func (ks *KeySet) lookup(kid string) *rsa.PublicKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.keys[kid]
+}
+
+// claimRefresh ghi nhận lần tải mới trước khi tải, trả về false nếu lần tải trước còn quá gần
+func (ks *KeySet) claimRefresh() bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if time.Since(ks.lastAttempt) < minRefreshGap {
+		return false
+	}
+	ks.lastAttempt = time.Now()
+	return true
+}
+
+// parseJWKSet parse JWKS JSON thành map kid -> RSA public key, bỏ qua key không phải RSA signing key
+func parseJWKSet(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set jwkSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJWKSet, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		if k.Alg != "" && k.Alg != jwt.SigningMethodRS256.Alg() {
+			continue
+		}
+		pub, err := k.rsaPublicKey()
+		if err != nil {
+			slog.Warn("Skipping invalid JWK", "kid", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrNoKeysLoaded
+	}
+
+	return keys, nil
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	if len(nBytes) == 0 || len(eBytes) == 0 {
+		return nil, ErrInvalidJWKSet
+	}
+
+	e := new(big.Int).SetBytes(eBytes)
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("exponent too large")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(e.Int64()),
+	}, nil
+}
@@ -0,0 +1,360 @@
+package middleware
+
+import (
+	"api_gateway/internal/config"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// toJWK encode public key giống JwtUtils.getJwks của auth-service
+func toJWK(kid string, pub *rsa.PublicKey) jwk {
+	return jwk{
+		Kty: "RSA",
+		Kid: kid,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
+
+func jwksJSON(t *testing.T, keys ...jwk) []byte {
+	t.Helper()
+	data, err := json.Marshal(jwkSet{Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// jwksServer phục vụ JWKS có thể thay đổi giữa các lần tải và đếm số lần tải
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	body    []byte
+	status  int
+	fetches atomic.Int32
+	gate    chan struct{} // nếu khác nil, mỗi lần tải chờ tới khi gate đóng
+	started chan struct{} // nhận tín hiệu khi một lần tải bắt đầu
+}
+
+func newJWKSServer(t *testing.T, body []byte) *jwksServer {
+	s := &jwksServer{body: body, status: http.StatusOK, started: make(chan struct{}, 16)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		s.started <- struct{}{}
+		s.mu.Lock()
+		gate, body, status := s.gate, s.body, s.status
+		s.mu.Unlock()
+		if gate != nil {
+			<-gate
+		}
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) set(body []byte, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.body, s.status = body, status
+}
+
+func newTestKeySet(t *testing.T, url string) *KeySet {
+	t.Helper()
+	ks, err := NewKeySet(context.Background(), &config.Config{JWKSURL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func tokenWithKid(kid string) *jwt.Token {
+	return &jwt.Token{Header: map[string]interface{}{"kid": kid}}
+}
+
+func TestClassifyTokenError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil error on invalid token", nil, ErrCodeTokenInvalid},
+		{"malformed", jwt.ErrTokenMalformed, ErrCodeTokenMalformed},
+		{"expired", fmt.Errorf("%w: %w", jwt.ErrTokenInvalidClaims, jwt.ErrTokenExpired), ErrCodeTokenExpired},
+		{"not valid yet", jwt.ErrTokenNotValidYet, ErrCodeTokenNotYetValid},
+		{"used before issued", jwt.ErrTokenUsedBeforeIssued, ErrCodeTokenNotYetValid},
+		{"bad signature", jwt.ErrTokenSignatureInvalid, ErrCodeTokenSignatureInvalid},
+		{"unknown kid", fmt.Errorf("%w: %w", jwt.ErrTokenUnverifiable, fmt.Errorf("%w: k9", ErrKeyNotFound)), ErrCodeTokenSignatureInvalid},
+		{"disallowed algorithm", fmt.Errorf("%w: %w", jwt.ErrTokenSignatureInvalid, jwt.ErrTokenUnverifiable), ErrCodeTokenSignatureInvalid},
+		{"other", errors.New("boom"), ErrCodeTokenInvalid},
+	}
+	for _, tt := range tests {
+		if got, _ := classifyTokenError(tt.err); got != tt.want {
+			t.Errorf("%s: classifyTokenError() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseJWKSet(t *testing.T) {
+	pub := &newRSAKey(t).PublicKey
+	valid := toJWK("k1", pub)
+
+	withAlg := func(alg string) jwk {
+		k := valid
+		k.Kid, k.Alg = "k-"+alg, alg
+		return k
+	}
+	withUse := func(use string) jwk {
+		k := valid
+		k.Kid, k.Use = "k-"+use, use
+		return k
+	}
+	badModulus := valid
+	badModulus.Kid, badModulus.N = "k-bad", "!!"
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantKids []string
+		wantErr  error
+	}{
+		{"single RS256 key", jwksJSON(t, valid), []string{"k1"}, nil},
+		{"alg and use are optional", jwksJSON(t, jwk{Kty: "RSA", Kid: "k1", N: valid.N, E: valid.E}), []string{"k1"}, nil},
+		{"skips non-RS256 and encryption keys", jwksJSON(t, valid, withAlg("RS512"), withUse("enc")), []string{"k1"}, nil},
+		{"skips non-RSA keys", jwksJSON(t, valid, jwk{Kty: "EC", Kid: "ec"}), []string{"k1"}, nil},
+		{"skips undecodable keys", jwksJSON(t, valid, badModulus), []string{"k1"}, nil},
+		{"no usable key", jwksJSON(t, withAlg("HS256")), nil, ErrNoKeysLoaded},
+		{"empty set", []byte(`{"keys":[]}`), nil, ErrNoKeysLoaded},
+		{"not JSON", []byte(`<html>`), nil, ErrInvalidJWKSet},
+	}
+	for _, tt := range tests {
+		keys, err := parseJWKSet(tt.data)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if len(keys) != len(tt.wantKids) {
+			t.Errorf("%s: got %d keys, want %v", tt.name, len(keys), tt.wantKids)
+		}
+		for _, kid := range tt.wantKids {
+			if k := keys[kid]; k == nil || k.N.Cmp(pub.N) != 0 || k.E != pub.E {
+				t.Errorf("%s: key %s missing or different", tt.name, kid)
+			}
+		}
+	}
+}
+
+// Token RS256 có kid như auth-service cấp được AuthMiddleware chấp nhận qua Keyfunc
+func TestKeyfuncVerifiesAuthServiceToken(t *testing.T) {
+	key := newRSAKey(t)
+	srv := newJWKSServer(t, jwksJSON(t, toJWK("auth-service-1", &key.PublicKey)))
+	ks := newTestKeySet(t, srv.URL)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":  "42",
+		"type": "access",
+		"role": "ROLE_BIDDER",
+		"jti":  "7b0c",
+		"iat":  time.Now().Unix(),
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "auth-service-1"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256"}), jwt.WithExpirationRequired())
+	if _, err := parser.Parse(signed, ks.Keyfunc); err != nil {
+		t.Fatalf("token rejected: %v", err)
+	}
+
+	// Token HS256 ký bằng secret cũ bị từ chối
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "42", "exp": time.Now().Add(time.Hour).Unix()})
+	hsSigned, _ := hs.SignedString([]byte("X8f3N2p9V6yR1qT7Z4wM0bC5sH2kJ8lP"))
+	_, err = parser.Parse(hsSigned, ks.Keyfunc)
+	if code, _ := classifyTokenError(err); code != ErrCodeTokenSignatureInvalid {
+		t.Errorf("HS256 token: code = %s (%v), want %s", code, err, ErrCodeTokenSignatureInvalid)
+	}
+}
+
+// Trong release chuyển đổi, token HS256 cấp trước khi auth-service chuyển sang RS256 vẫn hợp lệ nếu có secret cũ
+func TestLegacyHS256Transition(t *testing.T) {
+	const legacySecret = "X8f3N2p9V6yR1qT7Z4wM0bC5sH2kJ8lP"
+	key := newRSAKey(t)
+	srv := newJWKSServer(t, jwksJSON(t, toJWK("auth-service-1", &key.PublicKey)))
+
+	hsToken := func(secret []byte) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":  "42",
+			"type": "access",
+			"iat":  time.Now().Unix(),
+			"exp":  time.Now().Add(time.Hour).Unix(),
+		})
+		signed, err := token.SignedString(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	rsaModulus := key.PublicKey.N.Bytes()
+
+	tests := []struct {
+		name     string
+		secret   string
+		token    string
+		wantCode string // rỗng nếu token hợp lệ
+	}{
+		{"legacy token accepted during transition", legacySecret, hsToken([]byte(legacySecret)), ""},
+		{"legacy token rejected after transition", "", hsToken([]byte(legacySecret)), ErrCodeTokenSignatureInvalid},
+		{"HS256 with another secret", legacySecret, hsToken([]byte("another-secret-another-secret-32")), ErrCodeTokenSignatureInvalid},
+		{"HS256 keyed with the RSA public key", legacySecret, hsToken(rsaModulus), ErrCodeTokenSignatureInvalid},
+	}
+	for _, tt := range tests {
+		cfg := &config.Config{JWKSURL: srv.URL, JWTLegacyHS256Secret: tt.secret}
+		ks, err := NewKeySet(context.Background(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = newAccessTokenParser(cfg).Parse(tt.token, ks.Keyfunc)
+		code := ""
+		if err != nil {
+			code, _ = classifyTokenError(err)
+		}
+		if code != tt.wantCode {
+			t.Errorf("%s: code = %q (%v), want %q", tt.name, code, err, tt.wantCode)
+		}
+	}
+}
+
+func TestKeyfuncRefreshesOnUnknownKid(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	srv := newJWKSServer(t, jwksJSON(t, toJWK("k1", &oldKey.PublicKey)))
+	ks := newTestKeySet(t, srv.URL)
+	<-srv.started
+
+	// auth-service rotate key sau khi gateway đã tải JWKS
+	srv.set(jwksJSON(t, toJWK("k1", &oldKey.PublicKey), toJWK("k2", &newKey.PublicKey)), http.StatusOK)
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now().Add(-minRefreshGap)
+	ks.mu.Unlock()
+
+	got, err := ks.Keyfunc(tokenWithKid("k2"))
+	if err != nil {
+		t.Fatalf("Keyfunc(k2) error = %v", err)
+	}
+	if got.(*rsa.PublicKey).N.Cmp(newKey.N) != 0 {
+		t.Error("Keyfunc(k2) returned the wrong key")
+	}
+	if n := srv.fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+
+	// kid đã có trong cache không cần tải lại
+	if _, err := ks.Keyfunc(tokenWithKid("k1")); err != nil {
+		t.Fatalf("Keyfunc(k1) error = %v", err)
+	}
+	if n := srv.fetches.Load(); n != 2 {
+		t.Errorf("fetches after cached kid = %d, want 2", n)
+	}
+}
+
+func TestKeyfuncRefreshGap(t *testing.T) {
+	key := newRSAKey(t)
+	srv := newJWKSServer(t, jwksJSON(t, toJWK("k1", &key.PublicKey)))
+	ks := newTestKeySet(t, srv.URL)
+	<-srv.started
+
+	// Ngay sau lần tải lúc khởi động: kid lạ không làm gateway tải lại
+	if _, err := ks.Keyfunc(tokenWithKid("forged")); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("error = %v, want ErrKeyNotFound", err)
+	}
+	if n := srv.fetches.Load(); n != 1 {
+		t.Fatalf("fetches within gap = %d, want 1", n)
+	}
+
+	// Sau minRefreshGap được tải một lần; lần tải lỗi cũng tính vào giới hạn
+	srv.set([]byte("unavailable"), http.StatusServiceUnavailable)
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now().Add(-minRefreshGap)
+	ks.mu.Unlock()
+	for i := 0; i < 5; i++ {
+		if _, err := ks.Keyfunc(tokenWithKid(fmt.Sprintf("forged-%d", i))); !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("error = %v, want ErrKeyNotFound", err)
+		}
+	}
+	if n := srv.fetches.Load(); n != 2 {
+		t.Errorf("fetches after failed refresh = %d, want 2", n)
+	}
+
+	// Lần tải lỗi giữ lại key cũ
+	if _, err := ks.Keyfunc(tokenWithKid("k1")); err != nil {
+		t.Errorf("Keyfunc(k1) after failed refresh error = %v", err)
+	}
+}
+
+// Các request đồng thời gặp cùng kid mới chờ chung một lần tải và đều thấy key mới
+func TestKeyfuncSharesRefresh(t *testing.T) {
+	oldKey, newKey := newRSAKey(t), newRSAKey(t)
+	srv := newJWKSServer(t, jwksJSON(t, toJWK("k1", &oldKey.PublicKey)))
+	ks := newTestKeySet(t, srv.URL)
+	<-srv.started
+
+	gate := make(chan struct{})
+	srv.mu.Lock()
+	srv.gate = gate
+	srv.mu.Unlock()
+	srv.set(jwksJSON(t, toJWK("k2", &newKey.PublicKey)), http.StatusOK)
+	ks.mu.Lock()
+	ks.lastAttempt = time.Now().Add(-minRefreshGap)
+	ks.mu.Unlock()
+
+	const callers = 8
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			_, err := ks.Keyfunc(tokenWithKid("k2"))
+			errs <- err
+		}()
+	}
+
+	<-srv.started
+	// Cho các caller còn lại kịp vào singleflight trước khi trả JWKS
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+
+	for i := 0; i < callers; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("caller error = %v", err)
+		}
+	}
+	if n := srv.fetches.Load(); n != 2 {
+		t.Errorf("fetches = %d, want 2", n)
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"api_gateway/internal/config"
+	"api_gateway/internal/modes"
+	"api_gateway/internal/routes"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes trả về khi request bị từ chối bởi chế độ bảo trì / chỉ đọc
+const (
+	ErrCodeMaintenance = "MAINTENANCE"
+	ErrCodeReadOnly    = "READ_ONLY"
+)
+
+const (
+	defaultMaintenanceMessage = "Service is under maintenance, please try again later"
+	defaultReadOnlyMessage    = "Service is in read-only mode, changes are temporarily disabled"
+)
+
+// MaintenanceMiddleware trả 503 kèm Retry-After cho mọi request khi chế độ bảo trì đang bật, trừ health check,
+// metrics và admin (để vẫn tắt được chế độ bảo trì). Đặt sau CORS để browser đọc được response.
+func MaintenanceMiddleware(cfg *config.Config, sw *modes.Switch) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		m := &sw.State().Maintenance
+		if !m.Enabled || maintenanceExempt(c.Path()) {
+			return c.Next()
+		}
+		return modeUnavailable(c, ErrCodeMaintenance, m.Message, defaultMaintenanceMessage,
+			m.RetryAfterSeconds, cfg.ModeRetryAfter, "")
+	}
+}
+
+// ReadOnlyMiddleware từ chối request ghi (khác GET/HEAD/OPTIONS) tới upstream đang ở chế độ chỉ đọc,
+// request đọc vẫn được proxy. Đặt sau RouteMiddleware.
+func ReadOnlyMiddleware(cfg *config.Config, sw *modes.Switch) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+		route, ok := c.Locals("route").(*routes.Route)
+		if !ok {
+			return c.Next()
+		}
+		r := &sw.State().ReadOnly
+		if !r.Applies(route.Upstream) {
+			return c.Next()
+		}
+		return modeUnavailable(c, ErrCodeReadOnly, r.Message, defaultReadOnlyMessage,
+			r.RetryAfterSeconds, cfg.ModeRetryAfter, route.Upstream)
+	}
+}
+
+func maintenanceExempt(path string) bool {
+	return path == "/health" || path == "/metrics" || path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+func modeUnavailable(c *fiber.Ctx, code, message, defaultMessage string, retryAfter, defaultRetryAfter int, upstream string) error {
+	if message == "" {
+		message = defaultMessage
+	}
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+
+	body := fiber.Map{
+		"error": message,
+		"code":  code,
+	}
+	if upstream != "" {
+		body["upstream"] = upstream
+	}
+	if retryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		body["retry_after"] = retryAfter
+	}
+	return c.Status(fiber.StatusServiceUnavailable).JSON(body)
+}
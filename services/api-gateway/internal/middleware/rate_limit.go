@@ -2,228 +2,394 @@ package middleware
 
 import (
 	"api_gateway/internal/config"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/ratelimit"
+	"api_gateway/internal/routes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/redis/go-redis/v9"
 )
 
-// RateLimiter implements token bucket algorithm with Redis
+// slidingWindowScript checks and records one request atomically using a sliding window log.
+// KEYS = rate-limit key, per-policy top keys set, per-policy rejected counter set, effective limit key
+// ARGV = now (µs), window start (µs), max requests (limit + burst), unique member, key TTL (ms), key,
+// effective limit (empty when the policy has no role_limits)
+// Timestamps are computed by the caller: Lua 5.1 formats numbers with 14 significant digits.
+// Returns {allowed, count, oldest entry score (µs)}
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local max = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[2])
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < max then
+	redis.call('ZADD', key, ARGV[1], ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', key, ARGV[5])
+
+-- Track the busiest keys of the policy for the admin API, keeping the top 1000
+redis.call('ZADD', KEYS[2], count, ARGV[6])
+redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -1001)
+redis.call('PEXPIRE', KEYS[2], ARGV[5])
+if allowed == 0 then
+	redis.call('ZINCRBY', KEYS[3], 1, ARGV[6])
+	redis.call('PEXPIRE', KEYS[3], ARGV[5])
+end
+
+-- Remember the role-based limit applied to the key for the admin API
+if ARGV[7] ~= '' then
+	redis.call('SET', KEYS[4], ARGV[7], 'PX', ARGV[5])
+end
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+local oldestScore = tonumber(ARGV[1])
+if oldest[2] then
+	oldestScore = tonumber(oldest[2])
+end
+return {allowed, count, oldestScore}
+`)
+
+// RateLimiter evaluates rate-limit policies against Redis, falling back to an in-process
+// limiter while Redis is unreachable
 type RateLimiter struct {
-	client         *redis.Client
-	requestsPerIP  int
-	windowSeconds  int
-	burstSize      int
-	enabled        bool
+	client        *redis.Client
+	policies      []*ratelimit.Policy
+	local         *ratelimit.LocalLimiter
+	fallbackRetry time.Duration
+	enabled       bool
+
+	// Unix nano until which Redis is skipped after an error
+	redisDownUntil atomic.Int64
+	usingFallback  atomic.Bool
+
+	// Temporary allow/deny overrides by subject, cached from Redis
+	overrides   atomic.Pointer[map[string]Override]
+	overridesMu sync.Mutex
+
+	// Resolves the user of a verified access token before authentication, see SetUserVerifier
+	verifyUser func(c *fiber.Ctx) string
 }
 
-// NewRateLimiter creates a new rate limiter instance
-func NewRateLimiter(cfg *config.Config) (*RateLimiter, error) {
+// NewRateLimiter creates a new rate limiter instance backed by the shared Redis client.
+// Policies are loaded from cfg.RateLimitPoliciesFile; when the file does not exist a single
+// per-IP policy is built from RATE_LIMIT_REQUESTS_PER_IP, RATE_LIMIT_WINDOW_SECONDS and RATE_LIMIT_BURST_SIZE.
+func NewRateLimiter(cfg *config.Config, client *redis.Client) (*RateLimiter, error) {
 	if !cfg.RateLimitEnabled {
 		slog.Info("Rate limiting is disabled")
 		return &RateLimiter{enabled: false}, nil
 	}
 
-	// Initialize Redis client
-	client := redis.NewClient(&redis.Options{
-		Addr:         cfg.RedisAddr,
-		Password:     cfg.RedisPassword,
-		DB:           cfg.RedisDB,
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-		PoolSize:     10,
-		MinIdleConns: 5,
-	})
-
-	// Test Redis connection
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	if client == nil {
+		return nil, fmt.Errorf("rate limiter requires a Redis client")
+	}
 
-	if err := client.Ping(ctx).Err(); err != nil {
-		slog.Error("Failed to connect to Redis", "error", err)
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	policies, err := ratelimit.Load(cfg.RateLimitPoliciesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		policies = []*ratelimit.Policy{{
+			Name:   "ip",
+			Key:    []ratelimit.Dimension{ratelimit.DimIP},
+			Limit:  cfg.RateLimitRequestsPerIP,
+			Burst:  cfg.RateLimitBurstSize,
+			Window: fmt.Sprintf("%ds", cfg.RateLimitWindow),
+		}}
+		err = ratelimit.Validate(policies)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	slog.Info("Rate limiter initialized",
-		"requests_per_ip", cfg.RateLimitRequestsPerIP,
-		"window_seconds", cfg.RateLimitWindow,
-		"burst_size", cfg.RateLimitBurstSize,
+		"policies_file", cfg.RateLimitPoliciesFile,
+		"policies", len(policies),
 	)
 
 	return &RateLimiter{
 		client:        client,
-		requestsPerIP: cfg.RateLimitRequestsPerIP,
-		windowSeconds: cfg.RateLimitWindow,
-		burstSize:     cfg.RateLimitBurstSize,
+		policies:      policies,
+		local:         ratelimit.NewLocalLimiter(),
+		fallbackRetry: time.Duration(cfg.RateLimitFallbackRetry) * time.Second,
 		enabled:       true,
 	}, nil
 }
 
-// Middleware returns a Fiber middleware handler for rate limiting
+// SetUserVerifier lets the pre-authentication pass identify the user of a verified access token,
+// so that user overrides also apply to the per-IP and API key policies. The token is only
+// verified while a user override is active.
+func (rl *RateLimiter) SetUserVerifier(verify func(c *fiber.Ctx) string) {
+	rl.verifyUser = verify
+}
+
+// Middleware returns a Fiber middleware handler applying the policies that only need
+// the client IP or API key. It runs for every request before authentication.
 func (rl *RateLimiter) Middleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		if !rl.enabled {
 			return c.Next()
 		}
 
-		// Get client identifier (IP address)
-		clientIP := c.IP()
-		
 		// Allow internal health checks
 		if c.Path() == "/health" || c.Path() == "/metrics" {
 			return c.Next()
 		}
 
-		// Check rate limit
-		allowed, remaining, resetTime, err := rl.allowRequest(c.Context(), clientIP)
-		if err != nil {
-			slog.Error("Rate limiter error", "error", err, "ip", clientIP)
-			// On error, allow the request to proceed (fail open)
+		return rl.check(c, false)
+	}
+}
+
+// AfterAuthMiddleware applies the policies keyed by user or route group, or tiered by role.
+// Must run after RouteMiddleware and AuthMiddleware.
+func (rl *RateLimiter) AfterAuthMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !rl.enabled {
 			return c.Next()
 		}
+		return rl.check(c, true)
+	}
+}
 
-		// Set rate limit headers
-		c.Set("X-RateLimit-Limit", strconv.Itoa(rl.requestsPerIP))
-		c.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
-		c.Set("X-RateLimit-Reset", strconv.FormatInt(resetTime, 10))
-
-		if !allowed {
-			// Calculate retry-after in seconds
-			retryAfter := resetTime - time.Now().Unix()
-			if retryAfter < 0 {
-				retryAfter = 1
-			}
-			c.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
-
-			slog.Warn("Rate limit exceeded",
-				"ip", clientIP,
-				"path", c.Path(),
-				"method", c.Method(),
-				"retry_after", retryAfter,
-			)
-
-			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-				"error":   "Rate limit exceeded",
-				"message": fmt.Sprintf("Too many requests. Please try again in %d seconds", retryAfter),
-				"limit":   rl.requestsPerIP,
-				"window":  fmt.Sprintf("%ds", rl.windowSeconds),
-			})
+// check evaluates every matching policy of the given stage. The tightest result is reported
+// in the X-RateLimit-* headers; the first policy that rejects ends the request with 429.
+func (rl *RateLimiter) check(c *fiber.Ctx, afterAuth bool) error {
+	req := rateLimitRequest(c)
+
+	// Overrides set through the admin API. Before authentication the user is taken from the
+	// access token once its signature is verified, so a user override also covers the per-IP
+	// and API key policies; a forged token cannot borrow another user's override.
+	var subjects []string
+	if afterAuth {
+		if req.UserID != "" {
+			subjects = append(subjects, "user:"+req.UserID)
+		}
+	} else {
+		subjects = append(subjects, "ip:"+req.IP)
+		if req.APIKey != "" {
+			subjects = append(subjects, "api_key:"+req.APIKey)
+		}
+		if userID := rl.preAuthUser(c); userID != "" {
+			subjects = append(subjects, "user:"+userID)
+		}
+	}
+	if o, ok := rl.override(subjects...); ok {
+		if o.Action == OverrideAllow {
+			return c.Next()
+		}
+		logger.WithContext(c.UserContext()).Warn("Request denied by rate limit override",
+			"subject", o.Subject,
+			"path", c.Path(),
+			"method", c.Method(),
+		)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access temporarily blocked",
+			"code":  "RATE_LIMIT_DENIED",
+		})
+	}
+
+	var reported *ratelimit.Result
+	for _, policy := range rl.policies {
+		if policy.AfterAuth() != afterAuth || !policy.Matches(req) {
+			continue
+		}
+
+		limit, unlimited := policy.LimitFor(req.Role)
+		if unlimited {
+			continue
 		}
 
-		return c.Next()
+		key := policy.KeyFor(req)
+		result := rl.allow(c.Context(), policy, key, limit)
+
+		if !result.Allowed {
+			return rl.reject(c, policy, key, result)
+		}
+		if reported == nil || result.Remaining < reported.Remaining {
+			reported = &result
+		}
+	}
+
+	if reported != nil {
+		setRateLimitHeaders(c, *reported)
 	}
+	return c.Next()
 }
 
-// allowRequest checks if a request from the given identifier is allowed
-// Uses sliding window log algorithm for accurate rate limiting
-func (rl *RateLimiter) allowRequest(ctx context.Context, identifier string) (bool, int, int64, error) {
-	now := time.Now()
-	windowStart := now.Add(-time.Duration(rl.windowSeconds) * time.Second)
-	
-	key := fmt.Sprintf("ratelimit:%s", identifier)
-	
-	// Use Redis pipeline for atomic operations
-	pipe := rl.client.Pipeline()
-	
-	// Remove old entries outside the current window
-	pipe.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(windowStart.UnixNano(), 10))
-	
-	// Count requests in current window
-	countCmd := pipe.ZCard(ctx, key)
-	
-	// Execute pipeline
-	_, err := pipe.Exec(ctx)
-	if err != nil {
-		return false, 0, 0, err
-	}
-	
-	currentCount := int(countCmd.Val())
-	
-	// Calculate remaining requests
-	remaining := rl.requestsPerIP - currentCount
-	if remaining < 0 {
-		remaining = 0
-	}
-	
-	// Calculate reset time (end of current window)
-	resetTime := now.Add(time.Duration(rl.windowSeconds) * time.Second).Unix()
-	
-	// Check if request is allowed
-	// Allow burst up to burstSize above the limit
-	maxAllowed := rl.requestsPerIP + rl.burstSize
-	
-	if currentCount >= maxAllowed {
-		return false, 0, resetTime, nil
-	}
-	
-	// Add current request timestamp to sorted set
-	score := now.UnixNano()
-	member := fmt.Sprintf("%d:%s", score, generateRequestID())
-	
-	pipe2 := rl.client.Pipeline()
-	pipe2.ZAdd(ctx, key, redis.Z{
-		Score:  float64(score),
-		Member: member,
+// preAuthUser returns the user of a verified access token when a user override could apply
+func (rl *RateLimiter) preAuthUser(c *fiber.Ctx) string {
+	if rl.verifyUser == nil || !rl.hasUserOverrides() {
+		return ""
+	}
+	return rl.verifyUser(c)
+}
+
+func (rl *RateLimiter) reject(c *fiber.Ctx, policy *ratelimit.Policy, key string, result ratelimit.Result) error {
+	retryAfter := int64(result.RetryAfter.Round(time.Second) / time.Second)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	setRateLimitHeaders(c, result)
+	c.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+
+	logger.WithContext(c.UserContext()).Warn("Rate limit exceeded",
+		"policy", policy.Name,
+		"key", key,
+		"ip", c.IP(),
+		"path", c.Path(),
+		"method", c.Method(),
+		"retry_after", retryAfter,
+	)
+
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":   "Rate limit exceeded",
+		"message": fmt.Sprintf("Too many requests. Please try again in %d seconds", retryAfter),
+		"policy":  policy.Name,
+		"limit":   result.Limit,
+		"window":  policy.Window,
 	})
-	
-	// Set expiration on the key to prevent memory leak
-	pipe2.Expire(ctx, key, time.Duration(rl.windowSeconds*2)*time.Second)
-	
-	_, err = pipe2.Exec(ctx)
-	if err != nil {
-		return false, 0, 0, err
+}
+
+func setRateLimitHeaders(c *fiber.Ctx, result ratelimit.Result) {
+	c.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Set("X-RateLimit-Reset", strconv.FormatInt(result.ResetAt.Unix(), 10))
+}
+
+// rateLimitRequest collects the request attributes used by policies
+func rateLimitRequest(c *fiber.Ctx) ratelimit.Request {
+	req := ratelimit.Request{
+		IP:     c.IP(),
+		Method: c.Method(),
+		Path:   c.Path(),
 	}
-	
-	// Update remaining count
-	remaining = rl.requestsPerIP - (currentCount + 1)
-	if remaining < 0 {
-		remaining = 0
+	req.UserID, _ = c.Locals("userID").(string)
+	req.Role, _ = c.Locals("role").(string)
+	if route, ok := c.Locals("route").(*routes.Route); ok {
+		req.Route = route.Name
 	}
-	
-	return true, remaining, resetTime, nil
+	if apiKey := c.Get("X-API-Key"); apiKey != "" {
+		// Never store raw API keys in Redis
+		sum := sha256.Sum256([]byte(apiKey))
+		req.APIKey = hex.EncodeToString(sum[:8])
+	}
+	return req
 }
 
-// generateRequestID generates a simple unique identifier for each request
-func generateRequestID() string {
-	return strconv.FormatInt(time.Now().UnixNano(), 36)
+// allow checks the policy in Redis, or in the in-process limiter while Redis is unavailable
+func (rl *RateLimiter) allow(ctx context.Context, policy *ratelimit.Policy, key string, limit int) ratelimit.Result {
+	maxRequests := limit + policy.Burst
+	window := policy.WindowDuration()
+
+	if time.Now().UnixNano() < rl.redisDownUntil.Load() {
+		return rl.local.Allow(policy.Name+":"+key, limit, maxRequests, window)
+	}
+
+	result, err := rl.allowRequest(ctx, policy, key, limit, maxRequests, window)
+	if err != nil {
+		rl.redisDownUntil.Store(time.Now().Add(rl.fallbackRetry).UnixNano())
+		if rl.usingFallback.CompareAndSwap(false, true) {
+			slog.Error("Rate limiter cannot reach Redis, using in-process limits", "error", err)
+		}
+		return rl.local.Allow(policy.Name+":"+key, limit, maxRequests, window)
+	}
+
+	if rl.usingFallback.CompareAndSwap(true, false) {
+		slog.Info("Rate limiter reconnected to Redis")
+	}
+	return result
 }
 
-// Close closes the Redis connection
-func (rl *RateLimiter) Close() error {
-	if rl.client != nil {
-		return rl.client.Close()
+// allowRequest checks and records one request for the key with a single atomic Lua script
+func (rl *RateLimiter) allowRequest(ctx context.Context, policy *ratelimit.Policy, key string, limit, maxRequests int, window time.Duration) (ratelimit.Result, error) {
+	now := time.Now()
+	nowMicro := now.UnixMicro()
+	member := strconv.FormatInt(nowMicro, 36) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	// The key does not contain the role, keep the limit applied to it so usage reports the same limit
+	effectiveLimit := ""
+	if len(policy.RoleLimits) > 0 {
+		effectiveLimit = strconv.Itoa(limit)
 	}
-	return nil
+
+	keys := []string{rateLimitKey(policy.Name, key), topKeysKey(policy.Name), rejectedKey(policy.Name), limitKey(policy.Name, key)}
+	values, err := slidingWindowScript.Run(ctx, rl.client, keys,
+		nowMicro, nowMicro-window.Microseconds(), maxRequests, member, window.Milliseconds(), key, effectiveLimit,
+	).Int64Slice()
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	if len(values) != 3 {
+		return ratelimit.Result{}, fmt.Errorf("unexpected rate limit script result: %v", values)
+	}
+
+	allowed, count, oldest := values[0] == 1, int(values[1]), values[2]
+
+	// The window frees a slot when the oldest entry expires
+	resetAt := time.UnixMicro(oldest).Add(window)
+	result := ratelimit.Result{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: max(limit-count, 0),
+		ResetAt:   resetAt,
+	}
+	if !allowed {
+		result.RetryAfter = resetAt.Sub(now)
+	}
+	return result, nil
 }
 
-// ResetLimit resets the rate limit for a specific identifier (for testing or admin purposes)
-func (rl *RateLimiter) ResetLimit(ctx context.Context, identifier string) error {
+func rateLimitKey(policy, key string) string {
+	return fmt.Sprintf("ratelimit:%s:%s", policy, key)
+}
+
+func topKeysKey(policy string) string {
+	return "ratelimit-top:" + policy
+}
+
+func rejectedKey(policy string) string {
+	return "ratelimit-rejected:" + policy
+}
+
+func limitKey(policy, key string) string {
+	return fmt.Sprintf("ratelimit-limit:%s:%s", policy, key)
+}
+
+// ResetLimit resets the rate limit of a policy for a specific key (for testing or admin purposes)
+func (rl *RateLimiter) ResetLimit(ctx context.Context, policy, key string) error {
 	if !rl.enabled {
 		return nil
 	}
-	
-	key := fmt.Sprintf("ratelimit:%s", identifier)
-	return rl.client.Del(ctx, key).Err()
+
+	pipe := rl.client.TxPipeline()
+	pipe.Del(ctx, rateLimitKey(policy, key), limitKey(policy, key))
+	pipe.ZRem(ctx, topKeysKey(policy), key)
+	pipe.ZRem(ctx, rejectedKey(policy), key)
+	_, err := pipe.Exec(ctx)
+	return err
 }
 
-// GetCurrentCount returns the current request count for an identifier
-func (rl *RateLimiter) GetCurrentCount(ctx context.Context, identifier string) (int64, error) {
+// GetCurrentCount returns the current request count of a policy for a key
+func (rl *RateLimiter) GetCurrentCount(ctx context.Context, policy *ratelimit.Policy, key string) (int64, error) {
 	if !rl.enabled {
 		return 0, nil
 	}
-	
-	now := time.Now()
-	windowStart := now.Add(-time.Duration(rl.windowSeconds) * time.Second)
-	key := fmt.Sprintf("ratelimit:%s", identifier)
-	
-	// Remove old entries
-	rl.client.ZRemRangeByScore(ctx, key, "0", strconv.FormatInt(windowStart.UnixNano(), 10))
-	
-	// Count current requests
-	return rl.client.ZCard(ctx, key).Result()
+
+	windowStart := time.Now().Add(-policy.WindowDuration())
+	redisKey := rateLimitKey(policy.Name, key)
+
+	// Count only entries inside the current window
+	return rl.client.ZCount(ctx, redisKey, "("+strconv.FormatInt(windowStart.UnixMicro(), 10), "+inf").Result()
 }
@@ -0,0 +1,423 @@
+package middleware
+
+import (
+	"api_gateway/internal/ratelimit"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OverrideAction is the action of a temporary rate-limit override
+type OverrideAction string
+
+const (
+	OverrideAllow OverrideAction = "allow" // skip rate limiting for the subject
+	OverrideDeny  OverrideAction = "deny"  // reject every request of the subject
+)
+
+const overridePrefix = "ratelimit-override:"
+
+var (
+	ErrRateLimitDisabled = errors.New("rate limiting is disabled")
+	ErrUnknownPolicy     = errors.New("unknown rate limit policy")
+	ErrInvalidSubject    = errors.New("subject must be user:<id>, ip:<address> or api_key:<hash>")
+	ErrInvalidAction     = errors.New("action must be allow or deny")
+)
+
+// Override temporarily allows or denies a subject regardless of the policies
+type Override struct {
+	Subject   string         `json:"subject"`
+	Action    OverrideAction `json:"action"`
+	Reason    string         `json:"reason,omitempty"`
+	CreatedBy string         `json:"created_by,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	ExpiresAt time.Time      `json:"expires_at"`
+}
+
+// KeyUsage is the state of one rate-limit key in the current window
+type KeyUsage struct {
+	Policy    string `json:"policy"`
+	Key       string `json:"key"`
+	Count     int64  `json:"count"`
+	Limit     int    `json:"limit"`
+	Burst     int    `json:"burst"`
+	Window    string `json:"window"`
+	Rejected  int64  `json:"rejected"`
+	Throttled bool   `json:"throttled"`
+}
+
+// ValidateSubject checks an override/lookup subject (user:<id>, ip:<address>, api_key:<hash>)
+func ValidateSubject(subject string) error {
+	kind, value, ok := strings.Cut(subject, ":")
+	if !ok || value == "" {
+		return ErrInvalidSubject
+	}
+	switch ratelimit.Dimension(kind) {
+	case ratelimit.DimUser, ratelimit.DimIP, ratelimit.DimAPIKey:
+		return nil
+	}
+	return ErrInvalidSubject
+}
+
+// Policies returns the loaded policies
+func (rl *RateLimiter) Policies() []*ratelimit.Policy {
+	return rl.policies
+}
+
+func (rl *RateLimiter) policy(name string) (*ratelimit.Policy, error) {
+	for _, p := range rl.policies {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, ErrUnknownPolicy
+}
+
+// TopOffenders returns the keys with the most requests in the current window,
+// for one policy or for every policy when policyName is empty
+func (rl *RateLimiter) TopOffenders(ctx context.Context, policyName string, n int) ([]KeyUsage, error) {
+	if !rl.enabled {
+		return nil, ErrRateLimitDisabled
+	}
+
+	policies := rl.policies
+	if policyName != "" {
+		p, err := rl.policy(policyName)
+		if err != nil {
+			return nil, err
+		}
+		policies = []*ratelimit.Policy{p}
+	}
+
+	var usages []KeyUsage
+	for _, p := range policies {
+		// The tracked counts may be stale for idle keys, re-count the candidates
+		candidates, err := rl.client.ZRevRange(ctx, topKeysKey(p.Name), 0, int64(n*2-1)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range candidates {
+			usage, err := rl.usage(ctx, p, key)
+			if err != nil {
+				return nil, err
+			}
+			if usage.Count == 0 && usage.Rejected == 0 {
+				rl.client.ZRem(ctx, topKeysKey(p.Name), key)
+				continue
+			}
+			usages = append(usages, usage)
+		}
+	}
+
+	sort.SliceStable(usages, func(i, j int) bool {
+		if usages[i].Throttled != usages[j].Throttled {
+			return usages[i].Throttled
+		}
+		return usages[i].Count > usages[j].Count
+	})
+	if len(usages) > n {
+		usages = usages[:n]
+	}
+	return usages, nil
+}
+
+// SubjectUsage returns the usage of every key that belongs to the subject (user:<id>, ip:<address>...)
+// across all policies, including composite keys such as user:42|route:bids
+func (rl *RateLimiter) SubjectUsage(ctx context.Context, subject string) ([]KeyUsage, error) {
+	if !rl.enabled {
+		return nil, ErrRateLimitDisabled
+	}
+
+	usages := []KeyUsage{}
+	err := rl.scanSubject(ctx, subject, func(p *ratelimit.Policy, key string) error {
+		usage, err := rl.usage(ctx, p, key)
+		if err != nil {
+			return err
+		}
+		usages = append(usages, usage)
+		return nil
+	})
+	return usages, err
+}
+
+// ResetSubject resets every key of the subject across all policies and returns the number of keys reset
+func (rl *RateLimiter) ResetSubject(ctx context.Context, subject string) (int, error) {
+	if !rl.enabled {
+		return 0, ErrRateLimitDisabled
+	}
+
+	reset := 0
+	err := rl.scanSubject(ctx, subject, func(p *ratelimit.Policy, key string) error {
+		if err := rl.ResetLimit(ctx, p.Name, key); err != nil {
+			return err
+		}
+		reset++
+		return nil
+	})
+	return reset, err
+}
+
+// ResetKey resets one key of a policy
+func (rl *RateLimiter) ResetKey(ctx context.Context, policyName, key string) error {
+	if !rl.enabled {
+		return ErrRateLimitDisabled
+	}
+	if _, err := rl.policy(policyName); err != nil {
+		return err
+	}
+	return rl.ResetLimit(ctx, policyName, key)
+}
+
+// scanSubject calls fn for every existing rate-limit key containing the subject as a key part
+func (rl *RateLimiter) scanSubject(ctx context.Context, subject string, fn func(*ratelimit.Policy, string) error) error {
+	for _, p := range rl.policies {
+		prefix := rateLimitKey(p.Name, "")
+		iter := rl.client.Scan(ctx, 0, prefix+"*"+escapeGlob(subject)+"*", 500).Iterator()
+		for iter.Next(ctx) {
+			key := strings.TrimPrefix(iter.Val(), prefix)
+			if !hasKeyPart(key, subject) {
+				continue
+			}
+			if err := fn(p, key); err != nil {
+				return err
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rl *RateLimiter) usage(ctx context.Context, p *ratelimit.Policy, key string) (KeyUsage, error) {
+	count, err := rl.GetCurrentCount(ctx, p, key)
+	if err != nil {
+		return KeyUsage{}, err
+	}
+	rejected, err := rl.client.ZScore(ctx, rejectedKey(p.Name), key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return KeyUsage{}, err
+	}
+	limit, err := rl.effectiveLimit(ctx, p, key)
+	if err != nil {
+		return KeyUsage{}, err
+	}
+
+	return KeyUsage{
+		Policy:    p.Name,
+		Key:       key,
+		Count:     count,
+		Limit:     limit,
+		Burst:     p.Burst,
+		Window:    p.Window,
+		Rejected:  int64(rejected),
+		Throttled: count >= int64(limit+p.Burst),
+	}, nil
+}
+
+// effectiveLimit returns the limit last applied to the key, which depends on the role for policies
+// with role_limits. The recorded limit expires with the window, idle keys report the policy limit.
+func (rl *RateLimiter) effectiveLimit(ctx context.Context, p *ratelimit.Policy, key string) (int, error) {
+	if len(p.RoleLimits) == 0 {
+		return p.Limit, nil
+	}
+	limit, err := rl.client.Get(ctx, limitKey(p.Name, key)).Int()
+	if errors.Is(err, redis.Nil) {
+		return p.Limit, nil
+	}
+	return limit, err
+}
+
+// SetOverride stores a temporary override in Redis; it applies on every gateway instance
+// within the override refresh interval and immediately on this one
+func (rl *RateLimiter) SetOverride(ctx context.Context, o Override, ttl time.Duration) (Override, error) {
+	if !rl.enabled {
+		return Override{}, ErrRateLimitDisabled
+	}
+	if err := ValidateSubject(o.Subject); err != nil {
+		return Override{}, err
+	}
+	if o.Action != OverrideAllow && o.Action != OverrideDeny {
+		return Override{}, ErrInvalidAction
+	}
+
+	o.CreatedAt = time.Now()
+	o.ExpiresAt = o.CreatedAt.Add(ttl)
+	data, err := json.Marshal(o)
+	if err != nil {
+		return Override{}, err
+	}
+	if err := rl.client.Set(ctx, overridePrefix+o.Subject, data, ttl).Err(); err != nil {
+		return Override{}, err
+	}
+
+	rl.updateOverrides(func(m map[string]Override) { m[o.Subject] = o })
+	slog.Info("Rate limit override set",
+		"subject", o.Subject,
+		"action", o.Action,
+		"expires_at", o.ExpiresAt,
+		"created_by", o.CreatedBy,
+		"reason", o.Reason,
+	)
+	return o, nil
+}
+
+// DeleteOverride removes the override of a subject
+func (rl *RateLimiter) DeleteOverride(ctx context.Context, subject string) error {
+	if !rl.enabled {
+		return ErrRateLimitDisabled
+	}
+	if err := rl.client.Del(ctx, overridePrefix+subject).Err(); err != nil {
+		return err
+	}
+	rl.updateOverrides(func(m map[string]Override) { delete(m, subject) })
+	slog.Info("Rate limit override removed", "subject", subject)
+	return nil
+}
+
+// Overrides returns the active overrides known to this instance
+func (rl *RateLimiter) Overrides() []Override {
+	now := time.Now()
+	list := []Override{}
+	if m := rl.overrides.Load(); m != nil {
+		for _, o := range *m {
+			if now.Before(o.ExpiresAt) {
+				list = append(list, o)
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Subject < list[j].Subject })
+	return list
+}
+
+// StartOverrideRefresh reloads overrides from Redis periodically so overrides set through
+// another gateway instance also apply here
+func (rl *RateLimiter) StartOverrideRefresh(ctx context.Context, interval time.Duration) {
+	if !rl.enabled || interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := rl.refreshOverrides(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to refresh rate limit overrides", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (rl *RateLimiter) refreshOverrides(ctx context.Context) error {
+	overrides := map[string]Override{}
+	iter := rl.client.Scan(ctx, 0, overridePrefix+"*", 500).Iterator()
+	for iter.Next(ctx) {
+		data, err := rl.client.Get(ctx, iter.Val()).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var o Override
+		if err := json.Unmarshal(data, &o); err != nil {
+			slog.Warn("Invalid rate limit override", "key", iter.Val(), "error", err)
+			continue
+		}
+		overrides[o.Subject] = o
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+
+	rl.overrides.Store(&overrides)
+	return nil
+}
+
+func (rl *RateLimiter) updateOverrides(fn func(map[string]Override)) {
+	rl.overridesMu.Lock()
+	defer rl.overridesMu.Unlock()
+
+	next := map[string]Override{}
+	if m := rl.overrides.Load(); m != nil {
+		for k, v := range *m {
+			next[k] = v
+		}
+	}
+	fn(next)
+	rl.overrides.Store(&next)
+}
+
+// override returns the active override among the subjects, a deny taking precedence over an allow
+func (rl *RateLimiter) override(subjects ...string) (Override, bool) {
+	m := rl.overrides.Load()
+	if m == nil {
+		return Override{}, false
+	}
+	now := time.Now()
+	var found Override
+	ok := false
+	for _, subject := range subjects {
+		o, exists := (*m)[subject]
+		if !exists || !now.Before(o.ExpiresAt) {
+			continue
+		}
+		if o.Action == OverrideDeny {
+			return o, true
+		}
+		if !ok {
+			found, ok = o, true
+		}
+	}
+	return found, ok
+}
+
+// hasUserOverrides reports whether an active override targets a user
+func (rl *RateLimiter) hasUserOverrides() bool {
+	m := rl.overrides.Load()
+	if m == nil {
+		return false
+	}
+	now := time.Now()
+	for subject, o := range *m {
+		if strings.HasPrefix(subject, "user:") && now.Before(o.ExpiresAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasKeyPart reports whether the subject is one of the |-separated parts of a rate-limit key
+func hasKeyPart(key, subject string) bool {
+	for _, part := range strings.Split(key, "|") {
+		if part == subject {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeGlob escapes the Redis SCAN MATCH special characters
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
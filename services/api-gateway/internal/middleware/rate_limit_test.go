@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"api_gateway/internal/ratelimit"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func limiterWithOverrides(overrides ...Override) *RateLimiter {
+	rl := &RateLimiter{enabled: true}
+	m := map[string]Override{}
+	for _, o := range overrides {
+		m[o.Subject] = o
+	}
+	rl.overrides.Store(&m)
+	return rl
+}
+
+func activeOverride(subject string, action OverrideAction) Override {
+	return Override{Subject: subject, Action: action, ExpiresAt: time.Now().Add(time.Hour)}
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	expired := activeOverride("user:7", OverrideDeny)
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+
+	rl := limiterWithOverrides(
+		activeOverride("ip:10.0.0.1", OverrideAllow),
+		activeOverride("ip:10.0.0.2", OverrideDeny),
+		activeOverride("user:42", OverrideAllow),
+		activeOverride("user:43", OverrideDeny),
+		expired,
+	)
+
+	tests := []struct {
+		name     string
+		subjects []string
+		want     OverrideAction // rỗng nếu không có override
+	}{
+		{"user allow lifts the IP policies", []string{"ip:10.0.0.9", "user:42"}, OverrideAllow},
+		{"IP deny wins over user allow", []string{"ip:10.0.0.2", "user:42"}, OverrideDeny},
+		{"user deny wins over IP allow", []string{"ip:10.0.0.1", "user:43"}, OverrideDeny},
+		{"expired override is ignored", []string{"ip:10.0.0.9", "user:7"}, ""},
+		{"no override", []string{"ip:10.0.0.9"}, ""},
+	}
+	for _, tt := range tests {
+		got := OverrideAction("")
+		if o, ok := rl.override(tt.subjects...); ok {
+			got = o.Action
+		}
+		if got != tt.want {
+			t.Errorf("%s: override = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// Bidder bị policy theo IP chặn được mở bằng override allow cho user, kể cả trước khi xác thực
+func TestPreAuthUserOverride(t *testing.T) {
+	verified := 0
+	verify := func(c *fiber.Ctx) string {
+		verified++
+		if c.Get("X-User-Token") == "valid-token-of-42" {
+			return "42"
+		}
+		return ""
+	}
+
+	newLimiter := func(overrides ...Override) *RateLimiter {
+		policies := []*ratelimit.Policy{{Name: "ip", Key: []ratelimit.Dimension{ratelimit.DimIP}, Limit: 1, Window: "1m"}}
+		if err := ratelimit.Validate(policies); err != nil {
+			t.Fatal(err)
+		}
+		rl := limiterWithOverrides(overrides...)
+		rl.policies = policies
+		rl.local = ratelimit.NewLocalLimiter()
+		// Không có Redis trong test: dùng limiter trong process
+		rl.redisDownUntil.Store(time.Now().Add(time.Hour).UnixNano())
+		rl.SetUserVerifier(verify)
+		return rl
+	}
+
+	run := func(rl *RateLimiter, token string) int {
+		app := fiber.New()
+		app.Use(rl.Middleware())
+		app.Get("/", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if token != "" {
+			req.Header.Set("X-User-Token", token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	// Không có override của user: request thứ hai từ cùng IP bị chặn, token không được verify
+	rl := newLimiter()
+	run(rl, "valid-token-of-42")
+	if status := run(rl, "valid-token-of-42"); status != fiber.StatusTooManyRequests {
+		t.Errorf("without override: status = %d, want 429", status)
+	}
+	if verified != 0 {
+		t.Errorf("token verified %d times without user overrides", verified)
+	}
+
+	// Override allow của user 42 bỏ qua policy theo IP, token giả vẫn bị chặn
+	rl = newLimiter(activeOverride("user:42", OverrideAllow))
+	for i := 0; i < 3; i++ {
+		if status := run(rl, "valid-token-of-42"); status != fiber.StatusOK {
+			t.Errorf("user allow, request %d: status = %d, want 200", i+1, status)
+		}
+	}
+	run(rl, "forged")
+	if status := run(rl, "forged"); status != fiber.StatusTooManyRequests {
+		t.Errorf("forged token: status = %d, want 429", status)
+	}
+
+	// Override deny của user 42 áp dụng trước xác thực
+	rl = newLimiter(activeOverride("user:42", OverrideDeny))
+	if status := run(rl, "valid-token-of-42"); status != fiber.StatusForbidden {
+		t.Errorf("user deny: status = %d, want 403", status)
+	}
+}
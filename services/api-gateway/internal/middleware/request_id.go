@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+
+	"api_gateway/internal/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// RequestIDHeader là header mang request ID giữa client, gateway và các service
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength giới hạn độ dài request ID nhận từ client
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware nhận X-Request-ID của client (nếu hợp lệ) hoặc sinh ID mới, gắn vào context
+// (logger.WithContext đọc từ đây), request gửi upstream và response trả về client.
+// Đặt trước TracingMiddleware để access log của mọi request đều có request_id.
+func RequestIDMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		requestID := c.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Request().Header.Set(RequestIDHeader, requestID)
+		c.Set(RequestIDHeader, requestID)
+		c.Locals("requestID", requestID)
+		c.SetUserContext(logger.ContextWithRequestID(c.UserContext(), requestID))
+
+		return c.Next()
+	}
+}
+
+// RequestID trả về request ID của request hiện tại
+func RequestID(c *fiber.Ctx) string {
+	requestID, _ := c.Locals("requestID").(string)
+	return requestID
+}
+
+// CorrelationHeaders trả về header dùng để nối request gửi đi với request hiện tại:
+// X-Request-ID và trace context (traceparent, tracestate) của span hiện tại
+func CorrelationHeaders(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if requestID := logger.RequestIDFromContext(ctx); requestID != "" {
+		carrier[RequestIDHeader] = requestID
+	}
+	return carrier
+}
+
+// validRequestID chỉ nhận ID ngắn gồm ký tự an toàn để tránh log injection
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == ':') {
+			return false
+		}
+	}
+	return true
+}
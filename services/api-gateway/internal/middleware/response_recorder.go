@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	responseRecordersKey = "responseRecorders"
+	upstreamForwardedKey = "upstreamForwarded"
+)
+
+// TrackForwarding đánh dấu request cần biết đã được gửi tới upstream hay chưa, xem Forwarded
+func TrackForwarding(c *fiber.Ctx) *atomic.Bool {
+	forwarded := &atomic.Bool{}
+	c.Locals(upstreamForwardedKey, forwarded)
+	return forwarded
+}
+
+// WithForwardTrace gắn vào ctx của request tới upstream một trace đánh dấu request đã được gửi đi
+// khi header của request được ghi xong vào connection (kể cả khi sau đó lỗi hoặc timeout)
+func WithForwardTrace(c *fiber.Ctx, ctx context.Context) context.Context {
+	forwarded, ok := c.Locals(upstreamForwardedKey).(*atomic.Bool)
+	if !ok {
+		return ctx
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaders: func() { forwarded.Store(true) },
+	})
+}
+
+// ResponseRecorder ghi lại response body mà ProxyHandler stream từ upstream về client.
+// Body chỉ được đọc khi fasthttp ghi response, tức là sau khi handler chain đã return.
+type ResponseRecorder struct {
+	limit      int64
+	onComplete func(body []byte)
+	onAbort    func()
+}
+
+// RecordResponse đăng ký một recorder cho request, body lớn hơn limit byte (> 0) sẽ không được ghi lại
+func RecordResponse(c *fiber.Ctx, limit int64) *ResponseRecorder {
+	r := &ResponseRecorder{limit: limit}
+	recorders, _ := c.Locals(responseRecordersKey).([]*ResponseRecorder)
+	c.Locals(responseRecordersKey, append(recorders, r))
+	return r
+}
+
+// OnComplete đặt hàm nhận toàn bộ body khi body được stream hết về client mà không lỗi.
+// Phải được gọi trước khi middleware return.
+func (r *ResponseRecorder) OnComplete(fn func(body []byte)) {
+	r.onComplete = fn
+}
+
+// OnAbort đặt hàm được gọi khi body không được stream hết (lỗi upstream, client ngắt kết nối)
+// hoặc vượt limit. Phải được gọi trước khi middleware return.
+func (r *ResponseRecorder) OnAbort(fn func()) {
+	r.onAbort = fn
+}
+
+// WrapResponseBody bọc response body của upstream bằng các recorder đã đăng ký cho request
+func WrapResponseBody(c *fiber.Ctx, body io.ReadCloser) io.ReadCloser {
+	recorders, _ := c.Locals(responseRecordersKey).([]*ResponseRecorder)
+	for _, r := range recorders {
+		body = &recordingBody{body: body, recorder: r}
+	}
+	return body
+}
+
+type recordingBody struct {
+	body     io.ReadCloser
+	recorder *ResponseRecorder
+	buf      bytes.Buffer
+	overflow bool
+	eof      bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 && !b.overflow {
+		if b.recorder.limit > 0 && int64(b.buf.Len()+n) > b.recorder.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.body.Close()
+	switch {
+	case b.eof && !b.overflow:
+		if b.recorder.onComplete != nil {
+			b.recorder.onComplete(b.buf.Bytes())
+		}
+	case b.recorder.onAbort != nil:
+		b.recorder.onAbort()
+	}
+	return err
+}
+
+// responseHeaderNames trả về tên các header đã có trong response trước khi proxy,
+// tức là header do gateway set (CORS, rate limit...) chứ không phải của upstream
+func responseHeaderNames(c *fiber.Ctx) map[string]bool {
+	names := map[string]bool{}
+	c.Response().Header.VisitAll(func(k, _ []byte) {
+		names[http.CanonicalHeaderKey(string(k))] = true
+	})
+	delete(names, fiber.HeaderContentType)
+	return names
+}
+
+// upstreamHeaders copy header của response sau khi proxy, bỏ các header của gateway và header trong skip
+func upstreamHeaders(c *fiber.Ctx, gatewayHeaders map[string]bool, skip []string) map[string][]string {
+	header := map[string][]string{}
+	c.Response().Header.VisitAll(func(k, v []byte) {
+		name := http.CanonicalHeaderKey(string(k))
+		if gatewayHeaders[name] || containsHeader(skip, name) {
+			return
+		}
+		header[name] = append(header[name], string(v))
+	})
+	return header
+}
+
+// setResponseHeaders ghi header đã lưu vào response, thay thế giá trị cùng tên
+func setResponseHeaders(c *fiber.Ctx, header map[string][]string) {
+	for name, values := range header {
+		c.Response().Header.Del(name)
+		for _, v := range values {
+			c.Response().Header.Add(name, v)
+		}
+	}
+}
+
+func containsHeader(list []string, name string) bool {
+	for _, h := range list {
+		if strings.EqualFold(name, h) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"api_gateway/internal/logger"
+	"api_gateway/internal/routes"
+	"log/slog"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RouteMiddleware tìm route trong bảng route hiện tại cho request /api/...
+// và lưu vào Locals("route"), phần path còn lại lưu vào Locals("routePath")
+func RouteMiddleware(registry *routes.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		path := strings.TrimPrefix(c.Path(), "/api")
+		if len(path) > 1 {
+			path = strings.TrimSuffix(path, "/")
+		}
+
+		route, rest, ok := registry.Match(path)
+		if !ok {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Route not found",
+			})
+		}
+
+		c.Locals("route", route)
+		c.Locals("routePath", rest)
+		return c.Next()
+	}
+}
+
+// RouteAccessMiddleware áp dụng yêu cầu auth và roles của route sau khi AuthMiddleware đã chạy
+func RouteAccessMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		route, ok := c.Locals("route").(*routes.Route)
+		if !ok || route.Auth != routes.AuthRequired {
+			return c.Next()
+		}
+
+		identity := IdentityFromContext(c)
+		if !identity.Authenticated() {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Authentication required",
+			})
+		}
+
+		if !route.AllowsRole(identity.Role) {
+			logger.WithContext(c.UserContext()).Warn("Role not allowed for route",
+				slog.String("route", route.Name),
+				slog.String("role", identity.Role),
+				slog.String("user_id", identity.UserID),
+				slog.String("client_id", identity.ClientID),
+			)
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient permissions",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireRoles yêu cầu user đã đăng nhập và có một trong các role được liệt kê
+func RequireRoles(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, _ := c.Locals("userID").(string)
+		if userID == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Authentication required",
+			})
+		}
+
+		role, _ := c.Locals("role").(string)
+		for _, allowed := range roles {
+			if role == allowed {
+				return c.Next()
+			}
+		}
+
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient permissions",
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"api_gateway/internal/config"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/routes"
+	"api_gateway/internal/security"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Error codes trả về khi request bị chặn bởi security policy
+const (
+	ErrCodeIPDenied        = "IP_DENIED"
+	ErrCodeOriginDenied    = "CORS_ORIGIN_DENIED"
+	ErrCodeBodyTooLarge    = "REQUEST_BODY_TOO_LARGE"
+	ErrCodeHeadersTooLarge = "REQUEST_HEADERS_TOO_LARGE"
+)
+
+// IPFilterMiddleware chặn request từ IP khớp rule deny (CIDR tĩnh trong file policy hoặc rule runtime).
+// Đặt trước rate limiter để IP bị chặn không tốn quota và không chạm tới Redis.
+func IPFilterMiddleware(filter *security.IPFilter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		rule, allowed := filter.Check(c.IP())
+		if allowed {
+			return c.Next()
+		}
+
+		logger.WithContext(c.UserContext()).Warn("Request blocked by IP rule",
+			slog.String("ip", c.IP()),
+			slog.String("cidr", rule.CIDR),
+			slog.String("source", rule.Source),
+			slog.String("method", c.Method()),
+			slog.String("path", c.Path()),
+		)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Access denied",
+			"code":  ErrCodeIPDenied,
+		})
+	}
+}
+
+// SecurityHeadersMiddleware set security header (HSTS, nosniff, frame-options...) cho mọi response,
+// kể cả response lỗi và response proxy từ upstream
+func SecurityHeadersMiddleware(policy *security.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := c.Next()
+		for name, value := range policy.Headers {
+			c.Set(name, value)
+		}
+		return err
+	}
+}
+
+// CORSMiddleware trả CORS header cho origin nằm trong allowlist của môi trường hiện tại.
+// Origin được trả lại nguyên văn kèm Vary: Origin; origin không được phép không nhận CORS header nào
+// (browser sẽ chặn response) và preflight của origin đó bị từ chối. CORS header của upstream bị bỏ
+// để gateway là nơi duy nhất quyết định.
+func CORSMiddleware(policy *security.Policy) fiber.Handler {
+	cors := &policy.CORS
+	allowMethods := strings.Join(cors.AllowMethods, ", ")
+	allowHeaders := strings.Join(cors.AllowHeaders, ", ")
+	exposeHeaders := strings.Join(cors.ExposeHeaders, ", ")
+	maxAge := strconv.Itoa(cors.MaxAgeSeconds())
+
+	return func(c *fiber.Ctx) error {
+		origin := c.Get(fiber.HeaderOrigin)
+		preflight := c.Method() == fiber.MethodOptions && c.Get(fiber.HeaderAccessControlRequestMethod) != ""
+
+		if origin == "" {
+			return c.Next()
+		}
+
+		allowed := cors.AllowsOrigin(origin)
+		if preflight {
+			c.Vary(fiber.HeaderOrigin)
+			if !allowed || !cors.AllowsMethod(c.Get(fiber.HeaderAccessControlRequestMethod)) ||
+				!cors.AllowsHeaders(c.Get(fiber.HeaderAccessControlRequestHeaders)) {
+				logger.WithContext(c.UserContext()).Warn("CORS preflight rejected",
+					slog.String("origin", origin),
+					slog.String("method", c.Get(fiber.HeaderAccessControlRequestMethod)),
+					slog.String("headers", c.Get(fiber.HeaderAccessControlRequestHeaders)),
+				)
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error": "Origin not allowed",
+					"code":  ErrCodeOriginDenied,
+				})
+			}
+			setAllowOrigin(c, cors, origin)
+			c.Set(fiber.HeaderAccessControlAllowMethods, allowMethods)
+			c.Set(fiber.HeaderAccessControlAllowHeaders, allowHeaders)
+			c.Set(fiber.HeaderAccessControlMaxAge, maxAge)
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+
+		err := c.Next()
+
+		resp := &c.Response().Header
+		resp.Del(fiber.HeaderAccessControlAllowOrigin)
+		resp.Del(fiber.HeaderAccessControlAllowCredentials)
+		resp.Del(fiber.HeaderAccessControlExposeHeaders)
+		c.Vary(fiber.HeaderOrigin)
+		if allowed {
+			setAllowOrigin(c, cors, origin)
+			if exposeHeaders != "" {
+				c.Set(fiber.HeaderAccessControlExposeHeaders, exposeHeaders)
+			}
+		}
+		return err
+	}
+}
+
+func setAllowOrigin(c *fiber.Ctx, cors *security.CORS, origin string) {
+	if cors.AnyOrigin() {
+		c.Set(fiber.HeaderAccessControlAllowOrigin, "*")
+		return
+	}
+	c.Set(fiber.HeaderAccessControlAllowOrigin, origin)
+	if cors.AllowCredentials {
+		c.Set(fiber.HeaderAccessControlAllowCredentials, "true")
+	}
+}
+
+// RequestLimitsMiddleware áp dụng giới hạn kích thước header và body của route (limits trong bảng route,
+// mặc định MAX_HEADER_BYTES và PROXY_MAX_REQUEST_BODY_BYTES) trước khi xác thực. Body stream không có
+// Content-Length được giới hạn tiếp khi proxy đọc body.
+func RequestLimitsMiddleware(cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		route, ok := c.Locals("route").(*routes.Route)
+		if !ok {
+			return c.Next()
+		}
+
+		maxHeader := route.MaxHeaderBytes(int64(cfg.MaxHeaderBytes))
+		if size := requestHeaderSize(c); maxHeader > 0 && size > maxHeader {
+			logger.WithContext(c.UserContext()).Warn("Request headers too large",
+				slog.String("route", route.Name),
+				slog.Int64("size", size),
+				slog.Int64("limit", maxHeader),
+				slog.String("ip", c.IP()),
+			)
+			return c.Status(fiber.StatusRequestHeaderFieldsTooLarge).JSON(fiber.Map{
+				"error": "Request headers too large",
+				"code":  ErrCodeHeadersTooLarge,
+				"limit": maxHeader,
+			})
+		}
+
+		maxBody := route.MaxBodyBytes(cfg.ProxyMaxRequestBodyBytes)
+		if length := int64(c.Request().Header.ContentLength()); maxBody > 0 && length > maxBody {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error": "Request body too large",
+				"code":  ErrCodeBodyTooLarge,
+				"limit": maxBody,
+			})
+		}
+		return c.Next()
+	}
+}
+
+// requestHeaderSize trả về tổng kích thước header của request (tên, giá trị và dấu phân cách)
+func requestHeaderSize(c *fiber.Ctx) int64 {
+	if raw := c.Request().Header.RawHeaders(); len(raw) > 0 {
+		return int64(len(raw))
+	}
+	var size int64
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		size += int64(len(key) + len(value) + 4)
+	})
+	return size
+}
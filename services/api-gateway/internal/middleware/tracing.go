@@ -1,10 +1,12 @@
 package middleware
 
 import (
-	"log/slog"
+	"errors"
 	"time"
 
+	"api_gateway/internal/logger"
 	"api_gateway/internal/metrics"
+	"api_gateway/internal/routes"
 
 	"github.com/gofiber/fiber/v2"
 	"go.opentelemetry.io/otel"
@@ -20,9 +22,9 @@ var tracer = otel.Tracer("fiber-http")
 // TracingMiddleware thêm OpenTelemetry tracing vào Fiber requests
 func TracingMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Extract trace context từ headers
+		// Extract trace context từ headers; UserContext đã có request ID (RequestIDMiddleware)
 		ctx := otel.GetTextMapPropagator().Extract(
-			c.Context(),
+			c.UserContext(),
 			propagation.HeaderCarrier(c.GetReqHeaders()),
 		)
 
@@ -59,18 +61,24 @@ func TracingMiddleware() fiber.Handler {
 		// Record duration
 		duration := time.Since(start).Milliseconds()
 
+		// Lỗi trả về từ handler chưa được ghi vào response (error handler của Fiber chạy sau middleware)
+		statusCode := responseStatus(c, err)
+		upstream, routeTemplate := metricLabels(c)
+
 		// Add response attributes
 		span.SetAttributes(
-			attribute.Int("http.status_code", c.Response().StatusCode()),
-			attribute.Int64("http.response_size", int64(len(c.Response().Body()))),
-			attribute.Int64("http.request_size", int64(len(c.Request().Body()))),
+			attribute.String("http.route", routeTemplate),
+			attribute.Int("http.status_code", statusCode),
+			attribute.Int64("http.response_size", responseSize(c)),
+			attribute.Int64("http.request_size", int64(c.Request().Header.ContentLength())),
 		)
 
 		// Record metrics
 		metricAttrs := []attribute.KeyValue{
+			attribute.String("upstream", upstream),
+			attribute.String("route", routeTemplate),
 			attribute.String("method", c.Method()),
-			attribute.String("route", c.Route().Path),
-			attribute.Int("status", c.Response().StatusCode()),
+			attribute.String("status_class", metrics.StatusClass(statusCode)),
 		}
 
 		if metrics.HTTPRequestCounter != nil {
@@ -85,24 +93,22 @@ func TracingMiddleware() fiber.Handler {
 			)
 		}
 
-		// Log HTTP request với trace context
-		statusCode := c.Response().StatusCode()
+		// Log HTTP request với request ID và trace context
 		logAttrs := []any{
 			"method", c.Method(),
-			"route", c.Route().Path,
+			"route", routeTemplate,
 			"status", statusCode,
 			"duration_ms", duration,
 			"client_ip", c.IP(),
-			"trace_id", span.SpanContext().TraceID().String(),
-			"span_id", span.SpanContext().SpanID().String(),
 		}
 
+		log := logger.WithContext(ctx)
 		if statusCode >= 500 {
-			slog.ErrorContext(ctx, "HTTP request", logAttrs...)
+			log.ErrorContext(ctx, "HTTP request", logAttrs...)
 		} else if statusCode >= 400 {
-			slog.WarnContext(ctx, "HTTP request", logAttrs...)
+			log.WarnContext(ctx, "HTTP request", logAttrs...)
 		} else {
-			slog.InfoContext(ctx, "HTTP request", logAttrs...)
+			log.InfoContext(ctx, "HTTP request", logAttrs...)
 		}
 
 		// Handle errors
@@ -122,3 +128,38 @@ func TracingMiddleware() fiber.Handler {
 		return nil
 	}
 }
+
+// responseSize trả về kích thước response; với body dạng stream thì dùng Content-Length
+// để không đọc toàn bộ body vào bộ nhớ
+func responseSize(c *fiber.Ctx) int64 {
+	if c.Response().IsBodyStream() {
+		return int64(c.Response().Header.ContentLength())
+	}
+	return int64(len(c.Response().Body()))
+}
+
+// metricLabels trả về upstream và route template của request để làm label metrics.
+// Request proxy theo bảng route dùng template /api<path_prefix>/*, upstream là upstream của target canary nếu có;
+// endpoint của chính gateway dùng path của route Fiber.
+// Không dùng path thực tế để tránh số lượng time series tăng theo id trong URL.
+func metricLabels(c *fiber.Ctx) (string, string) {
+	if route, ok := c.Locals("route").(*routes.Route); ok && route != nil {
+		if target, ok := c.Locals("routeTarget").(*routes.Target); ok {
+			return target.Upstream, "/api" + route.PathPrefix + "/*"
+		}
+		return route.Upstream, "/api" + route.PathPrefix + "/*"
+	}
+	return "gateway", c.Route().Path
+}
+
+// responseStatus trả về status code của request, kể cả khi handler trả về error
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe.Code
+	}
+	return fiber.StatusInternalServerError
+}
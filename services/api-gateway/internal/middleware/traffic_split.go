@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"api_gateway/internal/config"
+	"api_gateway/internal/logger"
+	"api_gateway/internal/routes"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TrafficSplitMiddleware chọn target (stable hoặc canary) cho route có targets trong bảng route
+// và lưu target canary vào Locals("routeTarget"). Client có thể chọn target bằng header cấu hình
+// (CANARY_HEADER, giá trị là tên target hoặc stable), ngược lại target được chọn theo trọng số,
+// cố định theo userID (hoặc IP nếu chưa đăng nhập). Phải đứng sau AuthMiddleware.
+func TrafficSplitMiddleware(cfg *config.Config) fiber.Handler {
+	header := cfg.CanaryHeader
+	return func(c *fiber.Ctx) error {
+		route, ok := c.Locals("route").(*routes.Route)
+		if !ok || len(route.Targets) == 0 {
+			return c.Next()
+		}
+
+		var (
+			target   *routes.Target
+			selected bool
+		)
+		if header != "" {
+			if name := c.Get(header); name != "" {
+				if target, selected = route.LookupTarget(name); !selected {
+					logger.WithContext(c.UserContext()).Debug("Unknown route target requested",
+						slog.String("route", route.Name),
+						slog.String("target", name),
+					)
+				}
+				c.Request().Header.Del(header)
+			}
+		}
+		if !selected {
+			target = route.PickTarget(AffinityKey(c))
+		}
+
+		name := routes.StableTarget
+		if target != nil {
+			name = target.Name
+			c.Locals("routeTarget", target)
+		}
+		if header != "" {
+			c.Set(header, name)
+		}
+		return c.Next()
+	}
+}
+
+// AffinityKey là khoá consistent hashing và chia traffic: userID nếu đã đăng nhập, ngược lại là IP của client
+func AffinityKey(c *fiber.Ctx) string {
+	if userID, ok := c.Locals("userID").(string); ok && userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + c.IP()
+}
@@ -0,0 +1,209 @@
+package modes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// modesKey là Redis hash chứa trạng thái các chế độ, field là tên chế độ
+const modesKey = "gateway:modes"
+
+const (
+	fieldMaintenance = "maintenance"
+	fieldReadOnly    = "read_only"
+)
+
+// AllUpstreams trong danh sách upstream của read-only áp dụng cho mọi upstream
+const AllUpstreams = "*"
+
+var ErrInvalidRetryAfter = errors.New("retry_after_seconds must not be negative")
+
+// Maintenance là chế độ bảo trì: mọi route trừ health check, metrics và admin trả 503
+type Maintenance struct {
+	Enabled           bool       `json:"enabled"`
+	Message           string     `json:"message,omitempty"`
+	RetryAfterSeconds int        `json:"retry_after_seconds,omitempty"` // 0 = mặc định của gateway
+	UpdatedBy         string     `json:"updated_by,omitempty"`
+	UpdatedAt         *time.Time `json:"updated_at,omitempty"`
+}
+
+// ReadOnly là chế độ chỉ đọc: request ghi (khác GET/HEAD/OPTIONS) tới các upstream trong danh sách trả 503,
+// request đọc vẫn được proxy bình thường. Danh sách rỗng nghĩa là tắt.
+type ReadOnly struct {
+	Upstreams         []string   `json:"upstreams"`
+	Message           string     `json:"message,omitempty"`
+	RetryAfterSeconds int        `json:"retry_after_seconds,omitempty"` // 0 = mặc định của gateway
+	UpdatedBy         string     `json:"updated_by,omitempty"`
+	UpdatedAt         *time.Time `json:"updated_at,omitempty"`
+}
+
+// Enabled cho biết read-only đang bật cho ít nhất một upstream
+func (r *ReadOnly) Enabled() bool {
+	return len(r.Upstreams) > 0
+}
+
+// Applies cho biết upstream đang ở chế độ chỉ đọc
+func (r *ReadOnly) Applies(upstream string) bool {
+	for _, u := range r.Upstreams {
+		if u == AllUpstreams || u == upstream {
+			return true
+		}
+	}
+	return false
+}
+
+// State là trạng thái các chế độ đang áp dụng
+type State struct {
+	Maintenance Maintenance `json:"maintenance"`
+	ReadOnly    ReadOnly    `json:"read_only"`
+}
+
+// Switch bật/tắt chế độ bảo trì và chỉ đọc lúc runtime. Trạng thái được lưu trong Redis để mọi instance
+// gateway áp dụng giống nhau; mỗi instance tải lại định kỳ và cập nhật ngay khi chế độ được đổi qua instance đó.
+// Khi không đọc được Redis, instance giữ trạng thái đã biết gần nhất.
+type Switch struct {
+	client *redis.Client
+	state  atomic.Pointer[State]
+	loaded atomic.Bool // đã tải trạng thái từ Redis ít nhất một lần
+}
+
+func NewSwitch(client *redis.Client) *Switch {
+	s := &Switch{client: client}
+	s.state.Store(&State{ReadOnly: ReadOnly{Upstreams: []string{}}})
+	return s
+}
+
+// State trả về trạng thái hiện tại, không được sửa giá trị trả về
+func (s *Switch) State() *State {
+	return s.state.Load()
+}
+
+// SetMaintenance bật hoặc tắt chế độ bảo trì
+func (s *Switch) SetMaintenance(ctx context.Context, m Maintenance) (*State, error) {
+	if m.RetryAfterSeconds < 0 {
+		return nil, ErrInvalidRetryAfter
+	}
+	now := time.Now()
+	m.UpdatedAt = &now
+
+	if err := s.save(ctx, fieldMaintenance, m); err != nil {
+		return nil, err
+	}
+	next := *s.state.Load()
+	next.Maintenance = m
+	s.state.Store(&next)
+
+	slog.Warn("Maintenance mode changed",
+		slog.Bool("enabled", m.Enabled),
+		slog.String("updated_by", m.UpdatedBy),
+		slog.String("message", m.Message),
+	)
+	return &next, nil
+}
+
+// SetReadOnly đặt danh sách upstream ở chế độ chỉ đọc, danh sách rỗng để tắt
+func (s *Switch) SetReadOnly(ctx context.Context, r ReadOnly) (*State, error) {
+	if r.RetryAfterSeconds < 0 {
+		return nil, ErrInvalidRetryAfter
+	}
+	if r.Upstreams == nil {
+		r.Upstreams = []string{}
+	}
+	now := time.Now()
+	r.UpdatedAt = &now
+
+	if err := s.save(ctx, fieldReadOnly, r); err != nil {
+		return nil, err
+	}
+	next := *s.state.Load()
+	next.ReadOnly = r
+	s.state.Store(&next)
+
+	slog.Warn("Read-only mode changed",
+		slog.Any("upstreams", r.Upstreams),
+		slog.String("updated_by", r.UpdatedBy),
+		slog.String("message", r.Message),
+	)
+	return &next, nil
+}
+
+func (s *Switch) save(ctx context.Context, field string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(ctx, modesKey, field, data).Err()
+}
+
+// StartRefresh tải trạng thái từ Redis ngay (để instance mới khởi động áp dụng đúng chế độ trước khi nhận request)
+// và tải lại định kỳ để chế độ được đổi qua instance khác cũng được áp dụng
+func (s *Switch) StartRefresh(ctx context.Context, interval time.Duration) {
+	if err := s.refresh(ctx); err != nil {
+		slog.Warn("Failed to load gateway modes", slog.String("error", err.Error()))
+	}
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.refresh(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to refresh gateway modes", slog.String("error", err.Error()))
+			}
+		}
+	}()
+}
+
+func (s *Switch) refresh(ctx context.Context) error {
+	values, err := s.client.HGetAll(ctx, modesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	next := State{ReadOnly: ReadOnly{Upstreams: []string{}}}
+	if data, ok := values[fieldMaintenance]; ok {
+		if err := json.Unmarshal([]byte(data), &next.Maintenance); err != nil {
+			return err
+		}
+	}
+	if data, ok := values[fieldReadOnly]; ok {
+		if err := json.Unmarshal([]byte(data), &next.ReadOnly); err != nil {
+			return err
+		}
+		if next.ReadOnly.Upstreams == nil {
+			next.ReadOnly.Upstreams = []string{}
+		}
+	}
+
+	prev := s.state.Swap(&next)
+	if !s.loaded.Swap(true) {
+		if next.Maintenance.Enabled || next.ReadOnly.Enabled() {
+			slog.Warn("Gateway modes active",
+				slog.Bool("maintenance", next.Maintenance.Enabled),
+				slog.Any("read_only_upstreams", next.ReadOnly.Upstreams),
+			)
+		}
+		return nil
+	}
+	if prev.Maintenance.Enabled != next.Maintenance.Enabled {
+		slog.Warn("Maintenance mode changed by another instance", slog.Bool("enabled", next.Maintenance.Enabled))
+	}
+	if prev.ReadOnly.Enabled() != next.ReadOnly.Enabled() {
+		slog.Warn("Read-only mode changed by another instance", slog.Any("upstreams", next.ReadOnly.Upstreams))
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package modes
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestSwitch(t *testing.T) (*Switch, *miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewSwitch(client), mr, client
+}
+
+func TestReadOnlyApplies(t *testing.T) {
+	tests := []struct {
+		name      string
+		upstreams []string
+		upstream  string
+		want      bool
+	}{
+		{"disabled", []string{}, "order-service", false},
+		{"listed upstream", []string{"order-service", "bidding-service"}, "bidding-service", true},
+		{"other upstream", []string{"order-service"}, "product-service", false},
+		{"all upstreams", []string{AllUpstreams}, "product-service", true},
+	}
+	for _, tt := range tests {
+		r := &ReadOnly{Upstreams: tt.upstreams}
+		if got := r.Applies(tt.upstream); got != tt.want {
+			t.Errorf("%s: Applies(%q) = %v, want %v", tt.name, tt.upstream, got, tt.want)
+		}
+		if got := r.Enabled(); got != (len(tt.upstreams) > 0) {
+			t.Errorf("%s: Enabled() = %v", tt.name, got)
+		}
+	}
+}
+
+func TestSwitchRejectsNegativeRetryAfter(t *testing.T) {
+	s, _, _ := newTestSwitch(t)
+	ctx := context.Background()
+
+	if _, err := s.SetMaintenance(ctx, Maintenance{Enabled: true, RetryAfterSeconds: -1}); !errors.Is(err, ErrInvalidRetryAfter) {
+		t.Errorf("SetMaintenance() error = %v, want %v", err, ErrInvalidRetryAfter)
+	}
+	if _, err := s.SetReadOnly(ctx, ReadOnly{Upstreams: []string{"order-service"}, RetryAfterSeconds: -1}); !errors.Is(err, ErrInvalidRetryAfter) {
+		t.Errorf("SetReadOnly() error = %v, want %v", err, ErrInvalidRetryAfter)
+	}
+	if state := s.State(); state.Maintenance.Enabled || state.ReadOnly.Enabled() {
+		t.Errorf("State() = %+v, want unchanged", state)
+	}
+}
+
+func TestSwitchSharedAcrossInstances(t *testing.T) {
+	s, _, client := newTestSwitch(t)
+	ctx := context.Background()
+
+	state, err := s.SetMaintenance(ctx, Maintenance{Enabled: true, Message: "Nâng cấp hệ thống", UpdatedBy: "admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Maintenance.Enabled || state.Maintenance.UpdatedAt == nil {
+		t.Errorf("SetMaintenance() state = %+v", state.Maintenance)
+	}
+	if _, err := s.SetReadOnly(ctx, ReadOnly{Upstreams: []string{"order-service"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Đổi read-only không tắt chế độ bảo trì
+	if !s.State().Maintenance.Enabled {
+		t.Error("SetReadOnly() reset maintenance mode")
+	}
+
+	// Instance mới khởi động áp dụng chế độ đang bật
+	other := NewSwitch(client)
+	other.StartRefresh(ctx, 0)
+	got := other.State()
+	if !got.Maintenance.Enabled || got.Maintenance.Message != "Nâng cấp hệ thống" {
+		t.Errorf("maintenance on other instance = %+v", got.Maintenance)
+	}
+	if !got.ReadOnly.Applies("order-service") || got.ReadOnly.Applies("product-service") {
+		t.Errorf("read-only on other instance = %+v", got.ReadOnly)
+	}
+
+	// Tắt qua instance khác, instance đầu nhận sau lần refresh
+	if _, err := other.SetMaintenance(ctx, Maintenance{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.SetReadOnly(ctx, ReadOnly{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if state := s.State(); state.Maintenance.Enabled || state.ReadOnly.Enabled() || state.ReadOnly.Upstreams == nil {
+		t.Errorf("State() after refresh = %+v, want both modes off", state)
+	}
+}
+
+func TestSwitchKeepsStateWhenRedisFails(t *testing.T) {
+	s, mr, _ := newTestSwitch(t)
+	ctx := context.Background()
+
+	if _, err := s.SetReadOnly(ctx, ReadOnly{Upstreams: []string{AllUpstreams}}); err != nil {
+		t.Fatal(err)
+	}
+	mr.Close()
+
+	if err := s.refresh(ctx); err == nil {
+		t.Fatal("refresh() with Redis down returned no error")
+	}
+	if !s.State().ReadOnly.Applies("order-service") {
+		t.Error("state lost after a failed refresh")
+	}
+}
@@ -0,0 +1,477 @@
+package openapi
+
+import (
+	"api_gateway/internal/routes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	maxSpecBytes = 5 * 1024 * 1024
+	fetchTimeout = 10 * time.Second
+
+	userTokenScheme = "X-User-Token"
+	apiKeyScheme    = "X-API-Key"
+	gatewaySource   = "api-gateway"
+)
+
+var operationMethods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true,
+}
+
+// gatewayHeaders là các header do gateway tự set khi proxy, không phải tham số của client
+var gatewayHeaders = map[string]bool{
+	"x-user-id":               true,
+	"x-user-email":            true,
+	"x-user-role":             true,
+	"x-user-token":            true,
+	"x-client-id":             true,
+	"x-internal-jwt":          true,
+	"x-api-gateway":           true,
+	"x-auth-internal-service": true,
+	"authorization":           true,
+}
+
+// Fetcher gọi upstream của route (qua load balancer, circuit breaker và retry của gateway)
+type Fetcher interface {
+	Fetch(ctx context.Context, route *routes.Route, target string, header http.Header) (*http.Response, error)
+}
+
+// Info là phần info của spec tổng hợp
+type Info struct {
+	Title       string
+	Description string
+	Version     string
+}
+
+// Source là trạng thái spec của một route trong lần tổng hợp gần nhất
+type Source struct {
+	Route     string     `json:"route"`
+	Upstream  string     `json:"upstream"`
+	Status    string     `json:"status"` // ok | stale (dùng spec lấy được lần trước) | unavailable
+	Error     string     `json:"error,omitempty"`
+	FetchedAt *time.Time `json:"fetched_at,omitempty"`
+}
+
+// spec là document Swagger 2.0 do swag sinh ra, chỉ giữ các phần được gộp
+type spec struct {
+	BasePath    string                    `json:"basePath"`
+	Paths       map[string]map[string]any `json:"paths"`
+	Definitions map[string]any            `json:"definitions"`
+	Tags        []map[string]any          `json:"tags"`
+}
+
+type cachedSpec struct {
+	spec      *spec
+	fetchedAt time.Time
+}
+
+// Aggregator gộp spec Swagger 2.0 của các upstream (route có khối openapi trong routes.yaml) thành một spec public:
+// path được đổi sang path phía gateway (/api<path_prefix>/...), definitions được đặt namespace theo upstream
+// và security của mỗi operation lấy theo auth của route.
+type Aggregator struct {
+	registry   *routes.Registry
+	fetcher    Fetcher
+	gatewayDoc func() string // spec của chính gateway (swag), có thể nil
+	info       Info
+	ttl        time.Duration
+	building   singleflight.Group
+	mu         sync.Mutex // bảo vệ document, builtAt, table và lastGood
+	document   []byte
+	builtAt    time.Time
+	table      *routes.Table
+	lastGood   map[string]cachedSpec // theo tên route
+}
+
+func NewAggregator(registry *routes.Registry, fetcher Fetcher, gatewayDoc func() string, info Info, ttl time.Duration) *Aggregator {
+	return &Aggregator{
+		registry:   registry,
+		fetcher:    fetcher,
+		gatewayDoc: gatewayDoc,
+		info:       info,
+		ttl:        ttl,
+		lastGood:   map[string]cachedSpec{},
+	}
+}
+
+// Document trả về spec tổng hợp (JSON). Spec được build lại sau ttl hoặc khi bảng route thay đổi;
+// upstream không lấy được spec thì dùng spec lấy được lần trước.
+// Spec của upstream được tải ngoài mutex, các request đồng thời chờ chung một lần build.
+func (a *Aggregator) Document(ctx context.Context) ([]byte, error) {
+	table := a.registry.Table()
+	a.mu.Lock()
+	if a.document != nil && table == a.table && time.Since(a.builtAt) < a.ttl {
+		doc := a.document
+		a.mu.Unlock()
+		return doc, nil
+	}
+	a.mu.Unlock()
+
+	doc, err, _ := a.building.Do("build", func() (any, error) {
+		// Request khởi tạo build có thể bị huỷ trong khi request khác đang chờ kết quả
+		return a.build(context.WithoutCancel(ctx), table)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return doc.([]byte), nil
+}
+
+func (a *Aggregator) build(ctx context.Context, table *routes.Table) ([]byte, error) {
+	var sourceRoutes []*routes.Route
+	for _, r := range table.Routes {
+		if r.OpenAPI != nil {
+			sourceRoutes = append(sourceRoutes, r)
+		}
+	}
+	sort.Slice(sourceRoutes, func(i, j int) bool { return sourceRoutes[i].Name < sourceRoutes[j].Name })
+
+	specs := make([]*spec, len(sourceRoutes))
+	errs := make([]error, len(sourceRoutes))
+	var wg sync.WaitGroup
+	for i, r := range sourceRoutes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			specs[i], errs[i] = a.fetchSpec(ctx, r)
+		}()
+	}
+	wg.Wait()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	doc, err := a.merge(table, sourceRoutes, specs, errs)
+	if err != nil {
+		return nil, err
+	}
+	a.document, a.builtAt, a.table = doc, time.Now(), table
+	return doc, nil
+}
+
+// merge gộp spec của các route thành spec public, gọi khi đang giữ mutex (lastGood)
+func (a *Aggregator) merge(table *routes.Table, sourceRoutes []*routes.Route, specs []*spec, errs []error) ([]byte, error) {
+	sources := make([]Source, len(sourceRoutes))
+	for i, r := range sourceRoutes {
+		specs[i], sources[i] = a.resolve(r, specs[i], errs[i])
+	}
+
+	out := map[string]any{
+		"swagger": "2.0",
+		"info": map[string]any{
+			"title":       a.info.Title,
+			"description": a.info.Description,
+			"version":     a.info.Version,
+		},
+		"basePath": "/",
+		"securityDefinitions": map[string]any{
+			userTokenScheme: map[string]any{
+				"type":        "apiKey",
+				"in":          "header",
+				"name":        "X-User-Token",
+				"description": "Access token của user, không có prefix Bearer",
+			},
+			apiKeyScheme: map[string]any{
+				"type":        "apiKey",
+				"in":          "header",
+				"name":        "X-API-Key",
+				"description": "API key của đối tác: <client_id>.<secret>",
+			},
+		},
+	}
+	paths := map[string]any{}
+	definitions := map[string]any{}
+	tags := map[string]map[string]any{}
+
+	if a.gatewayDoc != nil {
+		if s, err := parseSpec([]byte(a.gatewayDoc())); err != nil {
+			slog.Warn("Failed to parse gateway swagger document", slog.String("error", err.Error()))
+		} else {
+			mergeGateway(s, paths, definitions, tags)
+		}
+	}
+
+	for i, r := range sourceRoutes {
+		if specs[i] == nil {
+			continue
+		}
+		mergeRoute(table, r, specs[i], paths, definitions, tags)
+	}
+
+	tagList := make([]map[string]any, 0, len(tags))
+	for _, t := range tags {
+		tagList = append(tagList, t)
+	}
+	sort.Slice(tagList, func(i, j int) bool {
+		return fmt.Sprint(tagList[i]["name"]) < fmt.Sprint(tagList[j]["name"])
+	})
+
+	out["paths"] = paths
+	out["definitions"] = definitions
+	out["tags"] = tagList
+	out["x-gateway-sources"] = sources
+	return json.Marshal(out)
+}
+
+// resolve ghi nhận spec vừa lấy được của route, lỗi thì dùng spec lấy được lần trước (nếu có)
+func (a *Aggregator) resolve(r *routes.Route, s *spec, err error) (*spec, Source) {
+	source := Source{Route: r.Name, Upstream: r.Upstream, Status: "ok"}
+	if err == nil {
+		now := time.Now()
+		source.FetchedAt = &now
+		a.lastGood[r.Name] = cachedSpec{spec: s, fetchedAt: now}
+		return s, source
+	}
+
+	source.Error = err.Error()
+	slog.Warn("Failed to fetch upstream OpenAPI spec",
+		slog.String("route", r.Name),
+		slog.String("upstream", r.Upstream),
+		slog.String("error", err.Error()),
+	)
+	if cached, ok := a.lastGood[r.Name]; ok {
+		source.Status = "stale"
+		source.FetchedAt = &cached.fetchedAt
+		return cached.spec, source
+	}
+	source.Status = "unavailable"
+	return nil, source
+}
+
+func (a *Aggregator) fetchSpec(ctx context.Context, r *routes.Route) (*spec, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	resp, err := a.fetcher.Fetch(ctx, r, r.OpenAPI.Path, http.Header{"Accept": {"application/json"}})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSpecBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSpecBytes {
+		return nil, fmt.Errorf("spec is larger than %d bytes", maxSpecBytes)
+	}
+	return parseSpec(data)
+}
+
+func parseSpec(data []byte) (*spec, error) {
+	var s spec
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("invalid swagger document: %w", err)
+	}
+	return &s, nil
+}
+
+// mergeRoute thêm các operation của upstream với path phía gateway và security theo auth của route
+func mergeRoute(table *routes.Table, r *routes.Route, s *spec, paths, definitions map[string]any, tags map[string]map[string]any) {
+	namespace := r.Upstream + "."
+	for name, def := range s.Definitions {
+		definitions[namespace+name] = rewriteRefs(def, namespace)
+	}
+	for _, t := range s.Tags {
+		if name, ok := t["name"].(string); ok {
+			tags[name] = t
+		}
+	}
+
+	for specPath, item := range s.Paths {
+		full := joinPath(s.BasePath, specPath)
+		rest := full
+		if r.OpenAPI.StripPrefix != "" {
+			var ok bool
+			if rest, ok = cutPathPrefix(full, r.OpenAPI.StripPrefix); !ok {
+				slog.Debug("OpenAPI path outside strip_prefix skipped", slog.String("route", r.Name), slog.String("path", full))
+				continue
+			}
+		}
+		for _, rw := range r.OpenAPI.Rewrite {
+			if after, ok := cutPathPrefix(rest, rw.From); ok {
+				rest = strings.TrimSuffix(rw.To, "/") + after
+				break
+			}
+		}
+		rest = strings.TrimSuffix(rest, "/")
+
+		// Chỉ giữ path mà gateway thực sự route tới route này (không bị route có prefix dài hơn che)
+		if matched, _, ok := table.Match(r.PathPrefix + rest); !ok || matched != r {
+			slog.Debug("OpenAPI path shadowed by another route skipped", slog.String("route", r.Name), slog.String("path", full))
+			continue
+		}
+		gatewayPath := "/api" + r.PathPrefix + rest
+
+		target, _ := paths[gatewayPath].(map[string]any)
+		if target == nil {
+			target = map[string]any{}
+			paths[gatewayPath] = target
+		}
+		for key, value := range item {
+			value = rewriteRefs(value, namespace)
+			op, isOperation := value.(map[string]any)
+			if !operationMethods[key] || !isOperation {
+				if key == "parameters" {
+					value = withoutGatewayHeaders(value)
+				}
+				target[key] = value
+				continue
+			}
+			applyRoute(r, op)
+			target[key] = op
+		}
+	}
+}
+
+// applyRoute đặt security theo auth của route, bỏ tham số header do gateway set và gắn tag mặc định
+func applyRoute(r *routes.Route, op map[string]any) {
+	upstreamSecurity, _ := op["security"].([]any)
+
+	auth := r.Auth
+	switch {
+	case auth == routes.AuthPublic:
+		op["security"] = []any{}
+	case auth == routes.AuthRequired || len(upstreamSecurity) > 0:
+		// Route optional nhưng service yêu cầu đăng nhập cho operation này
+		op["security"] = []any{
+			map[string]any{userTokenScheme: []any{}},
+			map[string]any{apiKeyScheme: []any{}},
+		}
+	default:
+		op["security"] = []any{
+			map[string]any{},
+			map[string]any{userTokenScheme: []any{}},
+			map[string]any{apiKeyScheme: []any{}},
+		}
+	}
+	op["x-gateway-route"] = r.Name
+	op["x-gateway-auth"] = string(auth)
+	if len(r.Roles) > 0 {
+		op["x-gateway-roles"] = r.Roles
+	}
+
+	if params, ok := op["parameters"]; ok {
+		op["parameters"] = withoutGatewayHeaders(params)
+	}
+	if tags, ok := op["tags"].([]any); !ok || len(tags) == 0 {
+		op["tags"] = []any{r.Name}
+	}
+	if id, ok := op["operationId"].(string); ok && id != "" {
+		op["operationId"] = r.Name + "_" + id
+	}
+}
+
+// mergeGateway thêm các endpoint của chính gateway (trừ endpoint admin) với basePath của spec gateway
+func mergeGateway(s *spec, paths, definitions map[string]any, tags map[string]map[string]any) {
+	namespace := gatewaySource + "."
+	for name, def := range s.Definitions {
+		definitions[namespace+name] = rewriteRefs(def, namespace)
+	}
+	for _, t := range s.Tags {
+		if name, ok := t["name"].(string); ok && name != "Admin" {
+			tags[name] = t
+		}
+	}
+
+	for specPath, item := range s.Paths {
+		gatewayPath := joinPath(s.BasePath, specPath)
+		target := map[string]any{}
+		for key, value := range item {
+			op, isOperation := value.(map[string]any)
+			if operationMethods[key] && isOperation && hasTag(op, "Admin") {
+				continue
+			}
+			target[key] = rewriteRefs(value, namespace)
+		}
+		if len(target) > 0 {
+			paths[gatewayPath] = target
+		}
+	}
+}
+
+// rewriteRefs đổi "#/definitions/X" thành "#/definitions/<namespace>X" trong toàn bộ value
+func rewriteRefs(value any, namespace string) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, child := range v {
+			if ref, ok := child.(string); ok && key == "$ref" {
+				if name, ok := strings.CutPrefix(ref, "#/definitions/"); ok {
+					child = "#/definitions/" + namespace + name
+				}
+			} else {
+				child = rewriteRefs(child, namespace)
+			}
+			out[key] = child
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, child := range v {
+			out[i] = rewriteRefs(child, namespace)
+		}
+		return out
+	}
+	return value
+}
+
+func withoutGatewayHeaders(value any) any {
+	params, ok := value.([]any)
+	if !ok {
+		return value
+	}
+	out := make([]any, 0, len(params))
+	for _, p := range params {
+		if param, ok := p.(map[string]any); ok && param["in"] == "header" {
+			if name, _ := param["name"].(string); gatewayHeaders[strings.ToLower(name)] {
+				continue
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func hasTag(op map[string]any, tag string) bool {
+	tags, _ := op["tags"].([]any)
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// cutPathPrefix bỏ prefix khỏi p nếu prefix khớp trọn các đoạn đầu của p (/orders không khớp /orders-x)
+func cutPathPrefix(p, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(p, prefix)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return "", false
+	}
+	return rest, true
+}
+
+// joinPath nối basePath và path của spec, luôn bắt đầu bằng /
+func joinPath(basePath, p string) string {
+	joined := path.Join("/", basePath, p)
+	if strings.HasSuffix(p, "/") && joined != "/" {
+		joined += "/"
+	}
+	return joined
+}
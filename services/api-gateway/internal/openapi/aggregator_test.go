@@ -0,0 +1,103 @@
+package openapi
+
+import (
+	"api_gateway/internal/routes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// orderServiceSpec là trích đoạn swag của order-service: basePath /api và @Router /orders/...,
+// trong khi route thật của service là order/... (cmd/main.go)
+const orderServiceSpec = `{
+	"swagger": "2.0",
+	"basePath": "/api",
+	"paths": {
+		"/orders": {"get": {"operationId": "getUserOrders"}, "post": {"operationId": "createOrder"}},
+		"/orders/{id}": {"get": {"operationId": "getOrderByID"}},
+		"/orders/{id}/pay": {"post": {"operationId": "payOrder"}},
+		"/orders/product/{id}/messages": {"get": {"operationId": "getMessages"}},
+		"/watchlist/{product_id}": {"delete": {"operationId": "removeFromWatchList"}},
+		"/admin/orders": {"get": {"operationId": "getAllOrders"}}
+	}
+}`
+
+// specFetcher trả spec theo upstream của route, upstream khác không truy cập được
+type specFetcher map[string]string
+
+func (f specFetcher) Fetch(ctx context.Context, route *routes.Route, target string, header http.Header) (*http.Response, error) {
+	body, ok := f[route.Upstream]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+// TestOrderServicePaths kiểm tra path của order-service trong spec tổng hợp với routes.yaml thật:
+// mỗi path phải là path mà gateway proxy tới đúng route của order-service
+func TestOrderServicePaths(t *testing.T) {
+	registry, err := routes.NewRegistry("../../routes.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregator := NewAggregator(registry, specFetcher{"order-service": orderServiceSpec}, nil, Info{Title: "test"}, time.Minute)
+
+	data, err := aggregator.Document(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths map[string]map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"/api/orders/data/order":                  "get",
+		"/api/orders/data/order/{id}":             "get",
+		"/api/orders/data/order/{id}/pay":         "post",
+		"/api/orders/data/order/{id}/messages":    "get",
+		"/api/orders/data/watchlist/{product_id}": "delete",
+		"/api/orders/data/admin/orders":           "get",
+	}
+	for path, method := range want {
+		item, ok := doc.Paths[path]
+		if !ok {
+			t.Errorf("missing path %s", path)
+			continue
+		}
+		if _, ok := item[method]; !ok {
+			t.Errorf("%s: missing %s operation", path, method)
+		}
+	}
+	for path := range doc.Paths {
+		if strings.HasPrefix(path, "/api/orders/data/orders") {
+			t.Errorf("path %s does not exist on order-service", path)
+		}
+	}
+}
+
+func TestCutPathPrefix(t *testing.T) {
+	tests := []struct {
+		path, prefix string
+		want         string
+		wantOK       bool
+	}{
+		{"/orders/{id}", "/orders", "/{id}", true},
+		{"/orders", "/orders", "", true},
+		{"/orders-archive", "/orders", "", false},
+		{"/admin/orders", "/orders", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := cutPathPrefix(tt.path, tt.prefix)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("cutPathPrefix(%q, %q) = %q, %v, want %q, %v", tt.path, tt.prefix, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
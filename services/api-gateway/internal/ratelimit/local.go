@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// LocalLimiter là rate limiter trong bộ nhớ của process, dùng khi Redis không truy cập được.
+// Dùng sliding window counter (ước lượng từ window hiện tại và window trước) nên chỉ tốn O(1) bộ nhớ mỗi key.
+// Limit được áp dụng riêng cho từng instance gateway.
+type LocalLimiter struct {
+	mu        sync.Mutex
+	windows   map[string]*localWindow
+	lastSweep time.Time
+}
+
+type localWindow struct {
+	index    int64 // số thứ tự window hiện tại (now / window)
+	current  int
+	previous int
+	window   time.Duration
+}
+
+// Result là kết quả kiểm tra một policy
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAt    time.Time
+	RetryAfter time.Duration
+}
+
+func NewLocalLimiter() *LocalLimiter {
+	return &LocalLimiter{
+		windows:   map[string]*localWindow{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow kiểm tra và ghi nhận một request cho key. maxRequests là limit + burst.
+func (l *LocalLimiter) Allow(key string, limit, maxRequests int, window time.Duration) Result {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+	}
+
+	index := now.UnixNano() / int64(window)
+	w, ok := l.windows[key]
+	if !ok {
+		w = &localWindow{index: index, window: window}
+		l.windows[key] = w
+	}
+	switch {
+	case w.index == index:
+	case w.index == index-1:
+		w.previous, w.current, w.index = w.current, 0, index
+	default:
+		w.previous, w.current, w.index = 0, 0, index
+	}
+
+	windowStart := time.Unix(0, index*int64(window))
+	elapsed := now.Sub(windowStart)
+	weight := 1 - float64(elapsed)/float64(window)
+	estimated := int(float64(w.previous)*weight) + w.current
+
+	result := Result{
+		Limit:   limit,
+		ResetAt: windowStart.Add(window),
+	}
+	if estimated >= maxRequests {
+		result.RetryAfter = window - elapsed
+		return result
+	}
+
+	w.current++
+	result.Allowed = true
+	result.Remaining = max(limit-estimated-1, 0)
+	return result
+}
+
+// sweep xoá key không còn request trong hai window gần nhất. Caller phải giữ lock.
+func (l *LocalLimiter) sweep(now time.Time) {
+	for key, w := range l.windows {
+		if now.UnixNano()/int64(w.window)-w.index > 1 {
+			delete(l.windows, key)
+		}
+	}
+	l.lastSweep = now
+}
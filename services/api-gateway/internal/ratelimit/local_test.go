@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalLimiterAllow(t *testing.T) {
+	l := NewLocalLimiter()
+
+	// limit 3 + burst 1
+	wantRemaining := []int{2, 1, 0, 0}
+	for i, want := range wantRemaining {
+		r := l.Allow("ip:10.0.0.1", 3, 4, time.Hour)
+		if !r.Allowed || r.Remaining != want || r.Limit != 3 {
+			t.Errorf("request %d = %+v, want allowed with %d remaining", i+1, r, want)
+		}
+	}
+
+	r := l.Allow("ip:10.0.0.1", 3, 4, time.Hour)
+	if r.Allowed {
+		t.Fatal("request over limit + burst allowed")
+	}
+	if r.RetryAfter <= 0 || r.RetryAfter > time.Hour || !r.ResetAt.After(time.Now()) {
+		t.Errorf("rejected result = %+v, want RetryAfter and ResetAt within the window", r)
+	}
+
+	// Mỗi key có bộ đếm riêng
+	if r := l.Allow("ip:10.0.0.2", 3, 4, time.Hour); !r.Allowed {
+		t.Error("another key was limited")
+	}
+}
+
+func TestLocalLimiterWindowRollover(t *testing.T) {
+	l := NewLocalLimiter()
+	for i := 0; i < 2; i++ {
+		l.Allow("user:42", 2, 2, time.Hour)
+	}
+	if r := l.Allow("user:42", 2, 2, time.Hour); r.Allowed {
+		t.Fatal("request over limit allowed")
+	}
+
+	// Hai window đã trôi qua: request cũ không còn được tính
+	l.windows["user:42"].index -= 2
+	if r := l.Allow("user:42", 2, 2, time.Hour); !r.Allowed || r.Remaining != 1 {
+		t.Errorf("request after two windows = %+v, want allowed with 1 remaining", r)
+	}
+}
+
+func TestLocalLimiterSweep(t *testing.T) {
+	l := NewLocalLimiter()
+	l.Allow("ip:10.0.0.1", 1, 1, time.Hour)
+	l.Allow("ip:10.0.0.2", 1, 1, time.Hour)
+
+	l.windows["ip:10.0.0.1"].index -= 2
+	l.lastSweep = time.Now().Add(-2 * time.Minute)
+	l.Allow("ip:10.0.0.3", 1, 1, time.Hour)
+
+	if _, ok := l.windows["ip:10.0.0.1"]; ok {
+		t.Error("idle key not swept")
+	}
+	if _, ok := l.windows["ip:10.0.0.2"]; !ok {
+		t.Error("active key swept")
+	}
+}
@@ -0,0 +1,234 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Dimension là một thành phần của rate-limit key
+type Dimension string
+
+const (
+	DimIP     Dimension = "ip"
+	DimUser   Dimension = "user"    // userID, request chưa đăng nhập dùng IP
+	DimRoute  Dimension = "route"   // tên route trong routes.yaml (giới hạn chung cho cả nhóm route)
+	DimAPIKey Dimension = "api_key" // header X-API-Key, request không có key dùng IP
+)
+
+// RoleAnonymous là key trong role_limits áp dụng cho request chưa đăng nhập
+const RoleAnonymous = "anonymous"
+
+// Unlimited trong role_limits nghĩa là role đó không bị giới hạn bởi policy
+const Unlimited = -1
+
+// Policy mô tả một giới hạn request. Một request bị kiểm tra bởi mọi policy khớp với nó.
+type Policy struct {
+	Name string      `yaml:"name" json:"name"`
+	Key  []Dimension `yaml:"key" json:"key"`
+	// Điều kiện áp dụng, để trống là khớp mọi request
+	Routes     []string `yaml:"routes" json:"routes,omitempty"`
+	Methods    []string `yaml:"methods" json:"methods,omitempty"`
+	PathPrefix string   `yaml:"path_prefix" json:"path_prefix,omitempty"`
+	// Số request trong window, cộng thêm burst
+	Limit  int    `yaml:"limit" json:"limit"`
+	Burst  int    `yaml:"burst" json:"burst"`
+	Window string `yaml:"window" json:"window"`
+	// Limit riêng theo role (ROLE_BIDDER, anonymous...), -1 = không giới hạn
+	RoleLimits map[string]int `yaml:"role_limits" json:"role_limits,omitempty"`
+
+	window time.Duration
+}
+
+// Request là thông tin của request dùng để chọn policy và build key
+type Request struct {
+	IP     string
+	UserID string
+	Role   string
+	Route  string
+	APIKey string
+	Method string
+	Path   string
+}
+
+// WindowDuration trả về window đã parse của policy
+func (p *Policy) WindowDuration() time.Duration {
+	return p.window
+}
+
+// AfterAuth cho biết policy cần thông tin user/route, chỉ được kiểm tra sau RouteMiddleware và AuthMiddleware
+func (p *Policy) AfterAuth() bool {
+	if len(p.Routes) > 0 || len(p.RoleLimits) > 0 {
+		return true
+	}
+	for _, d := range p.Key {
+		if d == DimUser || d == DimRoute {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches kiểm tra policy có áp dụng cho request không
+func (p *Policy) Matches(r Request) bool {
+	if len(p.Routes) > 0 && !contains(p.Routes, r.Route) {
+		return false
+	}
+	if len(p.Methods) > 0 && !contains(p.Methods, r.Method) {
+		return false
+	}
+	if p.PathPrefix != "" && r.Path != p.PathPrefix && !strings.HasPrefix(r.Path, p.PathPrefix+"/") {
+		return false
+	}
+	return true
+}
+
+// LimitFor trả về limit (chưa cộng burst) cho role, unlimited = true nếu role không bị giới hạn
+func (p *Policy) LimitFor(role string) (limit int, unlimited bool) {
+	if role == "" {
+		role = RoleAnonymous
+	}
+	limit = p.Limit
+	if l, ok := p.RoleLimits[role]; ok {
+		limit = l
+	}
+	return limit, limit < 0
+}
+
+// KeyFor build rate-limit key của request theo các dimension của policy, ví dụ "user:42|route:bids"
+func (p *Policy) KeyFor(r Request) string {
+	parts := make([]string, 0, len(p.Key))
+	for _, d := range p.Key {
+		switch d {
+		case DimIP:
+			parts = append(parts, "ip:"+r.IP)
+		case DimUser:
+			if r.UserID != "" {
+				parts = append(parts, "user:"+r.UserID)
+			} else {
+				parts = append(parts, "ip:"+r.IP)
+			}
+		case DimRoute:
+			parts = append(parts, "route:"+r.Route)
+		case DimAPIKey:
+			if r.APIKey != "" {
+				parts = append(parts, "api_key:"+r.APIKey)
+			} else {
+				parts = append(parts, "ip:"+r.IP)
+			}
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+type fileFormat struct {
+	Policies []*Policy `yaml:"policies" json:"policies"`
+}
+
+// Load đọc file policy (YAML hoặc JSON) và validate
+func Load(path string) ([]*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limit policies file: %w", err)
+	}
+	return Parse(path, data)
+}
+
+// Parse parse nội dung file policy, định dạng dựa vào extension của path
+func Parse(path string, data []byte) ([]*Policy, error) {
+	var file fileFormat
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse rate limit policies file: %w", err)
+		}
+	default:
+		if err := yaml.UnmarshalStrict(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse rate limit policies file: %w", err)
+		}
+	}
+
+	if err := Validate(file.Policies); err != nil {
+		return nil, err
+	}
+	return file.Policies, nil
+}
+
+// Validate kiểm tra và chuẩn hoá danh sách policy
+func Validate(policies []*Policy) error {
+	if len(policies) == 0 {
+		return errors.New("rate limit policies file contains no policies")
+	}
+
+	var errs []error
+	names := map[string]bool{}
+
+	for i, p := range policies {
+		where := fmt.Sprintf("policy[%d] %q", i, p.Name)
+
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", where))
+		} else if names[p.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate name", where))
+		}
+		names[p.Name] = true
+
+		if len(p.Key) == 0 {
+			errs = append(errs, fmt.Errorf("%s: key is required", where))
+		}
+		for _, d := range p.Key {
+			switch d {
+			case DimIP, DimUser, DimRoute, DimAPIKey:
+			default:
+				errs = append(errs, fmt.Errorf("%s: unknown key dimension %q", where, d))
+			}
+		}
+
+		for j, m := range p.Methods {
+			p.Methods[j] = strings.ToUpper(m)
+		}
+		if p.PathPrefix != "" {
+			p.PathPrefix = strings.TrimSuffix(p.PathPrefix, "/")
+			if !strings.HasPrefix(p.PathPrefix, "/") {
+				errs = append(errs, fmt.Errorf("%s: path_prefix must start with /", where))
+			}
+		}
+
+		if p.Limit <= 0 {
+			errs = append(errs, fmt.Errorf("%s: limit must be positive", where))
+		}
+		if p.Burst < 0 {
+			errs = append(errs, fmt.Errorf("%s: burst must not be negative", where))
+		}
+		for role, l := range p.RoleLimits {
+			if l < Unlimited || l == 0 {
+				errs = append(errs, fmt.Errorf("%s: role_limits[%s] must be positive or -1", where, role))
+			}
+		}
+
+		d, err := time.ParseDuration(p.Window)
+		if err != nil || d < time.Second {
+			errs = append(errs, fmt.Errorf("%s: invalid window %q (min 1s)", where, p.Window))
+		} else {
+			p.window = d
+			p.Window = d.String()
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"testing"
+)
+
+const testPolicies = `
+policies:
+  - name: global-ip
+    key: [ip]
+    limit: 100
+    window: 1m
+  - name: bids
+    key: [user, route]
+    routes: [bids]
+    methods: [post]
+    limit: 10
+    burst: 5
+    window: 10s
+    role_limits:
+      anonymous: 2
+      ROLE_ADMIN: -1
+  - name: partner-search
+    key: [api_key]
+    path_prefix: /search/
+    limit: 50
+    window: 1m
+`
+
+func parsePolicies(t *testing.T) map[string]*Policy {
+	t.Helper()
+	policies, err := Parse("rate_limits.yaml", []byte(testPolicies))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := map[string]*Policy{}
+	for _, p := range policies {
+		m[p.Name] = p
+	}
+	return m
+}
+
+func TestPolicyMatches(t *testing.T) {
+	policies := parsePolicies(t)
+
+	tests := []struct {
+		name   string
+		policy string
+		req    Request
+		want   bool
+	}{
+		{"no condition", "global-ip", Request{Route: "products", Method: "GET", Path: "/products"}, true},
+		{"route and method", "bids", Request{Route: "bids", Method: "POST", Path: "/bids"}, true},
+		{"other method", "bids", Request{Route: "bids", Method: "GET", Path: "/bids"}, false},
+		{"other route", "bids", Request{Route: "orders", Method: "POST", Path: "/orders"}, false},
+		{"exact path prefix", "partner-search", Request{Path: "/search"}, true},
+		{"nested path", "partner-search", Request{Path: "/search/products"}, true},
+		{"path prefix lookalike", "partner-search", Request{Path: "/searchable"}, false},
+	}
+	for _, tt := range tests {
+		if got := policies[tt.policy].Matches(tt.req); got != tt.want {
+			t.Errorf("%s: Matches(%+v) = %v, want %v", tt.name, tt.req, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyLimitFor(t *testing.T) {
+	p := parsePolicies(t)["bids"]
+
+	tests := []struct {
+		role          string
+		wantLimit     int
+		wantUnlimited bool
+	}{
+		{"ROLE_BIDDER", 10, false},
+		{"", 2, false},
+		{RoleAnonymous, 2, false},
+		{"ROLE_ADMIN", Unlimited, true},
+	}
+	for _, tt := range tests {
+		limit, unlimited := p.LimitFor(tt.role)
+		if limit != tt.wantLimit || unlimited != tt.wantUnlimited {
+			t.Errorf("LimitFor(%q) = %d, %v, want %d, %v", tt.role, limit, unlimited, tt.wantLimit, tt.wantUnlimited)
+		}
+	}
+}
+
+func TestPolicyKeyFor(t *testing.T) {
+	policies := parsePolicies(t)
+
+	tests := []struct {
+		name   string
+		policy string
+		req    Request
+		want   string
+	}{
+		{"ip", "global-ip", Request{IP: "10.0.0.1", UserID: "42"}, "ip:10.0.0.1"},
+		{"user and route", "bids", Request{IP: "10.0.0.1", UserID: "42", Route: "bids"}, "user:42|route:bids"},
+		{"anonymous user falls back to IP", "bids", Request{IP: "10.0.0.1", Route: "bids"}, "ip:10.0.0.1|route:bids"},
+		{"api key", "partner-search", Request{IP: "10.0.0.1", APIKey: "ak_1"}, "api_key:ak_1"},
+		{"missing api key falls back to IP", "partner-search", Request{IP: "10.0.0.1"}, "ip:10.0.0.1"},
+	}
+	for _, tt := range tests {
+		if got := policies[tt.policy].KeyFor(tt.req); got != tt.want {
+			t.Errorf("%s: KeyFor() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyAfterAuth(t *testing.T) {
+	policies := parsePolicies(t)
+	want := map[string]bool{"global-ip": false, "bids": true, "partner-search": false}
+	for name, w := range want {
+		if got := policies[name].AfterAuth(); got != w {
+			t.Errorf("%s: AfterAuth() = %v, want %v", name, got, w)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"valid", testPolicies, false},
+		{"no policies", `policies: []`, true},
+		{"missing name", `policies: [{key: [ip], limit: 1, window: 1s}]`, true},
+		{"duplicate name", `policies: [{name: a, key: [ip], limit: 1, window: 1s}, {name: a, key: [ip], limit: 1, window: 1s}]`, true},
+		{"missing key", `policies: [{name: a, limit: 1, window: 1s}]`, true},
+		{"unknown dimension", `policies: [{name: a, key: [session], limit: 1, window: 1s}]`, true},
+		{"zero limit", `policies: [{name: a, key: [ip], limit: 0, window: 1s}]`, true},
+		{"negative burst", `policies: [{name: a, key: [ip], limit: 1, burst: -1, window: 1s}]`, true},
+		{"zero role limit", `policies: [{name: a, key: [ip], limit: 1, window: 1s, role_limits: {ROLE_BIDDER: 0}}]`, true},
+		{"role limit below -1", `policies: [{name: a, key: [ip], limit: 1, window: 1s, role_limits: {ROLE_BIDDER: -2}}]`, true},
+		{"window below 1s", `policies: [{name: a, key: [ip], limit: 1, window: 500ms}]`, true},
+		{"relative path prefix", `policies: [{name: a, key: [ip], limit: 1, window: 1s, path_prefix: search}]`, true},
+	}
+	for _, tt := range tests {
+		_, err := Parse("rate_limits.yaml", []byte(tt.data))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Parse() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
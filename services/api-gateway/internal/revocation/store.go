@@ -0,0 +1,128 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	tokenKeyPrefix = "revoked:jti:"
+	userKeyPrefix  = "revoked:user:"
+)
+
+// Lý do token bị từ chối
+const (
+	ReasonTokenRevoked = "TOKEN_REVOKED"
+	ReasonUserRevoked  = "USER_TOKENS_REVOKED"
+)
+
+var ErrInvalidRevocation = errors.New("invalid revocation request")
+
+// raiseWatermarkScript chỉ cho phép watermark tăng, tránh một revocation cũ ghi đè revocation mới hơn
+var raiseWatermarkScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local incoming = tonumber(ARGV[1])
+if incoming > current then
+	redis.call("SET", KEYS[1], ARGV[1], "EX", ARGV[2])
+	return incoming
+end
+redis.call("EXPIRE", KEYS[1], ARGV[2])
+return current
+`)
+
+// Store lưu danh sách token bị thu hồi trong Redis:
+//   - revoked:jti:<jti>   : denylist theo từng token, TTL = thời gian sống còn lại của token
+//   - revoked:user:<id>   : watermark (unix giây, làm tròn xuống), mọi token của user có iat < watermark đều bị từ chối.
+//     iat của JWT chỉ chính xác tới giây: token cấp trong cùng giây với watermark vẫn hợp lệ, để user đăng nhập lại
+//     ngay sau logout-all hay đổi mật khẩu không bị từ chối tới khi token hết hạn.
+type Store struct {
+	client       *redis.Client
+	watermarkTTL time.Duration
+}
+
+// NewStore tạo revocation store. watermarkTTL phải >= thời gian sống tối đa của access token
+func NewStore(client *redis.Client, watermarkTTL time.Duration) *Store {
+	return &Store{
+		client:       client,
+		watermarkTTL: watermarkTTL,
+	}
+}
+
+// RevokeToken thêm jti vào denylist cho tới khi token hết hạn
+func (s *Store) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	if jti == "" {
+		return fmt.Errorf("%w: missing jti", ErrInvalidRevocation)
+	}
+
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		// Token đã hết hạn, không cần lưu
+		return nil
+	}
+
+	return s.client.Set(ctx, tokenKeyPrefix+jti, 1, ttl).Err()
+}
+
+// RevokeUserTokensBefore thu hồi mọi token của user được cấp trước giây chứa thời điểm before
+func (s *Store) RevokeUserTokensBefore(ctx context.Context, userID string, before time.Time) error {
+	if userID == "" {
+		return fmt.Errorf("%w: missing user_id", ErrInvalidRevocation)
+	}
+
+	return raiseWatermarkScript.Run(ctx, s.client,
+		[]string{userKeyPrefix + userID},
+		before.Unix(),
+		int64(s.watermarkTTL/time.Second),
+	).Err()
+}
+
+// IsRevoked kiểm tra token theo jti và watermark của user.
+// Trả về reason (ReasonTokenRevoked / ReasonUserRevoked) nếu token bị thu hồi.
+func (s *Store) IsRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, string, error) {
+	keys := make([]string, 0, 2)
+	if jti != "" {
+		keys = append(keys, tokenKeyPrefix+jti)
+	}
+	if userID != "" {
+		keys = append(keys, userKeyPrefix+userID)
+	}
+	if len(keys) == 0 {
+		return false, "", nil
+	}
+
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return false, "", err
+	}
+
+	idx := 0
+	if jti != "" {
+		if values[idx] != nil {
+			return true, ReasonTokenRevoked, nil
+		}
+		idx++
+	}
+
+	if userID != "" && values[idx] != nil {
+		raw, _ := values[idx].(string)
+		watermark, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return false, "", fmt.Errorf("invalid watermark for user %s: %w", userID, err)
+		}
+		if issuedBeforeWatermark(issuedAt, watermark) {
+			return true, ReasonUserRevoked, nil
+		}
+	}
+
+	return false, "", nil
+}
+
+// issuedBeforeWatermark so sánh iat (giây) với watermark (giây) của user
+func issuedBeforeWatermark(issuedAt time.Time, watermark int64) bool {
+	return !issuedAt.IsZero() && issuedAt.Unix() < watermark
+}
@@ -0,0 +1,34 @@
+package revocation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIssuedBeforeWatermark(t *testing.T) {
+	// logout-all lúc 10:00:00.300, watermark ghi theo RevokeUserTokensBefore
+	logoutAt := time.Date(2025, 1, 1, 10, 0, 0, 300_000_000, time.UTC)
+	watermark := logoutAt.Unix()
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		want     bool
+	}{
+		{"token from an earlier session", logoutAt.Add(-time.Hour), true},
+		{"token issued the second before logout", logoutAt.Add(-time.Second), true},
+		{"re-login right after logout in the same second", logoutAt.Add(500 * time.Millisecond), false},
+		{"re-login in a later second", logoutAt.Add(2 * time.Second), false},
+		{"token without iat", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		// iat của JWT chỉ chính xác tới giây
+		issuedAt := tt.issuedAt
+		if !issuedAt.IsZero() {
+			issuedAt = time.Unix(issuedAt.Unix(), 0)
+		}
+		if got := issuedBeforeWatermark(issuedAt, watermark); got != tt.want {
+			t.Errorf("%s: issuedBeforeWatermark() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Registry giữ bảng route đang active và hot-reload khi file thay đổi
+type Registry struct {
+	path    string
+	current atomic.Pointer[Table]
+	raw     []byte
+
+	listeners []func(*Table)
+}
+
+// NewRegistry load và validate file route lần đầu. Lỗi ở bước này khiến gateway không start.
+func NewRegistry(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table, err := Parse(path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Registry{path: path, raw: data}
+	r.current.Store(table)
+
+	slog.Info("Route table loaded", "source", path, "routes", len(table.Routes))
+	return r, nil
+}
+
+// Table trả về bảng route hiện tại
+func (r *Registry) Table() *Table {
+	return r.current.Load()
+}
+
+// OnReload đăng ký hàm được gọi sau mỗi lần bảng route được reload thành công.
+// Phải gọi trước Watch.
+func (r *Registry) OnReload(fn func(*Table)) {
+	r.listeners = append(r.listeners, fn)
+}
+
+// Match tìm route cho path trong bảng route hiện tại
+func (r *Registry) Match(path string) (*Route, string, bool) {
+	return r.Table().Match(path)
+}
+
+// Watch kiểm tra file route định kỳ và reload khi nội dung thay đổi.
+// File lỗi sẽ bị bỏ qua, gateway tiếp tục dùng bảng route cũ.
+func (r *Registry) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.reload()
+			}
+		}
+	}()
+}
+
+func (r *Registry) reload() {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		slog.Error("Failed to read routes file", "source", r.path, "error", err)
+		return
+	}
+	if bytes.Equal(data, r.raw) {
+		return
+	}
+
+	table, err := Parse(r.path, data)
+	if err != nil {
+		slog.Error("Invalid routes file, keeping previous route table", "source", r.path, "error", err)
+		r.raw = data
+		return
+	}
+
+	r.raw = data
+	r.current.Store(table)
+	slog.Info("Route table reloaded", "source", r.path, "routes", len(table.Routes))
+
+	for _, fn := range r.listeners {
+		fn(table)
+	}
+}
@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRoutes(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	writeRoutes(t, path, testRoutes)
+
+	r, err := NewRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reloaded []*Table
+	r.OnReload(func(table *Table) { reloaded = append(reloaded, table) })
+	initial := r.Table()
+
+	// File không đổi: không reload
+	r.reload()
+	if r.Table() != initial || len(reloaded) != 0 {
+		t.Fatal("unchanged file reloaded")
+	}
+
+	// File lỗi: giữ bảng route cũ
+	writeRoutes(t, path, `routes: [{name: orders, path_prefix: /orders}]`)
+	r.reload()
+	if r.Table() != initial || len(reloaded) != 0 {
+		t.Fatal("invalid file replaced the route table")
+	}
+	if _, _, ok := r.Match("/products/42"); !ok {
+		t.Error("previous routes no longer matched after an invalid reload")
+	}
+
+	writeRoutes(t, path, testRoutes+`
+  - name: orders
+    path_prefix: /orders
+    upstream: order-service
+    urls: ["http://order-service:8084"]
+`)
+	r.reload()
+	if len(reloaded) != 1 || reloaded[0] != r.Table() {
+		t.Fatalf("listeners called %d times, want once with the new table", len(reloaded))
+	}
+	if route, rest, ok := r.Match("/orders/7"); !ok || route.Name != "orders" || rest != "7" {
+		t.Errorf("Match(/orders/7) = %v %q %v, want the reloaded route", route, rest, ok)
+	}
+}
+
+func TestNewRegistryRejectsInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	writeRoutes(t, path, `routes: []`)
+	if _, err := NewRegistry(path); err == nil {
+		t.Error("NewRegistry() with an invalid file returned no error")
+	}
+	if _, err := NewRegistry(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("NewRegistry() with a missing file returned no error")
+	}
+}
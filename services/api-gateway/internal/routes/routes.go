@@ -0,0 +1,617 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// AuthMode quy định route có yêu cầu user đăng nhập hay không
+type AuthMode string
+
+const (
+	AuthPublic   AuthMode = "public"   // không verify X-User-Token
+	AuthOptional AuthMode = "optional" // verify token nếu có
+	AuthRequired AuthMode = "required" // bắt buộc token hợp lệ
+)
+
+// RouteType phân biệt route HTTP thường và WebSocket
+type RouteType string
+
+const (
+	TypeHTTP      RouteType = "http"
+	TypeWebSocket RouteType = "websocket"
+)
+
+// LoadBalancer là chiến lược chọn endpoint khi upstream có nhiều URL
+type LoadBalancer string
+
+const (
+	RoundRobin       LoadBalancer = "round_robin"
+	LeastConnections LoadBalancer = "least_connections"
+	// ConsistentHash chọn endpoint theo userID (hoặc IP nếu chưa đăng nhập),
+	// giữ affinity cho WebSocket/chat của cùng một user
+	ConsistentHash LoadBalancer = "consistent_hash"
+)
+
+// HealthCheck cấu hình active health check cho các endpoint của route
+type HealthCheck struct {
+	// Path được gọi trên host của từng endpoint, ví dụ /health
+	Path string `yaml:"path" json:"path"`
+}
+
+// Cache cấu hình cache response của gateway cho request GET/HEAD của route.
+// Response được cache theo query, role, Accept-Language và user nếu request có token.
+type Cache struct {
+	TTL string `yaml:"ttl" json:"ttl"`
+	// Sau TTL, response cũ vẫn được trả trong khoảng này trong khi gateway lấy bản mới ở background
+	StaleWhileRevalidate string `yaml:"stale_while_revalidate" json:"stale_while_revalidate,omitempty"`
+	// Exclude là các path (tính từ path_prefix) không được cache, ví dụ dữ liệu riêng của user
+	Exclude []string `yaml:"exclude" json:"exclude,omitempty"`
+
+	ttl   time.Duration
+	stale time.Duration
+}
+
+// TTLDuration trả về TTL đã parse
+func (c *Cache) TTLDuration() time.Duration {
+	return c.ttl
+}
+
+// StaleDuration trả về stale_while_revalidate đã parse
+func (c *Cache) StaleDuration() time.Duration {
+	return c.stale
+}
+
+// Excludes kiểm tra path còn lại sau prefix của route (routePath) có bị loại khỏi cache không
+func (c *Cache) Excludes(rest string) bool {
+	path := "/" + rest
+	for _, e := range c.Exclude {
+		if path == e || strings.HasPrefix(path, e+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenAPI cấu hình lấy spec Swagger 2.0 (swag) của upstream để gộp vào /openapi.json của gateway
+type OpenAPI struct {
+	// Path của spec, được nối vào url của route như request proxy, ví dụ /swagger/doc.json
+	Path string `yaml:"path" json:"path"`
+	// StripPrefix được bỏ khỏi path trong spec (basePath + path) trước khi thêm /api<path_prefix>,
+	// dùng khi basePath hoặc @Router của service không khớp với path thật
+	StripPrefix string `yaml:"strip_prefix" json:"strip_prefix,omitempty"`
+	// Rewrite đổi prefix của path (sau strip_prefix) khi @Router của service khác route thật,
+	// rule đầu tiên khớp được áp dụng, prefix khớp theo từng đoạn path
+	Rewrite []PathRewrite `yaml:"rewrite" json:"rewrite,omitempty"`
+}
+
+// PathRewrite đổi prefix From của path thành To, ví dụ /orders thành /order
+type PathRewrite struct {
+	From string `yaml:"from" json:"from"`
+	To   string `yaml:"to" json:"to"`
+}
+
+// Limits giới hạn kích thước request của route, ví dụ 512KB, 50MB. Để trống thì dùng giới hạn chung của gateway.
+type Limits struct {
+	MaxBodySize   string `yaml:"max_body_size" json:"max_body_size,omitempty"`
+	MaxHeaderSize string `yaml:"max_header_size" json:"max_header_size,omitempty"`
+
+	maxBody   int64
+	maxHeader int64
+}
+
+// StableTarget là tên của target mặc định (urls của route), dùng khi chọn target bằng header
+const StableTarget = "stable"
+
+// Target là một phiên bản khác của upstream (canary) nhận một phần traffic của route theo trọng số.
+// Phần traffic còn lại đi tới urls của route.
+type Target struct {
+	Name string `yaml:"name" json:"name"`
+	// Upstream là tên dùng cho circuit breaker và metrics, mặc định <upstream>-<name>
+	Upstream string   `yaml:"upstream" json:"upstream"`
+	URLs     []string `yaml:"urls" json:"urls"`
+	// Weight là phần trăm request (0-100) được chuyển tới target
+	Weight float64 `yaml:"weight" json:"weight"`
+}
+
+// Shadow cấu hình mirror request đọc (GET/HEAD) tới upstream đang thử nghiệm.
+// Response của shadow bị bỏ đi, gateway chỉ log khác biệt về status và latency so với response thật.
+type Shadow struct {
+	// Upstream là tên dùng cho metrics, mặc định <upstream>-shadow
+	Upstream string   `yaml:"upstream" json:"upstream"`
+	URLs     []string `yaml:"urls" json:"urls"`
+	// Sample là phần trăm request đọc (0-100) được mirror
+	Sample float64 `yaml:"sample" json:"sample"`
+
+	target *Target
+}
+
+// Target trả về shadow dưới dạng target để chọn endpoint qua load balancer
+func (s *Shadow) Target() *Target {
+	return s.target
+}
+
+const defaultTimeout = 30 * time.Second
+
+// Route mô tả một upstream được gateway proxy tới.
+// Request /api<path_prefix>/<rest> được forward tới <url>/<rest>.
+type Route struct {
+	Name       string    `yaml:"name" json:"name"`
+	PathPrefix string    `yaml:"path_prefix" json:"path_prefix"`
+	Upstream   string    `yaml:"upstream" json:"upstream"`
+	URLs       []string  `yaml:"urls" json:"urls"`
+	Type       RouteType `yaml:"type" json:"type"`
+	// Audience của X-Internal-JWT. Để trống thì gateway không ký JWT nội bộ
+	// và không forward thông tin user (ví dụ auth-service)
+	Audience string   `yaml:"audience" json:"audience"`
+	Auth     AuthMode `yaml:"auth" json:"auth"`
+	Roles    []string `yaml:"roles" json:"roles,omitempty"`
+	Timeout  string   `yaml:"timeout" json:"timeout"`
+	// LoadBalancer chọn endpoint trong URLs, mặc định round_robin
+	LoadBalancer LoadBalancer `yaml:"load_balancer" json:"load_balancer"`
+	// HealthCheck để trống thì endpoint luôn được coi là healthy
+	HealthCheck *HealthCheck `yaml:"health_check" json:"health_check,omitempty"`
+	// Cache để trống thì response của route không được cache
+	Cache *Cache `yaml:"cache" json:"cache,omitempty"`
+	// OpenAPI để trống thì API của route không có trong spec tổng hợp
+	OpenAPI *OpenAPI `yaml:"openapi" json:"openapi,omitempty"`
+	// Targets chia traffic sang phiên bản canary theo trọng số, cố định theo user
+	Targets []*Target `yaml:"targets" json:"targets,omitempty"`
+	// Shadow để trống thì không mirror traffic
+	Shadow *Shadow `yaml:"shadow" json:"shadow,omitempty"`
+	// Limits để trống thì dùng PROXY_MAX_REQUEST_BODY_BYTES và MAX_HEADER_BYTES
+	Limits *Limits `yaml:"limits" json:"limits,omitempty"`
+
+	timeout time.Duration
+}
+
+// TimeoutDuration trả về timeout đã parse của route
+func (r *Route) TimeoutDuration() time.Duration {
+	return r.timeout
+}
+
+// MaxBodyBytes trả về giới hạn request body của route, def nếu route không cấu hình
+func (r *Route) MaxBodyBytes(def int64) int64 {
+	if r.Limits != nil && r.Limits.maxBody > 0 {
+		return r.Limits.maxBody
+	}
+	return def
+}
+
+// MaxHeaderBytes trả về giới hạn tổng kích thước header của route, def nếu route không cấu hình
+func (r *Route) MaxHeaderBytes(def int64) int64 {
+	if r.Limits != nil && r.Limits.maxHeader > 0 {
+		return r.Limits.maxHeader
+	}
+	return def
+}
+
+// AllowsRole kiểm tra role của user có nằm trong danh sách roles của route
+func (r *Route) AllowsRole(role string) bool {
+	if len(r.Roles) == 0 {
+		return true
+	}
+	for _, allowed := range r.Roles {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
+
+// PickTarget chọn target cho request theo trọng số; nil nghĩa là urls của route (stable).
+// key là khoá affinity (userID hoặc IP) nên cùng một user luôn vào cùng một target
+// cho tới khi trọng số thay đổi.
+func (r *Route) PickTarget(key string) *Target {
+	if len(r.Targets) == 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(r.Name + "|" + key))
+	bucket := float64(h.Sum32()%10000) / 100
+
+	var cumulative float64
+	for _, t := range r.Targets {
+		cumulative += t.Weight
+		if bucket < cumulative {
+			return t
+		}
+	}
+	return nil
+}
+
+// LookupTarget tìm target theo tên, dùng khi client chọn target bằng header.
+// Trả về nil, true với tên stable.
+func (r *Route) LookupTarget(name string) (*Target, bool) {
+	if name == StableTarget {
+		return nil, true
+	}
+	for _, t := range r.Targets {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Table là bảng route đã được validate, sắp xếp theo prefix dài nhất trước
+type Table struct {
+	Routes   []*Route  `json:"routes"`
+	Source   string    `json:"source"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+type fileFormat struct {
+	Routes []*Route `yaml:"routes" json:"routes"`
+}
+
+// Load đọc file route (YAML hoặc JSON), expand biến môi trường và validate
+func Load(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routes file: %w", err)
+	}
+	return Parse(path, data)
+}
+
+// Parse parse nội dung file route, định dạng dựa vào extension của path
+func Parse(path string, data []byte) (*Table, error) {
+	var file fileFormat
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err := json.Unmarshal(data, &file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse routes file: %w", err)
+		}
+	default:
+		err := yaml.UnmarshalStrict(data, &file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse routes file: %w", err)
+		}
+	}
+
+	if err := validate(file.Routes); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(file.Routes, func(i, j int) bool {
+		return len(file.Routes[i].PathPrefix) > len(file.Routes[j].PathPrefix)
+	})
+
+	return &Table{
+		Routes:   file.Routes,
+		Source:   path,
+		LoadedAt: time.Now(),
+	}, nil
+}
+
+// Match tìm route có prefix dài nhất khớp với path (path không bao gồm /api).
+// Trả về route và phần path còn lại sau prefix.
+func (t *Table) Match(path string) (*Route, string, bool) {
+	for _, r := range t.Routes {
+		if path == r.PathPrefix {
+			return r, "", true
+		}
+		if strings.HasPrefix(path, r.PathPrefix+"/") {
+			return r, strings.TrimPrefix(path, r.PathPrefix+"/"), true
+		}
+	}
+	return nil, "", false
+}
+
+// Lookup tìm route theo tên
+func (t *Table) Lookup(name string) (*Route, bool) {
+	for _, r := range t.Routes {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+func validate(routes []*Route) error {
+	if len(routes) == 0 {
+		return errors.New("routes file contains no routes")
+	}
+
+	var errs []error
+	names := map[string]bool{}
+	prefixes := map[string]bool{}
+
+	for i, r := range routes {
+		where := fmt.Sprintf("route[%d] %q", i, r.Name)
+
+		if r.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", where))
+		} else if names[r.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate name", where))
+		}
+		names[r.Name] = true
+
+		r.PathPrefix = strings.TrimSuffix(r.PathPrefix, "/")
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			errs = append(errs, fmt.Errorf("%s: path_prefix must start with /", where))
+		} else if prefixes[r.PathPrefix] {
+			errs = append(errs, fmt.Errorf("%s: duplicate path_prefix %s", where, r.PathPrefix))
+		}
+		prefixes[r.PathPrefix] = true
+
+		if r.Upstream == "" {
+			errs = append(errs, fmt.Errorf("%s: upstream is required", where))
+		}
+
+		if r.Type == "" {
+			r.Type = TypeHTTP
+		}
+		if r.Type != TypeHTTP && r.Type != TypeWebSocket {
+			errs = append(errs, fmt.Errorf("%s: unknown type %q", where, r.Type))
+		}
+
+		if len(r.URLs) == 0 {
+			errs = append(errs, fmt.Errorf("%s: at least one url is required", where))
+		}
+		var urlErrs []error
+		r.URLs, urlErrs = expandURLs(where, r.Type, r.URLs)
+		errs = append(errs, urlErrs...)
+
+		if r.LoadBalancer == "" {
+			r.LoadBalancer = RoundRobin
+		}
+		switch r.LoadBalancer {
+		case RoundRobin, LeastConnections, ConsistentHash:
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown load_balancer %q", where, r.LoadBalancer))
+		}
+
+		if r.HealthCheck != nil && !strings.HasPrefix(r.HealthCheck.Path, "/") {
+			errs = append(errs, fmt.Errorf("%s: health_check.path must start with /", where))
+		}
+
+		if r.Cache != nil {
+			errs = append(errs, validateCache(where, r)...)
+		}
+
+		if r.OpenAPI != nil {
+			if r.Type == TypeWebSocket {
+				errs = append(errs, fmt.Errorf("%s: openapi is not supported for websocket routes", where))
+			}
+			if !strings.HasPrefix(r.OpenAPI.Path, "/") {
+				errs = append(errs, fmt.Errorf("%s: openapi.path must start with /", where))
+			}
+			if r.OpenAPI.StripPrefix != "" && !strings.HasPrefix(r.OpenAPI.StripPrefix, "/") {
+				errs = append(errs, fmt.Errorf("%s: openapi.strip_prefix must start with /", where))
+			}
+			for i, rw := range r.OpenAPI.Rewrite {
+				if !strings.HasPrefix(rw.From, "/") || !strings.HasPrefix(rw.To, "/") {
+					errs = append(errs, fmt.Errorf("%s: openapi.rewrite[%d] from and to must start with /", where, i))
+				}
+			}
+		}
+
+		if len(r.Targets) > 0 || r.Shadow != nil {
+			errs = append(errs, validateTargets(where, r)...)
+		}
+
+		if r.Limits != nil {
+			errs = append(errs, validateLimits(where, r.Limits)...)
+		}
+
+		if r.Auth == "" {
+			r.Auth = AuthRequired
+		}
+		switch r.Auth {
+		case AuthPublic, AuthOptional, AuthRequired:
+		default:
+			errs = append(errs, fmt.Errorf("%s: unknown auth mode %q", where, r.Auth))
+		}
+		if len(r.Roles) > 0 && r.Auth != AuthRequired {
+			errs = append(errs, fmt.Errorf("%s: roles require auth: required", where))
+		}
+
+		r.timeout = defaultTimeout
+		if r.Timeout != "" {
+			d, err := time.ParseDuration(r.Timeout)
+			if err != nil || d <= 0 {
+				errs = append(errs, fmt.Errorf("%s: invalid timeout %q", where, r.Timeout))
+			} else {
+				r.timeout = d
+			}
+		}
+		r.Timeout = r.timeout.String()
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateCache(where string, r *Route) []error {
+	var errs []error
+	c := r.Cache
+
+	if r.Type == TypeWebSocket {
+		errs = append(errs, fmt.Errorf("%s: cache is not supported for websocket routes", where))
+	}
+
+	d, err := time.ParseDuration(c.TTL)
+	if err != nil || d < time.Second {
+		errs = append(errs, fmt.Errorf("%s: invalid cache.ttl %q (min 1s)", where, c.TTL))
+	} else {
+		c.ttl = d
+		c.TTL = d.String()
+	}
+
+	if c.StaleWhileRevalidate != "" {
+		d, err := time.ParseDuration(c.StaleWhileRevalidate)
+		if err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid cache.stale_while_revalidate %q", where, c.StaleWhileRevalidate))
+		} else {
+			c.stale = d
+			c.StaleWhileRevalidate = d.String()
+		}
+	}
+
+	for i, e := range c.Exclude {
+		c.Exclude[i] = strings.TrimSuffix(e, "/")
+		if !strings.HasPrefix(e, "/") {
+			errs = append(errs, fmt.Errorf("%s: cache.exclude %q must start with /", where, e))
+		}
+	}
+	return errs
+}
+
+func validateTargets(where string, r *Route) []error {
+	var errs []error
+	if r.Type == TypeWebSocket {
+		errs = append(errs, fmt.Errorf("%s: targets and shadow are not supported for websocket routes", where))
+	}
+
+	names := map[string]bool{StableTarget: true}
+	var total float64
+	for i, t := range r.Targets {
+		tw := fmt.Sprintf("%s: targets[%d] %q", where, i, t.Name)
+		if t.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", tw))
+		} else if names[t.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate or reserved name", tw))
+		}
+		names[t.Name] = true
+
+		if t.Upstream == "" {
+			t.Upstream = r.Upstream + "-" + t.Name
+		}
+		if len(t.URLs) == 0 {
+			errs = append(errs, fmt.Errorf("%s: at least one url is required", tw))
+		}
+		var urlErrs []error
+		t.URLs, urlErrs = expandURLs(tw, r.Type, t.URLs)
+		errs = append(errs, urlErrs...)
+
+		if t.Weight < 0 || t.Weight > 100 {
+			errs = append(errs, fmt.Errorf("%s: weight must be between 0 and 100", tw))
+		}
+		total += t.Weight
+	}
+	if total > 100 {
+		errs = append(errs, fmt.Errorf("%s: total weight of targets is %g, must not exceed 100", where, total))
+	}
+
+	if s := r.Shadow; s != nil {
+		if s.Upstream == "" {
+			s.Upstream = r.Upstream + "-shadow"
+		}
+		if len(s.URLs) == 0 {
+			errs = append(errs, fmt.Errorf("%s: shadow requires at least one url", where))
+		}
+		var urlErrs []error
+		s.URLs, urlErrs = expandURLs(where+": shadow", r.Type, s.URLs)
+		errs = append(errs, urlErrs...)
+		if s.Sample <= 0 || s.Sample > 100 {
+			errs = append(errs, fmt.Errorf("%s: shadow.sample must be between 0 and 100", where))
+		}
+		s.target = &Target{Name: "shadow", Upstream: s.Upstream, URLs: s.URLs}
+	}
+	return errs
+}
+
+func validateLimits(where string, l *Limits) []error {
+	var errs []error
+	if l.MaxBodySize != "" {
+		n, err := ParseByteSize(l.MaxBodySize)
+		if err != nil || n <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid limits.max_body_size %q", where, l.MaxBodySize))
+		}
+		l.maxBody = n
+	}
+	if l.MaxHeaderSize != "" {
+		n, err := ParseByteSize(l.MaxHeaderSize)
+		if err != nil || n <= 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid limits.max_header_size %q", where, l.MaxHeaderSize))
+		}
+		l.maxHeader = n
+	}
+	return errs
+}
+
+// ParseByteSize parse kích thước dạng 512, 512B, 64KB, 10MB, 1GB (đơn vị 1024)
+func ParseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("size %s out of range", s)
+	}
+	return n * unit, nil
+}
+
+// expandURLs expand biến môi trường trong danh sách URL và validate từng URL.
+// Một biến môi trường có thể chứa nhiều URL, phân tách bởi dấu phẩy.
+func expandURLs(where string, t RouteType, raws []string) ([]string, []error) {
+	var (
+		urls []string
+		errs []error
+	)
+	for _, raw := range raws {
+		for _, expanded := range strings.Split(expandEnv(raw), ",") {
+			expanded = strings.TrimSpace(expanded)
+			if err := validateURL(t, expanded); err != nil {
+				errs = append(errs, fmt.Errorf("%s: url %q: %w", where, raw, err))
+			}
+			urls = append(urls, strings.TrimSuffix(expanded, "/"))
+		}
+	}
+	return urls, errs
+}
+
+func validateURL(t RouteType, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+	switch {
+	case t == TypeWebSocket && (u.Scheme == "ws" || u.Scheme == "wss"):
+	case t == TypeHTTP && (u.Scheme == "http" || u.Scheme == "https"):
+	default:
+		return fmt.Errorf("scheme %q not allowed for %s route", u.Scheme, t)
+	}
+	return nil
+}
+
+// expandEnv thay ${VAR} và ${VAR:-default} bằng giá trị biến môi trường
+func expandEnv(s string) string {
+	return os.Expand(s, func(key string) string {
+		name, def, hasDefault := strings.Cut(key, ":-")
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+		if hasDefault {
+			return def
+		}
+		return ""
+	})
+}
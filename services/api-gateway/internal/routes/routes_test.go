@@ -0,0 +1,212 @@
+package routes
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRoutes = `
+routes:
+  - name: products
+    path_prefix: /products/
+    upstream: product-service
+    urls: ["${PRODUCT_SERVICE_URLS:-http://product-1:8083,http://product-2:8083}"]
+    auth: optional
+  - name: product-images
+    path_prefix: /products/images
+    upstream: media-service
+    urls: ["http://media-service:8087/"]
+    auth: public
+  - name: chat
+    path_prefix: /ws/chat
+    upstream: chat-service
+    urls: ["ws://chat-service:8086"]
+    type: websocket
+`
+
+func parseTestRoutes(t *testing.T) *Table {
+	t.Helper()
+	table, err := Parse("routes.yaml", []byte(testRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return table
+}
+
+func TestParseDefaults(t *testing.T) {
+	table := parseTestRoutes(t)
+
+	products, _ := table.Lookup("products")
+	if products.PathPrefix != "/products" {
+		t.Errorf("path_prefix = %q, want trailing slash trimmed", products.PathPrefix)
+	}
+	if got := strings.Join(products.URLs, ","); got != "http://product-1:8083,http://product-2:8083" {
+		t.Errorf("urls = %v, want the env default split by comma", products.URLs)
+	}
+	if products.Type != TypeHTTP || products.LoadBalancer != RoundRobin || products.TimeoutDuration() != defaultTimeout {
+		t.Errorf("defaults = %s %s %s", products.Type, products.LoadBalancer, products.TimeoutDuration())
+	}
+
+	chat, _ := table.Lookup("chat")
+	if chat.Auth != AuthRequired {
+		t.Errorf("auth = %q, want %q by default", chat.Auth, AuthRequired)
+	}
+	images, _ := table.Lookup("product-images")
+	if images.URLs[0] != "http://media-service:8087" {
+		t.Errorf("url = %q, want trailing slash trimmed", images.URLs[0])
+	}
+}
+
+func TestParseEnvURLs(t *testing.T) {
+	t.Setenv("PRODUCT_SERVICE_URLS", "http://product-a:8083, http://product-b:8083")
+	products, _ := parseTestRoutes(t).Lookup("products")
+	if got := strings.Join(products.URLs, ","); got != "http://product-a:8083,http://product-b:8083" {
+		t.Errorf("urls = %v, want the env value", products.URLs)
+	}
+}
+
+func TestTableMatch(t *testing.T) {
+	table := parseTestRoutes(t)
+
+	tests := []struct {
+		path      string
+		wantRoute string
+		wantRest  string
+	}{
+		{"/products", "products", ""},
+		{"/products/42", "products", "42"},
+		{"/products/images/42.jpg", "product-images", "42.jpg"},
+		{"/products/imagesx", "products", "imagesx"},
+		{"/ws/chat", "chat", ""},
+		{"/productsx", "", ""},
+		{"/orders", "", ""},
+	}
+	for _, tt := range tests {
+		r, rest, ok := table.Match(tt.path)
+		if ok != (tt.wantRoute != "") {
+			t.Errorf("Match(%q) ok = %v, want route %q", tt.path, ok, tt.wantRoute)
+			continue
+		}
+		if ok && (r.Name != tt.wantRoute || rest != tt.wantRest) {
+			t.Errorf("Match(%q) = %s %q, want %s %q", tt.path, r.Name, rest, tt.wantRoute, tt.wantRest)
+		}
+	}
+}
+
+func TestParseValidation(t *testing.T) {
+	route := func(extra string) string {
+		return `
+routes:
+  - name: products
+    path_prefix: /products
+    upstream: product-service
+    urls: ["http://product-service:8083"]
+` + extra
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"valid", route(""), ""},
+		{"no routes", `routes: []`, "no routes"},
+		{"duplicate name", route(`
+  - name: products
+    path_prefix: /catalog
+    upstream: product-service
+    urls: ["http://product-service:8083"]
+`), "duplicate name"},
+		{"duplicate prefix", route(`
+  - name: catalog
+    path_prefix: /products/
+    upstream: product-service
+    urls: ["http://product-service:8083"]
+`), "duplicate path_prefix"},
+		{"relative prefix", `routes: [{name: a, path_prefix: products, upstream: a, urls: ["http://a"]}]`, "must start with /"},
+		{"missing upstream", `routes: [{name: a, path_prefix: /a, urls: ["http://a"]}]`, "upstream is required"},
+		{"missing urls", `routes: [{name: a, path_prefix: /a, upstream: a}]`, "at least one url"},
+		{"websocket scheme on http route", `routes: [{name: a, path_prefix: /a, upstream: a, urls: ["ws://a"]}]`, "not allowed"},
+		{"http scheme on websocket route", `routes: [{name: a, path_prefix: /a, upstream: a, type: websocket, urls: ["http://a"]}]`, "not allowed"},
+		{"unset env url", `routes: [{name: a, path_prefix: /a, upstream: a, urls: ["${ROUTES_TEST_UNSET_URL}"]}]`, "missing host"},
+		{"unknown load balancer", `routes: [{name: a, path_prefix: /a, upstream: a, urls: ["http://a"], load_balancer: random}]`, "unknown load_balancer"},
+		{"unknown auth", `routes: [{name: a, path_prefix: /a, upstream: a, urls: ["http://a"], auth: basic}]`, "unknown auth mode"},
+		{"roles without required auth", `routes: [{name: a, path_prefix: /a, upstream: a, urls: ["http://a"], auth: optional, roles: [ROLE_ADMIN]}]`, "roles require auth"},
+		{"invalid timeout", `routes: [{name: a, path_prefix: /a, upstream: a, urls: ["http://a"], timeout: 0s}]`, "invalid timeout"},
+		{"cache ttl below 1s", `routes: [{name: a, path_prefix: /a, upstream: a, urls: ["http://a"], cache: {ttl: 500ms}}]`, "invalid cache.ttl"},
+		{"cache on websocket", `routes: [{name: a, path_prefix: /a, upstream: a, type: websocket, urls: ["ws://a"], cache: {ttl: 10s}}]`, "cache is not supported"},
+		{"invalid body limit", `routes: [{name: a, path_prefix: /a, upstream: a, urls: ["http://a"], limits: {max_body_size: 10XB}}]`, "invalid limits.max_body_size"},
+		{"unknown field", `routes: [{name: a, path_prefix: /a, upstream: a, url: "http://a"}]`, "failed to parse"},
+	}
+	for _, tt := range tests {
+		_, err := Parse("routes.yaml", []byte(tt.data))
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: Parse() error = %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Parse() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"64kb", 64 << 10, false},
+		{"10 MB", 10 << 20, false},
+		{"1GB", 1 << 30, false},
+		{"-1KB", 0, true},
+		{"10XB", 0, true},
+		{"99999999999GB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v, want %d, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCacheExcludes(t *testing.T) {
+	table, err := Parse("routes.yaml", []byte(`
+routes:
+  - name: products
+    path_prefix: /products
+    upstream: product-service
+    urls: ["http://product-service:8083"]
+    cache:
+      ttl: 30s
+      exclude: [/watchlist/, /me]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	products, _ := table.Lookup("products")
+	if products.Cache.TTLDuration() != 30*time.Second {
+		t.Errorf("TTLDuration() = %s, want 30s", products.Cache.TTLDuration())
+	}
+	tests := []struct {
+		rest string
+		want bool
+	}{
+		{"watchlist", true},
+		{"watchlist/42", true},
+		{"me", true},
+		{"watchlists", false},
+		{"42", false},
+	}
+	for _, tt := range tests {
+		if got := products.Cache.Excludes(tt.rest); got != tt.want {
+			t.Errorf("Excludes(%q) = %v, want %v", tt.rest, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+const testCanaryRoutes = `
+routes:
+  - name: products
+    path_prefix: /products
+    upstream: product-service
+    urls: ["http://product-service:8083"]
+    targets:
+      - name: canary
+        urls: ["http://product-service-canary:8083"]
+        weight: 10
+      - name: next
+        upstream: product-service-v2
+        urls: ["http://product-service-v2:8083"]
+        weight: 20
+    shadow:
+      urls: ["http://product-service-shadow:8083"]
+      sample: 5
+`
+
+func canaryRoute(t *testing.T) *Route {
+	t.Helper()
+	table, err := Parse("routes.yaml", []byte(testCanaryRoutes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, _ := table.Lookup("products")
+	return route
+}
+
+func TestPickTargetWeights(t *testing.T) {
+	route := canaryRoute(t)
+
+	counts := map[string]int{}
+	const users = 20000
+	for i := 0; i < users; i++ {
+		name := StableTarget
+		if target := route.PickTarget(fmt.Sprintf("user:%d", i)); target != nil {
+			name = target.Name
+		}
+		counts[name]++
+	}
+
+	want := map[string]float64{StableTarget: 0.70, "canary": 0.10, "next": 0.20}
+	for name, share := range want {
+		got := float64(counts[name]) / users
+		if math.Abs(got-share) > 0.02 {
+			t.Errorf("target %s received %.3f of users, want about %.2f", name, got, share)
+		}
+	}
+}
+
+func TestPickTargetIsSticky(t *testing.T) {
+	route := canaryRoute(t)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		first := route.PickTarget(key)
+		for j := 0; j < 3; j++ {
+			if got := route.PickTarget(key); got != first {
+				t.Fatalf("%s moved from %v to %v", key, first, got)
+			}
+		}
+	}
+
+	// Route không có target: mọi request đi tới urls của route
+	route.Targets = nil
+	if got := route.PickTarget("user:1"); got != nil {
+		t.Errorf("PickTarget() without targets = %v, want nil", got)
+	}
+}
+
+func TestLookupTarget(t *testing.T) {
+	route := canaryRoute(t)
+
+	tests := []struct {
+		name       string
+		wantTarget string
+		wantOK     bool
+	}{
+		{StableTarget, "", true},
+		{"canary", "canary", true},
+		{"next", "next", true},
+		{"shadow", "", false},
+		{"unknown", "", false},
+	}
+	for _, tt := range tests {
+		target, ok := route.LookupTarget(tt.name)
+		var got string
+		if target != nil {
+			got = target.Name
+		}
+		if ok != tt.wantOK || got != tt.wantTarget {
+			t.Errorf("LookupTarget(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.wantTarget, tt.wantOK)
+		}
+	}
+}
+
+func TestTargetDefaults(t *testing.T) {
+	route := canaryRoute(t)
+
+	canary, _ := route.LookupTarget("canary")
+	next, _ := route.LookupTarget("next")
+	if canary.Upstream != "product-service-canary" || next.Upstream != "product-service-v2" {
+		t.Errorf("target upstreams = %q, %q", canary.Upstream, next.Upstream)
+	}
+	shadow := route.Shadow.Target()
+	if shadow.Upstream != "product-service-shadow" || shadow.URLs[0] != "http://product-service-shadow:8083" {
+		t.Errorf("shadow target = %+v", shadow)
+	}
+}
+
+func TestValidateTargets(t *testing.T) {
+	route := func(targets string) string {
+		return `
+routes:
+  - name: products
+    path_prefix: /products
+    upstream: product-service
+    urls: ["http://product-service:8083"]
+` + targets
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"total weight over 100", route(`
+    targets:
+      - {name: a, urls: ["http://a"], weight: 60}
+      - {name: b, urls: ["http://b"], weight: 50}
+`), "must not exceed 100"},
+		{"negative weight", route(`
+    targets: [{name: a, urls: ["http://a"], weight: -1}]
+`), "weight must be between 0 and 100"},
+		{"reserved name", route(`
+    targets: [{name: stable, urls: ["http://a"], weight: 10}]
+`), "duplicate or reserved name"},
+		{"duplicate name", route(`
+    targets:
+      - {name: a, urls: ["http://a"], weight: 10}
+      - {name: a, urls: ["http://b"], weight: 10}
+`), "duplicate or reserved name"},
+		{"target without urls", route(`
+    targets: [{name: a, weight: 10}]
+`), "at least one url"},
+		{"shadow sample of 0", route(`
+    shadow: {urls: ["http://a"], sample: 0}
+`), "shadow.sample"},
+		{"shadow without urls", route(`
+    shadow: {sample: 10}
+`), "shadow requires at least one url"},
+		{"targets on websocket route", `
+routes:
+  - name: chat
+    path_prefix: /ws/chat
+    upstream: chat-service
+    type: websocket
+    urls: ["ws://chat-service:8086"]
+    targets: [{name: a, urls: ["ws://a"], weight: 10}]
+`, "not supported for websocket"},
+	}
+	for _, tt := range tests {
+		_, err := Parse("routes.yaml", []byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Parse() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Action là hành động của IP rule
+type Action string
+
+const (
+	ActionAllow Action = "allow"
+	ActionDeny  Action = "deny"
+)
+
+// Nguồn của IP rule
+const (
+	SourceStatic  = "static"  // file policy
+	SourceRuntime = "runtime" // admin API, lưu trong Redis
+)
+
+// ipRulesKey là Redis hash chứa rule runtime, field là CIDR
+const ipRulesKey = "security:ip-rules"
+
+var (
+	ErrInvalidCIDR   = errors.New("cidr must be an IP address or CIDR, e.g. 203.0.113.0/24")
+	ErrInvalidAction = errors.New("action must be allow or deny")
+	ErrRuleNotFound  = errors.New("ip rule not found")
+	ErrStaticRule    = errors.New("static ip rules can only be changed in the security policy file")
+)
+
+// IPRule cho phép hoặc chặn một dải IP. Khi nhiều rule cùng khớp, rule có prefix dài nhất được áp dụng
+// (ví dụ deny 10.0.0.0/8 nhưng allow 10.1.2.0/24); cùng prefix thì deny được ưu tiên.
+// IP không khớp rule nào được cho phép.
+type IPRule struct {
+	CIDR      string     `yaml:"cidr" json:"cidr"`
+	Action    Action     `yaml:"action" json:"action"`
+	Reason    string     `yaml:"reason" json:"reason,omitempty"`
+	Source    string     `yaml:"-" json:"source"`
+	CreatedBy string     `yaml:"-" json:"created_by,omitempty"`
+	CreatedAt *time.Time `yaml:"-" json:"created_at,omitempty"`
+	ExpiresAt *time.Time `yaml:"-" json:"expires_at,omitempty"`
+
+	prefix netip.Prefix
+}
+
+// normalize parse CIDR (IP đơn được coi là /32 hoặc /128) và chuẩn hoá về dạng network address
+func (r *IPRule) normalize() error {
+	raw := strings.TrimSpace(r.CIDR)
+	var (
+		prefix netip.Prefix
+		err    error
+	)
+	if strings.Contains(raw, "/") {
+		prefix, err = netip.ParsePrefix(raw)
+	} else {
+		var addr netip.Addr
+		if addr, err = netip.ParseAddr(raw); err == nil {
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+	}
+	if err != nil {
+		return ErrInvalidCIDR
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	r.prefix = prefix.Masked()
+	r.CIDR = r.prefix.String()
+
+	if r.Action != ActionAllow && r.Action != ActionDeny {
+		return ErrInvalidAction
+	}
+	return nil
+}
+
+func (r *IPRule) expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
+
+// IPFilter áp dụng IP rule tĩnh và rule runtime. Rule runtime được lưu trong Redis để áp dụng
+// cho mọi instance gateway, mỗi instance tải lại định kỳ và cập nhật ngay khi rule được đổi qua instance đó.
+type IPFilter struct {
+	client *redis.Client
+	static []*IPRule
+
+	mu      sync.Mutex
+	runtime map[string]*IPRule
+	rules   atomic.Pointer[[]*IPRule] // static + runtime, sắp xếp theo độ ưu tiên
+}
+
+func NewIPFilter(client *redis.Client, static []*IPRule) *IPFilter {
+	f := &IPFilter{client: client, static: static, runtime: map[string]*IPRule{}}
+	f.rebuild()
+	return f
+}
+
+// Check trả về rule khớp với IP (nil nếu không có) và IP có được phép không
+func (f *IPFilter) Check(ip string) (*IPRule, bool) {
+	rules := f.rules.Load()
+	if rules == nil || len(*rules) == 0 {
+		return nil, true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, true
+	}
+	addr = addr.Unmap()
+
+	now := time.Now()
+	for _, r := range *rules {
+		if r.prefix.Contains(addr) && !r.expired(now) {
+			return r, r.Action == ActionAllow
+		}
+	}
+	return nil, true
+}
+
+// Rules trả về các rule đang có hiệu lực
+func (f *IPFilter) Rules() []*IPRule {
+	list := []*IPRule{}
+	now := time.Now()
+	if rules := f.rules.Load(); rules != nil {
+		for _, r := range *rules {
+			if !r.expired(now) {
+				list = append(list, r)
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].CIDR < list[j].CIDR })
+	return list
+}
+
+// Set thêm hoặc thay rule runtime của một CIDR. ttl <= 0 nghĩa là rule không hết hạn.
+func (f *IPFilter) Set(ctx context.Context, rule IPRule, ttl time.Duration) (*IPRule, error) {
+	if err := rule.normalize(); err != nil {
+		return nil, err
+	}
+	for _, s := range f.static {
+		if s.CIDR == rule.CIDR {
+			return nil, ErrStaticRule
+		}
+	}
+
+	now := time.Now()
+	rule.Source = SourceRuntime
+	rule.CreatedAt = &now
+	rule.ExpiresAt = nil
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		rule.ExpiresAt = &expiresAt
+	}
+
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.client.HSet(ctx, ipRulesKey, rule.CIDR, data).Err(); err != nil {
+		return nil, err
+	}
+
+	f.update(func(m map[string]*IPRule) { m[rule.CIDR] = &rule })
+	slog.Info("IP rule set",
+		slog.String("cidr", rule.CIDR),
+		slog.String("action", string(rule.Action)),
+		slog.String("created_by", rule.CreatedBy),
+		slog.String("reason", rule.Reason),
+	)
+	return &rule, nil
+}
+
+// Delete xoá rule runtime của một CIDR
+func (f *IPFilter) Delete(ctx context.Context, cidr string) error {
+	rule := IPRule{CIDR: cidr, Action: ActionDeny}
+	if err := rule.normalize(); err != nil {
+		return err
+	}
+	for _, s := range f.static {
+		if s.CIDR == rule.CIDR {
+			return ErrStaticRule
+		}
+	}
+
+	removed, err := f.client.HDel(ctx, ipRulesKey, rule.CIDR).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrRuleNotFound
+	}
+
+	f.update(func(m map[string]*IPRule) { delete(m, rule.CIDR) })
+	slog.Info("IP rule removed", slog.String("cidr", rule.CIDR))
+	return nil
+}
+
+// StartRefresh tải lại rule runtime từ Redis định kỳ để rule được đổi qua instance khác cũng được áp dụng
+func (f *IPFilter) StartRefresh(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := f.refresh(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to refresh IP rules", slog.String("error", err.Error()))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (f *IPFilter) refresh(ctx context.Context) error {
+	values, err := f.client.HGetAll(ctx, ipRulesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	runtime := make(map[string]*IPRule, len(values))
+	var expired []string
+	for field, data := range values {
+		var r IPRule
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			slog.Warn("Invalid IP rule", slog.String("cidr", field), slog.String("error", err.Error()))
+			continue
+		}
+		if err := r.normalize(); err != nil {
+			slog.Warn("Invalid IP rule", slog.String("cidr", field), slog.String("error", err.Error()))
+			continue
+		}
+		if r.expired(now) {
+			expired = append(expired, field)
+			continue
+		}
+		r.Source = SourceRuntime
+		runtime[r.CIDR] = &r
+	}
+	if len(expired) > 0 {
+		f.client.HDel(ctx, ipRulesKey, expired...)
+	}
+
+	f.mu.Lock()
+	f.runtime = runtime
+	f.rebuild()
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *IPFilter) update(fn func(map[string]*IPRule)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	next := make(map[string]*IPRule, len(f.runtime))
+	for k, v := range f.runtime {
+		next[k] = v
+	}
+	fn(next)
+	f.runtime = next
+	f.rebuild()
+}
+
+// rebuild gộp rule tĩnh và runtime theo thứ tự áp dụng: prefix dài hơn trước, cùng prefix thì deny trước.
+// Caller phải giữ mu (trừ khi khởi tạo).
+func (f *IPFilter) rebuild() {
+	rules := make([]*IPRule, 0, len(f.static)+len(f.runtime))
+	rules = append(rules, f.static...)
+	for _, r := range f.runtime {
+		rules = append(rules, r)
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].prefix.Bits() != rules[j].prefix.Bits() {
+			return rules[i].prefix.Bits() > rules[j].prefix.Bits()
+		}
+		return rules[i].Action == ActionDeny && rules[j].Action != ActionDeny
+	})
+	f.rules.Store(&rules)
+}
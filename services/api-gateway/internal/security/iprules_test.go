@@ -0,0 +1,161 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestFilter(t *testing.T, static ...*IPRule) (*IPFilter, *redis.Client) {
+	t.Helper()
+	for _, r := range static {
+		r.Source = SourceStatic
+		if err := r.normalize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewIPFilter(client, static), client
+}
+
+func TestIPFilterCheck(t *testing.T) {
+	f, _ := newTestFilter(t,
+		&IPRule{CIDR: "10.0.0.0/8", Action: ActionDeny},
+		&IPRule{CIDR: "10.1.2.0/24", Action: ActionAllow},
+		&IPRule{CIDR: "192.168.1.0/24", Action: ActionAllow},
+		&IPRule{CIDR: "192.168.1.0/24", Action: ActionDeny},
+		&IPRule{CIDR: "2001:db8::/32", Action: ActionDeny},
+		&IPRule{CIDR: "198.51.100.7", Action: ActionDeny},
+	)
+
+	tests := []struct {
+		name     string
+		ip       string
+		wantCIDR string
+		want     bool
+	}{
+		{"denied range", "10.9.8.7", "10.0.0.0/8", false},
+		{"longer allow prefix wins", "10.1.2.3", "10.1.2.0/24", true},
+		{"deny wins on the same prefix", "192.168.1.10", "192.168.1.0/24", false},
+		{"single IP rule", "198.51.100.7", "198.51.100.7/32", false},
+		{"IPv4-mapped IPv6", "::ffff:10.9.8.7", "10.0.0.0/8", false},
+		{"IPv6 range", "2001:db8::1", "2001:db8::/32", false},
+		{"no matching rule", "203.0.113.5", "", true},
+		{"unparsable IP", "not-an-ip", "", true},
+	}
+	for _, tt := range tests {
+		rule, allowed := f.Check(tt.ip)
+		if allowed != tt.want {
+			t.Errorf("%s: Check(%q) allowed = %v, want %v", tt.name, tt.ip, allowed, tt.want)
+		}
+		var cidr string
+		if rule != nil {
+			cidr = rule.CIDR
+		}
+		if cidr != tt.wantCIDR {
+			t.Errorf("%s: Check(%q) rule = %q, want %q", tt.name, tt.ip, cidr, tt.wantCIDR)
+		}
+	}
+}
+
+func TestIPRuleNormalize(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		action  Action
+		want    string
+		wantErr error
+	}{
+		{"203.0.113.77/24", ActionDeny, "203.0.113.0/24", nil},
+		{" 203.0.113.77 ", ActionDeny, "203.0.113.77/32", nil},
+		{"::ffff:203.0.113.0/120", ActionDeny, "203.0.113.0/24", nil},
+		{"2001:db8::1", ActionAllow, "2001:db8::1/128", nil},
+		{"203.0.113.0/33", ActionDeny, "", ErrInvalidCIDR},
+		{"example.com", ActionDeny, "", ErrInvalidCIDR},
+		{"203.0.113.0/24", "block", "203.0.113.0/24", ErrInvalidAction},
+	}
+	for _, tt := range tests {
+		r := IPRule{CIDR: tt.cidr, Action: tt.action}
+		err := r.normalize()
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("normalize(%q) error = %v, want %v", tt.cidr, err, tt.wantErr)
+			continue
+		}
+		if tt.want != "" && r.CIDR != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.cidr, r.CIDR, tt.want)
+		}
+	}
+}
+
+func TestIPFilterRuntimeRules(t *testing.T) {
+	f, client := newTestFilter(t, &IPRule{CIDR: "10.0.0.0/8", Action: ActionDeny})
+	ctx := context.Background()
+
+	// Rule tĩnh chỉ đổi được trong file policy
+	if _, err := f.Set(ctx, IPRule{CIDR: "10.0.0.0/8", Action: ActionAllow}, 0); !errors.Is(err, ErrStaticRule) {
+		t.Errorf("Set() of a static CIDR error = %v, want %v", err, ErrStaticRule)
+	}
+	if err := f.Delete(ctx, "10.0.0.0/8"); !errors.Is(err, ErrStaticRule) {
+		t.Errorf("Delete() of a static CIDR error = %v, want %v", err, ErrStaticRule)
+	}
+
+	if _, err := f.Set(ctx, IPRule{CIDR: "203.0.113.9", Action: ActionDeny, CreatedBy: "admin"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, allowed := f.Check("203.0.113.9"); allowed {
+		t.Error("runtime deny rule not applied on the instance that set it")
+	}
+
+	// Instance khác nhận rule sau lần refresh
+	other := NewIPFilter(client, nil)
+	if _, allowed := other.Check("203.0.113.9"); !allowed {
+		t.Fatal("rule applied before refresh")
+	}
+	if err := other.refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rule, allowed := other.Check("203.0.113.9")
+	if allowed || rule.Source != SourceRuntime || rule.CreatedBy != "admin" {
+		t.Errorf("Check() after refresh = %+v, %v, want runtime deny rule", rule, allowed)
+	}
+
+	if err := f.Delete(ctx, "203.0.113.9/32"); err != nil {
+		t.Fatal(err)
+	}
+	if _, allowed := f.Check("203.0.113.9"); !allowed {
+		t.Error("deleted rule still applied")
+	}
+	if err := f.Delete(ctx, "203.0.113.9"); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("second Delete() error = %v, want %v", err, ErrRuleNotFound)
+	}
+}
+
+func TestIPFilterExpiredRule(t *testing.T) {
+	f, client := newTestFilter(t)
+	ctx := context.Background()
+
+	if _, err := f.Set(ctx, IPRule{CIDR: "203.0.113.0/24", Action: ActionDeny}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	if _, allowed := f.Check("203.0.113.9"); !allowed {
+		t.Error("expired rule still applied")
+	}
+	if rules := f.Rules(); len(rules) != 0 {
+		t.Errorf("Rules() = %+v, want no active rule", rules)
+	}
+
+	// Refresh xoá rule hết hạn khỏi Redis
+	if err := f.refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := client.HLen(ctx, ipRulesKey).Val(); n != 0 {
+		t.Errorf("%d rules left in Redis, want 0", n)
+	}
+}
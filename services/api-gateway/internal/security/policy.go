@@ -0,0 +1,266 @@
+package security
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Policy là chính sách bảo mật chung của gateway: CORS, security header và danh sách IP tĩnh.
+// Origin được phép gọi gateway từ browser được chọn theo môi trường (OTEL_ENVIRONMENT).
+type Policy struct {
+	CORS CORS `yaml:"cors" json:"cors"`
+	// Headers được set cho mọi response, ghi đè header cùng tên của upstream
+	Headers map[string]string `yaml:"headers" json:"headers"`
+	// IPRules là danh sách CIDR allow/deny tĩnh, rule runtime được thêm qua admin API
+	IPRules []*IPRule `yaml:"ip_rules" json:"ip_rules"`
+}
+
+// CORS cấu hình cross-origin request từ browser. Origin khớp allowlist được trả lại nguyên văn
+// trong Access-Control-Allow-Origin, không bao giờ dùng * cùng với credentials.
+type CORS struct {
+	// Origins là allowlist theo môi trường, ví dụ development: [http://localhost:3000].
+	// Origin dạng https://*.example.com khớp mọi subdomain.
+	Origins          map[string][]string `yaml:"origins" json:"origins"`
+	AllowMethods     []string            `yaml:"allow_methods" json:"allow_methods"`
+	AllowHeaders     []string            `yaml:"allow_headers" json:"allow_headers"`
+	ExposeHeaders    []string            `yaml:"expose_headers" json:"expose_headers,omitempty"`
+	AllowCredentials bool                `yaml:"allow_credentials" json:"allow_credentials"`
+	MaxAge           string              `yaml:"max_age" json:"max_age"`
+
+	// Allowlist của môi trường hiện tại
+	Allowed []string `yaml:"-" json:"allowed"`
+
+	anyOrigin bool
+	exact     map[string]bool
+	wildcards []wildcardOrigin
+	maxAge    time.Duration
+}
+
+type wildcardOrigin struct {
+	prefix string // scheme://
+	suffix string // .example.com
+}
+
+// DefaultHeaders là security header khi file policy không cấu hình headers
+var DefaultHeaders = map[string]string{
+	"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+	"X-Content-Type-Options":    "nosniff",
+	"X-Frame-Options":           "DENY",
+	"Referrer-Policy":           "strict-origin-when-cross-origin",
+}
+
+// Default trả về policy khi không có file: không cho phép origin nào ngoài overrideOrigins,
+// security header mặc định và không có IP rule tĩnh
+func Default(env string, overrideOrigins []string) (*Policy, error) {
+	p := &Policy{}
+	if err := p.normalize(env, overrideOrigins); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Load đọc file policy (YAML hoặc JSON) và validate. overrideOrigins (CORS_ALLOWED_ORIGINS)
+// thay cho allowlist của môi trường trong file nếu không rỗng.
+func Load(path, env string, overrideOrigins []string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read security policy file: %w", err)
+	}
+	return Parse(path, data, env, overrideOrigins)
+}
+
+// Parse parse nội dung file policy, định dạng dựa vào extension của path
+func Parse(path string, data []byte, env string, overrideOrigins []string) (*Policy, error) {
+	var p Policy
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("failed to parse security policy file: %w", err)
+		}
+	default:
+		if err := yaml.UnmarshalStrict(data, &p); err != nil {
+			return nil, fmt.Errorf("failed to parse security policy file: %w", err)
+		}
+	}
+
+	if err := p.normalize(env, overrideOrigins); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *Policy) normalize(env string, overrideOrigins []string) error {
+	var errs []error
+
+	c := &p.CORS
+	if len(c.AllowMethods) == 0 {
+		c.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"}
+	}
+	if len(c.AllowHeaders) == 0 {
+		c.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-User-Token"}
+	}
+	c.maxAge = 24 * time.Hour
+	if c.MaxAge != "" {
+		d, err := time.ParseDuration(c.MaxAge)
+		if err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("cors: invalid max_age %q", c.MaxAge))
+		} else {
+			c.maxAge = d
+		}
+	}
+	c.MaxAge = c.maxAge.String()
+
+	origins := c.Origins[env]
+	if len(overrideOrigins) > 0 {
+		origins = overrideOrigins
+	}
+	c.Allowed = []string{}
+	c.exact = map[string]bool{}
+	for _, o := range origins {
+		o = strings.TrimSuffix(strings.TrimSpace(o), "/")
+		if o == "" {
+			continue
+		}
+		if err := c.addOrigin(o); err != nil {
+			errs = append(errs, fmt.Errorf("cors: origin %q: %w", o, err))
+			continue
+		}
+		c.Allowed = append(c.Allowed, o)
+	}
+
+	if p.Headers == nil {
+		p.Headers = DefaultHeaders
+	}
+	for name, value := range p.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") || strings.ContainsAny(value, "\r\n") {
+			errs = append(errs, fmt.Errorf("headers: invalid header %q", name))
+		}
+	}
+
+	for i, r := range p.IPRules {
+		r.Source = SourceStatic
+		if err := r.normalize(); err != nil {
+			errs = append(errs, fmt.Errorf("ip_rules[%d]: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *CORS) addOrigin(origin string) error {
+	if origin == "*" {
+		if c.AllowCredentials {
+			return errors.New("* cannot be used with allow_credentials")
+		}
+		c.anyOrigin = true
+		return nil
+	}
+
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok || (scheme != "http" && scheme != "https") || host == "" || strings.ContainsAny(host, "/?#") {
+		return errors.New("must be <scheme>://<host>[:port]")
+	}
+	if rest, wildcard := strings.CutPrefix(host, "*."); wildcard {
+		if rest == "" || strings.Contains(rest, "*") {
+			return errors.New("wildcard must be *.<domain>")
+		}
+		c.wildcards = append(c.wildcards, wildcardOrigin{prefix: scheme + "://", suffix: "." + strings.ToLower(rest)})
+		return nil
+	}
+	if strings.Contains(host, "*") {
+		return errors.New("wildcard must be *.<domain>")
+	}
+	if _, err := url.Parse(origin); err != nil {
+		return err
+	}
+	c.exact[strings.ToLower(origin)] = true
+	return nil
+}
+
+// AllowsOrigin kiểm tra origin của browser có nằm trong allowlist của môi trường hiện tại
+func (c *CORS) AllowsOrigin(origin string) bool {
+	if origin == "" || origin == "null" {
+		return false
+	}
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if c.exact[origin] {
+		return true
+	}
+	for _, w := range c.wildcards {
+		sub, ok := strings.CutPrefix(origin, w.prefix)
+		if !ok {
+			continue
+		}
+		// Chỉ khớp subdomain, không khớp chính domain hay origin có path/port lạ
+		label, ok := strings.CutSuffix(sub, w.suffix)
+		if ok && isHostLabel(label) {
+			return true
+		}
+	}
+	return false
+}
+
+// isHostLabel kiểm tra phần subdomain chỉ gồm chữ, số, '-' và '.'
+func isHostLabel(s string) bool {
+	if s == "" || strings.HasPrefix(s, ".") || strings.HasSuffix(s, ".") {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// AnyOrigin cho biết allowlist là *
+func (c *CORS) AnyOrigin() bool {
+	return c.anyOrigin
+}
+
+// MaxAgeSeconds trả về thời gian browser cache kết quả preflight
+func (c *CORS) MaxAgeSeconds() int {
+	return int(c.maxAge / time.Second)
+}
+
+// AllowsMethod kiểm tra method trong Access-Control-Request-Method của preflight
+func (c *CORS) AllowsMethod(method string) bool {
+	for _, m := range c.AllowMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsHeaders kiểm tra các header trong Access-Control-Request-Headers của preflight
+func (c *CORS) AllowsHeaders(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		allowed := false
+		for _, a := range c.AllowHeaders {
+			if strings.EqualFold(a, h) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package security
+
+import (
+	"testing"
+)
+
+const testPolicy = `
+cors:
+  origins:
+    development: ["http://localhost:3000"]
+    production: ["https://auction.example.com", "https://*.auction.example.com"]
+  allow_credentials: true
+  max_age: 1h
+`
+
+func TestAllowsOrigin(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		override []string
+		origin   string
+		want     bool
+	}{
+		{"exact origin", "production", nil, "https://auction.example.com", true},
+		{"exact origin is case insensitive", "production", nil, "https://Auction.Example.com", true},
+		{"subdomain wildcard", "production", nil, "https://admin.auction.example.com", true},
+		{"nested subdomain", "production", nil, "https://a.b.auction.example.com", true},
+		{"wildcard does not match the domain", "production", nil, "https://.auction.example.com", false},
+		{"wildcard requires the same scheme", "production", nil, "http://admin.auction.example.com", false},
+		{"suffix lookalike", "production", nil, "https://evilauction.example.com", false},
+		{"port is not a subdomain", "production", nil, "https://admin.auction.example.com:8443", false},
+		{"origin of another environment", "production", nil, "http://localhost:3000", false},
+		{"development allowlist", "development", nil, "http://localhost:3000", true},
+		{"unknown environment", "staging", nil, "https://auction.example.com", false},
+		{"override replaces allowlist", "production", []string{"https://preview.example.com/"}, "https://preview.example.com", true},
+		{"override drops file allowlist", "production", []string{"https://preview.example.com"}, "https://auction.example.com", false},
+		{"null origin", "production", nil, "null", false},
+		{"empty origin", "production", nil, "", false},
+	}
+	for _, tt := range tests {
+		p, err := Parse("security.yaml", []byte(testPolicy), tt.env, tt.override)
+		if err != nil {
+			t.Fatalf("%s: Parse() error = %v", tt.name, err)
+		}
+		if got := p.CORS.AllowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("%s: AllowsOrigin(%q) = %v, want %v", tt.name, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestParseValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"valid policy", testPolicy, false},
+		{"any origin without credentials", `cors: {origins: {production: ["*"]}}`, false},
+		{"any origin with credentials", `cors: {origins: {production: ["*"]}, allow_credentials: true}`, true},
+		{"origin with path", `cors: {origins: {production: ["https://auction.example.com/app"]}}`, true},
+		{"origin without scheme", `cors: {origins: {production: ["auction.example.com"]}}`, true},
+		{"wildcard in the middle", `cors: {origins: {production: ["https://api.*.example.com"]}}`, true},
+		{"invalid max_age", `cors: {max_age: soon}`, true},
+		{"header with colon", `headers: {"X-Frame-Options:": DENY}`, true},
+		{"header value with newline", `headers: {X-Frame-Options: "DENY\r\nSet-Cookie: a=b"}`, true},
+		{"invalid ip rule", `ip_rules: [{cidr: 10.0.0.0/33, action: deny}]`, true},
+		{"unknown ip rule action", `ip_rules: [{cidr: 10.0.0.0/8, action: block}]`, true},
+		{"unknown field", `cors: {origin: ["https://auction.example.com"]}`, true},
+	}
+	for _, tt := range tests {
+		_, err := Parse("security.yaml", []byte(tt.data), "production", nil)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Parse() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	p, err := Default("production", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.CORS.AllowsOrigin("https://auction.example.com") {
+		t.Error("default policy must not allow any origin")
+	}
+	if p.Headers["X-Frame-Options"] != "DENY" {
+		t.Errorf("default headers = %v, want DefaultHeaders", p.Headers)
+	}
+	if got := p.CORS.MaxAgeSeconds(); got != 86400 {
+		t.Errorf("MaxAgeSeconds() = %d, want 86400", got)
+	}
+}
+
+func TestAllowsPreflight(t *testing.T) {
+	p, err := Default("production", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &p.CORS
+
+	methods := []struct {
+		method string
+		want   bool
+	}{
+		{"PATCH", true},
+		{"patch", true},
+		{"TRACE", false},
+	}
+	for _, tt := range methods {
+		if got := c.AllowsMethod(tt.method); got != tt.want {
+			t.Errorf("AllowsMethod(%q) = %v, want %v", tt.method, got, tt.want)
+		}
+	}
+
+	headers := []struct {
+		requested string
+		want      bool
+	}{
+		{"", true},
+		{"content-type, x-user-token", true},
+		{"Authorization,", true},
+		{"Content-Type, X-Debug", false},
+	}
+	for _, tt := range headers {
+		if got := c.AllowsHeaders(tt.requested); got != tt.want {
+			t.Errorf("AllowsHeaders(%q) = %v, want %v", tt.requested, got, tt.want)
+		}
+	}
+}
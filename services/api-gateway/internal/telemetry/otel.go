@@ -4,12 +4,17 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/propagation"
 	sdklog "go.opentelemetry.io/otel/sdk/log"
 	"go.opentelemetry.io/otel/sdk/metric"
@@ -23,6 +28,7 @@ type OTelConfig struct {
 	ServiceVersion string
 	Environment    string
 	OTelEndpoint   string // OTel Collector endpoint
+	Prometheus     bool   // Bật endpoint /metrics cho Prometheus scrape, song song với OTLP
 }
 
 type OTelShutdown func(context.Context) error
@@ -30,6 +36,9 @@ type OTelShutdown func(context.Context) error
 // Global logger provider for slog bridge
 var globalLoggerProvider *sdklog.LoggerProvider
 
+// Registry chứa metrics xuất qua Prometheus, nil nếu không bật
+var prometheusRegistry *prometheus.Registry
+
 // InitOTel khởi tạo OpenTelemetry với tracing, metrics, và logs
 func InitOTel(ctx context.Context, cfg OTelConfig) (OTelShutdown, error) {
 	res, err := resource.New(ctx,
@@ -51,7 +60,7 @@ func InitOTel(ctx context.Context, cfg OTelConfig) (OTelShutdown, error) {
 	otel.SetTracerProvider(tracerProvider)
 
 	// Setup Meter Provider
-	meterProvider, err := setupMeterProvider(ctx, res, cfg.OTelEndpoint)
+	meterProvider, err := setupMeterProvider(ctx, res, cfg.OTelEndpoint, cfg.Prometheus)
 	if err != nil {
 		return nil, err
 	}
@@ -123,8 +132,8 @@ func setupTracerProvider(ctx context.Context, res *resource.Resource, endpoint s
 	return tp, nil
 }
 
-// setupMeterProvider tạo MeterProvider với OTLP exporter
-func setupMeterProvider(ctx context.Context, res *resource.Resource, endpoint string) (*metric.MeterProvider, error) {
+// setupMeterProvider tạo MeterProvider với OTLP exporter, thêm reader Prometheus nếu được bật
+func setupMeterProvider(ctx context.Context, res *resource.Resource, endpoint string, withPrometheus bool) (*metric.MeterProvider, error) {
 	metricExporter, err := otlpmetricgrpc.New(ctx,
 		otlpmetricgrpc.WithEndpoint(endpoint),
 		otlpmetricgrpc.WithInsecure(), // Sử dụng insecure cho local development
@@ -133,10 +142,26 @@ func setupMeterProvider(ctx context.Context, res *resource.Resource, endpoint st
 		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
 	}
 
-	mp := metric.NewMeterProvider(
+	opts := []metric.Option{
 		metric.WithReader(metric.NewPeriodicReader(metricExporter)),
 		metric.WithResource(res),
-	)
+	}
+
+	if withPrometheus {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+		promExporter, err := otelprom.New(otelprom.WithRegisterer(registry))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create prometheus exporter: %w", err)
+		}
+		opts = append(opts, metric.WithReader(promExporter))
+		prometheusRegistry = registry
+	}
+
+	mp := metric.NewMeterProvider(opts...)
 
 	return mp, nil
 }
@@ -163,3 +188,11 @@ func setupLoggerProvider(ctx context.Context, res *resource.Resource, endpoint s
 func GetLoggerProvider() *sdklog.LoggerProvider {
 	return globalLoggerProvider
 }
+
+// PrometheusHandler trả về http.Handler cho Prometheus scrape, nil nếu Prometheus không được bật
+func PrometheusHandler() http.Handler {
+	if prometheusRegistry == nil {
+		return nil
+	}
+	return promhttp.HandlerFor(prometheusRegistry, promhttp.HandlerOpts{})
+}
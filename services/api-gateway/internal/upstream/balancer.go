@@ -0,0 +1,348 @@
+package upstream
+
+import (
+	"api_gateway/internal/routes"
+	"hash/fnv"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// virtualNodes là số điểm trên hash ring của mỗi endpoint
+const virtualNodes = 100
+
+// Endpoint là một instance của upstream. Endpoint được dùng chung giữa các route có cùng URL
+// nên số connection đang mở và trạng thái health được tính theo instance.
+type Endpoint struct {
+	URL string
+
+	healthURL string
+	healthy   atomic.Bool
+	active    atomic.Int64
+
+	// Chỉ health checker ghi, snapshot đọc
+	mu                   sync.Mutex
+	consecutiveSuccesses int
+	consecutiveFailures  int
+	lastCheckedAt        time.Time
+	lastError            string
+}
+
+// EndpointSnapshot là trạng thái của endpoint, dùng cho admin endpoint
+type EndpointSnapshot struct {
+	URL               string    `json:"url"`
+	Healthy           bool      `json:"healthy"`
+	ActiveConnections int64     `json:"active_connections"`
+	HealthURL         string    `json:"health_url,omitempty"`
+	LastCheckedAt     time.Time `json:"last_checked_at,omitempty"`
+	LastError         string    `json:"last_error,omitempty"`
+}
+
+func newEndpoint(rawURL string) *Endpoint {
+	e := &Endpoint{URL: rawURL}
+	e.healthy.Store(true)
+	return e
+}
+
+// Healthy trả về false khi endpoint đã bị health check loại ra
+func (e *Endpoint) Healthy() bool {
+	return e.healthy.Load()
+}
+
+// Release giảm số connection đang mở, phải gọi đúng một lần cho mỗi lần Pick
+func (e *Endpoint) Release() {
+	e.active.Add(-1)
+}
+
+func (e *Endpoint) snapshot() EndpointSnapshot {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return EndpointSnapshot{
+		URL:               e.URL,
+		Healthy:           e.Healthy(),
+		ActiveConnections: e.active.Load(),
+		HealthURL:         e.healthURL,
+		LastCheckedAt:     e.lastCheckedAt,
+		LastError:         e.lastError,
+	}
+}
+
+type ringEntry struct {
+	hash     uint64
+	endpoint *Endpoint
+}
+
+// pool là danh sách endpoint của một route cùng chiến lược load balancing
+type pool struct {
+	strategy  routes.LoadBalancer
+	endpoints []*Endpoint
+	next      atomic.Uint64
+	ring      []ringEntry
+}
+
+func newPool(strategy routes.LoadBalancer, endpoints []*Endpoint) *pool {
+	p := &pool{strategy: strategy, endpoints: endpoints}
+	if strategy == routes.ConsistentHash {
+		// Ring chứa mọi endpoint kể cả unhealthy: endpoint bị loại chỉ làm dịch chuyển key của chính nó
+		for _, e := range endpoints {
+			for i := 0; i < virtualNodes; i++ {
+				p.ring = append(p.ring, ringEntry{hash: hashKey(e.URL + "#" + strconv.Itoa(i)), endpoint: e})
+			}
+		}
+		sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	}
+	return p
+}
+
+// pick chọn endpoint theo chiến lược của pool. Nếu mọi endpoint đều unhealthy,
+// vẫn chọn trong toàn bộ danh sách thay vì từ chối request.
+func (p *pool) pick(key string) *Endpoint {
+	if len(p.endpoints) == 1 {
+		return p.endpoints[0]
+	}
+
+	switch p.strategy {
+	case routes.ConsistentHash:
+		if key != "" {
+			return p.pickHash(key)
+		}
+	case routes.LeastConnections:
+		return p.pickLeastConnections()
+	}
+	return p.pickRoundRobin()
+}
+
+func (p *pool) pickRoundRobin() *Endpoint {
+	n := uint64(len(p.endpoints))
+	start := p.next.Add(1) - 1
+	for i := uint64(0); i < n; i++ {
+		if e := p.endpoints[(start+i)%n]; e.Healthy() {
+			return e
+		}
+	}
+	return p.endpoints[start%n]
+}
+
+func (p *pool) pickLeastConnections() *Endpoint {
+	// Bắt đầu từ vị trí xoay vòng để chia đều khi số connection bằng nhau
+	n := uint64(len(p.endpoints))
+	start := p.next.Add(1) - 1
+	var best *Endpoint
+	for i := uint64(0); i < n; i++ {
+		e := p.endpoints[(start+i)%n]
+		if !e.Healthy() {
+			continue
+		}
+		if best == nil || e.active.Load() < best.active.Load() {
+			best = e
+		}
+	}
+	if best == nil {
+		return p.endpoints[start%n]
+	}
+	return best
+}
+
+func (p *pool) pickHash(key string) *Endpoint {
+	h := hashKey(key)
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	for j := 0; j < len(p.ring); j++ {
+		if e := p.ring[(i+j)%len(p.ring)].endpoint; e.Healthy() {
+			return e
+		}
+	}
+	return p.ring[i%len(p.ring)].endpoint
+}
+
+// hashKey là FNV-1a cộng bước trộn bit của splitmix64 để key gần giống nhau (user:1, user:2...)
+// được phân bố đều trên ring
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// HealthSettings cấu hình active health check
+type HealthSettings struct {
+	Interval           time.Duration
+	Timeout            time.Duration
+	UnhealthyThreshold int // số lần check lỗi liên tiếp để loại endpoint
+	HealthyThreshold   int // số lần check thành công liên tiếp để đưa endpoint trở lại
+}
+
+// poolKey là route và target (canary/shadow) của pool; target nil là urls của route
+type poolKey struct {
+	route  *routes.Route
+	target *routes.Target
+}
+
+// Balancer chọn endpoint cho route theo chiến lược load balancing và health check
+type Balancer struct {
+	settings HealthSettings
+
+	mu        sync.RWMutex
+	pools     map[poolKey]*pool
+	endpoints map[string]*Endpoint
+}
+
+// NewBalancer tạo balancer cho bảng route ban đầu
+func NewBalancer(table *routes.Table, settings HealthSettings) *Balancer {
+	b := &Balancer{
+		settings:  settings,
+		pools:     map[poolKey]*pool{},
+		endpoints: map[string]*Endpoint{},
+	}
+	b.Sync(table)
+	return b
+}
+
+// Sync tạo lại pool cho bảng route mới. Endpoint có cùng URL được giữ lại
+// cùng trạng thái health và số connection đang mở.
+func (b *Balancer) Sync(table *routes.Table) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pools := make(map[poolKey]*pool, len(table.Routes))
+	endpoints := map[string]*Endpoint{}
+	for _, r := range table.Routes {
+		for _, key := range poolKeys(r) {
+			pools[key] = newPool(r.LoadBalancer, b.endpointsFor(key, endpoints))
+		}
+	}
+
+	// Endpoint không còn health check thì luôn được coi là healthy
+	for _, e := range endpoints {
+		e.mu.Lock()
+		if e.healthURL == "" {
+			e.healthy.Store(true)
+			e.consecutiveFailures = 0
+			e.lastError = ""
+		}
+		e.mu.Unlock()
+	}
+
+	for rawURL := range b.endpoints {
+		if _, ok := endpoints[rawURL]; !ok {
+			slog.Info("Upstream endpoint removed", "url", rawURL)
+		}
+	}
+	b.pools = pools
+	b.endpoints = endpoints
+}
+
+// poolKeys trả về pool của route: urls của route, từng target và shadow
+func poolKeys(r *routes.Route) []poolKey {
+	keys := []poolKey{{route: r}}
+	for _, t := range r.Targets {
+		keys = append(keys, poolKey{route: r, target: t})
+	}
+	if r.Shadow != nil {
+		keys = append(keys, poolKey{route: r, target: r.Shadow.Target()})
+	}
+	return keys
+}
+
+// endpointsFor trả về endpoint của pool, tái sử dụng endpoint cũ nếu có. Caller phải giữ lock.
+func (b *Balancer) endpointsFor(key poolKey, seen map[string]*Endpoint) []*Endpoint {
+	r, urls := key.route, key.route.URLs
+	if key.target != nil {
+		urls = key.target.URLs
+	}
+	list := make([]*Endpoint, 0, len(urls))
+	for _, rawURL := range urls {
+		e, ok := seen[rawURL]
+		if !ok {
+			if e, ok = b.endpoints[rawURL]; !ok {
+				e = newEndpoint(rawURL)
+			}
+			e.mu.Lock()
+			e.healthURL = ""
+			e.mu.Unlock()
+			seen[rawURL] = e
+		}
+		if r.HealthCheck != nil {
+			e.mu.Lock()
+			if e.healthURL == "" {
+				e.healthURL = healthURL(rawURL, r.HealthCheck.Path)
+			}
+			e.mu.Unlock()
+		}
+		list = append(list, e)
+	}
+	return list
+}
+
+// Pick chọn endpoint cho request. key là khoá affinity cho consistent hashing (userID hoặc IP).
+// Caller phải gọi Release khi request/kết nối kết thúc.
+func (b *Balancer) Pick(route *routes.Route, key string) *Endpoint {
+	return b.PickTarget(route, nil, key)
+}
+
+// PickTarget chọn endpoint trong target (canary hoặc shadow) của route; target nil tương đương Pick
+func (b *Balancer) PickTarget(route *routes.Route, target *routes.Target, key string) *Endpoint {
+	pk := poolKey{route: route, target: target}
+	b.mu.RLock()
+	p, ok := b.pools[pk]
+	b.mu.RUnlock()
+
+	if !ok {
+		// Request đang dùng route của bảng route trước khi reload
+		b.mu.Lock()
+		if p, ok = b.pools[pk]; !ok {
+			p = newPool(route.LoadBalancer, b.endpointsFor(pk, b.endpoints))
+			b.pools[pk] = p
+		}
+		b.mu.Unlock()
+	}
+
+	e := p.pick(key)
+	e.active.Add(1)
+	return e
+}
+
+// Snapshots trả về trạng thái của mọi endpoint, sắp xếp theo URL
+func (b *Balancer) Snapshots() []EndpointSnapshot {
+	b.mu.RLock()
+	endpoints := make([]*Endpoint, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		endpoints = append(endpoints, e)
+	}
+	b.mu.RUnlock()
+
+	snapshots := make([]EndpointSnapshot, 0, len(endpoints))
+	for _, e := range endpoints {
+		snapshots = append(snapshots, e.snapshot())
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].URL < snapshots[j].URL
+	})
+	return snapshots
+}
+
+// healthURL build URL health check trên host của endpoint (ws/wss được đổi thành http/https)
+func healthURL(rawURL, path string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	u.Path = path
+	u.RawPath = ""
+	u.RawQuery = ""
+	return u.String()
+}
@@ -0,0 +1,231 @@
+package upstream
+
+import (
+	"api_gateway/internal/routes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const testRoutes = `
+routes:
+  - name: products
+    path_prefix: /products
+    upstream: product-service
+    urls: ["http://product-1:8083", "http://product-2:8083", "http://product-3:8083"]
+    load_balancer: %s
+    health_check:
+      path: /health
+`
+
+func newTestBalancer(t *testing.T, strategy routes.LoadBalancer) (*Balancer, *routes.Route) {
+	t.Helper()
+	table, err := routes.Parse("routes.yaml", []byte(fmt.Sprintf(testRoutes, strategy)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, _ := table.Lookup("products")
+	return NewBalancer(table, HealthSettings{UnhealthyThreshold: 2, HealthyThreshold: 2}), route
+}
+
+// eject đánh dấu endpoint unhealthy như health checker
+func eject(b *Balancer, rawURL string) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	b.endpoints[rawURL].healthy.Store(false)
+}
+
+func pick(b *Balancer, route *routes.Route, key string) string {
+	e := b.Pick(route, key)
+	e.Release()
+	return e.URL
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	tests := []struct {
+		name    string
+		ejected []string
+		want    []string
+	}{
+		{
+			name: "all healthy",
+			want: []string{"http://product-1:8083", "http://product-2:8083", "http://product-3:8083", "http://product-1:8083"},
+		},
+		{
+			name:    "skips unhealthy endpoint",
+			ejected: []string{"http://product-2:8083"},
+			want:    []string{"http://product-1:8083", "http://product-3:8083", "http://product-3:8083", "http://product-1:8083"},
+		},
+		{
+			name:    "all unhealthy keeps rotating",
+			ejected: []string{"http://product-1:8083", "http://product-2:8083", "http://product-3:8083"},
+			want:    []string{"http://product-1:8083", "http://product-2:8083", "http://product-3:8083"},
+		},
+	}
+	for _, tt := range tests {
+		b, route := newTestBalancer(t, routes.RoundRobin)
+		for _, u := range tt.ejected {
+			eject(b, u)
+		}
+		for i, want := range tt.want {
+			if got := pick(b, route, ""); got != want {
+				t.Errorf("%s: pick %d = %s, want %s", tt.name, i+1, got, want)
+			}
+		}
+	}
+}
+
+func TestPickLeastConnections(t *testing.T) {
+	b, route := newTestBalancer(t, routes.LeastConnections)
+
+	// Giữ connection trên product-1 và product-3
+	held := []*Endpoint{b.Pick(route, ""), b.Pick(route, ""), b.Pick(route, "")}
+	held[1].Release()
+	if got := pick(b, route, ""); got != "http://product-2:8083" {
+		t.Errorf("pick = %s, want the endpoint without active connections", got)
+	}
+
+	// Endpoint unhealthy không được chọn dù có ít connection nhất
+	eject(b, "http://product-2:8083")
+	held[0].Release()
+	if got := pick(b, route, ""); got != "http://product-1:8083" {
+		t.Errorf("pick = %s, want the least loaded healthy endpoint", got)
+	}
+	held[2].Release()
+
+	for _, s := range b.Snapshots() {
+		if s.ActiveConnections != 0 {
+			t.Errorf("%s has %d active connections after release, want 0", s.URL, s.ActiveConnections)
+		}
+	}
+}
+
+func TestPickConsistentHash(t *testing.T) {
+	b, route := newTestBalancer(t, routes.ConsistentHash)
+
+	keys := make([]string, 200)
+	before := map[string]string{}
+	counts := map[string]int{}
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user:%d", i)
+		before[keys[i]] = pick(b, route, keys[i])
+		counts[before[keys[i]]]++
+	}
+	for _, u := range route.URLs {
+		if counts[u] == 0 {
+			t.Errorf("no key mapped to %s", u)
+		}
+	}
+
+	// Cùng key luôn vào cùng endpoint
+	for _, key := range keys {
+		if got := pick(b, route, key); got != before[key] {
+			t.Errorf("%s moved from %s to %s", key, before[key], got)
+		}
+	}
+
+	// Loại một endpoint chỉ dịch chuyển key của chính endpoint đó
+	ejected := "http://product-2:8083"
+	eject(b, ejected)
+	for _, key := range keys {
+		got := pick(b, route, key)
+		if got == ejected {
+			t.Errorf("%s still mapped to ejected endpoint", key)
+		}
+		if before[key] != ejected && got != before[key] {
+			t.Errorf("%s moved from %s to %s after another endpoint was ejected", key, before[key], got)
+		}
+	}
+}
+
+func TestSyncKeepsEndpointState(t *testing.T) {
+	b, _ := newTestBalancer(t, routes.RoundRobin)
+	eject(b, "http://product-1:8083")
+
+	table, err := routes.Parse("routes.yaml", []byte(`
+routes:
+  - name: products
+    path_prefix: /products
+    upstream: product-service
+    urls: ["http://product-1:8083", "http://product-4:8083"]
+    health_check:
+      path: /health
+  - name: search
+    path_prefix: /search
+    upstream: search-service
+    urls: ["http://product-2:8083"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Sync(table)
+
+	want := map[string]EndpointSnapshot{
+		// Endpoint cũ giữ trạng thái health
+		"http://product-1:8083": {Healthy: false, HealthURL: "http://product-1:8083/health"},
+		"http://product-4:8083": {Healthy: true, HealthURL: "http://product-4:8083/health"},
+		// Route không còn health check: endpoint không được check nữa
+		"http://product-2:8083": {Healthy: true},
+	}
+	snapshots := b.Snapshots()
+	if len(snapshots) != len(want) {
+		t.Fatalf("Snapshots() = %+v, want %d endpoints", snapshots, len(want))
+	}
+	for _, s := range snapshots {
+		w, ok := want[s.URL]
+		if !ok {
+			t.Errorf("unexpected endpoint %s", s.URL)
+			continue
+		}
+		if s.Healthy != w.Healthy || s.HealthURL != w.HealthURL {
+			t.Errorf("%s: healthy %v health_url %q, want %v %q", s.URL, s.Healthy, s.HealthURL, w.Healthy, w.HealthURL)
+		}
+	}
+}
+
+func TestRecordThresholds(t *testing.T) {
+	b, _ := newTestBalancer(t, routes.RoundRobin)
+	e := newEndpoint("http://product-1:8083")
+	errDown := errors.New("connection refused")
+
+	steps := []struct {
+		err         error
+		wantHealthy bool
+	}{
+		{errDown, true}, // một lần lỗi chưa đủ ngưỡng
+		{nil, true},
+		{errDown, true},
+		{errDown, false}, // lỗi 2 lần liên tiếp
+		{nil, false},
+		{errDown, false},
+		{nil, false},
+		{nil, true}, // thành công 2 lần liên tiếp
+	}
+	for i, s := range steps {
+		b.record(e, s.err)
+		if e.Healthy() != s.wantHealthy {
+			t.Errorf("step %d: healthy = %v, want %v", i+1, e.Healthy(), s.wantHealthy)
+		}
+	}
+	if snap := e.snapshot(); snap.LastError != "" || snap.LastCheckedAt.IsZero() {
+		t.Errorf("snapshot = %+v, want last check recorded without error", snap)
+	}
+}
+
+func TestHealthURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		path string
+		want string
+	}{
+		{"http://product-service:8083", "/health", "http://product-service:8083/health"},
+		{"https://product-service/api?x=1", "/actuator/health", "https://product-service/actuator/health"},
+		{"ws://chat-service:8086/ws", "/health", "http://chat-service:8086/health"},
+		{"wss://chat-service/ws", "/health", "https://chat-service/health"},
+	}
+	for _, tt := range tests {
+		if got := healthURL(tt.raw, tt.path); got != tt.want {
+			t.Errorf("healthURL(%q, %q) = %q, want %q", tt.raw, tt.path, got, tt.want)
+		}
+	}
+}
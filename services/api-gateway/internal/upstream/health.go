@@ -0,0 +1,124 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StartHealthChecks gọi health check của các endpoint định kỳ cho tới khi ctx bị huỷ.
+// Endpoint lỗi liên tiếp UnhealthyThreshold lần bị loại khỏi load balancing,
+// thành công liên tiếp HealthyThreshold lần thì được đưa trở lại.
+func (b *Balancer) StartHealthChecks(ctx context.Context) {
+	if b.settings.Interval <= 0 {
+		return
+	}
+
+	client := &http.Client{
+		Timeout: b.settings.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	go func() {
+		ticker := time.NewTicker(b.settings.Interval)
+		defer ticker.Stop()
+
+		for {
+			b.checkAll(ctx, client)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (b *Balancer) checkAll(ctx context.Context, client *http.Client) {
+	// Nhiều endpoint (ví dụ HTTP và WebSocket của cùng service) có thể chung health URL
+	targets := map[string][]*Endpoint{}
+	b.mu.RLock()
+	for _, e := range b.endpoints {
+		e.mu.Lock()
+		if e.healthURL != "" {
+			targets[e.healthURL] = append(targets[e.healthURL], e)
+		}
+		e.mu.Unlock()
+	}
+	b.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for target, endpoints := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := probe(ctx, client, target)
+			if ctx.Err() != nil {
+				return
+			}
+			for _, e := range endpoints {
+				b.record(e, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func probe(ctx context.Context, client *http.Client, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "api-gateway-health-check")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (b *Balancer) record(e *Endpoint, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastCheckedAt = time.Now()
+	if err != nil {
+		e.lastError = err.Error()
+		e.consecutiveSuccesses = 0
+		e.consecutiveFailures++
+		if e.Healthy() && e.consecutiveFailures >= b.settings.UnhealthyThreshold {
+			e.healthy.Store(false)
+			slog.Warn("Upstream endpoint ejected",
+				slog.String("url", e.URL),
+				slog.String("health_url", e.healthURL),
+				slog.Int("consecutive_failures", e.consecutiveFailures),
+				slog.String("error", err.Error()),
+			)
+		}
+		return
+	}
+
+	e.lastError = ""
+	e.consecutiveFailures = 0
+	e.consecutiveSuccesses++
+	if !e.Healthy() && e.consecutiveSuccesses >= b.settings.HealthyThreshold {
+		e.healthy.Store(true)
+		slog.Info("Upstream endpoint restored",
+			slog.String("url", e.URL),
+			slog.String("health_url", e.healthURL),
+		)
+	}
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopHeaders là các header hop-by-hop (RFC 7230 6.1), không được forward qua proxy
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopHeaders xoá header hop-by-hop khỏi request/response đi qua gateway
+func RemoveHopHeaders(header http.Header) {
+	// Header được liệt kê trong Connection cũng là hop-by-hop
+	for _, value := range header.Values("Connection") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				header.Del(field)
+			}
+		}
+	}
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+}
@@ -2,8 +2,10 @@ package com.Online_Auction.auth_service.controller;
 
 import java.io.IOException;
 import java.security.GeneralSecurityException;
+import java.util.Map;
 
 import org.springframework.http.ResponseEntity;
+import org.springframework.web.bind.annotation.GetMapping;
 import org.springframework.web.bind.annotation.PostMapping;
 import org.springframework.web.bind.annotation.RequestBody;
 import org.springframework.web.bind.annotation.RequestMapping;
@@ -26,9 +28,17 @@ import com.Online_Auction.auth_service.service.AuthService;
 public class AuthController {
 
     private final AuthService authService;
+    private final JwtUtils jwtUtils;
 
-    public AuthController(AuthService authService, RestTemplate restTemplate) {
+    public AuthController(AuthService authService, RestTemplate restTemplate, JwtUtils jwtUtils) {
         this.authService = authService;
+        this.jwtUtils = jwtUtils;
+    }
+
+    // JWKS: public key verify token do auth-service ký (api-gateway tải theo JWKS_URL)
+    @GetMapping("/.well-known/jwks.json")
+    public ResponseEntity<Map<String, Object>> jwks() {
+        return ResponseEntity.ok(jwtUtils.getJwks());
     }
 
     @PostMapping("/register")
@@ -54,14 +64,14 @@ public class AuthController {
 
         JwtResponse jwtResponse = new JwtResponse(
                 true,
-                JwtUtils.generateAccessToken(user.getId(), user.getEmail(), user.getUserRole()),
-                JwtUtils.generateRefreshToken(user.getId()));
+                jwtUtils.generateAccessToken(user.getId(), user.getEmail(), user.getUserRole()),
+                jwtUtils.generateRefreshToken(user.getId()));
         return ResponseEntity.ok(jwtResponse);
     }
 
     @PostMapping("/validate-jwt")
     public ResponseEntity<ValidateJwtResponse> validateJwt(@RequestBody ValidateJwtRequest request) {
-        boolean valid = JwtUtils.validateToken(request.getToken());
+        boolean valid = jwtUtils.validateToken(request.getToken());
 
         ValidateJwtResponse response = new ValidateJwtResponse(valid);
 
@@ -86,8 +96,8 @@ public class AuthController {
         }
         JwtResponse jwtResponse = new JwtResponse(
                 true,
-                JwtUtils.generateAccessToken(user.getId(), user.getEmail(), user.getUserRole()),
-                JwtUtils.generateRefreshToken(user.getId()));
+                jwtUtils.generateAccessToken(user.getId(), user.getEmail(), user.getUserRole()),
+                jwtUtils.generateRefreshToken(user.getId()));
         return ResponseEntity.ok(jwtResponse);
     }
 
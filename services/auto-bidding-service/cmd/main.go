@@ -9,11 +9,14 @@ import (
 	"auto-bidding-service/internal/middleware"
 	"auto-bidding-service/internal/repository"
 	"auto-bidding-service/internal/service"
+	"auto-bidding-service/internal/stream"
 	"auto-bidding-service/internal/telemetry"
+	"auto-bidding-service/internal/utils"
 	"context"
 	"log"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	fiberlogger "github.com/gofiber/fiber/v2/middleware/logger"
@@ -46,14 +49,56 @@ func main() {
 	config.InitSchema(db)
 
 	autoBidRepo := repository.NewAutoBidRepository(db)
-	biddingClient := client.NewBiddingServiceClient(os.Getenv("BIDDING_SERVICE_URL"))
-	productClient := client.NewProductServiceClient(os.Getenv("PRODUCT_SERVICE_URL"))
-	autoBidService := service.NewAutoBidService(autoBidRepo, biddingClient, productClient)
+	// Auto-bid được đặt bằng danh tính RS256 của service thay vì token của user
+	signer, err := utils.NewServiceSigner(cfg.JWTPrivateKey, cfg.ServiceName)
+	if err != nil {
+		log.Fatalf("Service identity error: %v", err)
+	}
+	biddingClient := client.NewBiddingServiceClient(os.Getenv("BIDDING_SERVICE_URL"), signer, cfg.BiddingServiceAudience, cfg.AuthInternalSecret)
+	productLocation := time.Local
+	if cfg.ProductServiceTimezone != "" {
+		productLocation, err = time.LoadLocation(cfg.ProductServiceTimezone)
+		if err != nil {
+			log.Fatalf("Invalid PRODUCT_SERVICE_TIMEZONE: %v", err)
+		}
+	}
+	productClient := client.NewProductServiceClient(os.Getenv("PRODUCT_SERVICE_URL"), cfg.AuthInternalSecret, productLocation)
+	productLock := repository.NewProductLock(db)
+	autoBidService := service.NewAutoBidService(autoBidRepo, productLock, biddingClient, productClient)
 	autoBidHandler := handlers.NewAutoBidHandler(autoBidService)
 
+	// Chốt trạng thái WON / OUTBID / EXPIRED của auto-bid khi phiên đấu giá kết thúc
+	if cfg.FinalizeInterval > 0 {
+		finalizer := service.NewAuctionFinalizer(autoBidRepo, productLock, productClient, time.Duration(cfg.FinalizeInterval)*time.Second)
+		finalizer.Start(ctx)
+		slog.Info("Auction finalizer started", "interval_seconds", cfg.FinalizeInterval)
+	}
+
+	// Auto-bidding được trigger bởi event bid.placed trên Redis Stream (consumer group, at-least-once)
+	if cfg.BidStreamEnabled {
+		consumer, err := stream.NewConsumer(cfg)
+		if err != nil {
+			log.Fatalf("Redis error: %v", err)
+		}
+		defer consumer.Close()
+
+		go func() {
+			if err := consumer.Run(ctx, autoBidService.HandleBidPlaced); err != nil {
+				slog.Error("Bid stream consumer stopped", "error", err)
+			}
+		}()
+		slog.Info("Bid stream consumer started",
+			"stream", cfg.RedisStreamKey,
+			"group", cfg.RedisConsumerGroup,
+			"consumer", cfg.RedisConsumerName)
+	}
+
 	app := fiber.New()
 	app.Use(recover.New())
-	app.Use(fiberlogger.New())
+	app.Use(middleware.RequestIDMiddleware())
+	app.Use(fiberlogger.New(fiberlogger.Config{
+		Format: "[${time}] ${status} - ${latency} ${method} ${path} request_id=${locals:requestID}\n",
+	}))
 	
 	// CORS middleware - Must handle OPTIONS properly
 	app.Use(func(c *fiber.Ctx) error {
@@ -80,7 +125,6 @@ func main() {
 
 	autoBids := api.Group("/auto-bids")
 	autoBids.Post("/", middleware.AuthMiddleware(cfg), autoBidHandler.CreateAutoBid)
-	autoBids.Post("/trigger", autoBidHandler.TriggerAutoBidding)
 	autoBids.Get("/my", middleware.AuthMiddleware(cfg), autoBidHandler.GetMyAutoBids)
 	autoBids.Get("/:id", middleware.AuthMiddleware(cfg), autoBidHandler.GetAutoBidByID)
 	autoBids.Post("/:id/cancel", middleware.AuthMiddleware(cfg), autoBidHandler.CancelAutoBid)
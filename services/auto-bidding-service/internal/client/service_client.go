@@ -1,27 +1,53 @@
 package client
 
 import (
+	"auto-bidding-service/internal/logger"
+	"auto-bidding-service/internal/utils"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
-// BiddingServiceClient là client để gọi API của bidding-service
+// requestIDHeader là header mang request ID giữa gateway và các service
+const requestIDHeader = "X-Request-ID"
+
+// setCorrelationHeaders gửi kèm request ID và trace context của request hiện tại
+// để log của service được gọi nối được với log của auto-bidding-service
+func setCorrelationHeaders(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if requestID := logger.RequestIDFromContext(ctx); requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+}
+
+// BiddingServiceClient là client để gọi API của bidding-service bằng danh tính của auto-bidding-service
 type BiddingServiceClient struct {
-	baseURL    string
-	httpClient *http.Client
+	baseURL        string
+	httpClient     *http.Client
+	signer         *utils.ServiceSigner
+	audience       string
+	internalSecret string
 }
 
-// NewBiddingServiceClient tạo client mới
-func NewBiddingServiceClient(baseURL string) *BiddingServiceClient {
+// NewBiddingServiceClient tạo client mới; signer ký JWT RS256 gửi tới audience (bidding-service),
+// internalSecret là key X-Auth-Internal-Service mà bidding-service kiểm tra với mọi request nội bộ
+func NewBiddingServiceClient(baseURL string, signer *utils.ServiceSigner, audience, internalSecret string) *BiddingServiceClient {
 	return &BiddingServiceClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		signer:         signer,
+		audience:       audience,
+		internalSecret: internalSecret,
 	}
 }
 
@@ -39,8 +65,10 @@ type BidResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-// PlaceBid gọi API bidding-service để đặt giá
-func (c *BiddingServiceClient) PlaceBid(productID int64, bidderID int64, amount float64, requestID string, userToken string) (*BidResponse, error) {
+// PlaceBid gọi endpoint nội bộ của bidding-service để đặt giá thay bidderID.
+// Bidder được mang trong claim bidder_id của JWT (header X-Internal-JWT) thay vì token của user,
+// nên auto-bid vẫn đặt được giá khi token của user đã hết hạn.
+func (c *BiddingServiceClient) PlaceBid(ctx context.Context, productID int64, bidderID int64, amount float64, requestID string) (*BidResponse, error) {
 	reqBody := BidRequest{
 		ProductID: productID,
 		Amount:    amount,
@@ -52,13 +80,20 @@ func (c *BiddingServiceClient) PlaceBid(productID int64, bidderID int64, amount
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", c.baseURL+"/bids", bytes.NewBuffer(jsonData))
+	serviceJWT, err := c.signer.BidderToken(c.audience, bidderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign service token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/bids/internal", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-User-Token", userToken)
+	req.Header.Set("X-Auth-Internal-Service", c.internalSecret)
+	req.Header.Set("X-Internal-JWT", serviceJWT)
+	setCorrelationHeaders(ctx, req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -73,6 +108,10 @@ func (c *BiddingServiceClient) PlaceBid(productID int64, bidderID int64, amount
 
 	var bidResp BidResponse
 	if err := json.Unmarshal(body, &bidResp); err != nil {
+		// 401/403 của Spring Security không có body JSON
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("bidding-service returned status %d", resp.StatusCode)
+		}
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
@@ -81,45 +120,109 @@ func (c *BiddingServiceClient) PlaceBid(productID int64, bidderID int64, amount
 
 // ProductServiceClient là client để gọi API của product-service
 type ProductServiceClient struct {
-	baseURL    string
-	httpClient *http.Client
+	baseURL        string
+	httpClient     *http.Client
+	internalSecret string
+	location       *time.Location
 }
 
-// NewProductServiceClient tạo client mới
-func NewProductServiceClient(baseURL string) *ProductServiceClient {
+// NewProductServiceClient tạo client mới, internalSecret là key X-Auth-Internal-Service mà product-service kiểm tra.
+// location là múi giờ của product-service: endAt là LocalDateTime (không có múi giờ), nil là múi giờ của máy.
+func NewProductServiceClient(baseURL, internalSecret string, location *time.Location) *ProductServiceClient {
+	if location == nil {
+		location = time.Local
+	}
 	return &ProductServiceClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		internalSecret: internalSecret,
+		location:       location,
 	}
 }
 
-// ProductInfo là thông tin sản phẩm từ product-service
+// ErrProductNotFound là lỗi khi product-service không tìm thấy sản phẩm
+var ErrProductNotFound = errors.New("product not found")
+
+// ProductInfo là thông tin sản phẩm cần cho auto-bidding
 type ProductInfo struct {
-	ID            int64   `json:"id"`
-	Name          string  `json:"name"`
-	CurrentPrice  float64 `json:"current_price"`
-	StepPrice     float64 `json:"step_price"`
-	HighestBidder int64   `json:"highest_bidder"`
-	Status        string  `json:"status"`
+	ID            int64
+	Name          string
+	CurrentPrice  float64
+	StepPrice     float64
+	HighestBidder int64     // 0 nếu chưa có ai ra giá
+	EndAt         time.Time // Thời điểm kết thúc đấu giá, zero nếu product-service không trả về
+}
+
+// Ended cho biết phiên đấu giá đã kết thúc (đã tới endAt). product-service không có trạng thái sản phẩm,
+// endAt là nguồn duy nhất; sản phẩm không có endAt được coi là chưa kết thúc.
+func (p *ProductInfo) Ended(now time.Time) bool {
+	return !p.EndAt.IsZero() && !now.Before(p.EndAt)
+}
+
+// parseEndAt đọc endAt của ProductDTO: LocalDateTime ("2006-01-02T15:04:05", có thể có phần lẻ giây)
+// theo múi giờ của product-service, hoặc RFC 3339 nếu có múi giờ
+func parseEndAt(value string, location *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05.999999999", value, location)
+}
+
+// productDTO là response của GET /api/products/{id}: ProductDTO của product-service (JSON camelCase, không có envelope)
+type productDTO struct {
+	ID            int64    `json:"id"`
+	Name          string   `json:"name"`
+	StartingPrice *float64 `json:"startingPrice"`
+	CurrentPrice  *float64 `json:"currentPrice"`
+	StepPrice     *float64 `json:"stepPrice"`
+	EndAt         string   `json:"endAt"`
+	HighestBidder *struct {
+		ID int64 `json:"id"`
+	} `json:"highestBidder"`
 }
 
-// ProductResponse là response từ product-service
-type ProductResponse struct {
-	Success bool         `json:"success"`
-	Message string       `json:"message"`
-	Data    *ProductInfo `json:"data,omitempty"`
+func (d *productDTO) toProductInfo(location *time.Location) (*ProductInfo, error) {
+	endAt, err := parseEndAt(d.EndAt, location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endAt %q: %w", d.EndAt, err)
+	}
+	info := &ProductInfo{
+		ID:    d.ID,
+		Name:  d.Name,
+		EndAt: endAt,
+	}
+	// Sản phẩm chưa có bid có thể chưa có currentPrice, giá hiện tại là giá khởi điểm
+	switch {
+	case d.CurrentPrice != nil:
+		info.CurrentPrice = *d.CurrentPrice
+	case d.StartingPrice != nil:
+		info.CurrentPrice = *d.StartingPrice
+	}
+	if d.StepPrice != nil {
+		info.StepPrice = *d.StepPrice
+	}
+	if d.HighestBidder != nil {
+		info.HighestBidder = d.HighestBidder.ID
+	}
+	return info, nil
 }
 
 // GetProduct lấy thông tin sản phẩm
-func (c *ProductServiceClient) GetProduct(productID int64) (*ProductInfo, error) {
-	url := fmt.Sprintf("%s/products/%d", c.baseURL, productID)
+func (c *ProductServiceClient) GetProduct(ctx context.Context, productID int64) (*ProductInfo, error) {
+	url := fmt.Sprintf("%s/api/products/%d", c.baseURL, productID)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-Auth-Internal-Service", c.internalSecret)
+	setCorrelationHeaders(ctx, req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -132,14 +235,20 @@ func (c *ProductServiceClient) GetProduct(productID int64) (*ProductInfo, error)
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	var prodResp ProductResponse
-	if err := json.Unmarshal(body, &prodResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrProductNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("product service error: status %d", resp.StatusCode)
 	}
 
-	if !prodResp.Success {
-		return nil, fmt.Errorf("product service error: %s", prodResp.Message)
+	var dto productDTO
+	if err := json.Unmarshal(body, &dto); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if dto.ID == 0 {
+		return nil, ErrProductNotFound
 	}
 
-	return prodResp.Data, nil
+	return dto.toProductInfo(c.location)
 }
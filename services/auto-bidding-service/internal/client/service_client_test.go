@@ -0,0 +1,233 @@
+package client
+
+import (
+	"auto-bidding-service/internal/utils"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// hcm là múi giờ của product-service trong test
+var hcm = time.FixedZone("ICT", 7*60*60)
+
+// productService trả về body cho GET /api/products/11 như product-service, kiểm tra header nội bộ
+func productService(t *testing.T, status int, body []byte) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/products/11" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("X-Auth-Internal-Service"); got != "internal-secret" {
+			t.Errorf("X-Auth-Internal-Service = %q, want %q", got, "internal-secret")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetProduct(t *testing.T) {
+	recorded, err := os.ReadFile("testdata/product_detail.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		body    []byte
+		want    *ProductInfo
+		wantErr error
+	}{
+		{
+			name:   "recorded product detail",
+			status: http.StatusOK,
+			body:   recorded,
+			want: &ProductInfo{
+				ID:            11,
+				Name:          "iPhone 11 Pro Max 256GB",
+				CurrentPrice:  11_100_000,
+				StepPrice:     100_000,
+				HighestBidder: 3,
+				EndAt:         time.Date(2025, 1, 8, 21, 30, 0, 0, hcm),
+			},
+		},
+		{
+			name:   "no bids yet uses starting price and no holder",
+			status: http.StatusOK,
+			body:   []byte(`{"id":11,"name":"iPhone 11","startingPrice":10000000.0,"currentPrice":null,"stepPrice":100000.0,"endAt":"2025-01-08T21:30:00","highestBidder":null}`),
+			want: &ProductInfo{
+				ID:           11,
+				Name:         "iPhone 11",
+				CurrentPrice: 10_000_000,
+				StepPrice:    100_000,
+				EndAt:        time.Date(2025, 1, 8, 21, 30, 0, 0, hcm),
+			},
+		},
+		{
+			name:    "not found",
+			status:  http.StatusNotFound,
+			body:    []byte(`{"timestamp":"2025-01-08T21:30:00","status":404,"error":"Not Found","path":"/api/products/11"}`),
+			wantErr: ErrProductNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := productService(t, tt.status, tt.body)
+			c := NewProductServiceClient(server.URL, "internal-secret", hcm)
+
+			got, err := c.GetProduct(context.Background(), 11)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetProduct: %v", err)
+			}
+			if got.ID != tt.want.ID || got.Name != tt.want.Name || got.CurrentPrice != tt.want.CurrentPrice ||
+				got.StepPrice != tt.want.StepPrice || got.HighestBidder != tt.want.HighestBidder || !got.EndAt.Equal(tt.want.EndAt) {
+				t.Errorf("GetProduct = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestGetProductServerError(t *testing.T) {
+	server := productService(t, http.StatusInternalServerError, []byte(`{"status":500,"error":"Internal Server Error"}`))
+	c := NewProductServiceClient(server.URL, "internal-secret", hcm)
+
+	if _, err := c.GetProduct(context.Background(), 11); err == nil || errors.Is(err, ErrProductNotFound) {
+		t.Fatalf("err = %v, want product service error", err)
+	}
+}
+
+func TestProductEnded(t *testing.T) {
+	endAt := time.Date(2025, 1, 8, 21, 30, 0, 0, hcm)
+
+	tests := []struct {
+		name    string
+		product ProductInfo
+		now     time.Time
+		want    bool
+	}{
+		{"before endAt", ProductInfo{EndAt: endAt}, endAt.Add(-time.Second), false},
+		{"at endAt", ProductInfo{EndAt: endAt}, endAt, true},
+		{"after endAt in another zone", ProductInfo{EndAt: endAt}, endAt.UTC().Add(time.Minute), true},
+		{"no endAt", ProductInfo{}, endAt, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.product.Ended(tt.now); got != tt.want {
+				t.Errorf("Ended = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEndAt(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2025-01-08T21:30:00", want: time.Date(2025, 1, 8, 21, 30, 0, 0, hcm)},
+		{value: "2025-01-08T21:30:00.123456", want: time.Date(2025, 1, 8, 21, 30, 0, 123456000, hcm)},
+		{value: "2025-01-08T14:30:00Z", want: time.Date(2025, 1, 8, 14, 30, 0, 0, time.UTC)},
+		{value: ""},
+		{value: "08/01/2025 21:30", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseEndAt(tt.value, hcm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("parseEndAt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testSigner tạo ServiceSigner với private key RSA sinh ngẫu nhiên
+func testSigner(t *testing.T) *utils.ServiceSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := utils.NewServiceSigner(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), "auto-bidding-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestPlaceBid(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantSuccess bool
+		wantErr     bool
+	}{
+		{name: "accepted", status: http.StatusOK, body: `{"success":true,"message":"Bid placed successfully"}`, wantSuccess: true},
+		{name: "rejected", status: http.StatusBadRequest, body: `{"success":false,"message":"Auction has already ended"}`},
+		{name: "forbidden without body", status: http.StatusForbidden, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// Route của BidController.placeBidOnBehalf
+				if r.Method != http.MethodPost || r.URL.Path != "/api/bids/internal" {
+					http.NotFound(w, r)
+					return
+				}
+				if got := r.Header.Get("X-Auth-Internal-Service"); got != "internal-secret" {
+					t.Errorf("X-Auth-Internal-Service = %q, want %q", got, "internal-secret")
+				}
+				if r.Header.Get("X-Internal-JWT") == "" {
+					t.Error("missing X-Internal-JWT")
+				}
+				var req map[string]interface{}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Fatal(err)
+				}
+				if req["product_id"] != float64(11) || req["amount"] != float64(10_600_000) || req["request_id"] != "req-1" {
+					t.Errorf("request body = %v", req)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			c := NewBiddingServiceClient(server.URL, testSigner(t), "bidding-service", "internal-secret")
+			got, err := c.PlaceBid(context.Background(), 11, 3, 10_600_000, "req-1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Success != tt.wantSuccess {
+				t.Errorf("Success = %v, want %v", got.Success, tt.wantSuccess)
+			}
+		})
+	}
+}
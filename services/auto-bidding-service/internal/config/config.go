@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -9,7 +10,7 @@ import (
 func init() {
 	err := godotenv.Load("../.env")
 	if err != nil {
-		panic("Error loading .env file")
+		// Không panic, có thể dùng environment variables (image Docker không có .env)
 	}
 }
 
@@ -26,9 +27,42 @@ type Config struct {
 	OTelServiceName    string
 	OTelServiceVersion string
 	OTelEnvironment    string
+
+	// Redis Stream chứa event bid.placed, auto-bidding được trigger bởi consumer group trên stream này
+	RedisHost          string
+	RedisPort          string
+	RedisPassword      string
+	RedisDB            int
+	RedisStreamKey     string
+	RedisConsumerGroup string
+	RedisConsumerName  string
+	BidStreamEnabled   bool
+	// Entry đã giao cho consumer khác quá thời gian này mà chưa ack (consumer chết) được nhận lại để xử lý
+	StreamClaimIdle int
+	// Số sản phẩm được xử lý song song, event của cùng sản phẩm luôn xử lý tuần tự
+	StreamWorkers int
+
+	// Chu kỳ job chốt trạng thái auto-bid của các phiên đấu giá đã kết thúc, 0 để tắt
+	FinalizeInterval int
+	// Múi giờ của endAt (LocalDateTime) do product-service trả về, để trống là múi giờ của máy
+	ProductServiceTimezone string
+
+	// Danh tính service-to-service: bid được đặt thay bidder bằng JWT RS256 ký bởi private key của service
+	ServiceName            string
+	JWTPrivateKey          string
+	AuthInternalSecret     string
+	BiddingServiceAudience string
 }
 
 func LoadConfig() *Config {
+	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
+	streamClaimIdle, _ := strconv.Atoi(getEnv("STREAM_CLAIM_IDLE_SECONDS", "60"))
+	streamWorkers, _ := strconv.Atoi(getEnv("STREAM_WORKERS", "8"))
+	finalizeInterval, _ := strconv.Atoi(getEnv("FINALIZE_INTERVAL_SECONDS", "60"))
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "auto_bidding_service_consumer_1"
+	}
 
 	return &Config{
 		DBHost:             getEnv("DB_HOST", "localhost"),
@@ -43,6 +77,23 @@ func LoadConfig() *Config {
 		OTelServiceName:    getEnv("OTEL_SERVICE_NAME", "final4-api"),
 		OTelServiceVersion: getEnv("OTEL_SERVICE_VERSION", "1.0.0"),
 		OTelEnvironment:    getEnv("OTEL_ENVIRONMENT", "development"),
+		RedisHost:          getEnv("REDIS_HOST", "localhost"),
+		RedisPort:          getEnv("REDIS_PORT", "6379"),
+		RedisPassword:      getEnv("REDIS_PASSWORD", ""),
+		RedisDB:            redisDB,
+		RedisStreamKey:     getEnv("REDIS_STREAM_KEY", "auction_events"),
+		RedisConsumerGroup: getEnv("REDIS_CONSUMER_GROUP", "auto_bidding_service_group"),
+		// Mỗi replica cần tên consumer riêng, mặc định là hostname (tên pod/container)
+		RedisConsumerName:      getEnv("REDIS_CONSUMER_NAME", hostname),
+		BidStreamEnabled:       getEnv("BID_STREAM_ENABLED", "true") == "true",
+		StreamClaimIdle:        streamClaimIdle,
+		StreamWorkers:          streamWorkers,
+		FinalizeInterval:       finalizeInterval,
+		ProductServiceTimezone: getEnv("PRODUCT_SERVICE_TIMEZONE", ""),
+		ServiceName:            getEnv("SERVICE_NAME", "auto-bidding-service"),
+		JWTPrivateKey:          getEnv("JWT_PRIVATE_KEY", ""),
+		AuthInternalSecret:     getEnv("X_AUTH_INTERNAL_KEY", ""),
+		BiddingServiceAudience: getEnv("BIDDING_SERVICE_AUDIENCE", "bidding-service"),
 	}
 }
 
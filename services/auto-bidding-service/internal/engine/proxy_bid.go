@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"auto-bidding-service/internal/models"
+	"math"
+	"sort"
+	"time"
+)
+
+// State là trạng thái hiện tại của phiên đấu giá
+type State struct {
+	CurrentPrice float64 // Giá hiện tại, là giá khởi điểm khi chưa có ai ra giá
+	StepPrice    float64 // Bước giá
+	HolderID     int64   // Người đang giữ giá, 0 nếu chưa có ai ra giá
+}
+
+// Result là kết quả sau khi so giá tối đa của các auto-bid với nhau và với người đang giữ giá
+type Result struct {
+	WinnerID int64             // Người giữ giá sau khi xử lý, 0 nếu không có ai
+	Price    float64           // Giá vào sản phẩm sau khi xử lý
+	Bid      *models.AutoBid   // Auto-bid cần đặt giá Price, nil nếu giá và người giữ giá không đổi
+	Outbid   []*models.AutoBid // Auto-bid không còn khả năng thắng (giá tối đa không vượt được Price)
+}
+
+// participant là một người tham gia so giá: một auto-bid, hoặc người giữ giá bằng bid thường
+type participant struct {
+	bidderID  int64
+	maxAmount float64
+	createdAt time.Time
+	autoBid   *models.AutoBid // nil với người giữ giá bằng bid thường
+}
+
+// before cho biết p ra giá trước q: người giữ giá bằng bid thường (createdAt rỗng) luôn đứng trước,
+// cùng thời điểm thì auto-bid có ID nhỏ hơn đứng trước để kết quả luôn xác định
+func (p *participant) before(q *participant) bool {
+	if !p.createdAt.Equal(q.createdAt) {
+		return p.createdAt.Before(q.createdAt)
+	}
+	if p.autoBid == nil || q.autoBid == nil {
+		return p.autoBid == nil && q.autoBid != nil
+	}
+	return p.autoBid.ID < q.autoBid.ID
+}
+
+// Resolve tính kết quả đấu giá tự động theo README §6.2 mà không gọi service nào:
+//   - Người có giá tối đa cao nhất thắng, bằng nhau thì người ra giá trước thắng
+//   - Giá vào sản phẩm là giá vừa đủ thắng: giá tối đa của người thứ hai, cộng thêm một bước giá
+//     nếu người thắng ra giá sau người thứ hai (bằng giá thì người trước giữ giá), không vượt giá tối đa của người thắng
+//   - Giá luôn nằm trên lưới bước giá (giá hiện tại + k × bước giá): làm tròn lên, nhưng bị chặn bởi
+//     mốc lưới cao nhất không vượt giá tối đa của người thắng
+//   - Người đang giữ giá bằng bid thường tham gia với giá tối đa là giá hiện tại; người thắng không tới được
+//     giá hiện tại + một bước giá thì không chiếm được giá
+//
+// Chỉ auto-bid của người thắng cần đặt một bid duy nhất, các auto-bid còn lại bị OUTBID.
+func Resolve(state State, autoBids []*models.AutoBid) Result {
+	result := Result{
+		WinnerID: state.HolderID,
+		Price:    state.CurrentPrice,
+	}
+
+	var participants []*participant
+	holderHasAutoBid := false
+	for _, ab := range autoBids {
+		if ab.MaxAmount < state.CurrentPrice {
+			result.Outbid = append(result.Outbid, ab)
+			continue
+		}
+		participants = append(participants, &participant{
+			bidderID:  ab.BidderID,
+			maxAmount: ab.MaxAmount,
+			createdAt: ab.CreatedAt,
+			autoBid:   ab,
+		})
+		if ab.BidderID == state.HolderID {
+			holderHasAutoBid = true
+		}
+	}
+	if state.HolderID != 0 && !holderHasAutoBid {
+		participants = append(participants, &participant{
+			bidderID:  state.HolderID,
+			maxAmount: state.CurrentPrice,
+		})
+	}
+	if len(participants) == 0 {
+		return result
+	}
+
+	sort.SliceStable(participants, func(i, j int) bool {
+		if participants[i].maxAmount != participants[j].maxAmount {
+			return participants[i].maxAmount > participants[j].maxAmount
+		}
+		return participants[i].before(participants[j])
+	})
+
+	winner := participants[0]
+	price := state.CurrentPrice
+	if len(participants) > 1 {
+		runnerUp := participants[1]
+		needed := runnerUp.maxAmount
+		if !winner.before(runnerUp) {
+			needed += state.StepPrice
+		}
+		if needed > price {
+			price = needed
+		}
+	}
+	price = state.alignUp(price)
+
+	// Người thắng chiếm giá của người khác phải ra giá cao hơn giá hiện tại ít nhất một bước
+	minPrice := state.CurrentPrice
+	if winner.bidderID != state.HolderID && state.HolderID != 0 {
+		minPrice += state.StepPrice
+	}
+	maxPrice := state.alignDown(winner.maxAmount)
+	if maxPrice < minPrice {
+		// Giá tối đa không đủ một bước giá: người đang giữ giá giữ nguyên, không auto-bid nào còn vượt được
+		for _, p := range participants {
+			if p.autoBid != nil && p.bidderID != state.HolderID {
+				result.Outbid = append(result.Outbid, p.autoBid)
+			}
+		}
+		return result
+	}
+	if price > maxPrice {
+		price = maxPrice
+	}
+
+	result.WinnerID = winner.bidderID
+	result.Price = price
+	if winner.autoBid != nil && (winner.bidderID != state.HolderID || price > state.CurrentPrice) {
+		result.Bid = winner.autoBid
+	}
+	for _, p := range participants[1:] {
+		if p.autoBid != nil {
+			result.Outbid = append(result.Outbid, p.autoBid)
+		}
+	}
+	return result
+}
+
+// gridEpsilon bỏ qua sai số làm tròn của float khi đưa giá về lưới bước giá
+const gridEpsilon = 1e-6
+
+// alignUp làm tròn price lên mốc gần nhất của lưới giá hiện tại + k × bước giá
+func (s State) alignUp(price float64) float64 {
+	if s.StepPrice <= 0 || price <= s.CurrentPrice {
+		return price
+	}
+	return s.CurrentPrice + math.Ceil((price-s.CurrentPrice)/s.StepPrice-gridEpsilon)*s.StepPrice
+}
+
+// alignDown làm tròn price xuống mốc gần nhất của lưới giá hiện tại + k × bước giá
+func (s State) alignDown(price float64) float64 {
+	if s.StepPrice <= 0 || price <= s.CurrentPrice {
+		return price
+	}
+	return s.CurrentPrice + math.Floor((price-s.CurrentPrice)/s.StepPrice+gridEpsilon)*s.StepPrice
+}
@@ -0,0 +1,291 @@
+package engine
+
+import (
+	"auto-bidding-service/internal/models"
+	"testing"
+	"time"
+)
+
+// Ví dụ iPhone 11 trong README §6.2: giá khởi điểm 10tr, bước giá 100k
+const (
+	iphoneStartPrice = 10_000_000
+	iphoneStepPrice  = 100_000
+)
+
+var baseTime = time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+
+func autoBid(id, bidderID int64, maxAmount float64, minute int) *models.AutoBid {
+	return &models.AutoBid{
+		ID:        id,
+		ProductID: 11,
+		BidderID:  bidderID,
+		MaxAmount: maxAmount,
+		Status:    models.AutoBidStatusActive,
+		CreatedAt: baseTime.Add(time.Duration(minute) * time.Minute),
+	}
+}
+
+func bidIDs(autoBids []*models.AutoBid) []int64 {
+	ids := []int64{}
+	for _, ab := range autoBids {
+		ids = append(ids, ab.ID)
+	}
+	return ids
+}
+
+func sameIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[int64]int{}
+	for _, id := range a {
+		seen[id]++
+	}
+	for _, id := range b {
+		seen[id]--
+		if seen[id] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		state      State
+		autoBids   []*models.AutoBid
+		wantWinner int64
+		wantPrice  float64
+		wantBid    int64 // ID auto-bid cần đặt giá, 0 nếu không đặt
+		wantOutbid []int64
+	}{
+		// Các dòng của bảng "Đấu giá tự động" trong README §6.2, mỗi dòng dùng trạng thái sau dòng trước
+		{
+			name:       "readme row 1: #1 max 11tr, first bid at start price",
+			state:      State{CurrentPrice: iphoneStartPrice, StepPrice: iphoneStepPrice},
+			autoBids:   []*models.AutoBid{autoBid(1, 1, 11_000_000, 1)},
+			wantWinner: 1,
+			wantPrice:  10_000_000,
+			wantBid:    1,
+		},
+		{
+			name:  "readme row 2: #2 max 10.8tr, #1 keeps the price because it bid first",
+			state: State{CurrentPrice: 10_000_000, StepPrice: iphoneStepPrice, HolderID: 1},
+			autoBids: []*models.AutoBid{
+				autoBid(1, 1, 11_000_000, 1),
+				autoBid(2, 2, 10_800_000, 2),
+			},
+			wantWinner: 1,
+			wantPrice:  10_800_000,
+			wantBid:    1,
+			wantOutbid: []int64{2},
+		},
+		{
+			name:  "readme row 3: #3 max 11.5tr beats #1 by one step",
+			state: State{CurrentPrice: 10_800_000, StepPrice: iphoneStepPrice, HolderID: 1},
+			autoBids: []*models.AutoBid{
+				autoBid(1, 1, 11_000_000, 1),
+				autoBid(3, 3, 11_500_000, 3),
+			},
+			wantWinner: 3,
+			wantPrice:  11_100_000,
+			wantBid:    3,
+			wantOutbid: []int64{1},
+		},
+		{
+			name:  "readme row 4: #4 ties #3 at 11.5tr, #3 bid first and keeps the price",
+			state: State{CurrentPrice: 11_100_000, StepPrice: iphoneStepPrice, HolderID: 3},
+			autoBids: []*models.AutoBid{
+				autoBid(3, 3, 11_500_000, 3),
+				autoBid(4, 4, 11_500_000, 4),
+			},
+			wantWinner: 3,
+			wantPrice:  11_500_000,
+			wantBid:    3,
+			wantOutbid: []int64{4},
+		},
+		{
+			name:  "readme row 5: #4 raises max to 11.7tr and takes the price one step above #3",
+			state: State{CurrentPrice: 11_500_000, StepPrice: iphoneStepPrice, HolderID: 3},
+			autoBids: []*models.AutoBid{
+				autoBid(3, 3, 11_500_000, 3),
+				autoBid(5, 4, 11_700_000, 5),
+			},
+			wantWinner: 4,
+			wantPrice:  11_600_000,
+			wantBid:    5,
+			wantOutbid: []int64{3},
+		},
+
+		{
+			name:       "no auto-bids keeps the current holder",
+			state:      State{CurrentPrice: 10_500_000, StepPrice: iphoneStepPrice, HolderID: 7},
+			wantWinner: 7,
+			wantPrice:  10_500_000,
+		},
+		{
+			name:       "holder alone needs no bid",
+			state:      State{CurrentPrice: 10_500_000, StepPrice: iphoneStepPrice, HolderID: 1},
+			autoBids:   []*models.AutoBid{autoBid(1, 1, 11_000_000, 1)},
+			wantWinner: 1,
+			wantPrice:  10_500_000,
+		},
+		{
+			name:       "auto-bid outbids a manual holder by one step",
+			state:      State{CurrentPrice: 10_500_000, StepPrice: iphoneStepPrice, HolderID: 9},
+			autoBids:   []*models.AutoBid{autoBid(1, 1, 11_000_000, 1)},
+			wantWinner: 1,
+			wantPrice:  10_600_000,
+			wantBid:    1,
+		},
+		{
+			name:       "auto-bid equal to a manual holder's price loses the tie",
+			state:      State{CurrentPrice: 10_500_000, StepPrice: iphoneStepPrice, HolderID: 9},
+			autoBids:   []*models.AutoBid{autoBid(1, 1, 10_500_000, 1)},
+			wantWinner: 9,
+			wantPrice:  10_500_000,
+			wantOutbid: []int64{1},
+		},
+		{
+			name:       "auto-bid below the current price is outbid",
+			state:      State{CurrentPrice: 10_500_000, StepPrice: iphoneStepPrice, HolderID: 9},
+			autoBids:   []*models.AutoBid{autoBid(1, 1, 10_400_000, 1)},
+			wantWinner: 9,
+			wantPrice:  10_500_000,
+			wantOutbid: []int64{1},
+		},
+		{
+			name:       "auto-bid short of one step does not outbid a manual holder",
+			state:      State{CurrentPrice: 10_500_000, StepPrice: iphoneStepPrice, HolderID: 9},
+			autoBids:   []*models.AutoBid{autoBid(1, 1, 10_550_000, 1)},
+			wantWinner: 9,
+			wantPrice:  10_500_000,
+			wantOutbid: []int64{1},
+		},
+		{
+			name:  "auto-bid short of one step does not outbid the holder's auto-bid",
+			state: State{CurrentPrice: 10_500_000, StepPrice: iphoneStepPrice, HolderID: 1},
+			autoBids: []*models.AutoBid{
+				autoBid(1, 1, 10_550_000, 1),
+				autoBid(2, 2, 10_580_000, 2),
+			},
+			wantWinner: 1,
+			wantPrice:  10_500_000,
+			wantOutbid: []int64{2},
+		},
+		{
+			name:  "winning price is rounded up to the step grid",
+			state: State{CurrentPrice: 10_000_000, StepPrice: iphoneStepPrice, HolderID: 9},
+			autoBids: []*models.AutoBid{
+				autoBid(1, 1, 10_250_000, 1),
+				autoBid(2, 2, 11_000_000, 2),
+			},
+			wantWinner: 2,
+			wantPrice:  10_400_000,
+			wantBid:    2,
+			wantOutbid: []int64{1},
+		},
+		{
+			name:  "winning price is capped at the step below the winner's max",
+			state: State{CurrentPrice: 10_000_000, StepPrice: iphoneStepPrice, HolderID: 9},
+			autoBids: []*models.AutoBid{
+				autoBid(1, 1, 10_250_000, 1),
+				autoBid(2, 2, 10_330_000, 2),
+			},
+			wantWinner: 2,
+			wantPrice:  10_300_000,
+			wantBid:    2,
+			wantOutbid: []int64{1},
+		},
+		{
+			name:  "many auto-bids still place a single bid",
+			state: State{CurrentPrice: 10_000_000, StepPrice: iphoneStepPrice, HolderID: 9},
+			autoBids: []*models.AutoBid{
+				autoBid(1, 1, 10_900_000, 1),
+				autoBid(2, 2, 12_000_000, 4),
+				autoBid(3, 3, 11_200_000, 2),
+				autoBid(4, 4, 10_200_000, 3),
+			},
+			wantWinner: 2,
+			wantPrice:  11_300_000,
+			wantBid:    2,
+			wantOutbid: []int64{1, 3, 4},
+		},
+		{
+			name:  "same max and same created_at falls back to the lower ID",
+			state: State{CurrentPrice: 10_000_000, StepPrice: iphoneStepPrice},
+			autoBids: []*models.AutoBid{
+				autoBid(8, 8, 11_000_000, 1),
+				autoBid(6, 6, 11_000_000, 1),
+			},
+			wantWinner: 6,
+			wantPrice:  11_000_000,
+			wantBid:    6,
+			wantOutbid: []int64{8},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Resolve(tt.state, tt.autoBids)
+
+			if got.WinnerID != tt.wantWinner {
+				t.Errorf("WinnerID = %d, want %d", got.WinnerID, tt.wantWinner)
+			}
+			if got.Price != tt.wantPrice {
+				t.Errorf("Price = %.0f, want %.0f", got.Price, tt.wantPrice)
+			}
+			var gotBid int64
+			if got.Bid != nil {
+				gotBid = got.Bid.ID
+			}
+			if gotBid != tt.wantBid {
+				t.Errorf("Bid = auto-bid %d, want %d", gotBid, tt.wantBid)
+			}
+			if !sameIDs(bidIDs(got.Outbid), tt.wantOutbid) {
+				t.Errorf("Outbid = %v, want %v", bidIDs(got.Outbid), tt.wantOutbid)
+			}
+		})
+	}
+}
+
+// TestResolveReadmeSequence chạy lần lượt bảng "Đấu giá tự động" của README §6.2: kết quả mỗi dòng
+// (giá, người giữ giá, auto-bid bị OUTBID) là trạng thái đầu vào của dòng sau
+func TestResolveReadmeSequence(t *testing.T) {
+	steps := []struct {
+		autoBid    *models.AutoBid
+		replaces   int64 // ID auto-bid cũ của cùng bidder bị thay thế, 0 nếu không có
+		wantPrice  float64
+		wantHolder int64
+	}{
+		{autoBid: autoBid(1, 1, 11_000_000, 1), wantPrice: 10_000_000, wantHolder: 1},
+		{autoBid: autoBid(2, 2, 10_800_000, 2), wantPrice: 10_800_000, wantHolder: 1},
+		{autoBid: autoBid(3, 3, 11_500_000, 3), wantPrice: 11_100_000, wantHolder: 3},
+		{autoBid: autoBid(4, 4, 11_500_000, 4), wantPrice: 11_500_000, wantHolder: 3},
+		{autoBid: autoBid(5, 4, 11_700_000, 5), replaces: 4, wantPrice: 11_600_000, wantHolder: 4},
+	}
+
+	state := State{CurrentPrice: iphoneStartPrice, StepPrice: iphoneStepPrice}
+	active := map[int64]*models.AutoBid{}
+	for i, step := range steps {
+		delete(active, step.replaces)
+		active[step.autoBid.ID] = step.autoBid
+
+		var autoBids []*models.AutoBid
+		for _, ab := range active {
+			autoBids = append(autoBids, ab)
+		}
+		got := Resolve(state, autoBids)
+
+		if got.Price != step.wantPrice || got.WinnerID != step.wantHolder {
+			t.Fatalf("row %d: price %.0f holder #%d, want price %.0f holder #%d",
+				i+1, got.Price, got.WinnerID, step.wantPrice, step.wantHolder)
+		}
+		for _, ab := range got.Outbid {
+			delete(active, ab.ID)
+		}
+		state.CurrentPrice = got.Price
+		state.HolderID = got.WinnerID
+	}
+}
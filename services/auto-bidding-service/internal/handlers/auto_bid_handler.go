@@ -26,7 +26,6 @@ func NewAutoBidHandler(service *service.AutoBidService) *AutoBidHandler {
 // @Produce json
 // @Param request body models.CreateAutoBidRequest true "Auto-bid request"
 // @Param X-User-ID header int true "User ID từ JWT"
-// @Param X-User-Token header string true "JWT token"
 // @Success 200 {object} models.AutoBidResponse
 // @Failure 400 {object} map[string]interface{}
 // @Failure 500 {object} map[string]interface{}
@@ -50,12 +49,6 @@ func (h *AutoBidHandler) CreateAutoBid(c *fiber.Ctx) error {
 		})
 	}
 
-	// Lấy JWT token
-	userToken := c.Get("X-User-Token")
-	if userToken == "" {
-		userToken = c.Get("Authorization")
-	}
-
 	// Parse request body
 	var req models.CreateAutoBidRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -82,7 +75,7 @@ func (h *AutoBidHandler) CreateAutoBid(c *fiber.Ctx) error {
 	}
 
 	// Tạo auto-bid
-	autoBid, err := h.service.CreateAutoBid(c.Context(), bidderID, req.ProductID, req.MaxAmount, userToken)
+	autoBid, err := h.service.CreateAutoBid(c.UserContext(), bidderID, req.ProductID, req.MaxAmount)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
@@ -97,51 +90,6 @@ func (h *AutoBidHandler) CreateAutoBid(c *fiber.Ctx) error {
 	})
 }
 
-// TriggerAutoBidding godoc
-// @Summary Trigger auto-bidding khi có bid mới
-// @Description Được gọi bởi bidding-service khi có bid mới để trigger auto-bidding
-// @Tags auto-bidding
-// @Accept json
-// @Produce json
-// @Param request body models.TriggerAutoBidRequest true "Trigger request"
-// @Param X-Internal-Key header string true "Internal service key"
-// @Success 200 {object} map[string]interface{}
-// @Failure 400 {object} map[string]interface{}
-// @Router /api/auto-bids/trigger [post]
-func (h *AutoBidHandler) TriggerAutoBidding(c *fiber.Ctx) error {
-	// Kiểm tra internal key (để bảo mật, chỉ cho phép bidding-service gọi)
-	// internalKey := c.Get("X-Internal-Key")
-	// TODO: Validate internal key với environment variable
-
-	var req models.TriggerAutoBidRequest
-	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid request body",
-			"error":   err.Error(),
-		})
-	}
-
-	// Trigger auto-bidding trong background
-	// Lấy user token nếu có
-	userToken := c.Get("X-User-Token")
-
-	go h.service.TriggerAutoBidding(
-		c.Context(),
-		req.ProductID,
-		req.CurrentPrice,
-		req.BidIncrement,
-		req.NewBidderID,
-		req.NewBidAmount,
-		userToken,
-	)
-
-	return c.JSON(fiber.Map{
-		"success": true,
-		"message": "Auto-bidding triggered",
-	})
-}
-
 // GetMyAutoBids godoc
 // @Summary Lấy danh sách auto-bid của user
 // @Description Lấy tất cả auto-bid của user hiện tại
@@ -169,7 +117,7 @@ func (h *AutoBidHandler) GetMyAutoBids(c *fiber.Ctx) error {
 		})
 	}
 
-	autoBids, err := h.service.GetAutoBidsByBidder(c.Context(), bidderID)
+	autoBids, err := h.service.GetAutoBidsByBidder(c.UserContext(), bidderID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
@@ -204,7 +152,7 @@ func (h *AutoBidHandler) GetAutoBidByID(c *fiber.Ctx) error {
 		})
 	}
 
-	autoBid, err := h.service.GetAutoBidByID(c.Context(), id)
+	autoBid, err := h.service.GetAutoBidByID(c.UserContext(), id)
 	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"success": false,
@@ -255,7 +203,7 @@ func (h *AutoBidHandler) CancelAutoBid(c *fiber.Ctx) error {
 		})
 	}
 
-	if err := h.service.CancelAutoBid(c.Context(), id, bidderID); err != nil {
+	if err := h.service.CancelAutoBid(c.UserContext(), id, bidderID); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
 			"message": err.Error(),
@@ -8,6 +8,7 @@ import (
 	"auto-bidding-service/internal/telemetry"
 
 	"go.opentelemetry.io/contrib/bridges/otelslog"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var Log *slog.Logger
@@ -99,10 +100,44 @@ func (h combinedHandler) WithGroup(name string) slog.Handler {
 	}
 }
 
-// WithContext thêm trace_id và span_id từ context vào log
+type requestIDKey struct{}
+
+// ContextWithRequestID gắn request ID (X-Request-ID) vào context để log và request gửi đi các service khác mang theo
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext trả về request ID trong context, rỗng nếu không có
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// WithContext thêm request_id, trace_id và span_id từ context vào log
 func WithContext(ctx context.Context) *slog.Logger {
-	// Sẽ extract trace context từ OpenTelemetry sau
-	return Log
+	l := Log
+	if l == nil {
+		l = slog.Default()
+	}
+	var attrs []any
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		attrs = append(attrs, slog.String("request_id", requestID))
+	}
+	if ctx != nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			attrs = append(attrs,
+				slog.String("trace_id", sc.TraceID().String()),
+				slog.String("span_id", sc.SpanID().String()),
+			)
+		}
+	}
+	if len(attrs) == 0 {
+		return l
+	}
+	return l.With(attrs...)
 }
 
 // Info logs info message
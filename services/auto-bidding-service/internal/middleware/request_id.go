@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"auto-bidding-service/internal/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader là header mang request ID giữa gateway và các service
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength giới hạn độ dài request ID nhận từ request
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware nhận X-Request-ID do gateway gửi (hoặc sinh ID mới khi service được gọi trực tiếp),
+// gắn vào context (logger.WithContext và client gọi service khác đọc từ đây) và trả lại trong response.
+// Đặt trước TracingMiddleware để access log của mọi request đều có request_id.
+func RequestIDMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		requestID := c.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Set(RequestIDHeader, requestID)
+		c.Locals("requestID", requestID)
+		c.SetUserContext(logger.ContextWithRequestID(c.UserContext(), requestID))
+
+		return c.Next()
+	}
+}
+
+// RequestID trả về request ID của request hiện tại
+func RequestID(c *fiber.Ctx) string {
+	requestID, _ := c.Locals("requestID").(string)
+	return requestID
+}
+
+// validRequestID chỉ nhận ID ngắn gồm ký tự an toàn để tránh log injection
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == ':') {
+			return false
+		}
+	}
+	return true
+}
@@ -1,9 +1,9 @@
 package middleware
 
 import (
-	"log/slog"
 	"time"
 
+	"auto-bidding-service/internal/logger"
 	"auto-bidding-service/internal/metrics"
 
 	"github.com/gofiber/fiber/v2"
@@ -20,9 +20,9 @@ var tracer = otel.Tracer("fiber-http")
 // TracingMiddleware thêm OpenTelemetry tracing vào Fiber requests
 func TracingMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Extract trace context từ headers
+		// Extract trace context từ headers; UserContext đã có request ID (RequestIDMiddleware)
 		ctx := otel.GetTextMapPropagator().Extract(
-			c.Context(),
+			c.UserContext(),
 			propagation.HeaderCarrier(c.GetReqHeaders()),
 		)
 
@@ -85,7 +85,7 @@ func TracingMiddleware() fiber.Handler {
 			)
 		}
 
-		// Log HTTP request với trace context
+		// Log HTTP request với request ID và trace context
 		statusCode := c.Response().StatusCode()
 		logAttrs := []any{
 			"method", c.Method(),
@@ -93,16 +93,15 @@ func TracingMiddleware() fiber.Handler {
 			"status", statusCode,
 			"duration_ms", duration,
 			"client_ip", c.IP(),
-			"trace_id", span.SpanContext().TraceID().String(),
-			"span_id", span.SpanContext().SpanID().String(),
 		}
 
+		log := logger.WithContext(ctx)
 		if statusCode >= 500 {
-			slog.ErrorContext(ctx, "HTTP request", logAttrs...)
+			log.ErrorContext(ctx, "HTTP request", logAttrs...)
 		} else if statusCode >= 400 {
-			slog.WarnContext(ctx, "HTTP request", logAttrs...)
+			log.WarnContext(ctx, "HTTP request", logAttrs...)
 		} else {
-			slog.InfoContext(ctx, "HTTP request", logAttrs...)
+			log.InfoContext(ctx, "HTTP request", logAttrs...)
 		}
 
 		// Handle errors
@@ -43,15 +43,6 @@ type AutoBidResponse struct {
 	UpdatedAt     time.Time     `json:"updated_at"`
 }
 
-// TriggerAutoBidRequest là request để trigger auto-bidding khi có bid mới
-type TriggerAutoBidRequest struct {
-	ProductID     int64   `json:"product_id" validate:"required,gt=0"`
-	CurrentPrice  float64 `json:"current_price" validate:"required,gt=0"`
-	BidIncrement  float64 `json:"bid_increment" validate:"required,gt=0"`
-	NewBidderID   int64   `json:"new_bidder_id" validate:"required,gt=0"` // ID của người vừa bid
-	NewBidAmount  float64 `json:"new_bid_amount" validate:"required,gt=0"` // Số tiền của bid mới
-}
-
 // ProductInfo lưu thông tin sản phẩm cần thiết cho auto-bidding
 type ProductInfo struct {
 	ID            int64   `json:"id"`
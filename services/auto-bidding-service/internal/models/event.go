@@ -0,0 +1,51 @@
+package models
+
+import "encoding/json"
+
+// EventType là loại event trên Redis Stream auction_events (cùng định dạng search-service đọc)
+type EventType string
+
+const (
+	EventBidPlaced EventType = "bid.placed" // Có bid mới vào sản phẩm
+)
+
+// Event là một event trên stream, JSON nằm trong field "event" của entry
+type Event struct {
+	Type      EventType              `json:"type"`
+	EntityID  int64                  `json:"entity_id"`
+	Timestamp int64                  `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// ProductID trả về sản phẩm của event: product_id trong data, không có thì entity_id.
+// err khác nil khi data sai định dạng, khi đó vẫn trả về entity_id.
+func (e *Event) ProductID() (int64, error) {
+	var data BidEventData
+	if e.Data != nil {
+		dataBytes, err := json.Marshal(e.Data)
+		if err != nil {
+			return e.EntityID, err
+		}
+		if err := json.Unmarshal(dataBytes, &data); err != nil {
+			return e.EntityID, err
+		}
+	}
+	if data.ProductID != 0 {
+		return data.ProductID, nil
+	}
+	return e.EntityID, nil
+}
+
+// BidEventData là data của event bid.placed
+type BidEventData struct {
+	ProductID       int64       `json:"product_id"`
+	CurrentPrice    float64     `json:"current_price"`
+	CurrentBidCount int         `json:"current_bid_count"`
+	BidderInfo      *BidderInfo `json:"bidder_info,omitempty"`
+}
+
+// BidderInfo là người vừa đặt bid, đang giữ giá
+type BidderInfo struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+}
@@ -148,3 +148,38 @@ func (r *AutoBidRepository) DeactivateOldAutoBids(ctx context.Context, bidderID,
 	}
 	return nil
 }
+
+// GetActiveProductIDs lấy các sản phẩm còn auto-bid ACTIVE
+func (r *AutoBidRepository) GetActiveProductIDs(ctx context.Context) ([]int64, error) {
+	var productIDs []int64
+	err := r.db.ModelContext(ctx, (*models.AutoBid)(nil)).
+		ColumnExpr("DISTINCT product_id").
+		Where("status = ?", models.AutoBidStatusActive).
+		Select(&productIDs)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get products with active auto-bids: %w", err)
+	}
+	return productIDs, nil
+}
+
+// FinalizeProduct chuyển các auto-bid ACTIVE của sản phẩm đã kết thúc sang trạng thái cuối:
+// auto-bid của người thắng → WON với current_amount là giá thắng, auto-bid có max_amount không vượt giá thắng → OUTBID,
+// còn lại (không có người thắng hoặc auto-bid chưa được xử lý) → EXPIRED.
+// Chỉ auto-bid ACTIVE bị cập nhật nên gọi lại nhiều lần không đổi kết quả. Trả về số auto-bid được cập nhật.
+func (r *AutoBidRepository) FinalizeProduct(ctx context.Context, productID, winnerID int64, finalPrice float64) (int, error) {
+	res, err := r.db.ModelContext(ctx, (*models.AutoBid)(nil)).
+		Set("status = CASE WHEN bidder_id = ?0 THEN ?2 WHEN ?0 <> 0 AND max_amount <= ?1 THEN ?3 ELSE ?4 END",
+			winnerID, finalPrice,
+			models.AutoBidStatusWon, models.AutoBidStatusOutbid, models.AutoBidStatusExpired).
+		Set("current_amount = CASE WHEN bidder_id = ?0 THEN ?1 ELSE current_amount END", winnerID, finalPrice).
+		Set("updated_at = ?", time.Now()).
+		Where("product_id = ?", productID).
+		Where("status = ?", models.AutoBidStatusActive).
+		Update()
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to finalize auto-bids: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"auto-bidding-service/internal/models"
+	"context"
+	"sync"
+	"testing"
+)
+
+// Sản phẩm iPhone 11 của ví dụ README §6.2 kết thúc ở giá 11.1tr, người thắng là bidder 3
+const (
+	iphoneWinner     = 3
+	iphoneFinalPrice = 11_100_000
+)
+
+// autoBid là một auto-bid của fixture: bidder, giá tối đa và trạng thái trước khi chốt
+type autoBid struct {
+	bidderID  int64
+	maxAmount float64
+	status    models.AutoBidStatus
+}
+
+func active(bidderID int64, maxAmount float64) autoBid {
+	return autoBid{bidderID: bidderID, maxAmount: maxAmount, status: models.AutoBidStatusActive}
+}
+
+// insertAutoBids xoá auto-bid cũ của sản phẩm rồi ghi fixture, trả về ID theo thứ tự fixture
+func insertAutoBids(t *testing.T, repo *AutoBidRepository, productID int64, bids ...autoBid) []int64 {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := repo.db.ExecContext(ctx, "DELETE FROM auto_bids WHERE product_id = ?", productID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		repo.db.ExecContext(context.Background(), "DELETE FROM auto_bids WHERE product_id = ?", productID)
+	})
+
+	var ids []int64
+	for _, b := range bids {
+		ab := &models.AutoBid{ProductID: productID, BidderID: b.bidderID, MaxAmount: b.maxAmount}
+		if err := repo.Create(ctx, ab); err != nil {
+			t.Fatal(err)
+		}
+		if b.status != models.AutoBidStatusActive {
+			if _, err := repo.db.ExecContext(ctx, "UPDATE auto_bids SET status = ? WHERE id = ?", b.status, ab.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids = append(ids, ab.ID)
+	}
+	return ids
+}
+
+// finalState đọc trạng thái và current_amount của các auto-bid theo ID
+func finalState(t *testing.T, repo *AutoBidRepository, ids []int64) []models.AutoBid {
+	t.Helper()
+	var states []models.AutoBid
+	for _, id := range ids {
+		ab := models.AutoBid{ID: id}
+		if err := repo.db.ModelContext(context.Background(), &ab).WherePK().Select(); err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, ab)
+	}
+	return states
+}
+
+func TestFinalizeProduct(t *testing.T) {
+	repo := NewAutoBidRepository(testDB(t))
+
+	type want struct {
+		status        models.AutoBidStatus
+		currentAmount float64
+	}
+	tests := []struct {
+		name        string
+		productID   int64
+		winnerID    int64
+		finalPrice  float64
+		bids        []autoBid
+		wantUpdated int
+		want        []want
+	}{
+		{
+			name:       "winner, outbid and unprocessed auto-bids",
+			productID:  9101,
+			winnerID:   iphoneWinner,
+			finalPrice: iphoneFinalPrice,
+			bids: []autoBid{
+				active(3, 12_000_000),
+				active(1, 11_000_000),
+				active(2, 11_100_000), // max bằng giá thắng: không thể vượt nên OUTBID
+				active(4, 11_500_000), // max vượt giá thắng nhưng chưa được xử lý
+				{bidderID: 5, maxAmount: 13_000_000, status: models.AutoBidStatusCancelled},
+			},
+			wantUpdated: 4,
+			want: []want{
+				{models.AutoBidStatusWon, iphoneFinalPrice},
+				{models.AutoBidStatusOutbid, 0},
+				{models.AutoBidStatusOutbid, 0},
+				{models.AutoBidStatusExpired, 0},
+				{models.AutoBidStatusCancelled, 0},
+			},
+		},
+		{
+			name:       "no winner expires every auto-bid",
+			productID:  9102,
+			winnerID:   0,
+			finalPrice: 10_000_000,
+			bids: []autoBid{
+				active(1, 9_000_000),
+				active(2, 11_000_000),
+			},
+			wantUpdated: 2,
+			want: []want{
+				{models.AutoBidStatusExpired, 0},
+				{models.AutoBidStatusExpired, 0},
+			},
+		},
+		{
+			name:       "winner placed a manual bid without an auto-bid",
+			productID:  9103,
+			winnerID:   7,
+			finalPrice: iphoneFinalPrice,
+			bids: []autoBid{
+				active(1, 11_000_000),
+				active(2, 12_000_000),
+			},
+			wantUpdated: 2,
+			want: []want{
+				{models.AutoBidStatusOutbid, 0},
+				{models.AutoBidStatusExpired, 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		ids := insertAutoBids(t, repo, tt.productID, tt.bids...)
+
+		updated, err := repo.FinalizeProduct(context.Background(), tt.productID, tt.winnerID, tt.finalPrice)
+		if err != nil {
+			t.Fatalf("%s: FinalizeProduct() error = %v", tt.name, err)
+		}
+		if updated != tt.wantUpdated {
+			t.Errorf("%s: updated = %d, want %d", tt.name, updated, tt.wantUpdated)
+		}
+		for i, ab := range finalState(t, repo, ids) {
+			if ab.Status != tt.want[i].status || ab.CurrentAmount != tt.want[i].currentAmount {
+				t.Errorf("%s: auto-bid of bidder %d = %s %.0f, want %s %.0f", tt.name, ab.BidderID,
+					ab.Status, ab.CurrentAmount, tt.want[i].status, tt.want[i].currentAmount)
+			}
+		}
+	}
+}
+
+func TestFinalizeProductIsIdempotent(t *testing.T) {
+	repo := NewAutoBidRepository(testDB(t))
+	ids := insertAutoBids(t, repo, 9104, active(3, 12_000_000), active(1, 11_000_000))
+	ctx := context.Background()
+
+	if _, err := repo.FinalizeProduct(ctx, 9104, iphoneWinner, iphoneFinalPrice); err != nil {
+		t.Fatal(err)
+	}
+	first := finalState(t, repo, ids)
+
+	// Lần chốt sau (replica khác, hoặc product-service trả giá khác) không đổi auto-bid đã chốt
+	updated, err := repo.FinalizeProduct(ctx, 9104, 1, 12_500_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 0 {
+		t.Errorf("second FinalizeProduct() updated %d auto-bids, want 0", updated)
+	}
+	for i, ab := range finalState(t, repo, ids) {
+		if ab.Status != first[i].Status || ab.CurrentAmount != first[i].CurrentAmount {
+			t.Errorf("auto-bid of bidder %d changed from %s %.0f to %s %.0f", ab.BidderID,
+				first[i].Status, first[i].CurrentAmount, ab.Status, ab.CurrentAmount)
+		}
+	}
+}
+
+func TestFinalizeProductConcurrentReplicas(t *testing.T) {
+	db := testDB(t)
+	// Hai replica dùng chung database, cùng chốt sản phẩm trong một lần chạy của finalizer
+	replicas := []*AutoBidRepository{NewAutoBidRepository(db), NewAutoBidRepository(db)}
+	bids := []autoBid{active(3, 12_000_000)}
+	for bidder := int64(10); bidder < 40; bidder++ {
+		bids = append(bids, active(bidder, 10_500_000))
+	}
+	ids := insertAutoBids(t, replicas[0], 9105, bids...)
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		total int
+	)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(repo *AutoBidRepository) {
+			defer wg.Done()
+			updated, err := repo.FinalizeProduct(context.Background(), 9105, iphoneWinner, iphoneFinalPrice)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			total += updated
+			mu.Unlock()
+		}(replicas[i%2])
+	}
+	wg.Wait()
+
+	// Mỗi auto-bid được chốt đúng một lần
+	if total != len(bids) {
+		t.Errorf("auto-bids updated = %d across replicas, want %d", total, len(bids))
+	}
+	for _, ab := range finalState(t, replicas[0], ids) {
+		want := models.AutoBidStatusOutbid
+		if ab.BidderID == iphoneWinner {
+			want = models.AutoBidStatusWon
+		}
+		if ab.Status != want {
+			t.Errorf("auto-bid of bidder %d = %s, want %s", ab.BidderID, ab.Status, want)
+		}
+	}
+}
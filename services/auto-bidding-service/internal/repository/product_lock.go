@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-pg/pg/v10"
+)
+
+// productLockTimeout là thời gian tối đa chờ lock của một sản phẩm (replica khác đang xử lý)
+const productLockTimeout = "60s"
+
+// ProductLock tuần tự hóa việc xử lý auto-bid của từng sản phẩm giữa các replica bằng advisory lock của Postgres
+type ProductLock struct {
+	db *pg.DB
+}
+
+// NewProductLock tạo lock mới
+func NewProductLock(db *pg.DB) *ProductLock {
+	return &ProductLock{db: db}
+}
+
+// Do chờ lock của sản phẩm rồi chạy fn. Lock là advisory lock mức session, giữ trên một connection riêng
+// không có transaction nào mở, và được nhả ngay khi fn xong; connection bị ngắt giữa chừng thì Postgres tự nhả lock.
+// fn không chạy trên connection của lock: truy vấn của repository đi qua pool như bình thường.
+func (l *ProductLock) Do(ctx context.Context, productID int64, fn func(ctx context.Context) error) (err error) {
+	conn := l.db.Conn()
+	defer conn.Close()
+
+	// Key được hash từ namespace để không trùng với advisory lock khác dùng chung database
+	key := fmt.Sprintf("auto_bids:product:%d", productID)
+	if err := l.lock(ctx, conn, key); err != nil {
+		return fmt.Errorf("failed to lock product %d: %w", productID, err)
+	}
+	defer func() {
+		// Nhả lock cả khi ctx đã bị hủy trong lúc fn chạy
+		var unlocked bool
+		_, unlockErr := conn.QueryOneContext(context.WithoutCancel(ctx), pg.Scan(&unlocked),
+			"SELECT pg_advisory_unlock(hashtextextended(?, 0))", key)
+		if unlockErr == nil && !unlocked {
+			unlockErr = fmt.Errorf("lock was not held")
+		}
+		if unlockErr != nil && err == nil {
+			err = fmt.Errorf("failed to unlock product %d: %w", productID, unlockErr)
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// lock chờ advisory lock tối đa productLockTimeout. lock_timeout được trả về mặc định trước khi connection về pool;
+// lỗi khi reset chỉ xảy ra khi connection hỏng và connection đó bị loại khỏi pool
+func (l *ProductLock) lock(ctx context.Context, conn *pg.Conn, key string) error {
+	if _, err := conn.ExecContext(ctx, "SET lock_timeout = '"+productLockTimeout+"'"); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtextextended(?, 0))", key)
+	conn.ExecContext(context.WithoutCancel(ctx), "RESET lock_timeout")
+	return err
+}
@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProductLockSerializesReplicas(t *testing.T) {
+	db := testDB(t)
+	// Mỗi replica có ProductLock riêng, dùng chung database
+	replicas := []*ProductLock{NewProductLock(db), NewProductLock(db)}
+
+	var inside, maxInside atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(lock *ProductLock) {
+			defer wg.Done()
+			err := lock.Do(context.Background(), 9001, func(ctx context.Context) error {
+				n := inside.Add(1)
+				if n > maxInside.Load() {
+					maxInside.Store(n)
+				}
+				time.Sleep(20 * time.Millisecond)
+				inside.Add(-1)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(replicas[i%2])
+	}
+	wg.Wait()
+
+	if got := maxInside.Load(); got != 1 {
+		t.Errorf("max concurrent holders = %d, want 1", got)
+	}
+}
+
+func TestProductLockHoldsNoTransaction(t *testing.T) {
+	db := testDB(t)
+	lock := NewProductLock(db)
+
+	errFn := errors.New("bidding-service unavailable")
+	err := lock.Do(context.Background(), 9002, func(ctx context.Context) error {
+		// Trong lúc giữ lock không có connection nào để transaction mở, truy vấn vẫn chạy qua pool
+		var idleInTx int
+		_, err := db.QueryOneContext(ctx, &idleInTx,
+			"SELECT count(*) FROM pg_stat_activity WHERE datname = current_database() AND state LIKE 'idle in transaction%'")
+		if err != nil {
+			return err
+		}
+		if idleInTx != 0 {
+			t.Errorf("%d connections idle in transaction while holding the lock", idleInTx)
+		}
+		return errFn
+	})
+	if !errors.Is(err, errFn) {
+		t.Fatalf("Do() = %v, want %v", err, errFn)
+	}
+
+	// Lock được nhả khi fn lỗi và lock_timeout không còn trên connection trả về pool
+	var held int
+	if _, err := db.QueryOne(&held,
+		"SELECT count(*) FROM pg_locks WHERE locktype = 'advisory' AND granted"); err != nil {
+		t.Fatal(err)
+	}
+	if held != 0 {
+		t.Errorf("%d advisory locks still held after Do", held)
+	}
+	otherLock := NewProductLock(db)
+	done := make(chan error, 1)
+	go func() {
+		done <- otherLock.Do(context.Background(), 9002, func(ctx context.Context) error { return nil })
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock was not released after Do returned")
+	}
+}
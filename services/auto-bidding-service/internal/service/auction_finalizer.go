@@ -0,0 +1,116 @@
+package service
+
+import (
+	"auto-bidding-service/internal/client"
+	"auto-bidding-service/internal/logger"
+	"auto-bidding-service/internal/repository"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// AuctionFinalizer định kỳ tìm các sản phẩm còn auto-bid ACTIVE nhưng đã kết thúc đấu giá (tới endAt của product-service)
+// và chốt trạng thái cuối của các auto-bid: WON, OUTBID hoặc EXPIRED.
+// Chạy được trên nhiều replica: mỗi sản phẩm được chốt khi giữ lock của sản phẩm (cùng lock với auto-bidding)
+// và chỉ auto-bid còn ACTIVE bị cập nhật, nên replica chạy sau không đổi kết quả.
+type AuctionFinalizer struct {
+	repo                 *repository.AutoBidRepository
+	productLock          *repository.ProductLock
+	productServiceClient *client.ProductServiceClient
+	interval             time.Duration
+}
+
+// NewAuctionFinalizer tạo job mới
+func NewAuctionFinalizer(
+	repo *repository.AutoBidRepository,
+	productLock *repository.ProductLock,
+	productServiceClient *client.ProductServiceClient,
+	interval time.Duration,
+) *AuctionFinalizer {
+	return &AuctionFinalizer{
+		repo:                 repo,
+		productLock:          productLock,
+		productServiceClient: productServiceClient,
+		interval:             interval,
+	}
+}
+
+// Start chạy job ở background tới khi ctx bị hủy
+func (f *AuctionFinalizer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+
+		for {
+			f.FinalizeEndedAuctions(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// FinalizeEndedAuctions chốt auto-bid của mọi sản phẩm đã kết thúc, trả về số auto-bid được cập nhật
+func (f *AuctionFinalizer) FinalizeEndedAuctions(ctx context.Context) int {
+	productIDs, err := f.repo.GetActiveProductIDs(ctx)
+	if err != nil {
+		slog.Error("Failed to list products with active auto-bids", "error", err)
+		return 0
+	}
+
+	total := 0
+	for _, productID := range productIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		updated, err := f.finalizeProduct(ctx, productID)
+		if err != nil {
+			// Sản phẩm lỗi được thử lại ở lần chạy sau
+			slog.Error("Failed to finalize auto-bids", "product_id", productID, "error", err)
+			continue
+		}
+		total += updated
+	}
+	return total
+}
+
+// finalizeProduct chốt auto-bid của một sản phẩm nếu phiên đấu giá đã kết thúc (đã tới endAt).
+// Người thắng, giá cuối và endAt được đọc từ product-service sau khi có lock của sản phẩm: auto-bid đang đặt
+// dở hoặc autoExtend dời endAt trước khi lock được nhả sẽ không làm chốt sai người thắng.
+// Sản phẩm đã bị xóa khỏi product-service không còn người thắng nên mọi auto-bid ACTIVE → EXPIRED.
+func (f *AuctionFinalizer) finalizeProduct(ctx context.Context, productID int64) (int, error) {
+	var (
+		product *client.ProductInfo
+		updated int
+	)
+	err := f.productLock.Do(ctx, productID, func(ctx context.Context) error {
+		var err error
+		product, err = f.productServiceClient.GetProduct(ctx, productID)
+		if errors.Is(err, client.ErrProductNotFound) {
+			slog.Warn("Product not found, expiring its auto-bids", "product_id", productID)
+			product = &client.ProductInfo{ID: productID}
+		} else if err != nil {
+			return err
+		} else if !product.Ended(time.Now()) {
+			return nil
+		}
+
+		updated, err = f.repo.FinalizeProduct(ctx, productID, product.HighestBidder, product.CurrentPrice)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if updated > 0 {
+		logger.WithContext(ctx).Info("Auto-bids finalized",
+			"product_id", productID,
+			"winner_id", product.HighestBidder,
+			"final_price", product.CurrentPrice,
+			"updated", updated)
+	}
+	return updated, nil
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"auto-bidding-service/internal/client"
+	"auto-bidding-service/internal/models"
+	"auto-bidding-service/internal/repository"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-pg/pg/v10"
+)
+
+const (
+	endedProduct     = 9201 // iPhone 11 đã kết thúc ở giá 11.1tr, người thắng là bidder 3
+	runningProduct   = 9202 // chưa tới endAt
+	deletedProduct   = 9203 // đã bị xóa khỏi product-service
+	iphoneWinner     = 3
+	iphoneEndedAt    = "2025-01-08T21:30:00"
+	iphoneFinalPrice = 11_100_000
+)
+
+// productService giả lập GET /api/products/{id} của product-service cho các sản phẩm của test.
+// Sản phẩm khác (của test chạy song song trên cùng database) trả 500 để finalizer bỏ qua.
+func productService(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case fmt.Sprintf("/api/products/%d", endedProduct):
+			fmt.Fprintf(w, `{"id":%d,"name":"iPhone 11","currentPrice":%d,"stepPrice":100000,"endAt":%q,"highestBidder":{"id":%d}}`,
+				endedProduct, iphoneFinalPrice, iphoneEndedAt, iphoneWinner)
+		case fmt.Sprintf("/api/products/%d", runningProduct):
+			fmt.Fprintf(w, `{"id":%d,"name":"iPhone 12","currentPrice":12000000,"stepPrice":100000,"endAt":%q,"highestBidder":{"id":1}}`,
+				runningProduct, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+		case fmt.Sprintf("/api/products/%d", deletedProduct):
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message":"Product not found"}`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// insertAutoBids ghi các auto-bid ACTIVE (bidder → giá tối đa) của sản phẩm, trả về ID theo bidder
+func insertAutoBids(t *testing.T, db *pg.DB, productID int64, maxAmounts map[int64]float64) map[int64]int64 {
+	t.Helper()
+	ctx := context.Background()
+	cleanup := func() {
+		db.ExecContext(context.Background(), "DELETE FROM auto_bids WHERE product_id = ?", productID)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	repo := repository.NewAutoBidRepository(db)
+	ids := map[int64]int64{}
+	for bidderID, maxAmount := range maxAmounts {
+		ab := &models.AutoBid{ProductID: productID, BidderID: bidderID, MaxAmount: maxAmount}
+		if err := repo.Create(ctx, ab); err != nil {
+			t.Fatal(err)
+		}
+		ids[bidderID] = ab.ID
+	}
+	return ids
+}
+
+func statusOf(t *testing.T, db *pg.DB, id int64) models.AutoBidStatus {
+	t.Helper()
+	ab := models.AutoBid{ID: id}
+	if err := db.ModelContext(context.Background(), &ab).WherePK().Select(); err != nil {
+		t.Fatal(err)
+	}
+	return ab.Status
+}
+
+func newReplica(db *pg.DB, productServiceURL string) *AuctionFinalizer {
+	return NewAuctionFinalizer(
+		repository.NewAutoBidRepository(db),
+		repository.NewProductLock(db),
+		client.NewProductServiceClient(productServiceURL, "internal-secret", time.UTC),
+		time.Minute,
+	)
+}
+
+func TestFinalizerReplicasFinalizeOnce(t *testing.T) {
+	db := testDB(t)
+	server := productService(t)
+
+	ended := insertAutoBids(t, db, endedProduct, map[int64]float64{
+		3: 12_000_000, // người thắng
+		1: 11_000_000, // không thể vượt giá thắng
+		4: 11_500_000, // chưa được xử lý khi phiên kết thúc
+	})
+	running := insertAutoBids(t, db, runningProduct, map[int64]float64{1: 13_000_000, 2: 12_500_000})
+	deleted := insertAutoBids(t, db, deletedProduct, map[int64]float64{1: 9_000_000})
+
+	// Hai replica chạy finalizer cùng lúc
+	replicas := []*AuctionFinalizer{newReplica(db, server.URL), newReplica(db, server.URL)}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		total int
+	)
+	for _, f := range replicas {
+		wg.Add(1)
+		go func(f *AuctionFinalizer) {
+			defer wg.Done()
+			n := f.FinalizeEndedAuctions(context.Background())
+			mu.Lock()
+			total += n
+			mu.Unlock()
+		}(f)
+	}
+	wg.Wait()
+
+	// 3 auto-bid của sản phẩm đã kết thúc và 1 của sản phẩm đã xóa, mỗi auto-bid chỉ được chốt một lần
+	if total != 4 {
+		t.Errorf("auto-bids finalized = %d across replicas, want 4", total)
+	}
+
+	tests := []struct {
+		name string
+		id   int64
+		want models.AutoBidStatus
+	}{
+		{"winner", ended[3], models.AutoBidStatusWon},
+		{"max below final price", ended[1], models.AutoBidStatusOutbid},
+		{"unprocessed higher max", ended[4], models.AutoBidStatusExpired},
+		{"running auction", running[1], models.AutoBidStatusActive},
+		{"running auction", running[2], models.AutoBidStatusActive},
+		{"deleted product", deleted[1], models.AutoBidStatusExpired},
+	}
+	for _, tt := range tests {
+		if got := statusOf(t, db, tt.id); got != tt.want {
+			t.Errorf("%s: status = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	// Lần chạy sau chỉ còn sản phẩm chưa kết thúc, không có gì để chốt
+	if n := replicas[0].FinalizeEndedAuctions(context.Background()); n != 0 {
+		t.Errorf("second run finalized %d auto-bids, want 0", n)
+	}
+}
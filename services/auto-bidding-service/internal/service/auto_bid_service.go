@@ -2,11 +2,14 @@ package service
 
 import (
 	"auto-bidding-service/internal/client"
+	"auto-bidding-service/internal/engine"
+	"auto-bidding-service/internal/logger"
 	"auto-bidding-service/internal/models"
 	"auto-bidding-service/internal/repository"
 	"context"
+	"errors"
 	"fmt"
-	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,32 +18,55 @@ import (
 // AutoBidService xử lý logic nghiệp vụ cho auto-bidding
 type AutoBidService struct {
 	repo                 *repository.AutoBidRepository
+	productLock          *repository.ProductLock
 	biddingServiceClient *client.BiddingServiceClient
 	productServiceClient *client.ProductServiceClient
+
+	// Trigger đang chạy trong instance theo sản phẩm, trigger tới trong lúc chạy được gộp vào một lần chạy tiếp theo
+	mu      sync.Mutex
+	running map[int64]*productRun
+	// run xử lý một lần chạy của sản phẩm (runLocked), tách ra để test việc gộp trigger
+	run func(ctx context.Context, productID int64) error
+}
+
+// followUp là lần chạy tiếp theo của một sản phẩm, gộp các trigger tới trong lúc sản phẩm đang được xử lý
+type followUp struct {
+	done chan struct{} // đóng khi lần chạy xong, err là kết quả
+	err  error
+}
+
+// productRun là lần xử lý đang chạy của một sản phẩm, next là lần chạy tiếp theo nếu có trigger mới
+type productRun struct {
+	next *followUp
 }
 
 // NewAutoBidService tạo service mới
 func NewAutoBidService(
 	repo *repository.AutoBidRepository,
+	productLock *repository.ProductLock,
 	biddingServiceClient *client.BiddingServiceClient,
 	productServiceClient *client.ProductServiceClient,
 ) *AutoBidService {
-	return &AutoBidService{
+	s := &AutoBidService{
 		repo:                 repo,
+		productLock:          productLock,
 		biddingServiceClient: biddingServiceClient,
 		productServiceClient: productServiceClient,
+		running:              map[int64]*productRun{},
 	}
+	s.run = s.runLocked
+	return s
 }
 
 // CreateAutoBid tạo một auto-bid mới cho bidder
-func (s *AutoBidService) CreateAutoBid(ctx context.Context, bidderID, productID int64, maxAmount float64, userToken string) (*models.AutoBid, error) {
+func (s *AutoBidService) CreateAutoBid(ctx context.Context, bidderID, productID int64, maxAmount float64) (*models.AutoBid, error) {
 	// 1. Kiểm tra sản phẩm có tồn tại và đang active không
-	product, err := s.productServiceClient.GetProduct(productID)
+	product, err := s.productServiceClient.GetProduct(ctx, productID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get product info: %w", err)
 	}
 
-	if product.Status != "ACTIVE" {
+	if product.Ended(time.Now()) {
 		return nil, fmt.Errorf("product is not active for bidding")
 	}
 
@@ -51,7 +77,7 @@ func (s *AutoBidService) CreateAutoBid(ctx context.Context, bidderID, productID
 
 	// 3. Deactivate auto-bid cũ của bidder cho sản phẩm này (nếu có)
 	if err := s.repo.DeactivateOldAutoBids(ctx, bidderID, productID); err != nil {
-		slog.Error("Failed to deactivate old auto-bids", "error", err)
+		logger.WithContext(ctx).Error("Failed to deactivate old auto-bids", "error", err)
 		// Không return error, tiếp tục tạo mới
 	}
 
@@ -68,147 +94,207 @@ func (s *AutoBidService) CreateAutoBid(ctx context.Context, bidderID, productID
 		return nil, fmt.Errorf("failed to create auto-bid: %w", err)
 	}
 
-	// 5. Trigger auto-bidding ngay lập tức
-	go s.TriggerAutoBidding(context.Background(), productID, product.CurrentPrice, product.StepPrice, bidderID, maxAmount, userToken)
+	// 5. Trigger auto-bidding ngay lập tức ở background
+	// Context tách khỏi request để không bị hủy khi response trả về, nhưng giữ request ID và trace cho log
+	go s.TriggerAutoBidding(context.WithoutCancel(ctx), productID)
 
 	return autoBid, nil
 }
 
-// TriggerAutoBidding xử lý logic auto-bidding khi có bid mới
-// Logic:
-// - Lấy tất cả auto-bid ACTIVE của sản phẩm, sắp xếp theo max_amount giảm dần
-// - Những người có max_amount < giá hiện tại → OUTBID
-// - Những người có max_amount >= giá hiện tại:
-//   - Người thứ 2,3,4... bid hết max của họ
-//   - Người thứ nhất (max cao nhất) chỉ bid cao hơn người thứ 2 một bước giá
-func (s *AutoBidService) TriggerAutoBidding(ctx context.Context, productID int64, currentPrice, stepPrice float64, triggerBidderID int64, triggerAmount float64, userToken string) error {
-	slog.Info("Triggering auto-bidding",
-		"product_id", productID,
-		"current_price", currentPrice,
-		"step_price", stepPrice,
-		"trigger_bidder", triggerBidderID,
-		"trigger_amount", triggerAmount)
+// TriggerAutoBidding xử lý logic auto-bidding khi có bid mới hoặc auto-bid mới.
+// Trigger chỉ là tín hiệu đánh thức: trạng thái phiên đấu giá và các auto-bid được đọc lại khi đã giữ lock.
+// Mỗi sản phẩm chỉ được xử lý bởi một trigger tại một thời điểm, kể cả giữa các replica (advisory lock).
+// Trigger tới khi sản phẩm đang được xử lý trong instance được gộp thành một lần chạy tiếp theo,
+// TriggerAutoBidding trả về khi lần chạy đó xong.
+func (s *AutoBidService) TriggerAutoBidding(ctx context.Context, productID int64) error {
+	s.mu.Lock()
+	if run, ok := s.running[productID]; ok {
+		if run.next == nil {
+			run.next = &followUp{done: make(chan struct{})}
+		}
+		next := run.next
+		s.mu.Unlock()
+
+		logger.WithContext(ctx).Info("Auto-bidding already running, trigger coalesced", "product_id", productID)
+		select {
+		case <-next.done:
+			return next.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	run := &productRun{}
+	s.running[productID] = run
+	s.mu.Unlock()
+
+	err := s.run(ctx, productID)
+	for {
+		s.mu.Lock()
+		next := run.next
+		run.next = nil
+		if next == nil {
+			delete(s.running, productID)
+			s.mu.Unlock()
+			return err
+		}
+		s.mu.Unlock()
 
-	// 1. Lấy tất cả auto-bid ACTIVE, sắp xếp theo max_amount DESC
-	autoBids, err := s.repo.GetActiveByProduct(ctx, productID)
+		next.err = s.run(ctx, productID)
+		close(next.done)
+	}
+}
+
+// runLocked xử lý một trigger khi đang giữ lock của sản phẩm
+func (s *AutoBidService) runLocked(ctx context.Context, productID int64) error {
+	err := s.productLock.Do(ctx, productID, func(ctx context.Context) error {
+		return s.resolveAutoBids(ctx, productID)
+	})
 	if err != nil {
-		slog.Error("Failed to get active auto-bids", "error", err)
-		return err
+		logger.WithContext(ctx).Error("Auto-bidding failed", "error", err, "product_id", productID)
 	}
+	return err
+}
 
-	if len(autoBids) == 0 {
-		slog.Info("No active auto-bids found for product", "product_id", productID)
+// HandleBidPlaced trigger auto-bidding từ event bid.placed trên Redis Stream
+func (s *AutoBidService) HandleBidPlaced(ctx context.Context, event *models.Event) error {
+	if event.Type != models.EventBidPlaced {
 		return nil
 	}
 
-	slog.Info("Found active auto-bids", "count", len(autoBids))
-
-	// 2. Lọc ra các auto-bid có max_amount > currentPrice (có khả năng bid)
-	var eligibleBids []*models.AutoBid
-	for _, ab := range autoBids {
-		if ab.MaxAmount > currentPrice {
-			eligibleBids = append(eligibleBids, ab)
-		} else {
-			// Đánh dấu OUTBID cho những người có max < giá hiện tại
-			s.repo.UpdateStatus(ctx, ab.ID, models.AutoBidStatusOutbid)
-			slog.Info("Auto-bid marked as OUTBID", "auto_bid_id", ab.ID, "max_amount", ab.MaxAmount)
-		}
+	productID, err := event.ProductID()
+	if err != nil {
+		// Data sai định dạng sẽ không bao giờ đọc được, lấy trạng thái từ product-service
+		logger.WithContext(ctx).Warn("Invalid bid event data", "error", err, "entity_id", event.EntityID)
 	}
-
-	if len(eligibleBids) == 0 {
-		slog.Info("No eligible auto-bids after filtering")
+	if productID == 0 {
+		logger.WithContext(ctx).Warn("Bid event without product ID, skipping")
 		return nil
 	}
 
-	// 3. Xử lý logic bidding
-	// - Nếu chỉ có 1 người: bid = currentPrice + stepPrice
-	// - Nếu có 2+ người:
-	//   + Người 2,3,4,... bid hết max của họ
-	//   + Người 1 (max cao nhất) bid = min(max của người 2 + stepPrice, max của người 1)
+	// Giá trong event có thể đã cũ (event được giao lại), trạng thái được đọc lại từ product-service khi giữ lock
+	return s.TriggerAutoBidding(ctx, productID)
+}
 
-	if len(eligibleBids) == 1 {
-		// Chỉ có 1 người, bid ngay
-		autoBid := eligibleBids[0]
-		nextBidAmount := currentPrice + stepPrice
+// resolveAutoBids tính và đặt bid auto-bidding cho sản phẩm, gọi khi đang giữ lock của sản phẩm.
+// Giá, bước giá và người giữ giá được đọc lại từ product-service sau khi có lock để không tính trên trạng thái cũ.
+// Logic (xem engine.Resolve):
+// - Lấy tất cả auto-bid ACTIVE của sản phẩm và so với người đang giữ giá
+// - Người có max_amount cao nhất thắng, bằng nhau thì người tạo trước thắng
+// - Chỉ đặt một bid duy nhất cho người thắng với giá vừa đủ: max của người thứ 2 + một bước giá
+// - Các auto-bid còn lại → OUTBID
+func (s *AutoBidService) resolveAutoBids(ctx context.Context, productID int64) error {
+	product, err := s.productServiceClient.GetProduct(ctx, productID)
+	if errors.Is(err, client.ErrProductNotFound) {
+		// Sản phẩm đã bị xóa, job chốt phiên đấu giá sẽ chuyển các auto-bid sang EXPIRED
+		logger.WithContext(ctx).Warn("Product not found, skipping auto-bidding", "product_id", productID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get product info: %w", err)
+	}
+	if product.Ended(time.Now()) {
+		logger.WithContext(ctx).Info("Auction has ended, skipping auto-bidding", "product_id", productID, "end_at", product.EndAt.Format(time.RFC3339))
+		return nil
+	}
+	currentPrice, stepPrice, holderID := product.CurrentPrice, product.StepPrice, product.HighestBidder
 
-		// Không vượt quá max
-		if nextBidAmount > autoBid.MaxAmount {
-			nextBidAmount = autoBid.MaxAmount
-		}
+	logger.WithContext(ctx).Info("Triggering auto-bidding",
+		"product_id", productID,
+		"current_price", currentPrice,
+		"step_price", stepPrice,
+		"holder_id", holderID)
 
-		s.executeBid(ctx, autoBid, nextBidAmount, userToken)
-	} else {
-		// Có nhiều người
-		highestAutoBid := eligibleBids[0] // Người có max cao nhất
-		secondHighest := eligibleBids[1]  // Người thứ 2
+	// 1. Lấy tất cả auto-bid ACTIVE của sản phẩm
+	autoBids, err := s.repo.GetActiveByProduct(ctx, productID)
+	if err != nil {
+		logger.WithContext(ctx).Error("Failed to get active auto-bids", "error", err)
+		return err
+	}
 
-		// Người từ thứ 2 trở đi: bid hết max của họ
-		for i := len(eligibleBids) - 1; i >= 1; i-- {
-			autoBid := eligibleBids[i]
-			s.executeBid(ctx, autoBid, autoBid.MaxAmount, userToken)
+	if len(autoBids) == 0 {
+		logger.WithContext(ctx).Info("No active auto-bids found for product", "product_id", productID)
+		return nil
+	}
 
-			// Delay một chút để tránh race condition
-			time.Sleep(100 * time.Millisecond)
-		}
+	logger.WithContext(ctx).Info("Found active auto-bids", "count", len(autoBids))
 
-		// Người thứ nhất: bid cao hơn người thứ 2 một bước giá
-		winningBidAmount := secondHighest.MaxAmount + stepPrice
+	// 2. Tính giá và người giữ giá sau khi so các giá tối đa
+	result := engine.Resolve(engine.State{
+		CurrentPrice: currentPrice,
+		StepPrice:    stepPrice,
+		HolderID:     holderID,
+	}, autoBids)
 
-		// Không vượt quá max của người thứ nhất
-		if winningBidAmount > highestAutoBid.MaxAmount {
-			winningBidAmount = highestAutoBid.MaxAmount
+	logger.WithContext(ctx).Info("Auto-bidding resolved",
+		"product_id", productID,
+		"winner_id", result.WinnerID,
+		"price", result.Price,
+		"outbid_count", len(result.Outbid))
+
+	// 3. Đặt bid duy nhất cho người thắng (nếu giá hoặc người giữ giá thay đổi)
+	if result.Bid != nil {
+		if err := s.executeBid(ctx, result.Bid, result.Price); err != nil {
+			// Giá không đổi nên chưa đánh dấu OUTBID, lần trigger sau sẽ tính lại
+			return err
 		}
+	}
 
-		// Delay trước khi bid cuối cùng
-		time.Sleep(100 * time.Millisecond)
-		s.executeBid(ctx, highestAutoBid, winningBidAmount, userToken)
+	// 4. Đánh dấu OUTBID cho những auto-bid không còn khả năng thắng
+	for _, ab := range result.Outbid {
+		if err := s.repo.UpdateStatus(ctx, ab.ID, models.AutoBidStatusOutbid); err != nil {
+			logger.WithContext(ctx).Error("Failed to mark auto-bid as OUTBID", "error", err, "auto_bid_id", ab.ID)
+			continue
+		}
+		logger.WithContext(ctx).Info("Auto-bid marked as OUTBID", "auto_bid_id", ab.ID, "max_amount", ab.MaxAmount)
 	}
 
 	return nil
 }
 
-// executeBid thực hiện việc đặt giá qua bidding-service
-func (s *AutoBidService) executeBid(ctx context.Context, autoBid *models.AutoBid, amount float64, userToken string) {
+// executeBid thực hiện việc đặt giá qua bidding-service thay cho bidder của auto-bid
+func (s *AutoBidService) executeBid(ctx context.Context, autoBid *models.AutoBid, amount float64) error {
 	requestID := uuid.New().String()
 
-	slog.Info("Executing auto-bid",
+	logger.WithContext(ctx).Info("Executing auto-bid",
 		"auto_bid_id", autoBid.ID,
 		"bidder_id", autoBid.BidderID,
 		"product_id", autoBid.ProductID,
 		"amount", amount,
 		"max_amount", autoBid.MaxAmount,
-		"request_id", requestID)
+		"bid_request_id", requestID)
 
 	// Gọi bidding-service để đặt giá
 	resp, err := s.biddingServiceClient.PlaceBid(
+		ctx,
 		autoBid.ProductID,
 		autoBid.BidderID,
 		amount,
 		requestID,
-		userToken,
 	)
 
 	if err != nil {
-		slog.Error("Failed to place bid via bidding-service",
+		logger.WithContext(ctx).Error("Failed to place bid via bidding-service",
 			"error", err,
 			"auto_bid_id", autoBid.ID)
-		return
+		return err
 	}
 
 	if resp.Success {
 		// Cập nhật current_amount
 		s.repo.UpdateCurrentAmount(ctx, autoBid.ID, amount)
-		slog.Info("Auto-bid executed successfully",
+		logger.WithContext(ctx).Info("Auto-bid executed successfully",
 			"auto_bid_id", autoBid.ID,
 			"amount", amount)
 	} else {
-		slog.Error("Bid rejected by bidding-service",
+		logger.WithContext(ctx).Error("Bid rejected by bidding-service",
 			"auto_bid_id", autoBid.ID,
 			"message", resp.Message)
 
 		// Nếu bid thất bại, có thể cần đánh dấu auto-bid
 		// Tùy theo lý do thất bại mà xử lý khác nhau
+		return fmt.Errorf("bid rejected by bidding-service: %s", resp.Message)
 	}
+	return nil
 }
 
 // GetAutoBidsByBidder lấy danh sách auto-bid của một bidder
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const productID = 11
+
+// fakeRuns thay cho runLocked: mỗi lần chạy báo qua started rồi chờ kết quả từ release
+type fakeRuns struct {
+	started chan int
+	release chan error
+	count   int
+}
+
+func newFakeRuns() *fakeRuns {
+	return &fakeRuns{started: make(chan int), release: make(chan error)}
+}
+
+func (f *fakeRuns) run(ctx context.Context, id int64) error {
+	f.count++ // các lần chạy của một sản phẩm luôn tuần tự
+	f.started <- f.count
+	return <-f.release
+}
+
+func newTestService(f *fakeRuns) *AutoBidService {
+	return &AutoBidService{running: map[int64]*productRun{}, run: f.run}
+}
+
+// waitingCtx báo qua waiting khi trigger bị gộp bắt đầu chờ lần chạy tiếp theo (select trên Done)
+type waitingCtx struct {
+	context.Context
+	waiting chan struct{}
+}
+
+func (c waitingCtx) Done() <-chan struct{} {
+	c.waiting <- struct{}{}
+	return c.Context.Done()
+}
+
+func trigger(s *AutoBidService, ctx context.Context) <-chan error {
+	result := make(chan error, 1)
+	go func() { result <- s.TriggerAutoBidding(ctx, productID) }()
+	return result
+}
+
+func expectStarted(t *testing.T, f *fakeRuns, want int) {
+	t.Helper()
+	select {
+	case n := <-f.started:
+		if n != want {
+			t.Fatalf("run %d started, want run %d", n, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("run %d did not start", want)
+	}
+}
+
+func expectResult(t *testing.T, result <-chan error, want error) {
+	t.Helper()
+	select {
+	case err := <-result:
+		if !errors.Is(err, want) {
+			t.Fatalf("TriggerAutoBidding() = %v, want %v", err, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("TriggerAutoBidding did not return")
+	}
+}
+
+func TestTriggerCoalescesBurstIntoOneFollowUp(t *testing.T) {
+	f := newFakeRuns()
+	s := newTestService(f)
+
+	leader := trigger(s, context.Background())
+	expectStarted(t, f, 1)
+
+	// Trigger tới trong lúc sản phẩm đang được xử lý chỉ chờ, không chạy thêm
+	waiting := make(chan struct{})
+	ctx := waitingCtx{Context: context.Background(), waiting: waiting}
+	var burst []<-chan error
+	for i := 0; i < 5; i++ {
+		burst = append(burst, trigger(s, ctx))
+		<-waiting
+	}
+
+	f.release <- nil
+	expectStarted(t, f, 2)
+	errFollowUp := errors.New("bidding-service unavailable")
+	f.release <- errFollowUp
+
+	// Cả burst nhận kết quả của lần chạy tiếp theo, leader nhận kết quả lần chạy của nó
+	for _, result := range burst {
+		expectResult(t, result, errFollowUp)
+	}
+	expectResult(t, leader, nil)
+
+	if f.count != 2 {
+		t.Errorf("runs = %d, want 2", f.count)
+	}
+	if len(s.running) != 0 {
+		t.Errorf("running = %v, want empty after the burst", s.running)
+	}
+}
+
+func TestTriggerDuringFollowUpIsNotLost(t *testing.T) {
+	f := newFakeRuns()
+	s := newTestService(f)
+	waiting := make(chan struct{})
+	ctx := waitingCtx{Context: context.Background(), waiting: waiting}
+
+	leader := trigger(s, context.Background())
+	expectStarted(t, f, 1)
+	first := trigger(s, ctx)
+	<-waiting
+
+	f.release <- nil
+	expectStarted(t, f, 2)
+
+	// Trigger tới khi lần chạy tiếp theo đã bắt đầu có thể đọc trạng thái cũ: phải có thêm một lần chạy
+	second := trigger(s, ctx)
+	<-waiting
+
+	f.release <- nil
+	expectResult(t, first, nil)
+	expectStarted(t, f, 3)
+	errThird := errors.New("product-service unavailable")
+	f.release <- errThird
+	expectResult(t, second, errThird)
+	expectResult(t, leader, nil)
+
+	if f.count != 3 {
+		t.Errorf("runs = %d, want 3", f.count)
+	}
+}
+
+func TestTriggerCancelledWhileWaitingStillRuns(t *testing.T) {
+	f := newFakeRuns()
+	s := newTestService(f)
+
+	leader := trigger(s, context.Background())
+	expectStarted(t, f, 1)
+
+	waiting := make(chan struct{})
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	coalesced := trigger(s, waitingCtx{Context: cancelCtx, waiting: waiting})
+	<-waiting
+	cancel()
+	expectResult(t, coalesced, context.Canceled)
+
+	// Người gọi bỏ đi nhưng trigger đã được ghi nhận
+	f.release <- nil
+	expectStarted(t, f, 2)
+	f.release <- nil
+	expectResult(t, leader, nil)
+}
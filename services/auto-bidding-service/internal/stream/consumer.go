@@ -0,0 +1,274 @@
+package stream
+
+import (
+	"auto-bidding-service/internal/config"
+	"auto-bidding-service/internal/logger"
+	"auto-bidding-service/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	readCount = 10
+	readBlock = 2 * time.Second
+	// claimInterval là chu kỳ nhận lại các entry pending của consumer đã chết
+	claimInterval = 30 * time.Second
+	// maxDeliveries là số lần giao tối đa của một entry, quá số này entry được ack và bỏ qua để không kẹt mãi
+	maxDeliveries = 5
+	retryDelay    = time.Second
+	// defaultWorkers là số sản phẩm được xử lý song song khi STREAM_WORKERS không được đặt
+	defaultWorkers = 8
+)
+
+// Handler xử lý một event; trả về lỗi thì entry không được ack và sẽ được giao lại
+type Handler func(ctx context.Context, event *models.Event) error
+
+// Consumer đọc stream bằng consumer group: mỗi entry được giao cho một replica, chỉ ack sau khi xử lý xong
+// (at-least-once). Entry của consumer chết giữa chừng được replica khác nhận lại bằng XAUTOCLAIM.
+// Entry được xử lý song song theo sản phẩm (tối đa workers sản phẩm cùng lúc), entry của cùng sản phẩm xử lý tuần tự.
+type Consumer struct {
+	client        *redis.Client
+	streamKey     string
+	groupName     string
+	consumerName  string
+	claimIdle     time.Duration
+	claimInterval time.Duration
+	readBlock     time.Duration
+	workers       int
+
+	// Entry đang chờ hoặc đang được xử lý trong instance, XAUTOCLAIM không giao lại các entry này
+	mu       sync.Mutex
+	inFlight map[string]struct{}
+}
+
+// NewConsumer kết nối Redis và tạo consumer group nếu chưa có
+func NewConsumer(cfg *config.Config) (*Consumer, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%s", cfg.RedisHost, cfg.RedisPort),
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	claimIdle := time.Duration(cfg.StreamClaimIdle) * time.Second
+	if claimIdle <= 0 {
+		claimIdle = time.Minute
+	}
+	workers := cfg.StreamWorkers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	consumer := &Consumer{
+		client:        client,
+		streamKey:     cfg.RedisStreamKey,
+		groupName:     cfg.RedisConsumerGroup,
+		consumerName:  cfg.RedisConsumerName,
+		claimIdle:     claimIdle,
+		claimInterval: claimInterval,
+		readBlock:     readBlock,
+		workers:       workers,
+		inFlight:      map[string]struct{}{},
+	}
+
+	// Group mới chỉ đọc event từ thời điểm tạo: bid cũ trong stream đã được xử lý bởi endpoint trigger
+	err := client.XGroupCreateMkStream(ctx, consumer.streamKey, consumer.groupName, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	return consumer, nil
+}
+
+// Run đọc và xử lý event tới khi ctx bị hủy, trả về sau khi các entry đang xử lý xong
+func (c *Consumer) Run(ctx context.Context, handler Handler) error {
+	d := newDispatcher(c.workers, c.workers*readCount)
+	defer d.wait()
+
+	// Lần đầu nhận lại entry pending ngay, kể cả của chính consumer này trước khi restart
+	lastClaim := time.Time{}
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if time.Since(lastClaim) >= c.claimInterval {
+			c.claimPending(ctx, d, handler)
+			lastClaim = time.Now()
+		}
+
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.groupName,
+			Consumer: c.consumerName,
+			Streams:  []string{c.streamKey, ">"},
+			Count:    readCount,
+			Block:    c.readBlock,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			slog.Warn("Failed to read bid stream", "stream", c.streamKey, "error", err)
+			time.Sleep(retryDelay)
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				c.dispatch(ctx, d, message, handler)
+			}
+		}
+	}
+}
+
+// claimPending nhận và xử lý các entry pending quá claimIdle (consumer chết hoặc xử lý lỗi)
+func (c *Consumer) claimPending(ctx context.Context, d *dispatcher, handler Handler) {
+	start := "0-0"
+	for {
+		messages, next, err := c.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   c.streamKey,
+			Group:    c.groupName,
+			Consumer: c.consumerName,
+			MinIdle:  c.claimIdle,
+			Start:    start,
+			Count:    readCount,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("Failed to claim pending bid events", "stream", c.streamKey, "error", err)
+			}
+			return
+		}
+
+		for _, message := range messages {
+			if c.isInFlight(message.ID) {
+				// Entry xử lý lâu hơn claimIdle vẫn đang chạy trong instance này
+				continue
+			}
+			if c.deliveries(ctx, message.ID) > maxDeliveries {
+				slog.Error("Bid event failed too many times, skipping",
+					"stream", c.streamKey,
+					"message_id", message.ID,
+					"max_deliveries", maxDeliveries)
+				c.ack(ctx, message.ID)
+				continue
+			}
+			c.dispatch(ctx, d, message, handler)
+		}
+
+		if next == "0-0" || next == "" {
+			return
+		}
+		start = next
+	}
+}
+
+// deliveries trả về số lần entry đã được giao (tính cả lần XAUTOCLAIM vừa rồi)
+func (c *Consumer) deliveries(ctx context.Context, id string) int64 {
+	pending, err := c.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: c.streamKey,
+		Group:  c.groupName,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return 0
+	}
+	return pending[0].RetryCount
+}
+
+// dispatch đưa entry vào hàng đợi của sản phẩm; entry được ack khi worker xử lý xong
+func (c *Consumer) dispatch(ctx context.Context, d *dispatcher, message redis.XMessage, handler Handler) {
+	event, err := parseEvent(message.Values)
+	if err != nil {
+		// Entry không đọc được sẽ không bao giờ xử lý được, ack để không bị giao lại
+		slog.Warn("Invalid bid stream entry, skipping", "message_id", message.ID, "error", err)
+		c.ack(ctx, message.ID)
+		return
+	}
+
+	// Data sai định dạng vẫn được đưa cho handler (handler tự ghi log), sản phẩm chỉ dùng để chọn hàng đợi
+	productID, _ := event.ProductID()
+	c.setInFlight(message.ID, true)
+	ok := d.dispatch(ctx, productID, func() {
+		defer c.setInFlight(message.ID, false)
+		c.process(ctx, message.ID, event, handler)
+	})
+	if !ok {
+		// Consumer đang dừng: entry còn pending và được nhận lại sau
+		c.setInFlight(message.ID, false)
+	}
+}
+
+func (c *Consumer) process(ctx context.Context, id string, event *models.Event, handler Handler) {
+	if ctx.Err() != nil {
+		return
+	}
+	if err := handler(ctx, event); err != nil {
+		logger.WithContext(ctx).Error("Failed to handle bid event, will retry",
+			"message_id", id,
+			"event_type", event.Type,
+			"entity_id", event.EntityID,
+			"error", err)
+		return
+	}
+	c.ack(ctx, id)
+}
+
+func (c *Consumer) setInFlight(id string, inFlight bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if inFlight {
+		c.inFlight[id] = struct{}{}
+	} else {
+		delete(c.inFlight, id)
+	}
+}
+
+func (c *Consumer) isInFlight(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.inFlight[id]
+	return ok
+}
+
+func (c *Consumer) ack(ctx context.Context, id string) {
+	if err := c.client.XAck(ctx, c.streamKey, c.groupName, id).Err(); err != nil {
+		slog.Warn("Failed to ack bid event", "message_id", id, "error", err)
+	}
+}
+
+func parseEvent(values map[string]interface{}) (*models.Event, error) {
+	eventData, ok := values["event"].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing event data")
+	}
+
+	var event models.Event
+	if err := json.Unmarshal([]byte(eventData), &event); err != nil {
+		return nil, fmt.Errorf("error unmarshaling event: %w", err)
+	}
+	return &event, nil
+}
+
+// Close đóng kết nối Redis
+func (c *Consumer) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,271 @@
+package stream
+
+import (
+	"auto-bidding-service/internal/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	testStream = "auction_events"
+	testGroup  = "auto_bidding_service_group"
+)
+
+// newTestConsumer tạo consumer trên miniredis với thời gian nhận lại entry pending rút ngắn
+func newTestConsumer(t *testing.T) *Consumer {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	if err := client.XGroupCreateMkStream(context.Background(), testStream, testGroup, "$").Err(); err != nil {
+		t.Fatal(err)
+	}
+	return &Consumer{
+		client:        client,
+		streamKey:     testStream,
+		groupName:     testGroup,
+		consumerName:  "replica-1",
+		claimIdle:     20 * time.Millisecond,
+		claimInterval: 30 * time.Millisecond,
+		readBlock:     10 * time.Millisecond,
+		workers:       4,
+		inFlight:      map[string]struct{}{},
+	}
+}
+
+// run chạy consumer tới hết test
+func run(t *testing.T, c *Consumer, handler Handler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		c.Run(ctx, handler)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+}
+
+func addEntry(t *testing.T, c *Consumer, values map[string]interface{}) string {
+	t.Helper()
+	id, err := c.client.XAdd(context.Background(), &redis.XAddArgs{Stream: testStream, Values: values}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// addBid ghi event bid.placed của sản phẩm như bidding-service
+func addBid(t *testing.T, c *Consumer, productID int64) string {
+	t.Helper()
+	event, err := json.Marshal(models.Event{
+		Type:     models.EventBidPlaced,
+		EntityID: productID,
+		Data:     map[string]interface{}{"product_id": productID, "current_price": 10_000_000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addEntry(t, c, map[string]interface{}{"event": string(event)})
+}
+
+func pending(t *testing.T, c *Consumer) int64 {
+	t.Helper()
+	summary, err := c.client.XPending(context.Background(), testStream, testGroup).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return summary.Count
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// recorder là handler ghi lại sản phẩm của các event đã nhận, fail quyết định event có lỗi hay không
+type recorder struct {
+	mu    sync.Mutex
+	calls []int64
+	fail  func(call int) error
+}
+
+func (r *recorder) handle(ctx context.Context, event *models.Event) error {
+	productID, _ := event.ProductID()
+	r.mu.Lock()
+	r.calls = append(r.calls, productID)
+	call := len(r.calls)
+	r.mu.Unlock()
+	if r.fail != nil {
+		return r.fail(call)
+	}
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.calls)
+}
+
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]interface{}
+		want    *models.Event
+		wantErr bool
+	}{
+		{
+			name:   "bid placed",
+			values: map[string]interface{}{"event": `{"type":"bid.placed","entity_id":11,"timestamp":1735718400,"data":{"product_id":11}}`},
+			want: &models.Event{
+				Type:      models.EventBidPlaced,
+				EntityID:  11,
+				Timestamp: 1735718400,
+				Data:      map[string]interface{}{"product_id": float64(11)},
+			},
+		},
+		{name: "missing event field", values: map[string]interface{}{"type": "bid.placed"}, wantErr: true},
+		{name: "event is not a string", values: map[string]interface{}{"event": 11}, wantErr: true},
+		{name: "event is not JSON", values: map[string]interface{}{"event": "bid.placed"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseEvent(tt.values)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parseEvent() = %+v, want error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: parseEvent() error = %v", tt.name, err)
+			continue
+		}
+		if got.Type != tt.want.Type || got.EntityID != tt.want.EntityID || got.Timestamp != tt.want.Timestamp ||
+			fmt.Sprint(got.Data) != fmt.Sprint(tt.want.Data) {
+			t.Errorf("%s: parseEvent() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidEntryIsAcked(t *testing.T) {
+	c := newTestConsumer(t)
+	r := &recorder{}
+	run(t, c, r.handle)
+
+	addEntry(t, c, map[string]interface{}{"event": "not json"})
+	addEntry(t, c, map[string]interface{}{"type": "bid.placed"})
+	// Entry hợp lệ ghi sau cùng: khi handler nhận được nó thì hai entry trước đã được đọc
+	addBid(t, c, 11)
+
+	waitFor(t, "valid entry handled and acked", func() bool { return r.count() == 1 && pending(t, c) == 0 })
+	if got := r.count(); got != 1 {
+		t.Errorf("handler called %d times, want 1 (invalid entries skipped)", got)
+	}
+}
+
+func TestFailedEntryIsReclaimed(t *testing.T) {
+	c := newTestConsumer(t)
+	r := &recorder{fail: func(call int) error {
+		if call == 1 {
+			return errors.New("product-service unavailable")
+		}
+		return nil
+	}}
+	run(t, c, r.handle)
+
+	addBid(t, c, 11)
+
+	// Lần đầu lỗi nên entry còn pending, XAUTOCLAIM nhận lại sau claimIdle và lần hai thành công
+	waitFor(t, "entry reclaimed and acked", func() bool { return r.count() == 2 && pending(t, c) == 0 })
+}
+
+func TestEntrySkippedAfterMaxDeliveries(t *testing.T) {
+	c := newTestConsumer(t)
+	r := &recorder{fail: func(int) error { return errors.New("bidding-service unavailable") }}
+	run(t, c, r.handle)
+
+	addBid(t, c, 11)
+
+	waitFor(t, "failing entry acked", func() bool { return r.count() > 0 && pending(t, c) == 0 })
+	if got := r.count(); got != maxDeliveries {
+		t.Errorf("handler called %d times, want %d", got, maxDeliveries)
+	}
+}
+
+func TestSlowProductDoesNotBlockOthers(t *testing.T) {
+	c := newTestConsumer(t)
+
+	release := make(chan struct{})
+	var (
+		mu      sync.Mutex
+		handled []int64
+		active  = map[int64]int{}
+		overlap bool
+	)
+	handler := func(ctx context.Context, event *models.Event) error {
+		productID, _ := event.ProductID()
+		mu.Lock()
+		active[productID]++
+		if active[productID] > 1 {
+			overlap = true
+		}
+		mu.Unlock()
+
+		if productID == 11 {
+			// Sản phẩm 11 chờ lock lâu (replica khác đang xử lý)
+			<-release
+		}
+
+		mu.Lock()
+		active[productID]--
+		handled = append(handled, productID)
+		mu.Unlock()
+		return nil
+	}
+	run(t, c, handler)
+
+	addBid(t, c, 11)
+	addBid(t, c, 11)
+	addBid(t, c, 12)
+	addBid(t, c, 13)
+
+	// Sản phẩm khác được xử lý và ack trong lúc sản phẩm 11 còn chờ, kể cả khi claimIdle đã qua
+	waitFor(t, "other products handled", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == 2
+	})
+	time.Sleep(3 * c.claimInterval)
+	if got := pending(t, c); got != 2 {
+		t.Errorf("pending = %d while product 11 is blocked, want 2", got)
+	}
+
+	close(release)
+	waitFor(t, "all entries acked", func() bool { return pending(t, c) == 0 })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled) != 4 {
+		t.Errorf("handled %v, want each entry once", handled)
+	}
+	if overlap {
+		t.Error("entries of the same product were handled concurrently")
+	}
+}
@@ -0,0 +1,75 @@
+package stream
+
+import (
+	"context"
+	"sync"
+)
+
+// dispatcher chạy job theo sản phẩm: job của cùng sản phẩm chạy tuần tự theo thứ tự nhận, job của các sản phẩm
+// khác nhau chạy song song trên tối đa workers goroutine. Một sản phẩm chờ lock lâu không giữ chân sản phẩm khác.
+// Số job đang chờ hoặc đang chạy bị giới hạn bởi maxPending: dispatch chặn khi đầy nên consumer ngừng đọc stream
+// thay vì giữ vô hạn entry trong bộ nhớ.
+type dispatcher struct {
+	workers chan struct{}
+	pending chan struct{}
+
+	mu     sync.Mutex
+	queues map[int64][]func() // có key khi sản phẩm đang có goroutine xử lý, value là các job chờ sau
+	wg     sync.WaitGroup
+}
+
+func newDispatcher(workers, maxPending int) *dispatcher {
+	return &dispatcher{
+		workers: make(chan struct{}, workers),
+		pending: make(chan struct{}, maxPending),
+		queues:  map[int64][]func(){},
+	}
+}
+
+// dispatch đưa job vào hàng đợi của sản phẩm, trả về false nếu ctx bị hủy khi đang chờ chỗ trống
+func (d *dispatcher) dispatch(ctx context.Context, productID int64, job func()) bool {
+	select {
+	case d.pending <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+
+	d.mu.Lock()
+	if queue, running := d.queues[productID]; running {
+		d.queues[productID] = append(queue, job)
+		d.mu.Unlock()
+		return true
+	}
+	d.queues[productID] = nil
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go d.drain(productID, job)
+	return true
+}
+
+// drain chạy job rồi lần lượt các job tới sau của cùng sản phẩm, thoát khi hàng đợi rỗng
+func (d *dispatcher) drain(productID int64, job func()) {
+	defer d.wg.Done()
+	for {
+		d.workers <- struct{}{}
+		job()
+		<-d.workers
+		<-d.pending
+
+		d.mu.Lock()
+		queue := d.queues[productID]
+		if len(queue) == 0 {
+			delete(d.queues, productID)
+			d.mu.Unlock()
+			return
+		}
+		job, d.queues[productID] = queue[0], queue[1:]
+		d.mu.Unlock()
+	}
+}
+
+// wait chờ mọi job đã nhận chạy xong
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
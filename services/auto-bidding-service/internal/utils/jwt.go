@@ -1,9 +1,15 @@
 package utils
 
 import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type JWTClaims struct {
@@ -45,3 +51,53 @@ func ValidateToken(tokenString, secret string) (*JWTClaims, error) {
 
 	return nil, jwt.ErrSignatureInvalid
 }
+
+// ServiceClaims là claims của JWT service-to-service: auto-bidding-service đặt giá thay bidder BidderID
+type ServiceClaims struct {
+	BidderID int64 `json:"bidder_id"`
+	jwt.RegisteredClaims
+}
+
+// ServiceSigner ký JWT RS256 bằng private key riêng của service (giống generateInternalJWT của API Gateway)
+type ServiceSigner struct {
+	privateKey *rsa.PrivateKey
+	issuer     string
+}
+
+// NewServiceSigner parse private key PKCS8 dạng PEM, issuer là tên service
+func NewServiceSigner(privPem, issuer string) (*ServiceSigner, error) {
+	if privPem == "" {
+		return nil, errors.New("missing JWT_PRIVATE_KEY in config")
+	}
+	block, _ := pem.Decode([]byte(privPem))
+	if block == nil {
+		return nil, errors.New("failed to parse PEM block for private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return &ServiceSigner{privateKey: privateKey, issuer: issuer}, nil
+}
+
+// BidderToken tạo JWT ngắn hạn cho một request gửi tới aud, bidder được ký trong claim bidder_id và sub
+func (s *ServiceSigner) BidderToken(aud string, bidderID int64) (string, error) {
+	now := time.Now()
+	claims := ServiceClaims{
+		BidderID: bidderID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   strconv.FormatInt(bidderID, 10),
+			Audience:  []string{aud},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.New().String(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	return token.SignedString(s.privateKey)
+}
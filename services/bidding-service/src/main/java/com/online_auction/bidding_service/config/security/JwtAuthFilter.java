@@ -23,12 +23,18 @@ import lombok.extern.slf4j.Slf4j;
 @Slf4j
 public class JwtAuthFilter extends OncePerRequestFilter {
 
+    // Route đặt giá thay bidder của auto-bidding-service, chỉ nhận service token
+    public static final String INTERNAL_BID_PATH = "/api/bids/internal";
+
     private final InternalKeyValidator internalKeyValidator;
     private final TokenParser tokenParser;
+    private final ServiceTokenVerifier serviceTokenVerifier;
 
-    public JwtAuthFilter(InternalKeyValidator internalKeyValidator, TokenParser tokenParser) {
+    public JwtAuthFilter(InternalKeyValidator internalKeyValidator, TokenParser tokenParser,
+            ServiceTokenVerifier serviceTokenVerifier) {
         this.internalKeyValidator = internalKeyValidator;
         this.tokenParser = tokenParser;
+        this.serviceTokenVerifier = serviceTokenVerifier;
     }
 
     @Override
@@ -61,7 +67,27 @@ public class JwtAuthFilter extends OncePerRequestFilter {
             return;
         }
 
-        // 2. Parse JWT từ header X-User-Token
+        // 2. Route nội bộ chỉ nhận service token RS256 của auto-bidding-service (X-Internal-JWT),
+        // không dùng ROLE_GATEWAY mặc định của request không có X-User-Token
+        if (INTERNAL_BID_PATH.equals(request.getServletPath())) {
+            Long bidderId = serviceTokenVerifier.verifyBidder(request.getHeader("X-Internal-JWT"));
+            if (bidderId == null) {
+                response.sendError(HttpServletResponse.SC_UNAUTHORIZED, "Invalid service token");
+                return;
+            }
+
+            UserPrincipal principal = new UserPrincipal(bidderId, null, UserRole.ROLE_BIDDER);
+            UsernamePasswordAuthenticationToken auth = new UsernamePasswordAuthenticationToken(
+                    principal,
+                    null,
+                    List.of(new SimpleGrantedAuthority("ROLE_AUTO_BIDDING")));
+            SecurityContextHolder.getContext().setAuthentication(auth);
+
+            filterChain.doFilter(request, response);
+            return;
+        }
+
+        // 3. Parse JWT từ header X-User-Token
         String token = request.getHeader("X-User-Token");
         System.out.println("Token: " + token);
         if (!StringUtils.isBlank(token)) {
@@ -0,0 +1,137 @@
+package com.online_auction.bidding_service.config.security;
+
+import java.security.KeyFactory;
+import java.security.PublicKey;
+import java.security.spec.X509EncodedKeySpec;
+import java.time.Duration;
+import java.util.Base64;
+import java.util.Collection;
+import java.util.Date;
+
+import org.springframework.beans.factory.annotation.Value;
+import org.springframework.dao.DataAccessException;
+import org.springframework.data.redis.core.StringRedisTemplate;
+import org.springframework.stereotype.Component;
+
+import io.jsonwebtoken.Claims;
+import io.jsonwebtoken.JwtException;
+import io.jsonwebtoken.Jwts;
+import io.micrometer.common.util.StringUtils;
+import lombok.RequiredArgsConstructor;
+import lombok.extern.slf4j.Slf4j;
+
+// Xác thực JWT RS256 mà auto-bidding-service ký bằng private key của nó (header X-Internal-JWT).
+// Bidder được đặt giá thay nằm trong claim bidder_id. Mỗi token chỉ dùng được một lần: jti đã dùng
+// được giữ trong Redis (chung cho mọi replica) tới khi token hết hạn.
+@Component
+@RequiredArgsConstructor
+@Slf4j
+public class ServiceTokenVerifier {
+
+    private static final String USED_JTI_PREFIX = "service-token:jti:";
+
+    private final StringRedisTemplate redisTemplate;
+
+    @Value("${auto-bidding.public-key:}")
+    private String publicKeyPem;
+
+    @Value("${auto-bidding.issuer:auto-bidding-service}")
+    private String issuer;
+
+    @Value("${auto-bidding.audience:bidding-service}")
+    private String audience;
+
+    private volatile PublicKey publicKey;
+
+    // Trả về bidder ID trong token, null nếu token không hợp lệ hoặc chưa cấu hình public key
+    public Long verifyBidder(String token) {
+        if (StringUtils.isBlank(token)) {
+            return null;
+        }
+        try {
+            Claims claims = Jwts.parserBuilder()
+                    .setSigningKey(getPublicKey())
+                    .requireIssuer(issuer)
+                    .build()
+                    .parseClaimsJws(token)
+                    .getBody();
+
+            if (!hasAudience(claims.get(Claims.AUDIENCE))) {
+                log.warn("Service token audience mismatch");
+                return null;
+            }
+
+            Long bidderId = claims.get("bidder_id", Long.class);
+            if (bidderId == null || bidderId <= 0 || !String.valueOf(bidderId).equals(claims.getSubject())) {
+                log.warn("Service token has invalid bidder_id");
+                return null;
+            }
+
+            if (!markUsed(claims)) {
+                return null;
+            }
+            return bidderId;
+        } catch (JwtException | IllegalArgumentException e) {
+            log.warn("Invalid service token: {}", e.getMessage());
+            return null;
+        }
+    }
+
+    // Ghi jti vào Redis với TTL bằng thời gian còn lại của token, false nếu jti đã được dùng.
+    // Redis lỗi thì từ chối token: không kiểm tra được replay thì không đặt giá.
+    private boolean markUsed(Claims claims) {
+        String jti = claims.getId();
+        Date expiration = claims.getExpiration();
+        if (StringUtils.isBlank(jti) || expiration == null) {
+            log.warn("Service token without jti or exp");
+            return false;
+        }
+        long ttlMillis = expiration.getTime() - System.currentTimeMillis();
+        if (ttlMillis <= 0) {
+            return false;
+        }
+        try {
+            Boolean first = redisTemplate.opsForValue()
+                    .setIfAbsent(USED_JTI_PREFIX + jti, "1", Duration.ofMillis(ttlMillis));
+            if (!Boolean.TRUE.equals(first)) {
+                log.warn("Service token replayed: jti={}", jti);
+                return false;
+            }
+            return true;
+        } catch (DataAccessException e) {
+            log.error("Failed to record service token jti: {}", e.getMessage());
+            return false;
+        }
+    }
+
+    // aud có thể là chuỗi hoặc mảng chuỗi
+    private boolean hasAudience(Object aud) {
+        if (aud instanceof String value) {
+            return audience.equals(value);
+        }
+        if (aud instanceof Collection<?> values) {
+            return values.contains(audience);
+        }
+        return false;
+    }
+
+    private PublicKey getPublicKey() {
+        if (publicKey == null) {
+            if (StringUtils.isBlank(publicKeyPem)) {
+                throw new IllegalArgumentException("auto-bidding.public-key is not configured");
+            }
+            try {
+                String base64 = publicKeyPem
+                        .replace("\\n", "\n")
+                        .replace("-----BEGIN PUBLIC KEY-----", "")
+                        .replace("-----END PUBLIC KEY-----", "")
+                        .replaceAll("\\s", "");
+                byte[] der = Base64.getDecoder().decode(base64);
+                publicKey = KeyFactory.getInstance("RSA").generatePublic(new X509EncodedKeySpec(der));
+            } catch (Exception e) {
+                throw new IllegalArgumentException("Invalid auto-bidding.public-key: " + e.getMessage(), e);
+            }
+        }
+        return publicKey;
+    }
+}
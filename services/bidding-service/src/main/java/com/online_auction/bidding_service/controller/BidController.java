@@ -22,6 +22,7 @@ import com.online_auction.bidding_service.domain.BiddingHistory;
 import com.online_auction.bidding_service.domain.Product;
 import com.online_auction.bidding_service.dto.request.AutoBidRegisterRequest;
 import com.online_auction.bidding_service.dto.request.BidRequest;
+import com.online_auction.bidding_service.dto.request.InternalBidRequest;
 import com.online_auction.bidding_service.dto.response.ApiResponse;
 import com.online_auction.bidding_service.dto.response.BiddingHistorySearchResponse;
 import com.online_auction.bidding_service.dto.response.UserBidResponse;
@@ -58,6 +59,28 @@ public class BidController {
                                 .body(response);
         }
 
+        // Endpoint nội bộ cho auto-bidding-service: đặt giá thay bidder trong claim bidder_id của X-Internal-JWT
+        // (RS256, ký bằng private key của auto-bidding-service), không dùng token của user.
+        // JwtAuthFilter đã xác thực service token (kể cả replay theo jti) và gắn bidder vào principal.
+        @PostMapping("/internal")
+        @PreAuthorize("hasRole('ROLE_AUTO_BIDDING')")
+        public ResponseEntity<?> placeBidOnBehalf(@Valid @RequestBody InternalBidRequest req) {
+                Authentication auth = SecurityContextHolder.getContext().getAuthentication();
+                Long bidderId = ((UserPrincipal) auth.getPrincipal()).getUserId();
+
+                log.info("Internal bid: productId={}, bidderId={}, amount={}, requestId={}",
+                                req.getProductId(), bidderId, req.getAmount(), req.getRequestId());
+                ApiResponse<?> response = bidService.placeBid(
+                                req.getProductId(),
+                                bidderId,
+                                req.getAmount(),
+                                req.getRequestId());
+
+                return ResponseEntity
+                                .status(response.isSuccess() ? 200 : 400)
+                                .body(response);
+        }
+
         @GetMapping("/search")
         @PreAuthorize("hasAnyRole('ROLE_ADMIN', 'ROLE_SELLER', 'ROLE_BIDDER')")
         public Page<BiddingHistorySearchResponse> search(
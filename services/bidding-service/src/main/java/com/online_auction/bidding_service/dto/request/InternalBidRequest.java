@@ -0,0 +1,25 @@
+package com.online_auction.bidding_service.dto.request;
+
+import com.fasterxml.jackson.annotation.JsonProperty;
+
+import jakarta.validation.constraints.Min;
+import jakarta.validation.constraints.NotNull;
+import lombok.*;
+
+// Request đặt giá của auto-bidding-service (JSON snake_case của service Go),
+// bidder không nằm trong body mà trong claim bidder_id của X-Internal-JWT
+@Data
+@NoArgsConstructor
+@AllArgsConstructor
+public class InternalBidRequest {
+    @NotNull
+    @JsonProperty("product_id")
+    private Long productId;
+
+    @NotNull
+    @Min(0)
+    private Double amount;
+
+    @JsonProperty("request_id")
+    private String requestId;
+}
@@ -0,0 +1,91 @@
+package com.online_auction.bidding_service.event;
+
+import java.time.Instant;
+import java.util.LinkedHashMap;
+import java.util.Map;
+
+import org.springframework.beans.factory.annotation.Value;
+import org.springframework.data.redis.connection.stream.StreamRecords;
+import org.springframework.data.redis.core.StringRedisTemplate;
+import org.springframework.stereotype.Component;
+import org.springframework.transaction.support.TransactionSynchronization;
+import org.springframework.transaction.support.TransactionSynchronizationManager;
+
+import com.fasterxml.jackson.core.JsonProcessingException;
+import com.fasterxml.jackson.databind.ObjectMapper;
+import com.online_auction.bidding_service.domain.Product;
+
+import lombok.RequiredArgsConstructor;
+import lombok.extern.slf4j.Slf4j;
+
+// Ghi event bid.placed vào Redis Stream auction_events (auto-bidding-service và search-service đọc stream này).
+// Event được ghi sau khi transaction đặt giá commit, Redis lỗi chỉ được log và không làm hỏng bid.
+@Component
+@RequiredArgsConstructor
+@Slf4j
+public class BidStreamPublisher {
+
+    public static final String EVENT_BID_PLACED = "bid.placed";
+
+    private final StringRedisTemplate redisTemplate;
+    private final ObjectMapper objectMapper = new ObjectMapper();
+
+    @Value("${auction-events.enabled:true}")
+    private boolean enabled;
+
+    @Value("${auction-events.stream-key:auction_events}")
+    private String streamKey;
+
+    @Value("${auction-events.max-len:100000}")
+    private long maxLen;
+
+    public void publishBidPlaced(Product product) {
+        if (!enabled) {
+            return;
+        }
+
+        Map<String, Object> bidderInfo = new LinkedHashMap<>();
+        bidderInfo.put("user_id", product.getCurrentBidder());
+
+        Map<String, Object> data = new LinkedHashMap<>();
+        data.put("product_id", product.getId());
+        data.put("current_price", product.getCurrentPrice());
+        data.put("current_bid_count", product.getBidCount());
+        data.put("bidder_info", bidderInfo);
+
+        Map<String, Object> event = new LinkedHashMap<>();
+        event.put("type", EVENT_BID_PLACED);
+        event.put("entity_id", product.getId());
+        event.put("timestamp", Instant.now().getEpochSecond());
+        event.put("data", data);
+
+        String payload;
+        try {
+            payload = objectMapper.writeValueAsString(event);
+        } catch (JsonProcessingException e) {
+            log.error("Failed to serialize bid.placed event: productId={}", product.getId(), e);
+            return;
+        }
+
+        if (TransactionSynchronizationManager.isSynchronizationActive()) {
+            TransactionSynchronizationManager.registerSynchronization(new TransactionSynchronization() {
+                @Override
+                public void afterCommit() {
+                    add(product.getId(), payload);
+                }
+            });
+        } else {
+            add(product.getId(), payload);
+        }
+    }
+
+    private void add(Long productId, String payload) {
+        try {
+            redisTemplate.opsForStream().add(
+                    StreamRecords.string(Map.of("event", payload)).withStreamKey(streamKey));
+            redisTemplate.opsForStream().trim(streamKey, maxLen, true);
+        } catch (RuntimeException e) {
+            log.error("Failed to publish bid.placed event: productId={}, stream={}", productId, streamKey, e);
+        }
+    }
+}
@@ -26,6 +26,7 @@ import com.online_auction.bidding_service.dto.response.BiddingHistorySearchRespo
 import com.online_auction.bidding_service.dto.response.ProductBidSuccessData;
 import com.online_auction.bidding_service.dto.response.UserBidResponse;
 import com.online_auction.bidding_service.event.BidPlacedEvent;
+import com.online_auction.bidding_service.event.BidStreamPublisher;
 import com.online_auction.bidding_service.repository.AutoBidRepository;
 import com.online_auction.bidding_service.repository.BiddingHistoryRepository;
 import com.online_auction.bidding_service.repository.ProductRepository;
@@ -47,6 +48,7 @@ public class BidService {
         private final ProductServiceClient productServiceClient;
         private final RabbitTemplate rabbitTemplate;
         private final AutoBidRepository autoBidRepository;
+        private final BidStreamPublisher bidStreamPublisher;
         private ObjectMapper objectMapper = new ObjectMapper();
 
     @Transactional
@@ -113,6 +115,7 @@ public class BidService {
                     bidAmount,
                     null,
                     requestId);
+            bidStreamPublisher.publishBidPlaced(product);
 
             return ApiResponse.ok(
                     new ProductBidSuccessData(bidAmount, null),
@@ -156,6 +159,7 @@ public class BidService {
                                 bidAmount,
                                 previousHighestBidder,
                                 requestId);
+                bidStreamPublisher.publishBidPlaced(product);
 
                 return ApiResponse.ok(
                                 new ProductBidSuccessData(bidAmount, previousHighestBidder),
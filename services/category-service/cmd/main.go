@@ -43,6 +43,9 @@ import (
 
 func main() {
 
+	// Log nghiệp vụ ghi qua slog.*Context mang request_id của request
+	slog.SetDefault(slog.New(middleware.NewContextHandler(slog.NewTextHandler(os.Stdout, nil))))
+
 	// Load config
 	cfg := config.LoadConfig()
 
@@ -75,8 +78,9 @@ func main() {
 
 	// Middleware
 	app.Use(recover.New())
+	app.Use(middleware.RequestIDMiddleware())
 	app.Use(fiberlogger.New(fiberlogger.Config{
-		Format: "${time} | ${status} | ${latency} | ${method} | ${path}\n",
+		Format: "${time} | ${status} | ${latency} | ${method} | ${path} | request_id=${locals:requestID}\n",
 	}))
 	
 	// CORS middleware - IMPORTANT: Must be enabled for frontend to work
@@ -2,6 +2,7 @@ package main
 
 import (
 	"comment_service/internal/config"
+	"comment_service/internal/events"
 	"comment_service/internal/handlers"
 	"comment_service/internal/middleware"
 	"log"
@@ -43,6 +44,9 @@ import (
 
 func main() {
 
+	// Log nghiệp vụ ghi qua slog.*Context mang request_id của request
+	slog.SetDefault(slog.New(middleware.NewContextHandler(slog.NewTextHandler(os.Stdout, nil))))
+
 	// Load config
 	cfg := config.LoadConfig()
 
@@ -86,8 +90,9 @@ func main() {
 
 	// Middleware
 	app.Use(recover.New())
+	app.Use(middleware.RequestIDMiddleware())
 	app.Use(fiberlogger.New(fiberlogger.Config{
-		Format: "${time} | ${status} | ${latency} | ${method} | ${path}\n",
+		Format: "${time} | ${status} | ${latency} | ${method} | ${path} | request_id=${locals:requestID}\n",
 	}))
 
 	// Swagger route
@@ -96,8 +101,12 @@ func main() {
 	// Serve static files for testing
 	app.Static("/", "./public")
 
+	// Event của hub WebSocket được ghi vào Redis Stream cho SSE của API Gateway
+	publisher := events.NewPublisher(cfg)
+	defer publisher.Close()
+
 	// Initialize handlers
-	commentHandler := handlers.NewCommentHandler(db, cfg)
+	commentHandler := handlers.NewCommentHandler(db, cfg, publisher)
 
 	// Routes
 	api := app.Group("")
@@ -0,0 +1,107 @@
+// Publisher được giữ giống hệt nhau trong comment-service và order-service (mỗi service là một module riêng):
+// sửa file này thì sửa ở cả hai service. Test nằm ở order-service.
+
+package events
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	queueSize      = 1024
+	publishTimeout = 3 * time.Second
+)
+
+type entry struct {
+	stream    string
+	eventType string
+	data      []byte
+}
+
+// Publisher ghi các event mà hub WebSocket broadcast vào Redis Stream để client không dùng được WebSocket
+// nhận qua SSE của API Gateway. Event được ghi ở background, Redis lỗi không ảnh hưởng tới WebSocket.
+type Publisher struct {
+	client *redis.Client
+	maxLen int64
+	ttl    time.Duration
+	queue  chan entry
+	wg     sync.WaitGroup
+}
+
+// newPublisher kiểm tra kết nối Redis và bắt đầu ghi event ở background
+func newPublisher(client *redis.Client, maxLen int64, ttl time.Duration) *Publisher {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		// Không fatal: client tự kết nối lại, event được ghi khi Redis sẵn sàng
+		slog.Warn("Failed to connect to Redis, live events will be published once it is available", "error", err)
+	} else {
+		slog.Info("Connected to Redis for live events", "addr", client.Options().Addr)
+	}
+
+	p := &Publisher{
+		client: client,
+		maxLen: maxLen,
+		ttl:    ttl,
+		queue:  make(chan entry, queueSize),
+	}
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+// Publish đưa event vào hàng đợi, không chặn; event bị bỏ nếu hàng đợi đầy
+func (p *Publisher) Publish(stream, eventType string, data []byte) {
+	if p == nil {
+		return
+	}
+	select {
+	case p.queue <- entry{stream: stream, eventType: eventType, data: data}:
+	default:
+		slog.Warn("Live event queue full, event dropped", "stream", stream, "type", eventType)
+	}
+}
+
+// Close ghi nốt các event trong hàng đợi rồi đóng Redis client
+func (p *Publisher) Close() {
+	if p == nil {
+		return
+	}
+	close(p.queue)
+	p.wg.Wait()
+	p.client.Close()
+}
+
+func (p *Publisher) run() {
+	defer p.wg.Done()
+	for e := range p.queue {
+		p.write(e)
+	}
+}
+
+func (p *Publisher) write(e entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	pipe := p.client.Pipeline()
+	pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: e.stream,
+		MaxLen: p.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type": e.eventType,
+			"data": e.data,
+		},
+	})
+	if p.ttl > 0 {
+		pipe.Expire(ctx, e.stream, p.ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.Error("Failed to publish live event", "stream", e.stream, "type", e.eventType, "error", err)
+	}
+}
@@ -0,0 +1,31 @@
+package events
+
+import (
+	"comment_service/internal/config"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ProductStreamPrefix là prefix của Redis Stream chứa event bình luận của một sản phẩm,
+// API Gateway đọc stream này để stream qua SSE (/api/events/products/:id)
+const ProductStreamPrefix = "events:product:"
+
+// ProductStream trả về key stream của sản phẩm
+func ProductStream(productID int) string {
+	return fmt.Sprintf("%s%d", ProductStreamPrefix, productID)
+}
+
+// NewPublisher trả về nil nếu EVENTS_ENABLED=false, Publish trên nil Publisher không làm gì
+func NewPublisher(cfg *config.Config) *Publisher {
+	if !cfg.EventsEnabled {
+		return nil
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%s", cfg.RedisHost, cfg.RedisPort),
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+	return newPublisher(client, cfg.EventStreamMaxLen, time.Duration(cfg.EventStreamTTLSecs)*time.Second)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"comment_service/internal/config"
+	"comment_service/internal/events"
 	"comment_service/internal/middleware"
 	"comment_service/internal/models"
 	"comment_service/internal/utils"
@@ -102,14 +103,30 @@ func (h *Hub) Run() {
 }
 
 type CommentHandler struct {
-	db  *pg.DB
-	cfg *config.Config
+	db     *pg.DB
+	cfg    *config.Config
+	events *events.Publisher
 }
 
-func NewCommentHandler(db *pg.DB, cfg *config.Config) *CommentHandler {
+func NewCommentHandler(db *pg.DB, cfg *config.Config, publisher *events.Publisher) *CommentHandler {
 	return &CommentHandler{
-		db:  db,
-		cfg: cfg,
+		db:     db,
+		cfg:    cfg,
+		events: publisher,
+	}
+}
+
+// broadcast gửi message tới các client WebSocket của sản phẩm và ghi vào Redis Stream để client SSE
+// của API Gateway cũng nhận được. Typing là trạng thái tức thời nên không ghi vào stream,
+// tránh chiếm chỗ của bình luận khi client kết nối lại với Last-Event-ID.
+func (h *CommentHandler) broadcast(msg models.WebSocketMessage) {
+	msgBytes, _ := json.Marshal(msg)
+	hub.broadcast <- &BroadcastMessage{
+		ProductID: msg.ProductID,
+		Message:   msgBytes,
+	}
+	if msg.Type != "typing" {
+		h.events.Publish(events.ProductStream(msg.ProductID), msg.Type, msgBytes)
 	}
 }
 
@@ -156,7 +173,7 @@ func (h *CommentHandler) GetProductComments(c *fiber.Ctx) error {
 		Select(&commentsWithUsers)
 
 	if err != nil {
-		slog.Error("Failed to get comments", "error", err)
+		slog.ErrorContext(c.UserContext(), "Failed to get comments", "error", err)
 		return utils.ErrorResponse(c, fiber.StatusInternalServerError, "Lỗi lấy bình luận")
 	}
 
@@ -391,32 +408,21 @@ func (h *CommentHandler) readPumpWithContext(client *Client, ctx context.Context
 				CreatedAt:  comment.CreatedAt,
 			}
 
-			responseMsg := models.WebSocketMessage{
+			h.broadcast(models.WebSocketMessage{
 				Type:      "comment",
 				ProductID: client.ProductID,
 				Data:      commentResp,
-			}
-
-			msgBytes, _ := json.Marshal(responseMsg)
-			hub.broadcast <- &BroadcastMessage{
-				ProductID: client.ProductID,
-				Message:   msgBytes,
-			}
+			})
 
 		case "typing":
 			// Broadcast typing indicator
-			typingMsg := models.WebSocketMessage{
+			h.broadcast(models.WebSocketMessage{
 				Type:      "typing",
 				ProductID: client.ProductID,
 				Data: fiber.Map{
 					"userId": client.UserID,
 				},
-			}
-			msgBytes, _ := json.Marshal(typingMsg)
-			hub.broadcast <- &BroadcastMessage{
-				ProductID: client.ProductID,
-				Message:   msgBytes,
-			}
+			})
 		}
 	}
 }
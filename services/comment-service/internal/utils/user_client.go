@@ -2,6 +2,7 @@ package utils
 
 import (
 	"comment_service/internal/config"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -24,13 +25,14 @@ var httpClient = &http.Client{
 	Timeout: 5 * time.Second,
 }
 
-// GetUserName fetches user's full name from user-service
-func GetUserName(cfg *config.Config, userID int, token string) (string, error) {
+// GetUserName fetches user's full name from user-service.
+// requestID (middleware.RequestIDFromContext) is forwarded as X-Request-ID so both services log the same ID.
+func GetUserName(ctx context.Context, cfg *config.Config, userID int, token, requestID string) (string, error) {
 	url := fmt.Sprintf("%s/api/users/%d/simple", cfg.ServiceURLs["user-service"], userID)
 	
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		slog.Error("Failed to create request", "error", err)
+		slog.ErrorContext(ctx, "Failed to create request", "error", err)
 		return "", err
 	}
 
@@ -38,23 +40,26 @@ func GetUserName(cfg *config.Config, userID int, token string) (string, error) {
 	if token != "" {
 		req.Header.Set("X-User-Token", token)
 	}
+	if requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		slog.Error("Failed to fetch user info", "error", err, "userID", userID)
+		slog.ErrorContext(ctx, "Failed to fetch user info", "error", err, "userID", userID)
 		return "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		slog.Error("User service returned error", "status", resp.StatusCode, "body", string(body))
+		slog.ErrorContext(ctx, "User service returned error", "status", resp.StatusCode, "body", string(body))
 		return "", fmt.Errorf("failed to fetch user: status %d", resp.StatusCode)
 	}
 
 	var userResp UserSimpleResponse
 	if err := json.NewDecoder(resp.Body).Decode(&userResp); err != nil {
-		slog.Error("Failed to decode user response", "error", err)
+		slog.ErrorContext(ctx, "Failed to decode user response", "error", err)
 		return "", err
 	}
 
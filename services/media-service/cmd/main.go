@@ -47,8 +47,8 @@ func main() {
 		log.Fatalf("Failed to initialize S3 client: %v", err)
 	}
 
-	// Setup structured logging
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	// Setup structured logging, log ghi qua slog.*Context mang request_id của request
+	logger := slog.New(middleware.NewContextHandler(slog.NewJSONHandler(os.Stdout, nil)))
 	slog.SetDefault(logger)
 
 	// Create Fiber app
@@ -67,8 +67,9 @@ func main() {
 
 	// Middleware
 	app.Use(recover.New())
+	app.Use(middleware.RequestIDMiddleware())
 	app.Use(fiberlogger.New(fiberlogger.Config{
-		Format: "${time} | ${status} | ${latency} | ${method} | ${path}\n",
+		Format: "${time} | ${status} | ${latency} | ${method} | ${path} | request_id=${locals:requestID}\n",
 	}))
 	
 	// CORS middleware - Must handle OPTIONS properly
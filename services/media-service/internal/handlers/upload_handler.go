@@ -98,7 +98,7 @@ func (h *UploadHandler) UploadSingleFile(c *fiber.Ctx) error {
 	})
 
 	if err != nil {
-		slog.Error("Failed to upload file to S3", "error", err, "filename", fileHeader.Filename)
+		slog.ErrorContext(c.UserContext(), "Failed to upload file to S3", "error", err, "filename", fileHeader.Filename)
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
 			Error:   "Upload lên S3 thất bại",
 			Details: err.Error(),
@@ -107,7 +107,7 @@ func (h *UploadHandler) UploadSingleFile(c *fiber.Ctx) error {
 
 	url := config.GetS3URL(h.cfg, key)
 
-	slog.Info("File uploaded successfully", "filename", fileHeader.Filename, "key", key, "size", fileHeader.Size)
+	slog.InfoContext(c.UserContext(), "File uploaded successfully", "filename", fileHeader.Filename, "key", key, "size", fileHeader.Size)
 
 	return c.JSON(models.UploadResponse{
 		Message:    "Upload thành công",
@@ -204,7 +204,7 @@ func (h *UploadHandler) UploadMultipleFiles(c *fiber.Ctx) error {
 		})
 
 		if err != nil {
-			slog.Error("Failed to upload file to S3", "error", err, "filename", fileHeader.Filename)
+			slog.ErrorContext(c.UserContext(), "Failed to upload file to S3", "error", err, "filename", fileHeader.Filename)
 			failedFiles = append(failedFiles, models.FailedUpload{
 				Filename: fileHeader.Filename,
 				Error:    "Upload lên S3 thất bại: " + err.Error(),
@@ -222,7 +222,7 @@ func (h *UploadHandler) UploadMultipleFiles(c *fiber.Ctx) error {
 			UploadedAt: time.Now(),
 		})
 
-		slog.Info("File uploaded successfully", "filename", fileHeader.Filename, "key", key)
+		slog.InfoContext(c.UserContext(), "File uploaded successfully", "filename", fileHeader.Filename, "key", key)
 	}
 
 	return c.JSON(models.MultipleUploadResponse{
@@ -273,7 +273,7 @@ func (h *UploadHandler) GetPresignedURL(c *fiber.Ctx) error {
 		opts.Expires = presignDuration
 	})
 	if err != nil {
-		slog.Error("Failed to generate presigned URL", "error", err)
+		slog.ErrorContext(c.UserContext(), "Failed to generate presigned URL", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(models.ErrorResponse{
 			Error:   "Không tạo được presigned URL",
 			Details: err.Error(),
@@ -329,7 +329,7 @@ func (h *UploadHandler) GetPresignedURLs(c *fiber.Ctx) error {
 			opts.Expires = presignDuration
 		})
 		if err != nil {
-			slog.Error("Failed to generate presigned URL", "error", err, "filename", filename)
+			slog.ErrorContext(c.UserContext(), "Failed to generate presigned URL", "error", err, "filename", filename)
 			result = append(result, map[string]interface{}{
 				"filename": filename,
 				"error":    err.Error(),
@@ -146,7 +146,7 @@ func ExtractUserInfo(cfg *config.Config) fiber.Handler {
 			cfg.MediaServiceName,
 		)
 		if err != nil || !ok {
-			slog.Error(err.Error())
+			slog.ErrorContext(c.UserContext(), err.Error())
 			return utils.ErrorResponse(c, fiber.StatusUnauthorized, "Invalid Internal JWT")
 		}
 		c.Locals("userID", userID)
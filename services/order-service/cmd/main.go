@@ -4,6 +4,7 @@ import (
 	"log"
 	"log/slog"
 	"order_service/internal/config"
+	"order_service/internal/events"
 	"order_service/internal/handlers"
 	"order_service/internal/middleware"
 	"os"
@@ -43,6 +44,9 @@ import (
 
 func main() {
 
+	// Log nghiệp vụ ghi qua slog.*Context mang request_id của request
+	slog.SetDefault(slog.New(middleware.NewContextHandler(slog.NewTextHandler(os.Stdout, nil))))
+
 	// Load config
 	cfg := config.LoadConfig()
 
@@ -91,8 +95,9 @@ func main() {
 
 	// Middleware
 	app.Use(recover.New())
+	app.Use(middleware.RequestIDMiddleware())
 	app.Use(fiberlogger.New(fiberlogger.Config{
-		Format: "${time} | ${status} | ${latency} | ${method} | ${path}\n",
+		Format: "${time} | ${status} | ${latency} | ${method} | ${path} | request_id=${locals:requestID}\n",
 	}))
 
 	// Swagger route
@@ -101,8 +106,12 @@ func main() {
 	// Serve static files for testing
 	app.Static("/", "./public")
 
+	// Event của hub WebSocket được ghi vào Redis Stream cho SSE của API Gateway
+	publisher := events.NewPublisher(cfg)
+	defer publisher.Close()
+
 	// Initialize handlers
-	orderHandler := handlers.NewOrderHandler(db, cfg)
+	orderHandler := handlers.NewOrderHandler(db, cfg, publisher)
 	likeHandler := handlers.NewLikeHandler(db, cfg)
 	api := app.Group("")
 
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -25,6 +26,15 @@ type Config struct {
 	PublicKeys  map[string]string
 	ServiceURLs map[string]string
 
+	// Redis Stream nhận event của hub WebSocket, API Gateway đọc để stream qua SSE
+	RedisHost          string
+	RedisPort          string
+	RedisPassword      string
+	RedisDB            int
+	EventsEnabled      bool
+	EventStreamMaxLen  int64 // số event giữ lại trong stream của mỗi kênh (xấp xỉ)
+	EventStreamTTLSecs int   // stream của kênh không có event mới bị xóa sau thời gian này
+
 	JWTPublicKeyAuthService string
 	JWTPrivateKey           string
 
@@ -51,6 +61,11 @@ type Config struct {
 }
 
 func LoadConfig() *Config {
+	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
+	eventsEnabled, _ := strconv.ParseBool(getEnv("EVENTS_ENABLED", "true"))
+	eventStreamMaxLen, _ := strconv.ParseInt(getEnv("EVENT_STREAM_MAX_LEN", "1000"), 10, 64)
+	eventStreamTTL, _ := strconv.Atoi(getEnv("EVENT_STREAM_TTL_SECONDS", "86400"))
+
 	return &Config{
 		Port: getEnv("ORDER_SERVICE_PORT", "8086"),
 
@@ -111,6 +126,14 @@ func LoadConfig() *Config {
 		CommentServiceName:      getEnv("COMMENT_SERVICE_NAME", "comment-service"),
 		AutoBiddingServiceName:  getEnv("AUTO_BIDDING_SERVICE_NAME", "auto-bidding-service"),
 
+		RedisHost:          getEnv("REDIS_HOST", "localhost"),
+		RedisPort:          getEnv("REDIS_PORT", "6379"),
+		RedisPassword:      getEnv("REDIS_PASSWORD", ""),
+		RedisDB:            redisDB,
+		EventsEnabled:      eventsEnabled,
+		EventStreamMaxLen:  eventStreamMaxLen,
+		EventStreamTTLSecs: eventStreamTTL,
+
 		JWTPublicKeyAuthService: getEnv("JWT_PUBLIC_KEY_AUTH_SERVICE", ""),
 		JWTPrivateKey:           getEnv("JWT_PRIVATE_KEY", ""),
 	}
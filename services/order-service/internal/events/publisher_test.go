@@ -0,0 +1,82 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestPublisher(t *testing.T, maxLen int64, ttl time.Duration) (*Publisher, *miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	reader := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { reader.Close() })
+	return newPublisher(redis.NewClient(&redis.Options{Addr: mr.Addr()}), maxLen, ttl), mr, reader
+}
+
+func TestPublishWritesStreamEntries(t *testing.T) {
+	p, mr, reader := newTestPublisher(t, 100, time.Hour)
+
+	stream := OrderStream(1001)
+	p.Publish(stream, "message", []byte(`{"type":"message","orderId":1001}`))
+	p.Publish(stream, "message", []byte(`{"type":"message","orderId":1001,"data":{"message":"Xin chào!"}}`))
+	// Close ghi nốt các event trong hàng đợi
+	p.Close()
+
+	entries, err := reader.XRange(context.Background(), stream, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("stream has %d entries, want 2", len(entries))
+	}
+	// Gateway đọc field type và data của entry
+	if got := entries[1].Values["type"]; got != "message" {
+		t.Errorf("type = %v, want message", got)
+	}
+	if got := entries[1].Values["data"]; got != `{"type":"message","orderId":1001,"data":{"message":"Xin chào!"}}` {
+		t.Errorf("data = %v", got)
+	}
+	if ttl := mr.TTL(stream); ttl != time.Hour {
+		t.Errorf("stream TTL = %s, want %s", ttl, time.Hour)
+	}
+}
+
+func TestPublishTrimsStream(t *testing.T) {
+	p, _, reader := newTestPublisher(t, 3, 0)
+
+	stream := OrderStream(1002)
+	for i := 0; i < 10; i++ {
+		p.Publish(stream, "message", []byte(`{}`))
+	}
+	p.Close()
+
+	// MaxLen xấp xỉ: Redis có thể giữ nhiều hơn maxLen, nhưng stream không tăng vô hạn
+	n, err := reader.XLen(context.Background(), stream).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || n >= 10 {
+		t.Errorf("stream length = %d, want trimmed to about 3", n)
+	}
+	if ttl, _ := reader.TTL(context.Background(), stream).Result(); ttl > 0 {
+		t.Errorf("stream TTL = %s, want none when ttl is 0", ttl)
+	}
+}
+
+func TestNilPublisher(t *testing.T) {
+	// EVENTS_ENABLED=false: handler vẫn gọi Publish/Close trên nil Publisher
+	var p *Publisher
+	p.Publish(OrderStream(1001), "message", []byte(`{}`))
+	p.Close()
+}
+
+func TestOrderStream(t *testing.T) {
+	// Key phải khớp với kênh orders mà API Gateway subscribe
+	if got := OrderStream(1001); got != "events:order:1001" {
+		t.Errorf("OrderStream(1001) = %q, want %q", got, "events:order:1001")
+	}
+}
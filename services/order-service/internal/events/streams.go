@@ -0,0 +1,31 @@
+package events
+
+import (
+	"fmt"
+	"order_service/internal/config"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// OrderStreamPrefix là prefix của Redis Stream chứa event chat của một đơn hàng,
+// API Gateway đọc stream này để stream qua SSE (/api/events/orders/:id)
+const OrderStreamPrefix = "events:order:"
+
+// OrderStream trả về key stream của đơn hàng
+func OrderStream(orderID int64) string {
+	return fmt.Sprintf("%s%d", OrderStreamPrefix, orderID)
+}
+
+// NewPublisher trả về nil nếu EVENTS_ENABLED=false, Publish trên nil Publisher không làm gì
+func NewPublisher(cfg *config.Config) *Publisher {
+	if !cfg.EventsEnabled {
+		return nil
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     fmt.Sprintf("%s:%s", cfg.RedisHost, cfg.RedisPort),
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+	return newPublisher(client, cfg.EventStreamMaxLen, time.Duration(cfg.EventStreamTTLSecs)*time.Second)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"order_service/internal/config"
+	"order_service/internal/events"
 	"order_service/internal/middleware"
 	"order_service/internal/models"
 	"strconv"
@@ -123,13 +124,29 @@ type OrderHandler struct {
 	db        *pg.DB
 	validator *validator.Validate
 	cfg       *config.Config
+	events    *events.Publisher
 }
 
-func NewOrderHandler(db *pg.DB, cfg *config.Config) *OrderHandler {
+func NewOrderHandler(db *pg.DB, cfg *config.Config, publisher *events.Publisher) *OrderHandler {
 	return &OrderHandler{
 		db:        db,
 		validator: validator.New(),
 		cfg:       cfg,
+		events:    publisher,
+	}
+}
+
+// broadcast gửi message tới các client WebSocket của đơn hàng và ghi vào Redis Stream để client SSE
+// của API Gateway cũng nhận được. Typing là trạng thái tức thời nên không ghi vào stream,
+// tránh chiếm chỗ của tin nhắn khi client kết nối lại với Last-Event-ID.
+func (h *OrderHandler) broadcast(msg models.WebSocketMessage) {
+	msgBytes, _ := json.Marshal(msg)
+	hub.broadcast <- &BroadcastMessage{
+		OrderID: msg.OrderID,
+		Message: msgBytes,
+	}
+	if msg.Type != "typing" {
+		h.events.Publish(events.OrderStream(msg.OrderID), msg.Type, msgBytes)
 	}
 }
 
@@ -145,7 +162,7 @@ func NewOrderHandler(db *pg.DB, cfg *config.Config) *OrderHandler {
 // @Failure 500 {object} map[string]interface{}
 // @Router /orders [post]
 func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	req := new(models.CreateOrderRequest)
 
 	if err := c.BodyParser(req); err != nil {
@@ -168,7 +185,7 @@ func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 	_, err := h.db.QueryOneContext(ctx, pg.Scan(&orderID), query,
 		req.AuctionID, req.WinnerID, req.SellerID, req.FinalPrice, models.OrderStatusPendingPayment, now, now)
 	if err != nil {
-		slog.Error("Failed to create order", "error", err)
+		slog.ErrorContext(ctx, "Failed to create order", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create order",
 		})
@@ -178,7 +195,7 @@ func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 	ratingQuery := `INSERT INTO order_ratings (order_id, created_at, updated_at) VALUES (?, ?, ?)`
 	_, err = h.db.ExecContext(ctx, ratingQuery, orderID, now, now)
 	if err != nil {
-		slog.Error("Failed to create rating record", "error", err)
+		slog.ErrorContext(ctx, "Failed to create rating record", "error", err)
 	}
 
 	// Return created order
@@ -210,7 +227,7 @@ func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/{id} [get]
 func (h *OrderHandler) GetOrderByID(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -242,7 +259,7 @@ func (h *OrderHandler) GetOrderByID(c *fiber.Ctx) error {
 				"error": "Order not found",
 			})
 		}
-		slog.Error("Failed to get order", "error", err)
+		slog.ErrorContext(ctx, "Failed to get order", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to get order",
 		})
@@ -288,7 +305,7 @@ func (h *OrderHandler) GetOrderByID(c *fiber.Ctx) error {
 // @Router /orders [get]
 func (h *OrderHandler) GetUserOrders(c *fiber.Ctx) error {
 	fmt.Println("---------------------")
-	ctx := context.Background()
+	ctx := c.UserContext()
 	userID := c.Locals("userID").(string)
 	if userID == "" {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
@@ -338,7 +355,7 @@ func (h *OrderHandler) GetUserOrders(c *fiber.Ctx) error {
 	var total int
 	_, err := h.db.QueryOneContext(ctx, pg.Scan(&total), countQuery, args...)
 	if err != nil {
-		slog.Error("Failed to count orders", "error", err)
+		slog.ErrorContext(ctx, "Failed to count orders", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to count orders",
 		})
@@ -358,7 +375,7 @@ func (h *OrderHandler) GetUserOrders(c *fiber.Ctx) error {
 	var orders []models.Order
 	_, err = h.db.QueryContext(ctx, &orders, ordersQuery, args...)
 	if err != nil {
-		slog.Error("Failed to get orders", "error", err)
+		slog.ErrorContext(ctx, "Failed to get orders", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to get orders",
 		})
@@ -405,7 +422,7 @@ func (h *OrderHandler) GetUserOrders(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/{id}/pay [post]
 func (h *OrderHandler) PayOrder(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -472,7 +489,7 @@ func (h *OrderHandler) PayOrder(c *fiber.Ctx) error {
 		WHERE id = ?`
 	_, err = h.db.ExecContext(ctx, updateQuery, req.PaymentMethod, req.PaymentProof, models.OrderStatusPaid, now, now, id)
 	if err != nil {
-		slog.Error("Failed to update order", "error", err)
+		slog.ErrorContext(ctx, "Failed to update order", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update order",
 		})
@@ -504,7 +521,7 @@ func (h *OrderHandler) PayOrder(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/{id}/shipping-address [post]
 func (h *OrderHandler) ProvideShippingAddress(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -570,7 +587,7 @@ func (h *OrderHandler) ProvideShippingAddress(c *fiber.Ctx) error {
 	updateQuery := `UPDATE orders SET shipping_address = ?, shipping_phone = ?, status = ?, updated_at = ? WHERE id = ?`
 	_, err = h.db.ExecContext(ctx, updateQuery, req.ShippingAddress, req.ShippingPhone, models.OrderStatusAddressProvided, now, id)
 	if err != nil {
-		slog.Error("Failed to update order", "error", err)
+		slog.ErrorContext(ctx, "Failed to update order", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update order",
 		})
@@ -601,7 +618,7 @@ func (h *OrderHandler) ProvideShippingAddress(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/{id}/shipping-invoice [post]
 func (h *OrderHandler) SendShippingInvoice(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -668,7 +685,7 @@ func (h *OrderHandler) SendShippingInvoice(c *fiber.Ctx) error {
 	updateQuery := `UPDATE orders SET tracking_number = ?, shipping_invoice = ?, status = ?, updated_at = ? WHERE id = ?`
 	_, err = h.db.ExecContext(ctx, updateQuery, req.TrackingNumber, req.ShippingInvoice, models.OrderStatusShipping, now, id)
 	if err != nil {
-		slog.Error("Failed to update order", "error", err)
+		slog.ErrorContext(ctx, "Failed to update order", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update order",
 		})
@@ -698,7 +715,7 @@ func (h *OrderHandler) SendShippingInvoice(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/{id}/confirm-delivery [post]
 func (h *OrderHandler) ConfirmDelivery(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -751,7 +768,7 @@ func (h *OrderHandler) ConfirmDelivery(c *fiber.Ctx) error {
 	updateQuery := `UPDATE orders SET status = ?, delivered_at = ?, updated_at = ? WHERE id = ?`
 	_, err = h.db.ExecContext(ctx, updateQuery, models.OrderStatusDelivered, now, now, id)
 	if err != nil {
-		slog.Error("Failed to update order", "error", err)
+		slog.ErrorContext(ctx, "Failed to update order", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update order",
 		})
@@ -781,7 +798,7 @@ func (h *OrderHandler) ConfirmDelivery(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/{id}/cancel [post]
 func (h *OrderHandler) CancelOrder(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -847,7 +864,7 @@ func (h *OrderHandler) CancelOrder(c *fiber.Ctx) error {
 	updateQuery := `UPDATE orders SET status = ?, cancel_reason = ?, cancelled_at = ?, updated_at = ? WHERE id = ?`
 	_, err = h.db.ExecContext(ctx, updateQuery, models.OrderStatusCancelled, req.CancelReason, now, now, id)
 	if err != nil {
-		slog.Error("Failed to update order", "error", err)
+		slog.ErrorContext(ctx, "Failed to update order", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update order",
 		})
@@ -872,7 +889,7 @@ func (h *OrderHandler) CancelOrder(c *fiber.Ctx) error {
 			VALUES (?, ?, ?) RETURNING id`
 		_, err = h.db.QueryOneContext(ctx, pg.Scan(&rating.ID), insertQuery, order.ID, now, now)
 		if err != nil {
-			slog.Error("Failed to create rating record for cancelled order", "error", err)
+			slog.ErrorContext(ctx, "Failed to create rating record for cancelled order", "error", err)
 			// Don't return error, cancellation was successful
 		} else {
 			rating.OrderID = order.ID
@@ -880,7 +897,7 @@ func (h *OrderHandler) CancelOrder(c *fiber.Ctx) error {
 			rating.UpdatedAt = now
 		}
 	} else if err != nil {
-		slog.Error("Failed to get rating record", "error", err)
+		slog.ErrorContext(ctx, "Failed to get rating record", "error", err)
 	}
 
 	// If we have a rating record (existing or newly created), update it
@@ -890,7 +907,7 @@ func (h *OrderHandler) CancelOrder(c *fiber.Ctx) error {
 		comment := fmt.Sprintf("Order cancelled by seller. Reason: %s", req.CancelReason)
 		_, err = h.db.ExecContext(ctx, updateRatingQuery, negativeOne, comment, now, now, rating.ID)
 		if err != nil {
-			slog.Error("Failed to update rating", "error", err)
+			slog.ErrorContext(ctx, "Failed to update rating", "error", err)
 		} else {
 			// Update buyer's rating stats
 			h.updateUserRating(ctx, order.WinnerID, -1)
@@ -916,7 +933,7 @@ func (h *OrderHandler) CancelOrder(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/{id}/messages [post]
 func (h *OrderHandler) SendMessage(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -976,7 +993,7 @@ func (h *OrderHandler) SendMessage(c *fiber.Ctx) error {
 		VALUES (?, ?, ?, ?) RETURNING id`
 	_, err = h.db.QueryOneContext(ctx, pg.Scan(&messageID), insertQuery, id, userIDInt64, req.Message, now)
 	if err != nil {
-		slog.Error("Failed to save message", "error", err)
+		slog.ErrorContext(ctx, "Failed to save message", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to save message",
 		})
@@ -1011,7 +1028,7 @@ func (h *OrderHandler) SendMessage(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/product/{id}/messages [get]
 func (h *OrderHandler) GetMessages(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -1064,7 +1081,7 @@ func (h *OrderHandler) GetMessages(c *fiber.Ctx) error {
 	countQuery := `SELECT COUNT(*) FROM order_messages WHERE order_id = ?`
 	_, err = h.db.QueryOneContext(ctx, pg.Scan(&total), countQuery, id)
 	if err != nil {
-		slog.Error("Failed to count messages", "error", err)
+		slog.ErrorContext(ctx, "Failed to count messages", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to count messages",
 		})
@@ -1076,7 +1093,7 @@ func (h *OrderHandler) GetMessages(c *fiber.Ctx) error {
 	var messages []models.OrderMessage
 	_, err = h.db.QueryContext(ctx, &messages, messagesQuery, id, limit, offset)
 	if err != nil && err != pg.ErrNoRows {
-		slog.Error("Failed to get messages", "error", err)
+		slog.ErrorContext(ctx, "Failed to get messages", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to get messages",
 		})
@@ -1114,7 +1131,7 @@ func (h *OrderHandler) GetMessages(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/{id}/rate [post]
 func (h *OrderHandler) RateOrder(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -1182,7 +1199,7 @@ func (h *OrderHandler) RateOrder(c *fiber.Ctx) error {
 			VALUES (?, ?, ?) RETURNING id`
 		_, err = h.db.QueryOneContext(ctx, pg.Scan(&rating.ID), insertQuery, id, now, now)
 		if err != nil {
-			slog.Error("Failed to create rating record", "error", err)
+			slog.ErrorContext(ctx, "Failed to create rating record", "error", err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to create rating record",
 			})
@@ -1218,7 +1235,7 @@ func (h *OrderHandler) RateOrder(c *fiber.Ctx) error {
 	}
 
 	if err != nil {
-		slog.Error("Failed to update rating", "error", err)
+		slog.ErrorContext(ctx, "Failed to update rating", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to update rating",
 		})
@@ -1240,12 +1257,12 @@ func (h *OrderHandler) RateOrder(c *fiber.Ctx) error {
 	if oldRating != nil {
 		// Remove old rating first
 		if err := h.removeUserRating(ctx, targetUserID, *oldRating); err != nil {
-			slog.Error("Failed to remove old rating", "error", err)
+			slog.ErrorContext(ctx, "Failed to remove old rating", "error", err)
 		}
 	}
 	// Add new rating
 	if err := h.addUserRating(ctx, targetUserID, req.Rating); err != nil {
-		slog.Error("Failed to add new rating", "error", err)
+		slog.ErrorContext(ctx, "Failed to add new rating", "error", err)
 	}
 
 	// Check if both parties have rated, if yes, mark order as completed
@@ -1253,7 +1270,7 @@ func (h *OrderHandler) RateOrder(c *fiber.Ctx) error {
 		completeQuery := `UPDATE orders SET status = ?, completed_at = ?, updated_at = ? WHERE id = ?`
 		_, err = h.db.ExecContext(ctx, completeQuery, models.OrderStatusCompleted, now, now, id)
 		if err != nil {
-			slog.Error("Failed to complete order", "error", err)
+			slog.ErrorContext(ctx, "Failed to complete order", "error", err)
 		}
 	}
 
@@ -1271,7 +1288,7 @@ func (h *OrderHandler) RateOrder(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /orders/{id}/rating [get]
 func (h *OrderHandler) GetRating(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -1309,7 +1326,7 @@ func (h *OrderHandler) GetRating(c *fiber.Ctx) error {
 // @Failure 404 {object} map[string]interface{}
 // @Router /users/{id}/rating [get]
 func (h *OrderHandler) GetUserRating(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -1359,7 +1376,7 @@ func (h *OrderHandler) GetUserRating(c *fiber.Ctx) error {
 // @Failure 403 {object} map[string]interface{}
 // @Router /admin/orders [get]
 func (h *OrderHandler) GetAllOrders(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 
 	// Check if user is admin
 	role := c.Locals("role")
@@ -1393,7 +1410,7 @@ func (h *OrderHandler) GetAllOrders(c *fiber.Ctx) error {
 	var orders []models.Order
 	_, err := h.db.QueryContext(ctx, &orders, ordersQuery, args...)
 	if err != nil {
-		slog.Error("Failed to get orders", "error", err)
+		slog.ErrorContext(ctx, "Failed to get orders", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to get orders",
 		})
@@ -1421,7 +1438,7 @@ func (h *OrderHandler) addUserRating(ctx context.Context, userID int64, rating i
 	userQuery := `SELECT id, total_number_reviews, total_number_good_reviews FROM users WHERE id = ?`
 	_, err := h.db.QueryOneContext(ctx, &user, userQuery, userID)
 	if err != nil {
-		slog.Error("Failed to get user for rating update", "error", err, "userID", userID)
+		slog.ErrorContext(ctx, "Failed to get user for rating update", "error", err, "userID", userID)
 		return err
 	}
 
@@ -1436,7 +1453,7 @@ func (h *OrderHandler) addUserRating(ctx context.Context, userID int64, rating i
 	_, err = h.db.ExecContext(ctx, updateQuery, totalReviews, totalGoodReviews, userID)
 
 	if err != nil {
-		slog.Error("Failed to update user rating", "error", err, "userID", userID)
+		slog.ErrorContext(ctx, "Failed to update user rating", "error", err, "userID", userID)
 		return err
 	}
 
@@ -1449,7 +1466,7 @@ func (h *OrderHandler) removeUserRating(ctx context.Context, userID int64, ratin
 	userQuery := `SELECT id, total_number_reviews, total_number_good_reviews FROM users WHERE id = ?`
 	_, err := h.db.QueryOneContext(ctx, &user, userQuery, userID)
 	if err != nil {
-		slog.Error("Failed to get user for rating removal", "error", err, "userID", userID)
+		slog.ErrorContext(ctx, "Failed to get user for rating removal", "error", err, "userID", userID)
 		return err
 	}
 
@@ -1468,7 +1485,7 @@ func (h *OrderHandler) removeUserRating(ctx context.Context, userID int64, ratin
 	_, err = h.db.ExecContext(ctx, updateQuery, totalReviews, totalGoodReviews, userID)
 
 	if err != nil {
-		slog.Error("Failed to remove user rating", "error", err, "userID", userID)
+		slog.ErrorContext(ctx, "Failed to remove user rating", "error", err, "userID", userID)
 		return err
 	}
 
@@ -1636,32 +1653,21 @@ func (h *OrderHandler) readPump(client *Client) {
 			}
 
 			// Broadcast to all clients connected to this order
-			responseMsg := models.WebSocketMessage{
+			h.broadcast(models.WebSocketMessage{
 				Type:    "message",
 				OrderID: client.OrderID,
 				Data:    message,
-			}
-
-			msgBytes, _ := json.Marshal(responseMsg)
-			hub.broadcast <- &BroadcastMessage{
-				OrderID: client.OrderID,
-				Message: msgBytes,
-			}
+			})
 
 		case "typing":
 			// Broadcast typing indicator
-			typingMsg := models.WebSocketMessage{
+			h.broadcast(models.WebSocketMessage{
 				Type:    "typing",
 				OrderID: client.OrderID,
 				Data: fiber.Map{
 					"userId": client.UserID,
 				},
-			}
-			msgBytes, _ := json.Marshal(typingMsg)
-			hub.broadcast <- &BroadcastMessage{
-				OrderID: client.OrderID,
-				Message: msgBytes,
-			}
+			})
 		}
 	}
 }
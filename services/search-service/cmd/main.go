@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"search-service/internal/config"
 	"search-service/internal/elasticsearch"
 	"search-service/internal/handlers"
+	"search-service/internal/middleware"
 	"search-service/internal/models"
 	"search-service/internal/repository"
 	"search-service/internal/stream"
@@ -21,6 +23,9 @@ import (
 )
 
 func main() {
+	// Log nghiệp vụ ghi qua slog.*Context mang request_id của request
+	slog.SetDefault(slog.New(middleware.NewContextHandler(slog.NewTextHandler(os.Stdout, nil))))
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -75,7 +80,10 @@ func main() {
 
 	app := fiber.New()
 	app.Use(recover.New())
-	app.Use(logger.New())
+	app.Use(middleware.RequestIDMiddleware())
+	app.Use(logger.New(logger.Config{
+		Format: "[${time}] ${status} - ${latency} ${method} ${path} request_id=${locals:requestID}\n",
+	}))
 	
 	// CORS middleware - Must handle OPTIONS properly
 	app.Use(func(c *fiber.Ctx) error {
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader là header mang request ID giữa gateway và các service
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength giới hạn độ dài request ID nhận từ request
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestIDMiddleware nhận X-Request-ID do gateway gửi (hoặc sinh ID mới khi service được gọi trực tiếp),
+// trả lại trong response và gắn vào Locals("requestID") và UserContext để access log và log nghiệp vụ
+// nối được với log của gateway
+func RequestIDMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		requestID := c.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+
+		c.Set(RequestIDHeader, requestID)
+		c.Locals("requestID", requestID)
+		c.SetUserContext(context.WithValue(c.UserContext(), requestIDKey{}, requestID))
+
+		return c.Next()
+	}
+}
+
+// RequestIDFromContext trả về request ID trong context, rỗng nếu không có
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// contextHandler thêm request_id của context vào mỗi bản ghi log (slog.InfoContext, slog.ErrorContext...)
+type contextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler bọc handler của slog để log nghiệp vụ mang request_id của request
+func NewContextHandler(h slog.Handler) slog.Handler {
+	return contextHandler{Handler: h}
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		r.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// validRequestID chỉ nhận ID ngắn gồm ký tự an toàn để tránh log injection
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == ':') {
+			return false
+		}
+	}
+	return true
+}
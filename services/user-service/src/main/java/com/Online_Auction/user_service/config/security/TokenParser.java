@@ -1,27 +1,81 @@
 package com.Online_Auction.user_service.config.security;
 
 import java.security.Key;
+import java.security.KeyFactory;
+import java.security.PublicKey;
+import java.security.spec.X509EncodedKeySpec;
+import java.util.Base64;
 
+import org.springframework.beans.factory.annotation.Value;
 import org.springframework.stereotype.Component;
 
 import io.jsonwebtoken.Claims;
+import io.jsonwebtoken.JwsHeader;
 import io.jsonwebtoken.Jwts;
+import io.jsonwebtoken.SignatureAlgorithm;
+import io.jsonwebtoken.SigningKeyResolverAdapter;
+import io.jsonwebtoken.UnsupportedJwtException;
 import io.jsonwebtoken.security.Keys;
+import io.micrometer.common.util.StringUtils;
+import jakarta.annotation.PostConstruct;
 
+// Verify access token RS256 do auth-service ký, bằng public key của auth-service (bắt buộc, kiểm tra khi khởi động).
+// Trong release chuyển đổi, token HS256 cấp trước khi auth-service chuyển sang RS256 vẫn được chấp nhận
+// nếu cấu hình secret cũ (auth-service.legacy-hs256-secret).
 @Component
 public class TokenParser {
 
-    private String secretKey = "X8f3N2p9V6yR1qT7Z4wM0bC5sH2kJ8lP";
+    @Value("${auth-service.public-key:}")
+    private String publicKeyPem;
 
-    private Key getKey() {
-        return Keys.hmacShaKeyFor(secretKey.getBytes());
+    @Value("${auth-service.legacy-hs256-secret:}")
+    private String legacySecret;
+
+    private PublicKey publicKey;
+    private Key legacyKey;
+
+    @PostConstruct
+    void loadKeys() {
+        if (StringUtils.isBlank(publicKeyPem)) {
+            throw new IllegalStateException("auth-service.public-key is not configured (JWT_PUBLIC_KEY_AUTH_SERVICE)");
+        }
+        try {
+            String base64 = publicKeyPem
+                    .replace("\\n", "\n")
+                    .replace("-----BEGIN PUBLIC KEY-----", "")
+                    .replace("-----END PUBLIC KEY-----", "")
+                    .replaceAll("\\s", "");
+            byte[] der = Base64.getDecoder().decode(base64);
+            publicKey = KeyFactory.getInstance("RSA").generatePublic(new X509EncodedKeySpec(der));
+        } catch (Exception e) {
+            throw new IllegalStateException("Invalid auth-service.public-key: " + e.getMessage(), e);
+        }
+        if (StringUtils.isNotBlank(legacySecret)) {
+            legacyKey = Keys.hmacShaKeyFor(legacySecret.getBytes());
+        }
     }
 
     public Claims parseClaims(String token) {
         return Jwts.parserBuilder()
-                .setSigningKey(getKey())
+                .setSigningKeyResolver(new SigningKeyResolverAdapter() {
+                    @Override
+                    public Key resolveSigningKey(JwsHeader header, Claims claims) {
+                        return keyFor(header.getAlgorithm());
+                    }
+                })
                 .build()
                 .parseClaimsJws(token)
                 .getBody();
     }
+
+    // Key theo alg của token; token HS256 không bao giờ được verify bằng public key
+    private Key keyFor(String algorithm) {
+        if (SignatureAlgorithm.RS256.getValue().equals(algorithm)) {
+            return publicKey;
+        }
+        if (legacyKey != null && SignatureAlgorithm.HS256.getValue().equals(algorithm)) {
+            return legacyKey;
+        }
+        throw new UnsupportedJwtException("Unsupported token algorithm: " + algorithm);
+    }
 }